@@ -262,6 +262,8 @@ func (app *Application) clean() {
 func (app *Application) Stop() (err error) {
 	app.stopOnce.Do(func() {
 		app.stopped <- struct{}{}
+		// 停止前先置为未就绪, 健康检查据此摘除流量
+		server.SetReady(false)
 		app.runHooks(StageBeforeStop)
 
 		//stop servers
@@ -290,6 +292,8 @@ func (app *Application) Stop() (err error) {
 func (app *Application) GracefulStop(ctx context.Context) (err error) {
 	app.stopOnce.Do(func() {
 		app.stopped <- struct{}{}
+		// 停止前先置为未就绪, 健康检查据此摘除流量
+		server.SetReady(false)
 		app.runHooks(StageBeforeStop)
 
 		//stop servers
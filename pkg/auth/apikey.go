@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"crypto/subtle"
+)
+
+// SchemeAPIKey ...
+const SchemeAPIKey = "apikey"
+
+type apiKeyProvider struct {
+	header string
+	keys   []APIKey
+}
+
+func newAPIKeyProvider(config *APIKeyConfig) *apiKeyProvider {
+	var header = config.Header
+	if header == "" {
+		header = "X-Api-Key"
+	}
+	return &apiKeyProvider{
+		header: header,
+		keys:   config.Keys,
+	}
+}
+
+// Scheme implements Provider
+func (p *apiKeyProvider) Scheme() string {
+	return SchemeAPIKey
+}
+
+// Authenticate implements Provider
+func (p *apiKeyProvider) Authenticate(req *Request) (*Principal, error) {
+	val := req.Header.Get(p.header)
+	if val == "" {
+		return nil, ErrNoCredentials
+	}
+	for _, key := range p.keys {
+		if subtle.ConstantTimeCompare([]byte(key.Key), []byte(val)) == 1 {
+			return &Principal{Subject: key.Subject, Scheme: SchemeAPIKey}, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/metadata"
+	protov2 "google.golang.org/protobuf/proto"
+)
+
+// MethodGRPC grpc请求的Method, 用于hmac签名
+const MethodGRPC = "GRPC"
+
+var (
+	// ErrNoCredentials 请求中没有对应认证方式的凭证
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials 凭证无效
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrBodyTooLarge 请求体超过读取的限制
+	ErrBodyTooLarge = errors.New("request body too large")
+)
+
+// Principal 认证通过的调用方
+type Principal struct {
+	// Subject 调用方标识: jwt的sub, api key的subject或hmac的key id
+	Subject string
+	// Scheme 认证方式, 内置jwt、apikey和hmac
+	Scheme string
+	// Claims jwt的全部声明, 其他认证方式为空
+	Claims map[string]interface{}
+}
+
+type principalKey struct{}
+
+// NewContext returns a new context with principal
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns principal in ctx
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// Request 与协议无关的待认证请求
+type Request struct {
+	// Method http方法, grpc请求为MethodGRPC
+	Method string
+	// Path http路径或grpc完整方法名
+	Path string
+	// URI http请求的路径和查询参数, grpc请求为完整方法名
+	URI    string
+	Header http.Header
+	// Body 读取请求体, 超过limit字节时返回ErrBodyTooLarge. grpc请求为确定性序列化的请求消息, 流为空
+	Body func(limit int64) ([]byte, error)
+}
+
+// FromHTTP creates request from http request, body is restored after read
+func FromHTTP(r *http.Request) *Request {
+	return &Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		URI:    r.URL.RequestURI(),
+		Header: r.Header,
+		Body: func(limit int64) ([]byte, error) {
+			if r.Body == nil {
+				return nil, nil
+			}
+			bs, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+			if int64(len(bs)) > limit {
+				// 未读取的部分留给handler
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(bs), r.Body), r.Body}
+				return nil, ErrBodyTooLarge
+			}
+			r.Body.Close()
+			r.Body = ioutil.NopCloser(bytes.NewReader(bs))
+			return bs, err
+		},
+	}
+}
+
+// FromMetadata creates request from incoming metadata of grpc
+func FromMetadata(fullMethod string, md metadata.MD) *Request {
+	var header = make(http.Header, len(md))
+	for key, vals := range md {
+		for _, val := range vals {
+			header.Add(key, val)
+		}
+	}
+	return &Request{
+		Method: MethodGRPC,
+		Path:   fullMethod,
+		URI:    fullMethod,
+		Header: header,
+	}
+}
+
+// FromGRPC creates request from incoming metadata and request message of grpc unary call
+func FromGRPC(fullMethod string, md metadata.MD, msg interface{}) *Request {
+	req := FromMetadata(fullMethod, md)
+	req.Body = func(limit int64) ([]byte, error) {
+		bs, err := marshalMessage(msg)
+		if err == nil && int64(len(bs)) > limit {
+			return nil, ErrBodyTooLarge
+		}
+		return bs, err
+	}
+	return req
+}
+
+// marshalMessage 确定性序列化grpc消息, 用于签名
+func marshalMessage(msg interface{}) ([]byte, error) {
+	m, ok := msg.(proto.Message)
+	if !ok || msg == nil {
+		return nil, nil
+	}
+	return protov2.MarshalOptions{Deterministic: true}.Marshal(proto.MessageV2(m))
+}
+
+func (req *Request) body(limit int64) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	return req.Body(limit)
+}
+
+func (req *Request) uri() string {
+	if req.URI == "" {
+		return req.Path
+	}
+	return req.URI
+}
+
+// Provider 认证方式, 可以通过Auth.WithProvider注册自定义实现
+type Provider interface {
+	// Scheme 认证方式名称
+	Scheme() string
+	// Authenticate 请求中没有该方式的凭证时返回ErrNoCredentials
+	Authenticate(req *Request) (*Principal, error)
+}
+
+// Auth 认证器, 按顺序尝试配置的认证方式
+type Auth struct {
+	config    *Config
+	providers []Provider
+}
+
+// WithProvider registers custom providers, which are tried after builtin providers
+func (a *Auth) WithProvider(providers ...Provider) *Auth {
+	a.providers = append(a.providers, providers...)
+	return a
+}
+
+// Skip reports whether route skips authentication
+func (a *Auth) Skip(route string) bool {
+	return a.config.skip(route)
+}
+
+// Authenticate authenticates request by the first provider which finds credentials
+func (a *Auth) Authenticate(req *Request) (*Principal, error) {
+	for _, provider := range a.providers {
+		principal, err := provider.Authenticate(req)
+		if err == ErrNoCredentials {
+			continue
+		}
+		if err != nil {
+			return nil, &Error{Scheme: provider.Scheme(), Err: err}
+		}
+		if principal.Scheme == "" {
+			principal.Scheme = provider.Scheme()
+		}
+		return principal, nil
+	}
+	return nil, ErrNoCredentials
+}
+
+// Error 认证失败
+type Error struct {
+	Scheme string
+	Err    error
+}
+
+// Error ...
+func (e *Error) Error() string {
+	return e.Scheme + ": " + e.Err.Error()
+}
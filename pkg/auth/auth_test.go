@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/util/otest/proto/testproto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func sign(t *testing.T, header, claims map[string]interface{}, signer func(signed []byte) []byte) string {
+	h, err := json.Marshal(header)
+	assert.Nil(t, err)
+	c, err := json.Marshal(claims)
+	assert.Nil(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signer([]byte(signed)))
+}
+
+func hs256(secret string) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+}
+
+func rs256(key *rsa.PrivateKey) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		return sig
+	}
+}
+
+func bearer(token string) *Request {
+	return &Request{Header: http.Header{"Authorization": []string{"Bearer " + token}}}
+}
+
+func TestJWTHS256(t *testing.T) {
+	a, err := (&Config{JWT: &JWTConfig{Secret: "secret", Issuer: "ox", Audience: "api"}}).Build()
+	assert.Nil(t, err)
+
+	exp := time.Now().Add(time.Hour).Unix()
+	token := sign(t, map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"sub": "alice", "iss": "ox", "aud": []string{"api"}, "exp": exp}, hs256("secret"))
+	principal, err := a.Authenticate(bearer(token))
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", principal.Subject)
+	assert.Equal(t, SchemeJWT, principal.Scheme)
+	assert.Equal(t, "ox", principal.Claims["iss"])
+
+	// 签名错误
+	token = sign(t, map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"sub": "alice", "iss": "ox", "aud": "api"}, hs256("other"))
+	_, err = a.Authenticate(bearer(token))
+	assert.NotNil(t, err)
+
+	// 过期
+	token = sign(t, map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"sub": "alice", "iss": "ox", "aud": "api", "exp": time.Now().Add(-time.Minute).Unix()}, hs256("secret"))
+	_, err = a.Authenticate(bearer(token))
+	assert.Equal(t, errTokenExpired, err.(*Error).Err)
+
+	// audience不匹配
+	token = sign(t, map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"sub": "alice", "iss": "ox", "aud": "web"}, hs256("secret"))
+	_, err = a.Authenticate(bearer(token))
+	assert.Equal(t, errInvalidAudience, err.(*Error).Err)
+
+	// alg为none
+	token = sign(t, map[string]interface{}{"alg": "none"}, map[string]interface{}{"sub": "alice"}, func([]byte) []byte { return nil })
+	_, err = a.Authenticate(bearer(token))
+	assert.Equal(t, errUnsupportedAlg, err.(*Error).Err)
+
+	_, err = a.Authenticate(&Request{Header: http.Header{}})
+	assert.Equal(t, ErrNoCredentials, err)
+}
+
+func TestJWTRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+
+	dir, err := ioutil.TempDir("", "auth")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "jwks.json")
+	assert.Nil(t, ioutil.WriteFile(file, jwks, 0644))
+
+	t.Run("file", func(t *testing.T) {
+		a, err := (&Config{JWT: &JWTConfig{JWKSFile: file, Algorithms: []string{"RS256"}}}).Build()
+		assert.Nil(t, err)
+		token := sign(t, map[string]interface{}{"alg": "RS256", "kid": "k1"}, map[string]interface{}{"sub": "bob"}, rs256(key))
+		principal, err := a.Authenticate(bearer(token))
+		assert.Nil(t, err)
+		assert.Equal(t, "bob", principal.Subject)
+
+		// 未允许的算法
+		token = sign(t, map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"sub": "bob"}, hs256(""))
+		_, err = a.Authenticate(bearer(token))
+		assert.NotNil(t, err)
+	})
+
+	t.Run("url", func(t *testing.T) {
+		var fetched int
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetched++
+			w.Write(jwks)
+		}))
+		defer ts.Close()
+
+		a, err := (&Config{JWT: &JWTConfig{JWKSURL: ts.URL}}).Build()
+		assert.Nil(t, err)
+		token := sign(t, map[string]interface{}{"alg": "RS256", "kid": "k1"}, map[string]interface{}{"sub": "bob"}, rs256(key))
+		_, err = a.Authenticate(bearer(token))
+		assert.Nil(t, err)
+		_, err = a.Authenticate(bearer(token))
+		assert.Nil(t, err)
+
+		// 未知的kid在刷新间隔内不会重复拉取
+		token = sign(t, map[string]interface{}{"alg": "RS256", "kid": "k2"}, map[string]interface{}{"sub": "bob"}, rs256(key))
+		_, err = a.Authenticate(bearer(token))
+		assert.Equal(t, errUnknownKey, err.(*Error).Err)
+		assert.Equal(t, 1, fetched)
+	})
+}
+
+func TestAPIKey(t *testing.T) {
+	a, err := (&Config{APIKey: &APIKeyConfig{Keys: []APIKey{{Key: "k1", Subject: "job"}}}}).Build()
+	assert.Nil(t, err)
+
+	principal, err := a.Authenticate(&Request{Header: http.Header{"X-Api-Key": []string{"k1"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, "job", principal.Subject)
+	assert.Equal(t, SchemeAPIKey, principal.Scheme)
+
+	_, err = a.Authenticate(&Request{Header: http.Header{"X-Api-Key": []string{"k2"}}})
+	assert.Equal(t, ErrInvalidCredentials, err.(*Error).Err)
+}
+
+func TestHMAC(t *testing.T) {
+	a, err := (&Config{HMAC: &HMACConfig{Keys: []HMACKey{{ID: "app", Secret: "s3cret"}}, MaxBodySize: 64}}).Build()
+	assert.Nil(t, err)
+
+	t.Run("http", func(t *testing.T) {
+		body := []byte(`{"name":"ox"}`)
+		r := httptest.NewRequest(http.MethodPost, "/api/hello?id=1", bytes.NewReader(body))
+		SignHTTP(r, "app", "s3cret", body)
+		header := r.Header.Clone()
+		principal, err := a.Authenticate(FromHTTP(r))
+		assert.Nil(t, err)
+		assert.Equal(t, "app", principal.Subject)
+		// body可以再次读取
+		bs, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, body, bs)
+
+		// 重放
+		r = httptest.NewRequest(http.MethodPost, "/api/hello?id=1", bytes.NewReader(body))
+		r.Header = header
+		_, err = a.Authenticate(FromHTTP(r))
+		assert.Equal(t, errNonceReused, err.(*Error).Err)
+
+		// 篡改查询参数
+		r = httptest.NewRequest(http.MethodPost, "/api/hello?id=1", bytes.NewReader(body))
+		SignHTTP(r, "app", "s3cret", body)
+		r.URL.RawQuery = "id=2"
+		_, err = a.Authenticate(FromHTTP(r))
+		assert.Equal(t, ErrInvalidCredentials, err.(*Error).Err)
+
+		// 篡改请求体
+		r = httptest.NewRequest(http.MethodPost, "/api/hello", bytes.NewReader([]byte(`{}`)))
+		SignHTTP(r, "app", "s3cret", body)
+		_, err = a.Authenticate(FromHTTP(r))
+		assert.Equal(t, ErrInvalidCredentials, err.(*Error).Err)
+
+		// 请求体超过MaxBodySize, 未读取的部分仍然可以读取
+		large := bytes.Repeat([]byte("a"), 100)
+		r = httptest.NewRequest(http.MethodPost, "/api/hello", bytes.NewReader(large))
+		SignHTTP(r, "app", "s3cret", large)
+		_, err = a.Authenticate(FromHTTP(r))
+		assert.Equal(t, ErrBodyTooLarge, err.(*Error).Err)
+		bs, _ = ioutil.ReadAll(r.Body)
+		assert.Equal(t, large, bs)
+
+		// 过期
+		r = httptest.NewRequest(http.MethodGet, "/api/hello", nil)
+		r.Header.Set(HeaderKeyID, "app")
+		r.Header.Set(HeaderTimestamp, "1")
+		r.Header.Set(HeaderNonce, "n")
+		r.Header.Set(HeaderSignature, Sign("s3cret", http.MethodGet, "/api/hello", 1, "n", nil))
+		_, err = a.Authenticate(FromHTTP(r))
+		assert.Equal(t, errSignatureExpired, err.(*Error).Err)
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		var md metadata.MD
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		}
+		req := &testproto.HelloRequest{Name: "ox"}
+		err := HMACUnaryClientInterceptor("app", "s3cret")(context.Background(), "/helloworld.Greeter/SayHello", req, nil, nil, invoker)
+		assert.Nil(t, err)
+
+		// 篡改请求消息
+		_, err = a.Authenticate(FromGRPC("/helloworld.Greeter/SayHello", md, &testproto.HelloRequest{Name: "evil"}))
+		assert.Equal(t, ErrInvalidCredentials, err.(*Error).Err)
+		_, err = a.Authenticate(FromGRPC("/helloworld.Greeter/SayGoodbye", md, req))
+		assert.NotNil(t, err)
+
+		principal, err := a.Authenticate(FromGRPC("/helloworld.Greeter/SayHello", md, &testproto.HelloRequest{Name: "ox"}))
+		assert.Nil(t, err)
+		assert.Equal(t, "app", principal.Subject)
+		_, err = a.Authenticate(FromGRPC("/helloworld.Greeter/SayHello", md, req))
+		assert.Equal(t, errNonceReused, err.(*Error).Err)
+
+		// 流只签名方法
+		stream := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			md, _ = metadata.FromOutgoingContext(ctx)
+			return nil, nil
+		}
+		_, err = HMACStreamClientInterceptor("app", "s3cret")(context.Background(), nil, nil, "/helloworld.Greeter/StreamHello", stream)
+		assert.Nil(t, err)
+		_, err = a.Authenticate(FromMetadata("/helloworld.Greeter/StreamHello", md))
+		assert.Nil(t, err)
+	})
+}
+
+func TestNonceCache(t *testing.T) {
+	c := newNonceCache(time.Minute)
+	now := time.Now()
+	assert.True(t, c.add("a", now))
+	assert.False(t, c.add("a", now.Add(30*time.Second)))
+	// 轮换后仍然保留
+	assert.True(t, c.add("b", now.Add(70*time.Second)))
+	assert.False(t, c.add("a", now.Add(70*time.Second)))
+	// 至少保留ttl后过期
+	assert.True(t, c.add("a", now.Add(3*time.Minute)))
+	assert.False(t, c.add("a", now.Add(3*time.Minute)))
+}
+
+func TestSkip(t *testing.T) {
+	a, err := (&Config{Skip: []string{"/grpc.health.v1.Health/*", "/login"}}).Build()
+	assert.Nil(t, err)
+	assert.True(t, a.Skip("/grpc.health.v1.Health/Check"))
+	assert.True(t, a.Skip("/login"))
+	assert.False(t, a.Skip("/login/admin"))
+	assert.False(t, a.Skip("/helloworld.Greeter/SayHello"))
+}
+
+func TestContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+
+	ctx := NewContext(context.Background(), &Principal{Subject: "alice"})
+	principal, ok := FromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", principal.Subject)
+}
@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/xqk/ox/pkg/conf"
+	"github.com/xqk/ox/pkg/metric"
+	"github.com/xqk/ox/pkg/olog"
+)
+
+const (
+	// HeaderAID 调用方应用标识, grpc的metadata为aid
+	HeaderAID = "AID"
+	// HeaderAIDTimestamp 签名时间, unix秒
+	HeaderAIDTimestamp = "AID-Ts"
+	// HeaderAIDSign 调用方签名, 见SignCaller
+	HeaderAIDSign = "AID-Sign"
+)
+
+// callerUnknown 签名未校验的调用方在监控中的aid, 避免任意请求头产生新的监控标签
+const callerUnknown = "unknown"
+
+var (
+	// ErrCallerUnsigned 调用方没有签名
+	ErrCallerUnsigned = errors.New("caller unsigned")
+	// ErrCallerSignature 调用方签名无效
+	ErrCallerSignature = errors.New("invalid caller signature")
+	// ErrCallerExpired 调用方签名过期
+	ErrCallerExpired = errors.New("caller signature expired")
+	// ErrCallerDenied 调用方没有访问该方法的权限
+	ErrCallerDenied = errors.New("caller denied")
+)
+
+// CallerConfig 调用方身份校验和方法级ACL配置, 位于 ox.server.<name>.caller, 支持热更新
+type CallerConfig struct {
+	// Enable 开启校验, 默认关闭
+	Enable bool `json:"enable" toml:"enable"`
+	// Keys 调用方应用的签名密钥
+	Keys []AppKey `json:"keys" toml:"keys"`
+	// MaxSkew 签名时间与服务端时间允许的最大偏差, 默认5m
+	MaxSkew time.Duration `json:"maxSkew" toml:"maxSkew"`
+	// AllowUnsigned 允许未签名的调用方, 其aid仍然按ACL校验, 用于逐步接入
+	AllowUnsigned bool `json:"allowUnsigned" toml:"allowUnsigned"`
+	// DefaultAllow 没有匹配的ACL规则时是否允许访问, 默认允许
+	DefaultAllow bool `json:"defaultAllow" toml:"defaultAllow"`
+	// ACL 方法级访问控制
+	ACL []ACLRule `json:"acl" toml:"acl"`
+}
+
+// AppKey 应用的签名密钥
+type AppKey struct {
+	AID string `json:"aid" toml:"aid"`
+	Key string `json:"key" toml:"key"`
+}
+
+// ACLRule 允许访问方法的调用方
+type ACLRule struct {
+	// Method grpc完整方法名或http路由, 以*结尾时按前缀匹配
+	Method string `json:"method" toml:"method"`
+	// AIDs 允许的调用方应用, *表示全部
+	AIDs []string `json:"aids" toml:"aids"`
+}
+
+// DefaultCallerConfig ...
+func DefaultCallerConfig() *CallerConfig {
+	return &CallerConfig{
+		Enable:       false,
+		MaxSkew:      5 * time.Minute,
+		DefaultAllow: true,
+	}
+}
+
+// SignCaller returns hex hmac-sha256 of "aid\ntimestamp\ntarget" with key of the app,
+// target is the grpc full method or CallerTarget of http request, signature can not be replayed to other methods
+func SignCaller(aid, key, target string, timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(aid + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + target))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CallerTarget returns signed target of http request, such as "GET /orders/1"
+func CallerTarget(r *http.Request) string {
+	return r.Method + " " + r.URL.Path
+}
+
+// SignCallerHTTP sets aid and its signature to http request
+func SignCallerHTTP(r *http.Request, aid, key string) {
+	timestamp := time.Now().Unix()
+	r.Header.Set(HeaderAID, aid)
+	r.Header.Set(HeaderAIDTimestamp, strconv.FormatInt(timestamp, 10))
+	r.Header.Set(HeaderAIDSign, SignCaller(aid, key, CallerTarget(r), timestamp))
+}
+
+type callerState struct {
+	config *CallerConfig
+	keys   map[string]string
+}
+
+type callerKey struct{}
+
+// NewCallerContext returns a new context with aid of the verified caller
+func NewCallerContext(ctx context.Context, aid string) context.Context {
+	return context.WithValue(ctx, callerKey{}, aid)
+}
+
+// CallerFromContext returns aid of the verified caller in ctx
+func CallerFromContext(ctx context.Context) (string, bool) {
+	aid, ok := ctx.Value(callerKey{}).(string)
+	return aid, ok && aid != ""
+}
+
+// CallerGuard 校验调用方签名和方法级ACL
+type CallerGuard struct {
+	typ   string
+	state atomic.Value
+	now   func() time.Time
+}
+
+// NewCallerGuard creates a guard, typ is the type label of metrics, such as metric.TypeHTTP
+func NewCallerGuard(typ string, config *CallerConfig) *CallerGuard {
+	g := &CallerGuard{
+		typ: typ,
+		now: time.Now,
+	}
+	g.Update(config)
+	return g
+}
+
+// Update replaces keys and ACL of the guard, all callers are allowed when config is disabled
+func (g *CallerGuard) Update(config *CallerConfig) {
+	// 复制配置, 不修改调用方的配置
+	var copied = *config
+	var state = &callerState{
+		config: &copied,
+		keys:   make(map[string]string, len(config.Keys)),
+	}
+	if copied.MaxSkew <= 0 {
+		copied.MaxSkew = 5 * time.Minute
+	}
+	for _, key := range config.Keys {
+		state.keys[key.AID] = key.Key
+	}
+	g.state.Store(state)
+}
+
+// Watch reloads config of key when configuration changes
+func (g *CallerGuard) Watch(key string, logger *olog.Logger) {
+	conf.OnChange(func(c *conf.Configuration) {
+		var config = DefaultCallerConfig()
+		if err := c.UnmarshalKey(key, config); err != nil {
+			logger.Error("reload caller config", olog.FieldKey(key), olog.FieldErr(err))
+			return
+		}
+		g.Update(config)
+		logger.Info("reload caller config", olog.FieldKey(key), olog.Any("enable", config.Enable), olog.Int("keys", len(config.Keys)), olog.Int("acl", len(config.ACL)))
+	})
+}
+
+// Check verifies signature of caller for target and ACL of method, returns aid of the caller whose signature
+// is verified, empty if the caller is unsigned or the guard is disabled.
+// method is the grpc full method or http route, target is the grpc full method or CallerTarget of http request.
+// Denials are counted by metric.ServerCallerDeniedCounter, aid label is unknown if signature is not verified.
+func (g *CallerGuard) Check(method, target string, header http.Header) (string, error) {
+	var state = g.state.Load().(*callerState)
+	if !state.config.Enable {
+		return "", nil
+	}
+	var aid = header.Get(HeaderAID)
+	verified, err := g.verify(state, aid, target, header)
+	if err == nil && !state.allow(method, aid) {
+		err = ErrCallerDenied
+	}
+	if err != nil {
+		var label = callerUnknown
+		if verified {
+			label = aid
+		}
+		metric.ServerCallerDeniedCounter.Inc(g.typ, method, label, err.Error())
+		return "", err
+	}
+	if !verified {
+		return "", nil
+	}
+	return aid, nil
+}
+
+// CheckVerified checks ACL of method for aid which has been verified, such as the caller verified by the
+// in-process HTTP server of gateway, signature is not required. Empty aid means there is no verified caller,
+// only rules allowing all callers and DefaultAllow apply.
+func (g *CallerGuard) CheckVerified(method, aid string) error {
+	var state = g.state.Load().(*callerState)
+	if !state.config.Enable || state.allow(method, aid) {
+		return nil
+	}
+	var label = aid
+	if label == "" {
+		label = callerUnknown
+	}
+	metric.ServerCallerDeniedCounter.Inc(g.typ, method, label, ErrCallerDenied.Error())
+	return ErrCallerDenied
+}
+
+// verify reports whether signature of aid is verified, unsigned caller is allowed without verification if AllowUnsigned
+func (g *CallerGuard) verify(state *callerState, aid, target string, header http.Header) (bool, error) {
+	signature := header.Get(HeaderAIDSign)
+	if signature == "" {
+		if state.config.AllowUnsigned {
+			return false, nil
+		}
+		return false, ErrCallerUnsigned
+	}
+	key, ok := state.keys[aid]
+	if !ok {
+		return false, ErrCallerSignature
+	}
+	timestamp, err := strconv.ParseInt(header.Get(HeaderAIDTimestamp), 10, 64)
+	if err != nil {
+		return false, ErrCallerSignature
+	}
+	if skew := g.now().Sub(time.Unix(timestamp, 0)); skew > state.config.MaxSkew || skew < -state.config.MaxSkew {
+		return false, ErrCallerExpired
+	}
+	if !hmac.Equal([]byte(SignCaller(aid, key, target, timestamp)), []byte(signature)) {
+		return false, ErrCallerSignature
+	}
+	return true, nil
+}
+
+// allow reports whether aid may call method, exact rule first, then longest prefix
+func (state *callerState) allow(method, aid string) bool {
+	var rule *ACLRule
+	var matched = -1
+	for i, r := range state.config.ACL {
+		if r.Method == method {
+			rule = &state.config.ACL[i]
+			break
+		}
+		if prefix := strings.TrimSuffix(r.Method, "*"); prefix != r.Method && strings.HasPrefix(method, prefix) && len(prefix) > matched {
+			rule, matched = &state.config.ACL[i], len(prefix)
+		}
+	}
+	if rule == nil {
+		return state.config.DefaultAllow
+	}
+	for _, allowed := range rule.AIDs {
+		if allowed == "*" || (allowed == aid && aid != "") {
+			return true
+		}
+	}
+	return false
+}
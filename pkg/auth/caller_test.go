@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/conf"
+	"github.com/xqk/ox/pkg/metric"
+	"github.com/xqk/ox/pkg/olog"
+)
+
+func signedHeader(aid, key, target string, timestamp int64) http.Header {
+	header := http.Header{}
+	header.Set(HeaderAID, aid)
+	header.Set(HeaderAIDTimestamp, strconv.FormatInt(timestamp, 10))
+	header.Set(HeaderAIDSign, SignCaller(aid, key, target, timestamp))
+	return header
+}
+
+func check(g *CallerGuard, method string, header http.Header) (string, error) {
+	return g.Check(method, method, header)
+}
+
+func TestCallerGuard(t *testing.T) {
+	config := DefaultCallerConfig()
+	config.Enable = true
+	config.Keys = []AppKey{{AID: "1001", Key: "k1"}, {AID: "1002", Key: "k2"}}
+	config.ACL = []ACLRule{
+		{Method: "/order.Order/*", AIDs: []string{"1001"}},
+		{Method: "/order.Order/Get", AIDs: []string{"1001", "1002"}},
+		{Method: "/public.*", AIDs: []string{"*"}},
+	}
+	g := NewCallerGuard(metric.TypeGRPCUnary, config)
+	now := time.Now().Unix()
+
+	aid, err := check(g, "/order.Order/Create", signedHeader("1001", "k1", "/order.Order/Create", now))
+	assert.Nil(t, err)
+	assert.Equal(t, "1001", aid)
+
+	_, err = check(g, "/order.Order/Create", signedHeader("1002", "k2", "/order.Order/Create", now))
+	assert.Equal(t, ErrCallerDenied, err)
+	_, err = check(g, "/order.Order/Get", signedHeader("1002", "k2", "/order.Order/Get", now))
+	assert.Nil(t, err)
+
+	// 没有匹配的规则时默认允许
+	_, err = check(g, "/user.User/Get", signedHeader("1002", "k2", "/user.User/Get", now))
+	assert.Nil(t, err)
+
+	// 冒充其他应用
+	_, err = check(g, "/order.Order/Create", signedHeader("1001", "k2", "/order.Order/Create", now))
+	assert.Equal(t, ErrCallerSignature, err)
+	_, err = check(g, "/order.Order/Create", signedHeader("1003", "k3", "/order.Order/Create", now))
+	assert.Equal(t, ErrCallerSignature, err)
+	_, err = check(g, "/order.Order/Create", signedHeader("1001", "k1", "/order.Order/Create", now-3600))
+	assert.Equal(t, ErrCallerExpired, err)
+
+	header := http.Header{}
+	header.Set(HeaderAID, "1001")
+	_, err = check(g, "/order.Order/Create", header)
+	assert.Equal(t, ErrCallerUnsigned, err)
+
+	// 签名不能用于其他方法
+	_, err = check(g, "/order.Order/Create", signedHeader("1001", "k1", "/order.Order/Get", now))
+	assert.Equal(t, ErrCallerSignature, err)
+
+	// 允许未签名时仍然校验ACL
+	config = DefaultCallerConfig()
+	config.Enable = true
+	config.AllowUnsigned = true
+	config.DefaultAllow = false
+	config.ACL = []ACLRule{{Method: "/order.Order/*", AIDs: []string{"1001"}}}
+	config.MaxSkew = 0
+	g.Update(config)
+	assert.Equal(t, time.Duration(0), config.MaxSkew)
+	_, err = check(g, "/order.Order/Create", header)
+	assert.Nil(t, err)
+	_, err = check(g, "/user.User/Get", header)
+	assert.Equal(t, ErrCallerDenied, err)
+
+	// 未签名的调用方不作为校验过的调用方返回
+	aid, err = check(g, "/order.Order/Create", header)
+	assert.Nil(t, err)
+	assert.Equal(t, "", aid)
+
+	// 关闭后不校验
+	config.Enable = false
+	g.Update(config)
+	aid, err = check(g, "/user.User/Get", header)
+	assert.Nil(t, err)
+	assert.Equal(t, "", aid)
+}
+
+func TestCallerGuard_Metric(t *testing.T) {
+	config := DefaultCallerConfig()
+	config.Enable = true
+	config.Keys = []AppKey{{AID: "1001", Key: "k1"}}
+	config.ACL = []ACLRule{{Method: "/metric.Metric/*", AIDs: []string{"1002"}}}
+	g := NewCallerGuard(metric.TypeGRPCUnary, config)
+	now := time.Now().Unix()
+	denied := func(aid, reason string) float64 {
+		return testutil.ToFloat64(metric.ServerCallerDeniedCounter.WithLabelValues(metric.TypeGRPCUnary, "/metric.Metric/Get", aid, reason))
+	}
+
+	// 签名未通过时aid标签为unknown
+	_, err := check(g, "/metric.Metric/Get", signedHeader("random", "k", "/metric.Metric/Get", now))
+	assert.Equal(t, ErrCallerSignature, err)
+	assert.Equal(t, float64(1), denied("unknown", ErrCallerSignature.Error()))
+	assert.Equal(t, float64(0), denied("random", ErrCallerSignature.Error()))
+
+	_, err = check(g, "/metric.Metric/Get", signedHeader("1001", "k1", "/metric.Metric/Get", now))
+	assert.Equal(t, ErrCallerDenied, err)
+	assert.Equal(t, float64(1), denied("1001", ErrCallerDenied.Error()))
+}
+
+func TestCallerGuard_CheckVerified(t *testing.T) {
+	config := DefaultCallerConfig()
+	config.Enable = true
+	config.ACL = []ACLRule{
+		{Method: "/order.Order/*", AIDs: []string{"1001"}},
+		{Method: "/public.*", AIDs: []string{"*"}},
+	}
+	g := NewCallerGuard(metric.TypeGRPCUnary, config)
+
+	assert.Nil(t, g.CheckVerified("/order.Order/Create", "1001"))
+	assert.Equal(t, ErrCallerDenied, g.CheckVerified("/order.Order/Create", "1002"))
+	// 没有校验过的调用方时按规则和DefaultAllow校验
+	assert.Equal(t, ErrCallerDenied, g.CheckVerified("/order.Order/Create", ""))
+	assert.Nil(t, g.CheckVerified("/public.Public/Get", ""))
+	assert.Nil(t, g.CheckVerified("/user.User/Get", ""))
+
+	config.DefaultAllow = false
+	g.Update(config)
+	assert.Equal(t, ErrCallerDenied, g.CheckVerified("/user.User/Get", "1001"))
+}
+
+type memDataSource struct {
+	content string
+	changed chan struct{}
+}
+
+func (ds *memDataSource) ReadConfig() ([]byte, error)      { return []byte(ds.content), nil }
+func (ds *memDataSource) IsConfigChanged() <-chan struct{} { return ds.changed }
+func (ds *memDataSource) Close() error                     { return nil }
+
+func TestCallerGuardWatch(t *testing.T) {
+	conf.Reset()
+	defer conf.Reset()
+	ds := &memDataSource{
+		content: `
+[ox.server.grpc.caller]
+	enable = true
+	keys = [{aid = "1001", key = "k1"}]
+`,
+		changed: make(chan struct{}),
+	}
+	defer close(ds.changed)
+	assert.Nil(t, conf.LoadFromDataSource(ds, toml.Unmarshal))
+
+	config := DefaultCallerConfig()
+	assert.Nil(t, conf.UnmarshalKey("ox.server.grpc.caller", config))
+	g := NewCallerGuard(metric.TypeGRPCUnary, config)
+	g.Watch("ox.server.grpc.caller", olog.OxLogger)
+
+	r := httptest.NewRequest(http.MethodGet, "/order", nil)
+	SignCallerHTTP(r, "1001", "k1")
+	_, err := g.Check("/order", CallerTarget(r), r.Header)
+	assert.Nil(t, err)
+
+	// 更换密钥并限制访问
+	ds.content = `
+[ox.server.grpc.caller]
+	enable = true
+	keys = [{aid = "1001", key = "k2"}]
+	acl = [{method = "/order", aids = ["1002"]}]
+`
+	ds.changed <- struct{}{}
+	assert.Eventually(t, func() bool {
+		_, err := g.Check("/order", CallerTarget(r), r.Header)
+		return err == ErrCallerSignature
+	}, time.Second, 10*time.Millisecond)
+
+	SignCallerHTTP(r, "1001", "k2")
+	_, err = g.Check("/order", CallerTarget(r), r.Header)
+	assert.Equal(t, ErrCallerDenied, err)
+
+	// 签名绑定请求方法和路径
+	_, err = g.Check("/order", "POST /order", r.Header)
+	assert.Equal(t, ErrCallerSignature, err)
+}
@@ -0,0 +1,120 @@
+// Package auth 服务端统一认证, 内置jwt、api key和hmac签名三种方式,
+// 认证通过的调用方通过NewContext放入context, 业务使用FromContext获取
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Config 认证配置, 位于 ox.server.<name>.auth
+type Config struct {
+	// Enable 开启认证, 默认关闭
+	Enable bool `json:"enable" toml:"enable"`
+	// Skip 跳过认证的grpc完整方法名或http路由, 以*结尾时按前缀匹配
+	Skip []string `json:"skip" toml:"skip"`
+	// JWT jwt认证, 为空时不开启
+	JWT *JWTConfig `json:"jwt" toml:"jwt"`
+	// APIKey 静态api key认证, 为空时不开启
+	APIKey *APIKeyConfig `json:"apiKey" toml:"apiKey"`
+	// HMAC hmac签名认证, 为空时不开启
+	HMAC *HMACConfig `json:"hmac" toml:"hmac"`
+}
+
+// JWTConfig jwt认证配置, 支持HS256/384/512和RS256/384/512
+type JWTConfig struct {
+	// Header 携带token的头, 默认Authorization, 值为 Bearer <token>
+	Header string `json:"header" toml:"header"`
+	// Algorithms 允许的签名算法, 默认全部支持的算法
+	Algorithms []string `json:"algorithms" toml:"algorithms"`
+	// Secret HS算法的密钥
+	Secret string `json:"secret" toml:"secret"`
+	// SecretFile 从文件读取HS算法的密钥
+	SecretFile string `json:"secretFile" toml:"secretFile"`
+	// PublicKeyFiles RS算法的PEM格式公钥或证书文件
+	PublicKeyFiles []string `json:"publicKeyFiles" toml:"publicKeyFiles"`
+	// JWKSFile 本地JWKS文件
+	JWKSFile string `json:"jwksFile" toml:"jwksFile"`
+	// JWKSURL 本地提供的JWKS地址, 遇到未知的kid时重新拉取
+	JWKSURL string `json:"jwksURL" toml:"jwksURL"`
+	// JWKSRefreshInterval 两次拉取JWKS的最小间隔, 默认1m
+	JWKSRefreshInterval time.Duration `json:"jwksRefreshInterval" toml:"jwksRefreshInterval"`
+	// Issuer 不为空时校验iss
+	Issuer string `json:"issuer" toml:"issuer"`
+	// Audience 不为空时校验aud
+	Audience string `json:"audience" toml:"audience"`
+	// Leeway 校验exp和nbf时允许的时钟偏差
+	Leeway time.Duration `json:"leeway" toml:"leeway"`
+}
+
+// APIKeyConfig 静态api key认证配置
+type APIKeyConfig struct {
+	// Header 携带api key的头, 默认X-Api-Key
+	Header string `json:"header" toml:"header"`
+	// Keys 允许的api key
+	Keys []APIKey `json:"keys" toml:"keys"`
+}
+
+// APIKey ...
+type APIKey struct {
+	Key string `json:"key" toml:"key"`
+	// Subject 认证通过后调用方的标识
+	Subject string `json:"subject" toml:"subject"`
+}
+
+// HMACConfig hmac签名认证配置, 签名方式见Sign
+type HMACConfig struct {
+	// Keys 允许的签名密钥
+	Keys []HMACKey `json:"keys" toml:"keys"`
+	// MaxSkew 签名时间与服务端时间允许的最大偏差, 默认5m, 随机数在2*MaxSkew内不能重复使用
+	MaxSkew time.Duration `json:"maxSkew" toml:"maxSkew"`
+	// MaxBodySize 校验签名时读取的最大请求体, 默认4MB
+	MaxBodySize int64 `json:"maxBodySize" toml:"maxBodySize"`
+}
+
+// HMACKey ...
+type HMACKey struct {
+	// ID 密钥标识, 认证通过后作为调用方的标识
+	ID     string `json:"id" toml:"id"`
+	Secret string `json:"secret" toml:"secret"`
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Enable: false,
+	}
+}
+
+// Build 按配置创建认证器, 密钥文件读取失败时返回错误
+func (config *Config) Build() (*Auth, error) {
+	var a = &Auth{config: config}
+	if config.JWT != nil {
+		provider, err := newJWTProvider(config.JWT)
+		if err != nil {
+			return nil, fmt.Errorf("build jwt auth: %v", err)
+		}
+		a.providers = append(a.providers, provider)
+	}
+	if config.APIKey != nil {
+		a.providers = append(a.providers, newAPIKeyProvider(config.APIKey))
+	}
+	if config.HMAC != nil {
+		a.providers = append(a.providers, newHMACProvider(config.HMAC))
+	}
+	return a, nil
+}
+
+// skip reports whether route is in allow-list, exact match or prefix match with "*"
+func (config *Config) skip(route string) bool {
+	for _, pattern := range config.Skip {
+		if pattern == route {
+			return true
+		}
+		if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern && strings.HasPrefix(route, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// SchemeHMAC ...
+const SchemeHMAC = "hmac"
+
+const (
+	// HeaderKeyID 签名密钥标识
+	HeaderKeyID = "X-Ox-Key-Id"
+	// HeaderTimestamp 签名时间, unix秒
+	HeaderTimestamp = "X-Ox-Timestamp"
+	// HeaderNonce 随机数, 相同密钥的随机数在签名有效期内只能使用一次
+	HeaderNonce = "X-Ox-Nonce"
+	// HeaderSignature 签名, 小写十六进制
+	HeaderSignature = "X-Ox-Signature"
+)
+
+var (
+	errSignatureExpired = errors.New("signature expired")
+	errNonceReused      = errors.New("nonce reused")
+)
+
+// Sign returns hex hmac-sha256 of "method\nuri\ntimestamp\nnonce\nhex(sha256(body))".
+// uri of http requests is path with raw query, method of grpc requests is MethodGRPC,
+// uri is full method and body is deterministic marshaled request of unary call, empty for streams.
+func Sign(secret, method, uri string, timestamp int64, nonce string, body []byte) string {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + uri + "\n" + strconv.FormatInt(timestamp, 10) + "\n" + nonce + "\n" + hex.EncodeToString(digest[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignHTTP signs http request, body must be the same as r.Body
+func SignHTTP(r *http.Request, keyID, secret string, body []byte) {
+	timestamp, nonce := time.Now().Unix(), newNonce()
+	r.Header.Set(HeaderKeyID, keyID)
+	r.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	r.Header.Set(HeaderNonce, nonce)
+	r.Header.Set(HeaderSignature, Sign(secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body))
+}
+
+// HMACUnaryClientInterceptor signs every grpc unary call with request message
+func HMACUnaryClientInterceptor(keyID, secret string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		body, err := marshalMessage(req)
+		if err != nil {
+			return err
+		}
+		return invoker(signGRPC(ctx, method, keyID, secret, body), method, req, reply, cc, opts...)
+	}
+}
+
+// HMACStreamClientInterceptor signs every grpc stream, messages of streams are not signed
+func HMACStreamClientInterceptor(keyID, secret string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(signGRPC(ctx, method, keyID, secret, nil), desc, cc, method, opts...)
+	}
+}
+
+func signGRPC(ctx context.Context, method, keyID, secret string, body []byte) context.Context {
+	timestamp, nonce := time.Now().Unix(), newNonce()
+	return metadata.AppendToOutgoingContext(ctx,
+		HeaderKeyID, keyID,
+		HeaderTimestamp, strconv.FormatInt(timestamp, 10),
+		HeaderNonce, nonce,
+		HeaderSignature, Sign(secret, MethodGRPC, method, timestamp, nonce, body),
+	)
+}
+
+func newNonce() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+type hmacProvider struct {
+	keys        map[string]string
+	maxSkew     time.Duration
+	maxBodySize int64
+	nonces      *nonceCache
+	now         func() time.Time
+}
+
+func newHMACProvider(config *HMACConfig) *hmacProvider {
+	var p = &hmacProvider{
+		keys:        make(map[string]string, len(config.Keys)),
+		maxSkew:     config.MaxSkew,
+		maxBodySize: config.MaxBodySize,
+		now:         time.Now,
+	}
+	if p.maxSkew <= 0 {
+		p.maxSkew = 5 * time.Minute
+	}
+	if p.maxBodySize <= 0 {
+		p.maxBodySize = 4 << 20
+	}
+	// 签名时间在[now-maxSkew, now+maxSkew]内有效, 随机数需要保留2*maxSkew
+	p.nonces = newNonceCache(2 * p.maxSkew)
+	for _, key := range config.Keys {
+		p.keys[key.ID] = key.Secret
+	}
+	return p
+}
+
+// Scheme implements Provider
+func (p *hmacProvider) Scheme() string {
+	return SchemeHMAC
+}
+
+// Authenticate implements Provider
+func (p *hmacProvider) Authenticate(req *Request) (*Principal, error) {
+	keyID := req.Header.Get(HeaderKeyID)
+	signature := req.Header.Get(HeaderSignature)
+	if keyID == "" || signature == "" {
+		return nil, ErrNoCredentials
+	}
+	secret, ok := p.keys[keyID]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	nonce := req.Header.Get(HeaderNonce)
+	if nonce == "" {
+		return nil, ErrInvalidCredentials
+	}
+	now := p.now()
+	if skew := now.Sub(time.Unix(timestamp, 0)); skew > p.maxSkew || skew < -p.maxSkew {
+		return nil, errSignatureExpired
+	}
+	body, err := req.body(p.maxBodySize)
+	if err != nil {
+		return nil, err
+	}
+	expected := Sign(secret, req.Method, req.uri(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, ErrInvalidCredentials
+	}
+	// 签名正确后再记录随机数, 避免伪造的请求占用随机数
+	if !p.nonces.add(keyID+":"+nonce, now) {
+		return nil, errNonceReused
+	}
+	return &Principal{Subject: keyID, Scheme: SchemeHMAC}, nil
+}
+
+// nonceCache 记录使用过的随机数, 使用两代map轮换, 每条记录至少保留ttl
+type nonceCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	rotateAt time.Time
+	current  map[string]struct{}
+	previous map[string]struct{}
+}
+
+func newNonceCache(ttl time.Duration) *nonceCache {
+	return &nonceCache{
+		ttl:      ttl,
+		current:  make(map[string]struct{}),
+		previous: make(map[string]struct{}),
+	}
+}
+
+// add returns false if nonce is used
+func (c *nonceCache) add(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rotateAt.IsZero() {
+		c.rotateAt = now.Add(c.ttl)
+	}
+	if !now.Before(c.rotateAt) {
+		c.previous = c.current
+		if now.Sub(c.rotateAt) >= c.ttl {
+			// 长时间没有请求, 全部过期
+			c.previous = make(map[string]struct{})
+		}
+		c.current = make(map[string]struct{})
+		c.rotateAt = now.Add(c.ttl)
+	}
+	if _, ok := c.current[nonce]; ok {
+		return false
+	}
+	if _, ok := c.previous[nonce]; ok {
+		return false
+	}
+	c.current[nonce] = struct{}{}
+	return true
+}
@@ -0,0 +1,387 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SchemeJWT ...
+const SchemeJWT = "jwt"
+
+var (
+	errMalformedToken   = errors.New("malformed token")
+	errUnsupportedAlg   = errors.New("unsupported algorithm")
+	errUnknownKey       = errors.New("unknown key")
+	errInvalidSignature = errors.New("invalid signature")
+	errTokenExpired     = errors.New("token expired")
+	errTokenNotValidYet = errors.New("token not valid yet")
+	errInvalidIssuer    = errors.New("invalid issuer")
+	errInvalidAudience  = errors.New("invalid audience")
+)
+
+var _hashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtProvider struct {
+	config     *JWTConfig
+	header     string
+	algorithms map[string]struct{}
+	secret     []byte
+	now        func() time.Time
+
+	mu sync.RWMutex
+	// keys 有kid的公钥
+	keys map[string]*rsa.PublicKey
+	// anonymous 没有kid的公钥, token没有kid时逐个尝试
+	anonymous []*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+func newJWTProvider(config *JWTConfig) (*jwtProvider, error) {
+	var p = &jwtProvider{
+		config:     config,
+		header:     config.Header,
+		algorithms: make(map[string]struct{}),
+		secret:     []byte(config.Secret),
+		now:        time.Now,
+		keys:       make(map[string]*rsa.PublicKey),
+	}
+	if p.header == "" {
+		p.header = "Authorization"
+	}
+	if len(config.Algorithms) == 0 {
+		for alg := range _hashes {
+			p.algorithms[alg] = struct{}{}
+		}
+	}
+	for _, alg := range config.Algorithms {
+		if _, ok := _hashes[alg]; !ok {
+			return nil, fmt.Errorf("%s: %v", alg, errUnsupportedAlg)
+		}
+		p.algorithms[alg] = struct{}{}
+	}
+
+	if config.SecretFile != "" {
+		bs, err := ioutil.ReadFile(config.SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		p.secret = []byte(strings.TrimSpace(string(bs)))
+	}
+	for _, file := range config.PublicKeyFiles {
+		key, err := loadPublicKey(file)
+		if err != nil {
+			return nil, fmt.Errorf("load %s: %v", file, err)
+		}
+		p.anonymous = append(p.anonymous, key)
+	}
+	if config.JWKSFile != "" {
+		bs, err := ioutil.ReadFile(config.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.addJWKS(bs); err != nil {
+			return nil, fmt.Errorf("load %s: %v", config.JWKSFile, err)
+		}
+	}
+	return p, nil
+}
+
+// Scheme implements Provider
+func (p *jwtProvider) Scheme() string {
+	return SchemeJWT
+}
+
+// Authenticate implements Provider
+func (p *jwtProvider) Authenticate(req *Request) (*Principal, error) {
+	val := req.Header.Get(p.header)
+	if len(val) < 7 || !strings.EqualFold(val[:7], "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+	claims, err := p.verify(strings.TrimSpace(val[7:]))
+	if err != nil {
+		return nil, err
+	}
+	subject, _ := claims["sub"].(string)
+	return &Principal{Subject: subject, Scheme: SchemeJWT, Claims: claims}, nil
+}
+
+func (p *jwtProvider) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformedToken
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errMalformedToken
+	}
+	if _, ok := p.algorithms[header.Alg]; !ok {
+		return nil, errUnsupportedAlg
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+	if err := p.verifySignature(header, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errMalformedToken
+	}
+	if err := p.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (p *jwtProvider) verifySignature(header jwtHeader, signed string, signature []byte) error {
+	hash := _hashes[header.Alg]
+	if strings.HasPrefix(header.Alg, "HS") {
+		if len(p.secret) == 0 {
+			return errUnknownKey
+		}
+		mac := hmac.New(hash.New, p.secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errInvalidSignature
+		}
+		return nil
+	}
+
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	keys := p.publicKeys(header.Kid)
+	if len(keys) == 0 {
+		return errUnknownKey
+	}
+	for _, key := range keys {
+		if rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil {
+			return nil
+		}
+	}
+	return errInvalidSignature
+}
+
+// publicKeys returns candidate keys of kid, JWKS is fetched again if kid is unknown,
+// keys without kid are tried at last
+func (p *jwtProvider) publicKeys(kid string) []*rsa.PublicKey {
+	if kid == "" {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+		var keys = append([]*rsa.PublicKey{}, p.anonymous...)
+		for _, key := range p.keys {
+			keys = append(keys, key)
+		}
+		return keys
+	}
+
+	p.mu.RLock()
+	key, ok := p.keys[kid]
+	p.mu.RUnlock()
+	if !ok && p.refresh() {
+		p.mu.RLock()
+		key, ok = p.keys[kid]
+		p.mu.RUnlock()
+	}
+	if ok {
+		return []*rsa.PublicKey{key}
+	}
+	// 公钥文件没有kid, 逐个尝试
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]*rsa.PublicKey{}, p.anonymous...)
+}
+
+// refresh fetches JWKS from JWKSURL at most once in JWKSRefreshInterval
+func (p *jwtProvider) refresh() bool {
+	if p.config.JWKSURL == "" {
+		return false
+	}
+	var interval = p.config.JWKSRefreshInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	p.mu.Lock()
+	if !p.fetchedAt.IsZero() && p.now().Sub(p.fetchedAt) < interval {
+		p.mu.Unlock()
+		return false
+	}
+	p.fetchedAt = p.now()
+	p.mu.Unlock()
+
+	client := http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(p.config.JWKSURL)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false
+	}
+	return p.addJWKS(bs) == nil
+}
+
+func (p *jwtProvider) addJWKS(bs []byte) error {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(bs, &jwks); err != nil {
+		return err
+	}
+
+	var keys = make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	var anonymous []*rsa.PublicKey
+	for _, jwk := range jwks.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return fmt.Errorf("key %s: %v", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return fmt.Errorf("key %s: %v", jwk.Kid, err)
+		}
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if jwk.Kid == "" {
+			anonymous = append(anonymous, key)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for kid, key := range keys {
+		p.keys[kid] = key
+	}
+	for _, key := range anonymous {
+		if !containsKey(p.anonymous, key) {
+			p.anonymous = append(p.anonymous, key)
+		}
+	}
+	return nil
+}
+
+func containsKey(keys []*rsa.PublicKey, key *rsa.PublicKey) bool {
+	for _, k := range keys {
+		if k.E == key.E && k.N.Cmp(key.N) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *jwtProvider) validate(claims map[string]interface{}) error {
+	var now = p.now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(p.config.Leeway)) {
+		return errTokenExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0).Add(-p.config.Leeway)) {
+		return errTokenNotValidYet
+	}
+	if p.config.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != p.config.Issuer {
+			return errInvalidIssuer
+		}
+	}
+	if p.config.Audience != "" && !hasAudience(claims["aud"], p.config.Audience) {
+		return errInvalidAudience
+	}
+	return nil
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch val := aud.(type) {
+	case string:
+		return val == audience
+	case []interface{}:
+		for _, v := range val {
+			if s, _ := v.(string); s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	bs, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, v)
+}
+
+func loadPublicKey(file string) (*rsa.PublicKey, error) {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bs)
+	if block == nil {
+		return nil, errors.New("no pem block found")
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not a rsa public key")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,325 @@
+// Package introspect 包装任意负载均衡器, 记录解析到的地址和每个SubConn的连接状态, 用于运行时排查
+package introspect
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// Name is the name of introspect balancer
+const Name = "ox_introspect"
+
+var (
+	trackers = sync.Map{}
+	seq      int64
+)
+
+func init() {
+	balancer.Register(builder{})
+}
+
+// Stats is the snapshot of a tracked balancer
+type Stats struct {
+	Balancer      string         `json:"balancer"`
+	Addresses     []string       `json:"addresses"`
+	ResolverError string         `json:"resolverError,omitempty"`
+	SubConns      []SubConnStats `json:"subConns"`
+}
+
+// SubConnStats is the snapshot of a SubConn
+type SubConnStats struct {
+	Addresses []string  `json:"addresses"`
+	State     string    `json:"state"`
+	Error     string    `json:"error,omitempty"`
+	Since     time.Time `json:"since"`
+}
+
+type subConnInfo struct {
+	addresses []string
+	state     connectivity.State
+	err       error
+	since     time.Time
+}
+
+// Tracker records state of the balancer of a ClientConn
+type Tracker struct {
+	id          string
+	mu          sync.RWMutex
+	balancer    string
+	addresses   []string
+	resolverErr error
+	subConns    map[balancer.SubConn]*subConnInfo
+}
+
+// NewTracker creates a tracker, the balancer finds it by id in service config
+func NewTracker() *Tracker {
+	t := &Tracker{
+		id:       strconv.FormatInt(atomic.AddInt64(&seq, 1), 10),
+		subConns: make(map[balancer.SubConn]*subConnInfo),
+	}
+	trackers.Store(t.id, t)
+	return t
+}
+
+// Close unregisters the tracker
+func (t *Tracker) Close() {
+	trackers.Delete(t.id)
+}
+
+// ServiceConfig returns service config which wraps child balancer with introspect balancer
+func (t *Tracker) ServiceConfig(child string, childConfig interface{}) string {
+	if childConfig == nil {
+		childConfig = struct{}{}
+	}
+	bs, _ := json.Marshal(map[string]interface{}{
+		"loadBalancingConfig": []map[string]interface{}{
+			{Name: map[string]interface{}{
+				"id":          t.id,
+				"childPolicy": []map[string]interface{}{{child: childConfig}},
+			}},
+		},
+	})
+	return string(bs)
+}
+
+// Stats returns snapshot of the tracker
+func (t *Tracker) Stats() Stats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	stats := Stats{
+		Balancer:  t.balancer,
+		Addresses: append([]string{}, t.addresses...),
+		SubConns:  make([]SubConnStats, 0, len(t.subConns)),
+	}
+	if t.resolverErr != nil {
+		stats.ResolverError = t.resolverErr.Error()
+	}
+	for _, info := range t.subConns {
+		sc := SubConnStats{
+			Addresses: info.addresses,
+			State:     info.state.String(),
+			Since:     info.since,
+		}
+		if info.err != nil {
+			sc.Error = info.err.Error()
+		}
+		stats.SubConns = append(stats.SubConns, sc)
+	}
+	sort.Slice(stats.SubConns, func(i, j int) bool {
+		return fmt.Sprint(stats.SubConns[i].Addresses) < fmt.Sprint(stats.SubConns[j].Addresses)
+	})
+	return stats
+}
+
+func (t *Tracker) setBalancer(name string) {
+	t.mu.Lock()
+	t.balancer = name
+	t.mu.Unlock()
+}
+
+func (t *Tracker) updateResolverState(state resolver.State) {
+	t.mu.Lock()
+	t.addresses = addrStrings(state.Addresses)
+	t.resolverErr = nil
+	t.mu.Unlock()
+}
+
+func (t *Tracker) updateResolverError(err error) {
+	t.mu.Lock()
+	t.resolverErr = err
+	t.mu.Unlock()
+}
+
+func (t *Tracker) addSubConn(sc balancer.SubConn, addrs []resolver.Address) {
+	t.mu.Lock()
+	t.subConns[sc] = &subConnInfo{addresses: addrStrings(addrs), state: connectivity.Idle, since: time.Now()}
+	t.mu.Unlock()
+}
+
+func (t *Tracker) updateSubConnAddresses(sc balancer.SubConn, addrs []resolver.Address) {
+	t.mu.Lock()
+	if info, ok := t.subConns[sc]; ok {
+		info.addresses = addrStrings(addrs)
+	}
+	t.mu.Unlock()
+}
+
+func (t *Tracker) updateSubConnState(sc balancer.SubConn, state balancer.SubConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	info, ok := t.subConns[sc]
+	if !ok {
+		return
+	}
+	if state.ConnectivityState == connectivity.Shutdown {
+		delete(t.subConns, sc)
+		return
+	}
+	if info.state != state.ConnectivityState {
+		info.since = time.Now()
+	}
+	info.state = state.ConnectivityState
+	if state.ConnectionError != nil {
+		info.err = state.ConnectionError
+	}
+}
+
+func (t *Tracker) removeSubConn(sc balancer.SubConn) {
+	t.mu.Lock()
+	delete(t.subConns, sc)
+	t.mu.Unlock()
+}
+
+func addrStrings(addrs []resolver.Address) []string {
+	rets := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		rets = append(rets, addr.Addr)
+	}
+	return rets
+}
+
+type lbConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+	ID                                string                       `json:"id"`
+	ChildPolicy                       []map[string]json.RawMessage `json:"childPolicy"`
+	child                             string
+	childConfig                       serviceconfig.LoadBalancingConfig
+}
+
+type builder struct{}
+
+// Build ...
+func (builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	return &introspectBalancer{cc: cc, opts: opts}
+}
+
+// Name ...
+func (builder) Name() string {
+	return Name
+}
+
+// ParseConfig implements balancer.ConfigParser
+func (builder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	var config lbConfig
+	if err := json.Unmarshal(js, &config); err != nil {
+		return nil, fmt.Errorf("introspect: unable to unmarshal LBConfig: %v", err)
+	}
+	if len(config.ChildPolicy) != 1 || len(config.ChildPolicy[0]) != 1 {
+		return nil, fmt.Errorf("introspect: exactly one child policy is required")
+	}
+	for name, raw := range config.ChildPolicy[0] {
+		b := balancer.Get(name)
+		if b == nil {
+			return nil, fmt.Errorf("introspect: balancer %q is not registered", name)
+		}
+		config.child = name
+		if parser, ok := b.(balancer.ConfigParser); ok {
+			childConfig, err := parser.ParseConfig(raw)
+			if err != nil {
+				return nil, err
+			}
+			config.childConfig = childConfig
+		}
+	}
+	return &config, nil
+}
+
+type introspectBalancer struct {
+	cc      balancer.ClientConn
+	opts    balancer.BuildOptions
+	child   balancer.Balancer
+	tracker *Tracker
+}
+
+// UpdateClientConnState ...
+func (b *introspectBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	config, ok := s.BalancerConfig.(*lbConfig)
+	if !ok {
+		return fmt.Errorf("introspect: unexpected balancer config %T", s.BalancerConfig)
+	}
+
+	if b.child == nil {
+		if val, ok := trackers.Load(config.ID); ok {
+			b.tracker = val.(*Tracker)
+		} else {
+			// 未找到对应的tracker时仍然记录, 只是不会被展示
+			b.tracker = &Tracker{id: config.ID, subConns: make(map[balancer.SubConn]*subConnInfo)}
+		}
+		b.tracker.setBalancer(config.child)
+		b.child = balancer.Get(config.child).Build(&trackingClientConn{ClientConn: b.cc, tracker: b.tracker}, b.opts)
+	}
+
+	b.tracker.updateResolverState(s.ResolverState)
+	s.BalancerConfig = config.childConfig
+	return b.child.UpdateClientConnState(s)
+}
+
+// ResolverError ...
+func (b *introspectBalancer) ResolverError(err error) {
+	if b.child == nil {
+		return
+	}
+	b.tracker.updateResolverError(err)
+	b.child.ResolverError(err)
+}
+
+// UpdateSubConnState ...
+func (b *introspectBalancer) UpdateSubConnState(sc balancer.SubConn, state balancer.SubConnState) {
+	if b.child == nil {
+		return
+	}
+	b.tracker.updateSubConnState(sc, state)
+	b.child.UpdateSubConnState(sc, state)
+}
+
+// ExitIdle implements balancer.ExitIdler
+func (b *introspectBalancer) ExitIdle() {
+	if ei, ok := b.child.(balancer.ExitIdler); ok {
+		ei.ExitIdle()
+	}
+}
+
+// Close ...
+func (b *introspectBalancer) Close() {
+	if b.child != nil {
+		b.child.Close()
+	}
+}
+
+type trackingClientConn struct {
+	balancer.ClientConn
+	tracker *Tracker
+}
+
+// NewSubConn ...
+func (cc *trackingClientConn) NewSubConn(addrs []resolver.Address, opts balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	sc, err := cc.ClientConn.NewSubConn(addrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	cc.tracker.addSubConn(sc, addrs)
+	return sc, nil
+}
+
+// RemoveSubConn ...
+func (cc *trackingClientConn) RemoveSubConn(sc balancer.SubConn) {
+	cc.tracker.removeSubConn(sc)
+	cc.ClientConn.RemoveSubConn(sc)
+}
+
+// UpdateAddresses ...
+func (cc *trackingClientConn) UpdateAddresses(sc balancer.SubConn, addrs []resolver.Address) {
+	cc.tracker.updateSubConnAddresses(sc, addrs)
+	cc.ClientConn.UpdateAddresses(sc, addrs)
+}
@@ -0,0 +1,48 @@
+package outlier
+
+import (
+	"time"
+
+	"github.com/xqk/ox/pkg/util/otime"
+)
+
+// Config of outlier detection
+type Config struct {
+	// Enable 开启节点摘除
+	Enable bool `json:"enable" toml:"enable"`
+	// ConsecutiveErrors 连续失败次数达到该值时摘除节点, 0表示关闭
+	ConsecutiveErrors int `json:"consecutiveErrors" toml:"consecutiveErrors"`
+	// ErrorRate 统计周期内错误率达到该值时摘除节点(0~1), 0表示关闭
+	ErrorRate float64 `json:"errorRate" toml:"errorRate"`
+	// MinRequests 统计周期内请求数达到该值时才计算错误率
+	MinRequests int `json:"minRequests" toml:"minRequests"`
+	// Interval 统计周期
+	Interval time.Duration `json:"interval" toml:"interval"`
+	// BaseEjectionTime 基础摘除时间, 实际摘除时间为 BaseEjectionTime * 摘除次数
+	BaseEjectionTime time.Duration `json:"baseEjectionTime" toml:"baseEjectionTime"`
+	// MaxEjectionTime 最大摘除时间
+	MaxEjectionTime time.Duration `json:"maxEjectionTime" toml:"maxEjectionTime"`
+	// MaxEjectionPercent 最多摘除节点的百分比(0~100)
+	MaxEjectionPercent int `json:"maxEjectionPercent" toml:"maxEjectionPercent"`
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		ConsecutiveErrors:  5,
+		ErrorRate:          0.5,
+		MinRequests:        20,
+		Interval:           otime.Duration("10s"),
+		BaseEjectionTime:   otime.Duration("30s"),
+		MaxEjectionTime:    otime.Duration("300s"),
+		MaxEjectionPercent: 50,
+	}
+}
+
+func (config *Config) ejectionTime(times int) time.Duration {
+	du := config.BaseEjectionTime * time.Duration(times)
+	if config.MaxEjectionTime > 0 && du > config.MaxEjectionTime {
+		du = config.MaxEjectionTime
+	}
+	return du
+}
@@ -0,0 +1,274 @@
+package outlier
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xqk/ox/pkg/metric"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/util/op2c"
+)
+
+const (
+	// ReasonConsecutiveErrors ...
+	ReasonConsecutiveErrors = "consecutive_errors"
+	// ReasonErrorRate ...
+	ReasonErrorRate = "error_rate"
+)
+
+var (
+	detectors = sync.Map{}
+)
+
+// Range 遍历所有探测器
+func Range(fn func(target string, d *Detector) bool) {
+	detectors.Range(func(key, val interface{}) bool {
+		return fn(key.(string), val.(*Detector))
+	})
+}
+
+type node struct {
+	consecutive int
+	success     int
+	failure     int
+	// ejected 为零值时表示未被摘除
+	ejected time.Time
+	until   time.Time
+	times   int
+	reason  string
+}
+
+// Ejection describes an ejected node
+type Ejection struct {
+	Addr      string    `json:"addr"`
+	Reason    string    `json:"reason"`
+	Times     int       `json:"times"`
+	EjectedAt time.Time `json:"ejectedAt"`
+	Until     time.Time `json:"until"`
+}
+
+// Detector 基于连续失败次数和错误率的节点摘除
+type Detector struct {
+	target string
+	config *Config
+	nodes  map[string]*node
+	mu     sync.Mutex
+	stop   chan struct{}
+	logger *olog.Logger
+}
+
+// NewDetector creates a detector of target, and registers it for introspection.
+// Close should be called after use.
+func NewDetector(target string, config *Config) *Detector {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	d := &Detector{
+		target: target,
+		config: config,
+		nodes:  make(map[string]*node),
+		stop:   make(chan struct{}),
+		logger: olog.OxLogger.With(olog.FieldMod("client.grpc.outlier"), olog.FieldName(target)),
+	}
+	detectors.Store(target, d)
+	go d.sweep()
+	return d
+}
+
+// Target ...
+func (d *Detector) Target() string {
+	return d.target
+}
+
+// SetConfig replaces config of detector
+func (d *Detector) SetConfig(config *Config) {
+	if config == nil {
+		return
+	}
+	d.mu.Lock()
+	d.config = config
+	d.mu.Unlock()
+}
+
+// UpdateAddresses tells detector the current addresses, stats of removed addresses are dropped.
+func (d *Detector) UpdateAddresses(addrs []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var set = make(map[string]struct{}, len(addrs))
+	for _, addr := range addrs {
+		set[addr] = struct{}{}
+		if _, ok := d.nodes[addr]; !ok {
+			d.nodes[addr] = &node{}
+		}
+	}
+	for addr := range d.nodes {
+		if _, ok := set[addr]; !ok {
+			delete(d.nodes, addr)
+		}
+	}
+	d.updateGauge()
+}
+
+// Report records result of a call to addr
+func (d *Detector) Report(addr string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n, ok := d.nodes[addr]
+	if !ok {
+		return
+	}
+	if !op2c.IsFailure(err) {
+		n.consecutive = 0
+		n.success++
+		return
+	}
+
+	n.consecutive++
+	n.failure++
+	if d.config.ConsecutiveErrors > 0 && n.consecutive >= d.config.ConsecutiveErrors {
+		d.eject(addr, n, ReasonConsecutiveErrors)
+	}
+}
+
+// Ejected reports whether addr is ejected now
+func (d *Detector) Ejected(addr string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n, ok := d.nodes[addr]
+	if !ok || n.ejected.IsZero() {
+		return false
+	}
+	if time.Now().After(n.until) {
+		d.uneject(addr, n)
+		return false
+	}
+	return true
+}
+
+// Ejections returns all ejected nodes
+func (d *Detector) Ejections() []Ejection {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var rets = make([]Ejection, 0)
+	for addr, n := range d.nodes {
+		if n.ejected.IsZero() {
+			continue
+		}
+		rets = append(rets, Ejection{
+			Addr:      addr,
+			Reason:    n.reason,
+			Times:     n.times,
+			EjectedAt: n.ejected,
+			Until:     n.until,
+		})
+	}
+	return rets
+}
+
+// Close stops the detector, nodes are dropped so that pickers still holding it no longer eject nodes
+func (d *Detector) Close() {
+	close(d.stop)
+	d.mu.Lock()
+	d.nodes = make(map[string]*node)
+	d.mu.Unlock()
+	if val, ok := detectors.Load(d.target); ok && val == d {
+		detectors.Delete(d.target)
+	}
+	metric.ClientEjectedGauge.Set(0, metric.TypeGRPCUnary, d.target)
+}
+
+func (d *Detector) sweep() {
+	d.mu.Lock()
+	interval := d.config.Interval
+	d.mu.Unlock()
+	if interval <= 0 {
+		interval = time.Second * 10
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.check()
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+func (d *Detector) check() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for addr, n := range d.nodes {
+		if !n.ejected.IsZero() {
+			if now.After(n.until) {
+				d.uneject(addr, n)
+			}
+			continue
+		}
+
+		total := n.success + n.failure
+		if d.config.ErrorRate > 0 && total > 0 && total >= d.config.MinRequests &&
+			float64(n.failure)/float64(total) >= d.config.ErrorRate {
+			d.eject(addr, n, ReasonErrorRate)
+		} else if n.failure == 0 && n.times > 0 {
+			// 节点恢复正常, 逐步降低摘除时间
+			n.times--
+		}
+		n.success, n.failure = 0, 0
+	}
+}
+
+func (d *Detector) eject(addr string, n *node, reason string) {
+	if !n.ejected.IsZero() {
+		return
+	}
+
+	var ejected int
+	for _, other := range d.nodes {
+		if !other.ejected.IsZero() {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > len(d.nodes)*d.config.MaxEjectionPercent {
+		d.logger.Warn("outlier ejection skipped, max ejection percent reached", olog.FieldAddr(addr), olog.String("reason", reason))
+		return
+	}
+
+	now := time.Now()
+	n.times++
+	n.ejected = now
+	n.until = now.Add(d.config.ejectionTime(n.times))
+	n.reason = reason
+	n.consecutive = 0
+	n.success, n.failure = 0, 0
+
+	d.logger.Warn("outlier ejected", olog.FieldAddr(addr), olog.String("reason", reason), olog.Any("until", n.until))
+	metric.ClientEjectionCounter.Inc(metric.TypeGRPCUnary, d.target, addr, reason)
+	d.updateGauge()
+}
+
+func (d *Detector) uneject(addr string, n *node) {
+	n.ejected = time.Time{}
+	n.until = time.Time{}
+	n.reason = ""
+	d.logger.Info("outlier unejected", olog.FieldAddr(addr))
+	d.updateGauge()
+}
+
+func (d *Detector) updateGauge() {
+	var ejected int
+	for _, n := range d.nodes {
+		if !n.ejected.IsZero() {
+			ejected++
+		}
+	}
+	metric.ClientEjectedGauge.Set(float64(ejected), metric.TypeGRPCUnary, d.target)
+}
@@ -0,0 +1,116 @@
+package outlier
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errUnavailable = status.Error(codes.Unavailable, "unavailable")
+
+func newTestDetector(config *Config) *Detector {
+	d := NewDetector("test:///"+time.Now().String(), config)
+	d.UpdateAddresses([]string{"a", "b", "c", "d"})
+	return d
+}
+
+func TestConsecutiveErrors(t *testing.T) {
+	config := DefaultConfig()
+	config.ConsecutiveErrors = 3
+	d := newTestDetector(config)
+	defer d.Close()
+
+	d.Report("a", errUnavailable)
+	d.Report("a", errUnavailable)
+	d.Report("a", nil)
+	d.Report("a", errUnavailable)
+	d.Report("a", errUnavailable)
+	assert.False(t, d.Ejected("a"))
+
+	d.Report("a", errUnavailable)
+	assert.True(t, d.Ejected("a"))
+	assert.Len(t, d.Ejections(), 1)
+	assert.Equal(t, ReasonConsecutiveErrors, d.Ejections()[0].Reason)
+
+	// business errors never eject a node
+	for i := 0; i < 10; i++ {
+		d.Report("b", status.Error(codes.InvalidArgument, "invalid"))
+	}
+	assert.False(t, d.Ejected("b"))
+}
+
+func TestErrorRate(t *testing.T) {
+	config := DefaultConfig()
+	config.ConsecutiveErrors = 0
+	config.ErrorRate = 0.5
+	config.MinRequests = 4
+	d := newTestDetector(config)
+	defer d.Close()
+
+	d.Report("a", errors.New("failed"))
+	d.Report("a", nil)
+	d.Report("a", errors.New("failed"))
+	d.Report("b", errors.New("failed"))
+	d.check()
+	assert.False(t, d.Ejected("a"))
+
+	for i := 0; i < 3; i++ {
+		d.Report("a", errors.New("failed"))
+	}
+	d.Report("a", nil)
+	d.check()
+	assert.True(t, d.Ejected("a"))
+	assert.False(t, d.Ejected("b"))
+}
+
+func TestMaxEjectionPercent(t *testing.T) {
+	config := DefaultConfig()
+	config.ConsecutiveErrors = 1
+	config.MaxEjectionPercent = 50
+	d := newTestDetector(config)
+	defer d.Close()
+
+	for _, addr := range []string{"a", "b", "c", "d"} {
+		d.Report(addr, errUnavailable)
+	}
+	assert.Len(t, d.Ejections(), 2)
+}
+
+func TestEjectionTime(t *testing.T) {
+	config := DefaultConfig()
+	config.ConsecutiveErrors = 1
+	config.BaseEjectionTime = time.Millisecond * 50
+	d := newTestDetector(config)
+	defer d.Close()
+
+	d.Report("a", errUnavailable)
+	assert.True(t, d.Ejected("a"))
+	time.Sleep(time.Millisecond * 60)
+	assert.False(t, d.Ejected("a"))
+
+	// ejection time grows with ejection times
+	d.Report("a", errUnavailable)
+	time.Sleep(time.Millisecond * 60)
+	assert.True(t, d.Ejected("a"))
+}
+
+func TestClose(t *testing.T) {
+	config := DefaultConfig()
+	config.ConsecutiveErrors = 1
+	d := newTestDetector(config)
+
+	d.Report("a", errUnavailable)
+	assert.True(t, d.Ejected("a"))
+
+	// 关闭后不再摘除节点
+	d.Close()
+	assert.False(t, d.Ejected("a"))
+	d.Report("b", errUnavailable)
+	assert.False(t, d.Ejected("b"))
+	_, ok := detectors.Load(d.Target())
+	assert.False(t, ok)
+}
@@ -0,0 +1,19 @@
+package outlier
+
+import (
+	"net/http"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/xqk/ox/pkg/governor"
+)
+
+func init() {
+	governor.HandleFunc("/debug/client/grpc/outliers", func(w http.ResponseWriter, r *http.Request) {
+		var rets = make(map[string][]Ejection)
+		Range(func(target string, d *Detector) bool {
+			rets[target] = d.Ejections()
+			return true
+		})
+		_ = jsoniter.NewEncoder(w).Encode(rets)
+	})
+}
@@ -0,0 +1,155 @@
+package p2c
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/serviceconfig"
+	"github.com/xqk/ox/pkg/client/grpc/balancer/outlier"
+	"github.com/xqk/ox/pkg/util/op2c"
+)
+
+// LBConfig is the load balancing config of p2c balancers
+type LBConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+	// OutlierDetection enables outlier detection if not nil
+	OutlierDetection *outlier.Config `json:"outlierDetection"`
+}
+
+type p2cBuilder struct {
+	name   string
+	newP2c func() op2c.P2c
+}
+
+// newBuilder creates a new balance builder.
+func newBuilder(name string, newP2c func() op2c.P2c) balancer.Builder {
+	return &p2cBuilder{name: name, newP2c: newP2c}
+}
+
+// Build ...
+func (b *p2cBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &p2cPickerBuilder{newP2c: b.newP2c}
+	return &p2cBalancer{
+		Balancer:      base.NewBalancerBuilder(b.name, pb, base.Config{HealthCheck: true}).Build(cc, opts),
+		pickerBuilder: pb,
+		target:        fmt.Sprintf("%s://%s/%s", opts.Target.Scheme, opts.Target.Authority, opts.Target.Endpoint),
+	}
+}
+
+// Name ...
+func (b *p2cBuilder) Name() string {
+	return b.name
+}
+
+// ParseConfig implements balancer.ConfigParser
+func (b *p2cBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	var config LBConfig
+	if err := json.Unmarshal(js, &config); err != nil {
+		return nil, fmt.Errorf("p2c: unable to unmarshal LBConfig: %v", err)
+	}
+	return &config, nil
+}
+
+type p2cBalancer struct {
+	balancer.Balancer
+	pickerBuilder *p2cPickerBuilder
+	target        string
+}
+
+// UpdateClientConnState ...
+func (b *p2cBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	if config, ok := s.BalancerConfig.(*LBConfig); ok && config.OutlierDetection != nil && config.OutlierDetection.Enable {
+		if b.pickerBuilder.detector == nil {
+			b.pickerBuilder.detector = outlier.NewDetector(b.target, config.OutlierDetection)
+		} else {
+			b.pickerBuilder.detector.SetConfig(config.OutlierDetection)
+		}
+	} else if b.pickerBuilder.detector != nil {
+		// 关闭后停止探测, 已摘除的节点恢复
+		b.pickerBuilder.detector.Close()
+		b.pickerBuilder.detector = nil
+	}
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+// Close ...
+func (b *p2cBalancer) Close() {
+	b.Balancer.Close()
+	if b.pickerBuilder.detector != nil {
+		b.pickerBuilder.detector.Close()
+	}
+}
+
+type p2cPickerBuilder struct {
+	newP2c   func() op2c.P2c
+	detector *outlier.Detector
+}
+
+// Build ...
+func (b *p2cPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	grpclog.Infof("p2cPickerBuilder: newPicker called with readySCs: %v", info.ReadySCs)
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	var p2c = b.newP2c()
+	var addrs = make([]string, 0, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		p2c.Add(&subConn{SubConn: sc, addr: sci.Address.Addr})
+		addrs = append(addrs, sci.Address.Addr)
+	}
+
+	if b.detector != nil {
+		b.detector.UpdateAddresses(addrs)
+	}
+
+	return &p2cPicker{
+		p2c:      p2c,
+		size:     len(addrs),
+		detector: b.detector,
+	}
+}
+
+type subConn struct {
+	balancer.SubConn
+	addr string
+}
+
+type p2cPicker struct {
+	p2c      op2c.P2c
+	size     int
+	detector *outlier.Detector
+}
+
+// Pick ...
+func (p *p2cPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	var sc *subConn
+	var done func(balancer.DoneInfo)
+	for i := 0; i < p.size; i++ {
+		item, fn := p.p2c.Next()
+		if item == nil {
+			return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+		}
+		sc, done = item.(*subConn), fn
+
+		// 所有尝试都命中被摘除的节点时, 使用最后一次选中的节点
+		if p.detector == nil || i == p.size-1 || !p.detector.Ejected(sc.addr) {
+			break
+		}
+		// 释放未使用的节点, 不计入统计
+		done(balancer.DoneInfo{Err: op2c.ErrReleased})
+	}
+
+	return balancer.PickResult{
+		SubConn: sc.SubConn,
+		Done: func(di balancer.DoneInfo) {
+			done(di)
+			if p.detector != nil {
+				p.detector.Report(sc.addr, di.Err)
+			}
+		},
+	}, nil
+}
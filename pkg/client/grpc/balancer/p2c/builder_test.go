@@ -0,0 +1,51 @@
+package p2c
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/client/grpc/balancer/outlier"
+	"google.golang.org/grpc/balancer"
+)
+
+type nopBalancer struct {
+	balancer.Balancer
+}
+
+func (nopBalancer) UpdateClientConnState(balancer.ClientConnState) error { return nil }
+
+func (nopBalancer) Close() {}
+
+func TestP2cBalancer_OutlierDetection(t *testing.T) {
+	b := &p2cBalancer{
+		Balancer:      nopBalancer{},
+		pickerBuilder: &p2cPickerBuilder{},
+		target:        "test:///" + time.Now().String(),
+	}
+	update := func(enable bool) {
+		config := outlier.DefaultConfig()
+		config.Enable = enable
+		assert.Nil(t, b.UpdateClientConnState(balancer.ClientConnState{BalancerConfig: &LBConfig{OutlierDetection: config}}))
+	}
+
+	update(true)
+	detector := b.pickerBuilder.detector
+	assert.NotNil(t, detector)
+	update(true)
+	assert.Equal(t, detector, b.pickerBuilder.detector)
+
+	// 关闭后停止探测器
+	update(false)
+	assert.Nil(t, b.pickerBuilder.detector)
+	var found bool
+	outlier.Range(func(target string, d *outlier.Detector) bool {
+		found = found || d == detector
+		return true
+	})
+	assert.False(t, found)
+
+	update(true)
+	assert.NotNil(t, b.pickerBuilder.detector)
+	b.Close()
+}
@@ -0,0 +1,15 @@
+package p2c
+
+import (
+	"google.golang.org/grpc/balancer"
+	"github.com/xqk/ox/pkg/util/op2c/ewma"
+)
+
+// NameEWMA is the name of p2c balancer weighted by inflight requests, ewma latency and error rate.
+const (
+	NameEWMA = "p2c_ewma"
+)
+
+func init() {
+	balancer.Register(newBuilder(NameEWMA, ewma.New))
+}
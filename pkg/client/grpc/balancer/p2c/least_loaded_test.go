@@ -72,8 +72,7 @@ func startTestServers(count int) (_ *test, err error) {
 
 func TestOneBackend(t *testing.T) {
 
-	r, cleanup := manual.GenerateAndRegisterManualResolver()
-	defer cleanup()
+	r := manual.NewBuilderWithScheme("p2c")
 
 	test, err := startTestServers(1)
 	if err != nil {
@@ -81,7 +80,7 @@ func TestOneBackend(t *testing.T) {
 	}
 	defer test.cleanup()
 
-	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithBalancerName(p2c.Name))
+	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithResolvers(r), grpc.WithBalancerName(p2c.Name))
 	if err != nil {
 		t.Fatalf("failed to dial: %v", err)
 	}
@@ -103,8 +102,7 @@ func TestOneBackend(t *testing.T) {
 
 func TestAddressesRemoved(t *testing.T) {
 
-	r, cleanup := manual.GenerateAndRegisterManualResolver()
-	defer cleanup()
+	r := manual.NewBuilderWithScheme("p2c")
 
 	test, err := startTestServers(2)
 	if err != nil {
@@ -112,7 +110,7 @@ func TestAddressesRemoved(t *testing.T) {
 	}
 	defer test.cleanup()
 
-	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithBalancerName(p2c.Name))
+	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithResolvers(r), grpc.WithBalancerName(p2c.Name))
 	if err != nil {
 		t.Fatalf("failed to dial: %v", err)
 	}
@@ -140,8 +138,7 @@ func TestAddressesRemoved(t *testing.T) {
 
 func TestOneServerDown(t *testing.T) {
 
-	r, cleanup := manual.GenerateAndRegisterManualResolver()
-	defer cleanup()
+	r := manual.NewBuilderWithScheme("p2c")
 
 	backendCount := 3
 	test, err := startTestServers(backendCount)
@@ -150,7 +147,7 @@ func TestOneServerDown(t *testing.T) {
 	}
 	defer test.cleanup()
 
-	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithBalancerName(p2c.Name))
+	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithResolvers(r), grpc.WithBalancerName(p2c.Name))
 	if err != nil {
 		t.Fatalf("failed to dial: %v", err)
 	}
@@ -237,8 +234,7 @@ func TestOneServerDown(t *testing.T) {
 }
 func TestBackendsRandom(t *testing.T) {
 
-	r, cleanup := manual.GenerateAndRegisterManualResolver()
-	defer cleanup()
+	r := manual.NewBuilderWithScheme("p2c")
 
 	backendCount := 5
 	test, err := startTestServers(backendCount)
@@ -247,7 +243,7 @@ func TestBackendsRandom(t *testing.T) {
 	}
 	defer test.cleanup()
 
-	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithBalancerName(p2c.Name))
+	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithResolvers(r), grpc.WithBalancerName(p2c.Name))
 	if err != nil {
 		t.Fatalf("failed to dial: %v", err)
 	}
@@ -293,8 +289,7 @@ func TestBackendsRandom(t *testing.T) {
 
 func TestCloseWithPendingRPC(t *testing.T) {
 
-	r, cleanup := manual.GenerateAndRegisterManualResolver()
-	defer cleanup()
+	r := manual.NewBuilderWithScheme("p2c")
 
 	test, err := startTestServers(1)
 	if err != nil {
@@ -302,7 +297,7 @@ func TestCloseWithPendingRPC(t *testing.T) {
 	}
 	defer test.cleanup()
 
-	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithBalancerName(p2c.Name))
+	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithResolvers(r), grpc.WithBalancerName(p2c.Name))
 	if err != nil {
 		t.Fatalf("failed to dial: %v", err)
 	}
@@ -327,8 +322,7 @@ func TestCloseWithPendingRPC(t *testing.T) {
 
 func TestNewAddressWhileBlocking(t *testing.T) {
 
-	r, cleanup := manual.GenerateAndRegisterManualResolver()
-	defer cleanup()
+	r := manual.NewBuilderWithScheme("p2c")
 
 	test, err := startTestServers(1)
 	if err != nil {
@@ -336,7 +330,7 @@ func TestNewAddressWhileBlocking(t *testing.T) {
 	}
 	defer test.cleanup()
 
-	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithBalancerName(p2c.Name))
+	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithResolvers(r), grpc.WithBalancerName(p2c.Name))
 	if err != nil {
 		t.Fatalf("failed to dial: %v", err)
 	}
@@ -375,8 +369,7 @@ func TestNewAddressWhileBlocking(t *testing.T) {
 
 func TestAllServersDown(t *testing.T) {
 
-	r, cleanup := manual.GenerateAndRegisterManualResolver()
-	defer cleanup()
+	r := manual.NewBuilderWithScheme("p2c")
 
 	backendCount := 3
 	test, err := startTestServers(backendCount)
@@ -385,7 +378,7 @@ func TestAllServersDown(t *testing.T) {
 	}
 	defer test.cleanup()
 
-	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithBalancerName(p2c.Name))
+	cc, err := grpc.Dial(r.Scheme()+":///test.server", grpc.WithInsecure(), grpc.WithResolvers(r), grpc.WithBalancerName(p2c.Name))
 	if err != nil {
 		t.Fatalf("failed to dial: %v", err)
 	}
@@ -2,16 +2,21 @@ package grpc
 
 import (
 	"context"
+	"github.com/xqk/ox/pkg/client/grpc/resolver"
 	"github.com/xqk/ox/pkg/ecode"
 	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/util/otls"
+	"strings"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
-func newGRPCClient(config *Config) *grpc.ClientConn {
+// newGRPCClient dials with config, conn is used to track the connection
+func newGRPCClient(config *Config, conn *sharedConn) *grpc.ClientConn {
 	var ctx = context.Background()
-	var dialOptions = config.dialOptions
+	var dialOptions = config.buildDialOptions(conn)
 	logger := config.logger.With(
 		olog.FieldMod("client.grpc"),
 		olog.FieldAddr(config.Address),
@@ -27,21 +32,52 @@ func newGRPCClient(config *Config) *grpc.ClientConn {
 		dialOptions = append(dialOptions, grpc.WithBlock())
 	}
 
+	var tlsLoader *otls.Loader
+	if config.TLS != nil {
+		var err error
+		tlsLoader, err = otls.NewLoader(config.TLS)
+		if err != nil {
+			if config.OnDialError == "panic" {
+				logger.Panic("load grpc client tls", olog.FieldErrKind(ecode.ErrKindRequestErr), olog.FieldErr(err))
+			}
+			logger.Error("load grpc client tls", olog.FieldErrKind(ecode.ErrKindRequestErr), olog.FieldErr(err))
+			return nil
+		}
+		dialOptions = append(dialOptions, grpc.WithTransportCredentials(credentials.NewTLS(tlsLoader.ClientConfig())))
+	} else {
+		dialOptions = append(dialOptions, grpc.WithInsecure())
+	}
+
 	if config.KeepAlive != nil {
 		dialOptions = append(dialOptions, grpc.WithKeepaliveParams(*config.KeepAlive))
 	}
 
-	dialOptions = append(dialOptions, grpc.WithBalancerName(config.BalancerName))
+	// 包装负载均衡器, 记录解析到的地址和SubConn的连接状态
+	dialOptions = append(dialOptions, grpc.WithDefaultServiceConfig(conn.tracker.ServiceConfig(config.BalancerName, config.lbConfig())))
+
+	var target = config.Address
+	// 直连模式下不经过注册中心, unix:和unix-abstract:地址由grpc内置的resolver解析
+	if config.Direct && !strings.Contains(target, "://") && !isUnixTarget(target) {
+		target = resolver.SchemeDirect + ":///" + target
+	}
 
-	cc, err := grpc.DialContext(ctx, config.Address, dialOptions...)
+	cc, err := grpc.DialContext(ctx, target, dialOptions...)
 
 	if err != nil {
+		if tlsLoader != nil {
+			_ = tlsLoader.Close()
+		}
 		if config.OnDialError == "panic" {
 			logger.Panic("dial grpc server", olog.FieldErrKind(ecode.ErrKindRequestErr), olog.FieldErr(err))
-		} else {
-			logger.Error("dial grpc server", olog.FieldErrKind(ecode.ErrKindRequestErr), olog.FieldErr(err))
 		}
+		logger.Error("dial grpc server", olog.FieldErrKind(ecode.ErrKindRequestErr), olog.FieldErr(err))
+		return nil
 	}
+	conn.tlsLoader = tlsLoader
 	logger.Info("start grpc client")
 	return cc
 }
+
+func isUnixTarget(target string) bool {
+	return strings.HasPrefix(target, "unix:") || strings.HasPrefix(target, "unix-abstract:")
+}
@@ -2,10 +2,16 @@ package grpc
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/util/onet"
+	"github.com/xqk/ox/pkg/util/otls"
+	"google.golang.org/grpc"
 	"github.com/xqk/ox/pkg/util/otest/proto/testproto"
 	"github.com/xqk/ox/pkg/util/otest/server/yell"
 )
@@ -35,7 +41,7 @@ func TestConfigBlockTrue(t *testing.T) {
 		}()
 		cfg := DefaultConfig()
 		cfg.OnDialError = "panic"
-		newGRPCClient(cfg)
+		dialConn(cfg, "")
 	})
 }
 
@@ -44,7 +50,68 @@ func TestConfigBlockFalse(t *testing.T) {
 		cfg := DefaultConfig()
 		cfg.OnDialError = "panic"
 		cfg.Block = false
-		conn := newGRPCClient(cfg)
-		assert.Equal(t, conn.GetState().String(), "IDLE")
+		conn := dialConn(cfg, "")
+		defer releaseConn(conn)
+		assert.Equal(t, conn.cc.GetState().String(), "IDLE")
 	})
 }
+
+func TestConfigDirect(t *testing.T) {
+	t.Run("test direct resolver", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.Address = directClientAddr
+		cfg.Direct = true
+		conn := dialConn(cfg, "")
+		defer releaseConn(conn)
+		assert.Equal(t, "direct:///"+directClientAddr, conn.cc.Target())
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		res, err := testproto.NewGreeterClient(conn.cc).SayHello(ctx, &testproto.HelloRequest{
+			Name: "hello",
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, res.Message, yell.RespFantasy.Message)
+	})
+}
+
+func TestConfigTLS(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Address = directClientAddr
+	cfg.Direct = true
+	cfg.Block = false
+	cfg.TLS = &otls.Config{ServerName: "localhost"}
+	conn := dialConn(cfg, "")
+	assert.NotNil(t, conn.tlsLoader)
+	assert.Nil(t, releaseConn(conn))
+	assert.Equal(t, "SHUTDOWN", conn.cc.GetState().String())
+}
+
+func TestConfigUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grpc")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.sock")
+
+	l, err := onet.Listen("unix", path, 0600)
+	assert.Nil(t, err)
+	server := grpc.NewServer()
+	testproto.RegisterGreeterServer(server, &yell.FooServer{})
+	go server.Serve(l)
+	defer server.Stop()
+
+	for _, address := range []string{"unix://" + path, "unix:" + path} {
+		cfg := DefaultConfig()
+		cfg.Address = address
+		cfg.Direct = true
+		conn := dialConn(cfg, "")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		res, err := testproto.NewGreeterClient(conn.cc).SayHello(ctx, &testproto.HelloRequest{
+			Name: "hello",
+		})
+		cancel()
+		releaseConn(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, res.Message, yell.RespFantasy.Message)
+	}
+}
@@ -10,6 +10,7 @@ import (
 )
 
 var directClient testproto.GreeterClient
+var directClientAddr string
 
 func TestMain(m *testing.M) {
 	l, s := startServer("127.0.0.1:0", "srv1")
@@ -17,8 +18,9 @@ func TestMain(m *testing.M) {
 
 	cfg := DefaultConfig()
 	cfg.Address = l.Addr().String()
+	directClientAddr = cfg.Address
 
-	conn := newGRPCClient(cfg)
+	conn := dialConn(cfg, "").cc
 	directClient = testproto.NewGreeterClient(conn)
 	m.Run()
 	s.Stop()
@@ -4,10 +4,13 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/balancer/roundrobin"
 	"google.golang.org/grpc/keepalive"
+	"github.com/xqk/ox/pkg/client/grpc/balancer/outlier"
 	"github.com/xqk/ox/pkg/conf"
 	"github.com/xqk/ox/pkg/ecode"
 	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/util/oaccess"
 	"github.com/xqk/ox/pkg/util/otime"
+	"github.com/xqk/ox/pkg/util/otls"
 	"time"
 )
 
@@ -19,29 +22,39 @@ type Config struct {
 	Block        bool
 	DialTimeout  time.Duration
 	ReadTimeout  time.Duration
-	Direct       bool
-	OnDialError  string // panic | error
-	KeepAlive    *keepalive.ClientParameters
-	logger       *olog.Logger
-	dialOptions  []grpc.DialOption
+	// Direct 直连Address, 不经过注册中心, 也可以使用 static:/// direct:/// odns:/// 等地址,
+	// unix:///path/to/app.sock 和 unix-abstract:name 连接unix domain socket
+	Direct      bool
+	OnDialError string // panic | error
+	KeepAlive   *keepalive.ClientParameters
+	// TLS 开启TLS/mTLS, 为空时使用明文传输
+	TLS *otls.Config
+	// OutlierDetection 节点摘除配置, 仅p2c负载均衡支持, 默认关闭
+	OutlierDetection *outlier.Config
+	logger           *olog.Logger
+	dialOptions      []grpc.DialOption
 
 	SlowThreshold time.Duration
 
-	Debug                     bool
-	DisableTraceInterceptor   bool
-	DisableAidInterceptor     bool
+	Debug                   bool
+	DisableTraceInterceptor bool
+	DisableAidInterceptor   bool
+	// AppKey 当前应用的签名密钥, 不为空时对aid签名, 服务端据此校验调用方身份
+	AppKey                    string
 	DisableTimeoutInterceptor bool
 	DisableMetricInterceptor  bool
 	DisableAccessInterceptor  bool
-	AccessInterceptorLevel    string
+	// AccessInterceptorLevel 为info时记录成功请求的访问日志
+	// Deprecated: 使用Access.Level
+	AccessInterceptorLevel string
+	// Access 访问日志的级别、采样、截断和脱敏配置
+	Access *oaccess.Config
 }
 
 // DefaultConfig ...
 func DefaultConfig() *Config {
 	return &Config{
-		dialOptions: []grpc.DialOption{
-			grpc.WithInsecure(),
-		},
+		dialOptions:            []grpc.DialOption{},
 		logger:                 olog.OxLogger.With(olog.FieldMod(ecode.ModClientGrpc)),
 		BalancerName:           roundrobin.Name, // round robin by default
 		DialTimeout:            time.Second * 3,
@@ -50,6 +63,8 @@ func DefaultConfig() *Config {
 		OnDialError:            "panic",
 		AccessInterceptorLevel: "info",
 		Block:                  true,
+		OutlierDetection:       outlier.DefaultConfig(),
+		Access:                 oaccess.DefaultConfig(),
 	}
 }
 
@@ -82,43 +97,93 @@ func (config *Config) WithDialOption(opts ...grpc.DialOption) *Config {
 	return config
 }
 
-// Build ...
+// WithOutlierDetection ...
+func (config *Config) WithOutlierDetection(outlierDetection *outlier.Config) *Config {
+	config.OutlierDetection = outlierDetection
+	return config
+}
+
+// lbConfig returns config of the balancer, only p2c balancers parse it
+func (config *Config) lbConfig() map[string]interface{} {
+	return map[string]interface{}{"outlierDetection": config.OutlierDetection}
+}
+
+// accessConfig returns access log config, AccessInterceptorLevel other than info turns off logs of successful requests
+func (config *Config) accessConfig() *oaccess.Config {
+	var access = oaccess.DefaultConfig()
+	if config.Access != nil {
+		access = config.Access
+	}
+	if config.AccessInterceptorLevel != "" && config.AccessInterceptorLevel != "info" {
+		var cfg = *access
+		cfg.Level = oaccess.LevelOff
+		access = &cfg
+	}
+	return access
+}
+
+// Build 立即拨号并返回连接, 连接以Name(为空时为Address)注册, 可以通过Range查看
+// 连接关闭后自动注销, 同名客户端已注册时不覆盖
 func (config *Config) Build() *grpc.ClientConn {
-	if config.Debug {
-		config.dialOptions = append(config.dialOptions,
-			grpc.WithChainUnaryInterceptor(debugUnaryClientInterceptor(config.Address)),
-		)
+	client := newClient(config)
+	client.conn = dialConn(config, "")
+	if client.conn == nil {
+		return nil
 	}
+	if _, loaded := instances.LoadOrStore(client.name, client); loaded {
+		config.logger.Warn("grpc client already registered", olog.FieldMod("client.grpc"), olog.FieldName(client.name))
+	}
+	go client.unregisterOnShutdown(client.conn)
+	return client.conn.cc
+}
+
+// BuildClient 返回具名客户端, 首次调用ClientConn时才拨号, 目标地址和配置相同的客户端共享连接
+func (config *Config) BuildClient() *Client {
+	client := newClient(config)
+	instances.Store(client.name, client)
+	return client
+}
+
+// buildDialOptions returns dial options with interceptors, conn is used by stats and debug interceptors
+func (config *Config) buildDialOptions(conn *sharedConn) []grpc.DialOption {
+	var dialOptions = append([]grpc.DialOption{}, config.dialOptions...)
+	// debug拦截器始终安装, 可以在运行时通过governor开启, 请求id始终传递
+	dialOptions = append(dialOptions,
+		grpc.WithChainUnaryInterceptor(debugUnaryClientInterceptor(config.Address, conn.debugEnabled)),
+		grpc.WithChainUnaryInterceptor(statsUnaryClientInterceptor(conn.calls)),
+		grpc.WithChainUnaryInterceptor(requestIDUnaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(requestIDStreamClientInterceptor),
+	)
 
 	if !config.DisableAidInterceptor {
-		config.dialOptions = append(config.dialOptions,
-			grpc.WithChainUnaryInterceptor(aidUnaryClientInterceptor()),
+		dialOptions = append(dialOptions,
+			grpc.WithChainUnaryInterceptor(aidUnaryClientInterceptor(config.AppKey)),
+			grpc.WithChainStreamInterceptor(aidStreamClientInterceptor(config.AppKey)),
 		)
 	}
 
 	if !config.DisableTimeoutInterceptor {
-		config.dialOptions = append(config.dialOptions,
+		dialOptions = append(dialOptions,
 			grpc.WithChainUnaryInterceptor(timeoutUnaryClientInterceptor(config.logger, config.ReadTimeout, config.SlowThreshold)),
 		)
 	}
 
 	if !config.DisableTraceInterceptor {
-		config.dialOptions = append(config.dialOptions,
+		dialOptions = append(dialOptions,
 			grpc.WithChainUnaryInterceptor(traceUnaryClientInterceptor()),
 		)
 	}
 
 	if !config.DisableAccessInterceptor {
-		config.dialOptions = append(config.dialOptions,
-			grpc.WithChainUnaryInterceptor(loggerUnaryClientInterceptor(config.logger, config.Name, config.AccessInterceptorLevel)),
+		dialOptions = append(dialOptions,
+			grpc.WithChainUnaryInterceptor(loggerUnaryClientInterceptor(config.logger, config.Name, config.accessConfig().Build())),
 		)
 	}
 
 	if !config.DisableMetricInterceptor {
-		config.dialOptions = append(config.dialOptions,
+		dialOptions = append(dialOptions,
 			grpc.WithChainUnaryInterceptor(metricUnaryClientInterceptor(config.Name)),
 		)
 	}
-
-	return newGRPCClient(config)
+	return dialOptions
 }
@@ -7,7 +7,9 @@ import (
 
 	"github.com/BurntSushi/toml"
 	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/client/grpc/balancer/introspect"
 	"github.com/xqk/ox/pkg/conf"
+	"github.com/xqk/ox/pkg/util/oaccess"
 )
 
 func TestConfig(t *testing.T) {
@@ -16,6 +18,12 @@ func TestConfig(t *testing.T) {
 	balancerName="swr"
 	address="127.0.0.1:9091"
 	dialTimeout="10s"
+[ox.client.outlier]
+	balancerName="p2c_ewma"
+	[ox.client.outlier.outlierDetection]
+		enable=true
+		consecutiveErrors=3
+		baseEjectionTime="10s"
 	`
 	assert.Nil(t, conf.LoadFromReader(bytes.NewBufferString(configStr), toml.Unmarshal))
 
@@ -26,5 +34,24 @@ func TestConfig(t *testing.T) {
 		assert.Equal(t, "127.0.0.1:9091", config.Address)
 		assert.Equal(t, false, config.Direct)
 		assert.Equal(t, "panic", config.OnDialError)
+		assert.False(t, config.OutlierDetection.Enable)
+		assert.Equal(t, "info", config.accessConfig().Level)
+	})
+
+	t.Run("deprecated access interceptor level", func(t *testing.T) {
+		config := DefaultConfig()
+		config.AccessInterceptorLevel = "error"
+		assert.Equal(t, oaccess.LevelOff, config.accessConfig().Level)
+		assert.Equal(t, "info", config.Access.Level)
+	})
+
+	t.Run("outlier detection config", func(t *testing.T) {
+		config := StdConfig("outlier")
+		assert.Equal(t, 3, config.OutlierDetection.ConsecutiveErrors)
+		assert.Equal(t, time.Second*10, config.OutlierDetection.BaseEjectionTime)
+		assert.Equal(t, 50, config.OutlierDetection.MaxEjectionPercent)
+		tracker := introspect.NewTracker()
+		defer tracker.Close()
+		assert.Contains(t, tracker.ServiceConfig(config.BalancerName, config.lbConfig()), `"p2c_ewma":{"outlierDetection":{"enable":true,"consecutiveErrors":3`)
 	})
 }
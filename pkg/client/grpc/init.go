@@ -0,0 +1,40 @@
+package grpc
+
+import (
+	"net/http"
+	"strconv"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/xqk/ox/pkg/governor"
+)
+
+func init() {
+	governor.HandleFunc("/debug/client/grpc/stats", func(w http.ResponseWriter, r *http.Request) {
+		var rets = make(map[string]ClientStats)
+		Range(func(name string, client *Client) bool {
+			rets[name] = client.Stats()
+			return true
+		})
+		_ = jsoniter.NewEncoder(w).Encode(rets)
+	})
+
+	// 运行时开关Debug拦截器: /debug/client/grpc/debug?name=xxx&enable=true
+	governor.HandleFunc("/debug/client/grpc/debug", func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		client := Get(name)
+		if client == nil {
+			http.Error(w, "client not found: "+name, http.StatusNotFound)
+			return
+		}
+		enable, err := strconv.ParseBool(r.URL.Query().Get("enable"))
+		if err != nil {
+			http.Error(w, "invalid enable: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		client.SetDebug(enable)
+		_ = jsoniter.NewEncoder(w).Encode(map[string]interface{}{
+			"name":  name,
+			"debug": enable,
+		})
+	})
+}
@@ -0,0 +1,267 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+
+	"github.com/xqk/ox/pkg/client/grpc/balancer/introspect"
+	"github.com/xqk/ox/pkg/util/otls"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+var (
+	instances = sync.Map{}
+
+	// 共享连接, key为目标地址和配置
+	sharedConns   = make(map[string]*sharedConn)
+	sharedConnsMu sync.Mutex
+)
+
+// Range 遍历所有客户端
+func Range(fn func(name string, client *Client) bool) {
+	instances.Range(func(key, val interface{}) bool {
+		return fn(key.(string), val.(*Client))
+	})
+}
+
+// Get 返回已注册的客户端
+func Get(name string) *Client {
+	if ins, ok := instances.Load(name); ok {
+		return ins.(*Client)
+	}
+	return nil
+}
+
+// Invoker 返回已注册的客户端, 不存在时使用ox.client.<name>配置创建
+func Invoker(name string) *Client {
+	if client := Get(name); client != nil {
+		return client
+	}
+
+	config := StdConfig(name)
+	if config.Name == "" {
+		config.Name = name
+	}
+	ins, _ := instances.LoadOrStore(name, newClient(config))
+	return ins.(*Client)
+}
+
+// Client 具名的grpc客户端
+type Client struct {
+	name   string
+	config *Config
+	mu     sync.Mutex
+	conn   *sharedConn
+}
+
+// ClientStats 客户端的运行时状态
+type ClientStats struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+	// State 连接状态, 尚未拨号时为空
+	State string `json:"state"`
+	// Refs 共享该连接的客户端数
+	Refs     int              `json:"refs"`
+	Debug    bool             `json:"debug"`
+	Balancer introspect.Stats `json:"balancer"`
+	Requests int64            `json:"requests"`
+	Errors   map[string]int64 `json:"errors"`
+	Config   *Config          `json:"config"`
+}
+
+func newClient(config *Config) *Client {
+	name := config.Name
+	if name == "" {
+		name = config.Address
+	}
+	return &Client{name: name, config: config}
+}
+
+// Name ...
+func (c *Client) Name() string {
+	return c.name
+}
+
+// Config ...
+func (c *Client) Config() *Config {
+	return c.config
+}
+
+// ClientConn 返回连接, 首次调用时拨号
+func (c *Client) ClientConn() *grpc.ClientConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		c.conn = acquireConn(c.config)
+		if c.conn == nil {
+			return nil
+		}
+	}
+	return c.conn.cc
+}
+
+// SetDebug 运行时开启或关闭Debug拦截器, 共享同一连接的客户端同时生效
+func (c *Client) SetDebug(enable bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config.Debug = enable
+	if c.conn != nil {
+		c.conn.setDebug(enable)
+	}
+}
+
+// Close 释放连接并注销客户端, 共享连接在最后一个客户端关闭时关闭
+func (c *Client) Close() error {
+	instances.Delete(c.name)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	conn := c.conn
+	c.conn = nil
+	return releaseConn(conn)
+}
+
+// unregisterOnShutdown 等待连接被直接关闭后注销客户端并释放连接的资源, 用于Build返回的连接
+func (c *Client) unregisterOnShutdown(conn *sharedConn) {
+	for state := conn.cc.GetState(); state != connectivity.Shutdown; state = conn.cc.GetState() {
+		conn.cc.WaitForStateChange(context.Background(), state)
+	}
+	if ins, ok := instances.Load(c.name); ok && ins == c {
+		instances.Delete(c.name)
+	}
+	conn.release()
+}
+
+// Stats 返回客户端的运行时状态
+func (c *Client) Stats() ClientStats {
+	c.mu.Lock()
+	conn := c.conn
+	stats := ClientStats{
+		Name:   c.name,
+		Target: c.config.Address,
+		Debug:  c.config.Debug,
+		Errors: map[string]int64{},
+		Config: c.config,
+	}
+	c.mu.Unlock()
+	if conn == nil {
+		return stats
+	}
+
+	stats.Target = conn.cc.Target()
+	stats.State = conn.cc.GetState().String()
+	stats.Debug = conn.debugEnabled()
+	stats.Balancer = conn.tracker.Stats()
+	stats.Requests, stats.Errors = conn.calls.snapshot()
+	sharedConnsMu.Lock()
+	stats.Refs = conn.refs
+	sharedConnsMu.Unlock()
+	return stats
+}
+
+type sharedConn struct {
+	key       string
+	cc        *grpc.ClientConn
+	refs      int
+	debug     int32
+	tracker   *introspect.Tracker
+	tlsLoader *otls.Loader
+	calls     *callCounter
+	once      sync.Once
+}
+
+// release 释放连接之外的资源, 可以重复调用
+func (conn *sharedConn) release() {
+	conn.once.Do(func() {
+		conn.tracker.Close()
+		if conn.tlsLoader != nil {
+			_ = conn.tlsLoader.Close()
+		}
+	})
+}
+
+func (conn *sharedConn) debugEnabled() bool {
+	return atomic.LoadInt32(&conn.debug) == 1
+}
+
+func (conn *sharedConn) setDebug(enable bool) {
+	var debug int32
+	if enable {
+		debug = 1
+	}
+	atomic.StoreInt32(&conn.debug, debug)
+}
+
+// dialConn dials a new connection, it is shared by clients with the same key if key is not empty
+func dialConn(config *Config, key string) *sharedConn {
+	conn := &sharedConn{
+		key:     key,
+		refs:    1,
+		tracker: introspect.NewTracker(),
+		calls:   newCallCounter(),
+	}
+	conn.setDebug(config.Debug)
+	conn.cc = newGRPCClient(config, conn)
+	if conn.cc == nil {
+		conn.release()
+		return nil
+	}
+	return conn
+}
+
+func acquireConn(config *Config) *sharedConn {
+	key := shareKey(config)
+	if key == "" {
+		return dialConn(config, "")
+	}
+
+	sharedConnsMu.Lock()
+	defer sharedConnsMu.Unlock()
+	if conn, ok := sharedConns[key]; ok {
+		conn.refs++
+		return conn
+	}
+	conn := dialConn(config, key)
+	if conn != nil {
+		sharedConns[key] = conn
+	}
+	return conn
+}
+
+func releaseConn(conn *sharedConn) error {
+	sharedConnsMu.Lock()
+	conn.refs--
+	if conn.refs > 0 {
+		sharedConnsMu.Unlock()
+		return nil
+	}
+	if conn.key != "" {
+		delete(sharedConns, conn.key)
+	}
+	sharedConnsMu.Unlock()
+
+	conn.release()
+	return conn.cc.Close()
+}
+
+// shareKey 目标地址和配置(除Name和Debug外)都相同的客户端共享连接, 自定义了DialOption的客户端不共享
+// 共享连接的访问日志和监控使用首个拨号的客户端的Name
+func shareKey(config *Config) string {
+	if len(config.dialOptions) > 0 {
+		return ""
+	}
+	var cfg = *config
+	cfg.Name = ""
+	cfg.Debug = false
+	bs, err := json.Marshal(cfg)
+	if err != nil {
+		return ""
+	}
+	return string(bs)
+}
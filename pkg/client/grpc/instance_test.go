@@ -0,0 +1,139 @@
+package grpc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/governor"
+	"github.com/xqk/ox/pkg/util/otest/proto/testproto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClientRegistry(t *testing.T) {
+	newConfig := func(name string) *Config {
+		cfg := DefaultConfig()
+		cfg.Name = name
+		cfg.Address = directClientAddr
+		cfg.Direct = true
+		return cfg
+	}
+
+	foo := newConfig("foo").BuildClient()
+	bar := newConfig("bar").BuildClient()
+	defer foo.Close()
+	defer bar.Close()
+
+	t.Run("lazy dial", func(t *testing.T) {
+		assert.Equal(t, foo, Get("foo"))
+		stats := foo.Stats()
+		assert.Equal(t, "", stats.State)
+		assert.Equal(t, directClientAddr, stats.Target)
+	})
+
+	t.Run("shared conn", func(t *testing.T) {
+		assert.NotNil(t, foo.ClientConn())
+		assert.Equal(t, foo.ClientConn(), bar.ClientConn())
+		assert.Equal(t, 2, foo.Stats().Refs)
+
+		other := newConfig("other")
+		other.ReadTimeout = time.Second * 2
+		client := other.BuildClient()
+		assert.NotEqual(t, foo.ClientConn(), client.ClientConn())
+		assert.Nil(t, client.Close())
+		assert.Nil(t, Get("other"))
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		greeter := testproto.NewGreeterClient(foo.ClientConn())
+		_, err := greeter.SayHello(ctx, &testproto.HelloRequest{Name: "hello"})
+		assert.Nil(t, err)
+		_, err = greeter.SayHello(ctx, &testproto.HelloRequest{Name: "needErr"})
+		assert.Equal(t, codes.DataLoss, status.Code(err))
+
+		stats := foo.Stats()
+		assert.Equal(t, "READY", stats.State)
+		assert.Equal(t, "direct:///"+directClientAddr, stats.Target)
+		assert.Equal(t, "round_robin", stats.Balancer.Balancer)
+		assert.Equal(t, []string{directClientAddr}, stats.Balancer.Addresses)
+		assert.Len(t, stats.Balancer.SubConns, 1)
+		assert.Equal(t, "READY", stats.Balancer.SubConns[0].State)
+		assert.Equal(t, int64(2), stats.Requests)
+		assert.Equal(t, map[string]int64{"DataLoss": 1}, stats.Errors)
+	})
+
+	t.Run("toggle debug", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		governor.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/client/grpc/debug?name=foo&enable=true", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, foo.Stats().Debug)
+		assert.True(t, bar.Stats().Debug)
+
+		rec = httptest.NewRecorder()
+		governor.DefaultServeMux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/client/grpc/debug?name=unknown&enable=true", nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("release shared conn", func(t *testing.T) {
+		cc := bar.ClientConn()
+		assert.Nil(t, bar.Close())
+		assert.NotEqual(t, "SHUTDOWN", cc.GetState().String())
+		assert.Nil(t, foo.Close())
+		assert.Equal(t, "SHUTDOWN", cc.GetState().String())
+	})
+}
+
+func TestBuild(t *testing.T) {
+	newConfig := func() *Config {
+		cfg := DefaultConfig()
+		cfg.Name = "built"
+		cfg.Address = directClientAddr
+		cfg.Direct = true
+		return cfg
+	}
+
+	cc := newConfig().Build()
+	client := Get("built")
+	assert.NotNil(t, client)
+	assert.Equal(t, cc, client.ClientConn())
+
+	t.Run("keep registered client", func(t *testing.T) {
+		other := newConfig().Build()
+		assert.Equal(t, client, Get("built"))
+		assert.Nil(t, other.Close())
+		time.Sleep(100 * time.Millisecond)
+		assert.Equal(t, client, Get("built"))
+	})
+
+	t.Run("unregister on close", func(t *testing.T) {
+		assert.Nil(t, cc.Close())
+		assert.Eventually(t, func() bool {
+			return Get("built") == nil
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestCallCounter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newCallCounter()
+	c.now = func() time.Time { return now }
+
+	c.add(nil)
+	c.add(status.Error(codes.Unavailable, "unavailable"))
+	now = now.Add(time.Second * 30)
+	c.add(status.Error(codes.Unavailable, "unavailable"))
+	requests, errors := c.snapshot()
+	assert.Equal(t, int64(3), requests)
+	assert.Equal(t, map[string]int64{"Unavailable": 2}, errors)
+
+	now = now.Add(time.Second * 40)
+	requests, errors = c.snapshot()
+	assert.Equal(t, int64(1), requests)
+	assert.Equal(t, map[string]int64{"Unavailable": 1}, errors)
+}
@@ -2,7 +2,6 @@ package grpc
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/opentracing/opentracing-go/ext"
@@ -12,17 +11,23 @@ import (
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 	"github.com/xqk/ox/pkg"
+	"github.com/xqk/ox/pkg/auth"
+	"github.com/xqk/ox/pkg/deadline"
 	"github.com/xqk/ox/pkg/ecode"
 	"github.com/xqk/ox/pkg/metric"
 	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/requestid"
 	"github.com/xqk/ox/pkg/trace"
+	"github.com/xqk/ox/pkg/util/oaccess"
 	"github.com/xqk/ox/pkg/util/ocolor"
 	"github.com/xqk/ox/pkg/util/ostring"
+	"strconv"
 	"time"
 )
 
 var (
-	errSlowCommand = errors.New("grpc unary slow command")
+	errSlowCommand       = errors.New("grpc unary slow command")
+	errDeadlineExhausted = errors.New("grpc unary deadline exhausted")
 )
 
 // metric统计
@@ -59,8 +64,13 @@ func metricStreamClientInterceptor(name string) func(ctx context.Context, desc *
 	}
 }
 
-func debugUnaryClientInterceptor(addr string) grpc.UnaryClientInterceptor {
+// debugUnaryClientInterceptor 打印请求和响应, enabled返回false时不打印
+func debugUnaryClientInterceptor(addr string, enabled func() bool) grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !enabled() {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
 		var p peer.Peer
 		prefix := fmt.Sprintf("[%s]", addr)
 		if remote, ok := peer.FromContext(ctx); ok && remote.Addr != nil {
@@ -79,6 +89,15 @@ func debugUnaryClientInterceptor(addr string) grpc.UnaryClientInterceptor {
 	}
 }
 
+// statsUnaryClientInterceptor 统计最近的请求数和错误数, 用于governor展示
+func statsUnaryClientInterceptor(calls *callCounter) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		calls.add(err)
+		return err
+	}
+}
+
 func traceUnaryClientInterceptor() grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
 		md, ok := metadata.FromOutgoingContext(ctx)
@@ -111,21 +130,46 @@ func traceUnaryClientInterceptor() grpc.UnaryClientInterceptor {
 	}
 }
 
-func aidUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+// requestIDUnaryClientInterceptor 传递context中的请求id
+func requestIDUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(requestid.AppendToOutgoingContext(ctx), method, req, reply, cc, opts...)
+}
+
+// requestIDStreamClientInterceptor 传递context中的请求id
+func requestIDStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(requestid.AppendToOutgoingContext(ctx), desc, cc, method, opts...)
+}
+
+// aidUnaryClientInterceptor 传递当前应用的aid, appKey不为空时附带签名
+func aidUnaryClientInterceptor(appKey string) grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		md, ok := metadata.FromOutgoingContext(ctx)
-		clientAidMD := metadata.Pairs("aid", pkg.AppID())
-		if ok {
-			md = metadata.Join(md, clientAidMD)
-		} else {
-			md = clientAidMD
-		}
-		ctx = metadata.NewOutgoingContext(ctx, md)
+		return invoker(withAID(ctx, method, appKey), method, req, reply, cc, opts...)
+	}
+}
 
-		return invoker(ctx, method, req, reply, cc, opts...)
+// aidStreamClientInterceptor 传递当前应用的aid, appKey不为空时附带签名
+func aidStreamClientInterceptor(appKey string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(withAID(ctx, method, appKey), desc, cc, method, opts...)
 	}
 }
 
+func withAID(ctx context.Context, method, appKey string) context.Context {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	clientAidMD := metadata.Pairs("aid", pkg.AppID())
+	if appKey != "" {
+		timestamp := time.Now().Unix()
+		clientAidMD.Set(auth.HeaderAIDTimestamp, strconv.FormatInt(timestamp, 10))
+		clientAidMD.Set(auth.HeaderAIDSign, auth.SignCaller(pkg.AppID(), appKey, method, timestamp))
+	}
+	if ok {
+		md = metadata.Join(md, clientAidMD)
+	} else {
+		md = clientAidMD
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
 // timeoutUnaryClientInterceptor gRPC客户端超时拦截器
 func timeoutUnaryClientInterceptor(_logger *olog.Logger, timeout time.Duration, slowThreshold time.Duration) grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
@@ -138,15 +182,38 @@ func timeoutUnaryClientInterceptor(_logger *olog.Logger, timeout time.Duration,
 			defer cancel()
 		}
 
-		err := invoker(ctx, method, req, reply, cc, opts...)
+		// 时间预算已耗尽, 没有必要再发起调用
+		if remaining, _ := deadline.Remaining(ctx); remaining <= 0 {
+			_logger.WithContext(ctx).Warn("deadline exhausted",
+				olog.FieldErr(errDeadlineExhausted),
+				olog.FieldMethod(method),
+				olog.FieldName(cc.Target()),
+				olog.Any("hops", deadline.Hops(ctx)),
+			)
+			return ecode.DeadlineExhausted.Err()
+		}
+
+		// 将剩余时间预算传递给下游
+		err := invoker(deadline.AppendToOutgoingContext(ctx), method, req, reply, cc, opts...)
 		du := time.Since(now)
 		remoteIP := "unknown"
 		if remote, ok := peer.FromContext(ctx); ok && remote.Addr != nil {
 			remoteIP = remote.Addr.String()
 		}
 
+		if status.Code(err) == codes.DeadlineExceeded {
+			_logger.WithContext(ctx).Warn("deadline exceeded",
+				olog.FieldErr(err),
+				olog.FieldMethod(method),
+				olog.FieldName(cc.Target()),
+				olog.FieldCost(du),
+				olog.FieldAddr(remoteIP),
+				olog.Any("hops", deadline.Hops(ctx)),
+			)
+		}
+
 		if slowThreshold > time.Duration(0) && du > slowThreshold {
-			_logger.Error("slow",
+			_logger.WithContext(ctx).Error("slow",
 				olog.FieldErr(errSlowCommand),
 				olog.FieldMethod(method),
 				olog.FieldName(cc.Target()),
@@ -159,57 +226,44 @@ func timeoutUnaryClientInterceptor(_logger *olog.Logger, timeout time.Duration,
 }
 
 // loggerUnaryClientInterceptor gRPC客户端日志中间件
-func loggerUnaryClientInterceptor(_logger *olog.Logger, name string, accessInterceptorLevel string) grpc.UnaryClientInterceptor {
+func loggerUnaryClientInterceptor(_logger *olog.Logger, name string, access *oaccess.Access) grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
 		beg := time.Now()
 		err := invoker(ctx, method, req, reply, cc, opts...)
 
+		rule := access.Rule(method)
+		if !rule.Sampled(err != nil) {
+			return err
+		}
+
 		spbStatus := ecode.ExtractCodes(err)
+		var fields = []olog.Field{
+			olog.FieldType("unary"),
+			olog.FieldCode(spbStatus.Code),
+		}
+		if err != nil {
+			fields = append(fields, olog.FieldStringErr(spbStatus.Message))
+		}
+		fields = append(fields,
+			olog.FieldName(name),
+			olog.FieldMethod(method),
+			olog.FieldCost(time.Since(beg)),
+		)
+		fields = append(fields, rule.PayloadFields(err != nil, req, reply)...)
+
+		_logger := _logger.WithContext(ctx)
 		if err != nil {
 			// 只记录系统级别错误
 			if spbStatus.Code < ecode.EcodeNum {
-				// 只记录系统级别错误
-				_logger.Error(
-					"access",
-					olog.FieldType("unary"),
-					olog.FieldCode(spbStatus.Code),
-					olog.FieldStringErr(spbStatus.Message),
-					olog.FieldName(name),
-					olog.FieldMethod(method),
-					olog.FieldCost(time.Since(beg)),
-					olog.Any("req", json.RawMessage(ostring.Json(req))),
-					olog.Any("reply", json.RawMessage(ostring.Json(reply))),
-				)
+				_logger.Error("access", fields...)
 			} else {
 				// 业务报错只做warning
-				_logger.Warn(
-					"access",
-					olog.FieldType("unary"),
-					olog.FieldCode(spbStatus.Code),
-					olog.FieldStringErr(spbStatus.Message),
-					olog.FieldName(name),
-					olog.FieldMethod(method),
-					olog.FieldCost(time.Since(beg)),
-					olog.Any("req", json.RawMessage(ostring.Json(req))),
-					olog.Any("reply", json.RawMessage(ostring.Json(reply))),
-				)
+				_logger.Warn("access", fields...)
 			}
 			return err
-		} else {
-			if accessInterceptorLevel == "info" {
-				_logger.Info(
-					"access",
-					olog.FieldType("unary"),
-					olog.FieldCode(spbStatus.Code),
-					olog.FieldName(name),
-					olog.FieldMethod(method),
-					olog.FieldCost(time.Since(beg)),
-					olog.Any("req", json.RawMessage(ostring.Json(req))),
-					olog.Any("reply", json.RawMessage(ostring.Json(reply))),
-				)
-			}
 		}
 
+		rule.Log(_logger, "access", fields...)
 		return nil
 	}
 }
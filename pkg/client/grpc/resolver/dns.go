@@ -0,0 +1,190 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/util/ogo"
+)
+
+const (
+	// SchemeDNS resolves target by dns periodically.
+	// odns:///host:port looks up A/AAAA records, odns:///_grpc._tcp.host looks up SRV records.
+	// It is not named dns to keep the builtin dns resolver of grpc intact.
+	SchemeDNS = "odns"
+
+	defaultDNSRefreshInterval = time.Second * 30
+	dnsLookupTimeout          = time.Second * 5
+)
+
+var dnsLookup lookuper = net.DefaultResolver
+
+type lookuper interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+func init() {
+	RegisterDNS(SchemeDNS, defaultDNSRefreshInterval)
+}
+
+// RegisterDNS registers a dns resolver with scheme, which refreshes addresses every interval.
+func RegisterDNS(scheme string, interval time.Duration) {
+	resolver.Register(&dnsBuilder{
+		scheme:   scheme,
+		interval: interval,
+	})
+}
+
+type dnsBuilder struct {
+	scheme   string
+	interval time.Duration
+}
+
+// Build ...
+func (b *dnsBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	host, port, err := parseDNSTarget(target.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &dnsResolver{
+		target:   target.Endpoint,
+		host:     host,
+		port:     port,
+		interval: b.interval,
+		cc:       cc,
+		rn:       make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		logger:   olog.OxLogger.With(olog.FieldMod("client.grpc.resolver"), olog.FieldName(target.Endpoint)),
+	}
+	if r.interval <= 0 {
+		r.interval = defaultDNSRefreshInterval
+	}
+
+	r.wg.Add(1)
+	ogo.Go(r.watch)
+	return r, nil
+}
+
+// Scheme ...
+func (b *dnsBuilder) Scheme() string {
+	return b.scheme
+}
+
+// parseDNSTarget splits target into host and port, port is empty for srv targets.
+func parseDNSTarget(target string) (host, port string, err error) {
+	if target == "" {
+		return "", "", fmt.Errorf("dns resolver: empty target")
+	}
+	if !strings.Contains(target, ":") || strings.HasSuffix(target, "]") {
+		return strings.Trim(target, "[]"), "", nil
+	}
+
+	host, port, err = net.SplitHostPort(target)
+	if err != nil {
+		return "", "", fmt.Errorf("dns resolver: invalid target %q: %v", target, err)
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		return "", "", fmt.Errorf("dns resolver: invalid port in target %q", target)
+	}
+	return host, port, nil
+}
+
+type dnsResolver struct {
+	target   string
+	host     string
+	port     string
+	interval time.Duration
+	cc       resolver.ClientConn
+	rn       chan struct{}
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	logger   *olog.Logger
+}
+
+// ResolveNow ...
+func (r *dnsResolver) ResolveNow(options resolver.ResolveNowOptions) {
+	select {
+	case r.rn <- struct{}{}:
+	default:
+	}
+}
+
+// Close ...
+func (r *dnsResolver) Close() {
+	close(r.stop)
+	r.wg.Wait()
+}
+
+func (r *dnsResolver) watch() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		r.resolve()
+		select {
+		case <-ticker.C:
+		case <-r.rn:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *dnsResolver) resolve() {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsLookupTimeout)
+	defer cancel()
+
+	addrs, err := r.lookup(ctx)
+	if err != nil {
+		r.logger.Error("dns lookup", olog.FieldErr(err))
+		r.cc.ReportError(err)
+		return
+	}
+	r.cc.UpdateState(newState(r.serverName(), newEndpoints(r.target, addrs)))
+}
+
+// serverName returns the host of A/AAAA targets, srv records point to different hosts so it is empty
+func (r *dnsResolver) serverName() string {
+	if r.port == "" {
+		return ""
+	}
+	return r.host
+}
+
+func (r *dnsResolver) lookup(ctx context.Context) ([]string, error) {
+	var addrs = make([]string, 0)
+	if r.port == "" {
+		_, srvs, err := dnsLookup.LookupSRV(ctx, "", "", r.host)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvs {
+			addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+		}
+	} else {
+		hosts, err := dnsLookup.LookupHost(ctx, r.host)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			addrs = append(addrs, net.JoinHostPort(host, r.port))
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("dns resolver: no address found for %q", r.target)
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
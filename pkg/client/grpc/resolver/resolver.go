@@ -2,13 +2,20 @@ package resolver
 
 import (
 	"context"
+	"time"
+
 	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/resolver"
 	"github.com/xqk/ox/pkg/constant"
+	"github.com/xqk/ox/pkg/olog"
 	"github.com/xqk/ox/pkg/registry"
+	"github.com/xqk/ox/pkg/server"
 	"github.com/xqk/ox/pkg/util/ogo"
 )
 
+// resolveTimeout 重新获取节点的超时时间
+const resolveTimeout = time.Second * 5
+
 // Register ...
 func Register(name string, reg registry.Registry) {
 	resolver.Register(&baseBuilder{
@@ -29,36 +36,27 @@ func (b *baseBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts
 		return nil, err
 	}
 
-	var stop = make(chan struct{})
+	r := &baseResolver{
+		target: target.Endpoint,
+		reg:    b.reg,
+		cc:     cc,
+		rn:     make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		logger: olog.OxLogger.With(olog.FieldMod("client.grpc.resolver"), olog.FieldName(target.Endpoint)),
+	}
 	ogo.Go(func() {
 		for {
 			select {
 			case endpoint := <-endpoints:
-				var state = resolver.State{
-					Addresses: make([]resolver.Address, 0),
-					Attributes: attributes.New(
-						constant.KeyRouteConfig, endpoint.RouteConfigs, // 路由配置
-						constant.KeyProviderConfig, endpoint.ProviderConfigs, // 服务提供方元信息
-						constant.KeyConsumerConfig, endpoint.ConsumerConfigs, // 服务消费方配置信息
-					),
-				}
-				for _, node := range endpoint.Nodes {
-					var address resolver.Address
-					address.Addr = node.Address
-					address.ServerName = target.Endpoint
-					address.Attributes = attributes.New(constant.KeyServiceInfo, node)
-					state.Addresses = append(state.Addresses, address)
-				}
-				cc.UpdateState(state)
-			case <-stop:
+				r.update(&endpoint)
+			case <-r.rn:
+				r.resolve()
+			case <-r.stop:
 				return
 			}
 		}
 	})
-
-	return &baseResolver{
-		stop: stop,
-	}, nil
+	return r, nil
 }
 
 // Scheme ...
@@ -67,11 +65,97 @@ func (b baseBuilder) Scheme() string {
 }
 
 type baseResolver struct {
-	stop chan struct{}
+	target string
+	reg    registry.Registry
+	cc     resolver.ClientConn
+	rn     chan struct{}
+	stop   chan struct{}
+	logger *olog.Logger
+
+	// 最近一次的服务信息, 重新获取节点时保留其中的配置
+	last *registry.Endpoints
 }
 
-// ResolveNow ...
-func (b *baseResolver) ResolveNow(options resolver.ResolveNowOptions) {}
+// ResolveNow 从注册中心重新获取节点, 如连接失败时
+func (b *baseResolver) ResolveNow(options resolver.ResolveNowOptions) {
+	select {
+	case b.rn <- struct{}{}:
+	default:
+	}
+}
 
 // Close ...
 func (b *baseResolver) Close() { b.stop <- struct{}{} }
+
+func (b *baseResolver) update(endpoint *registry.Endpoints) {
+	b.last = endpoint
+	b.cc.UpdateState(newState(b.target, endpoint))
+}
+
+// resolve lists nodes from registry, configs are kept from the last watched endpoints
+func (b *baseResolver) resolve() {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	services, err := b.reg.ListServices(ctx, b.target, "grpc")
+	if err != nil {
+		b.logger.Error("list services", olog.FieldErr(err))
+		b.cc.ReportError(err)
+		return
+	}
+
+	var endpoint = newEndpoints(b.target, nil)
+	if b.last != nil {
+		endpoint.RouteConfigs = b.last.RouteConfigs
+		endpoint.ConsumerConfigs = b.last.ConsumerConfigs
+		endpoint.ProviderConfigs = b.last.ProviderConfigs
+	}
+	for _, service := range services {
+		endpoint.Nodes[service.Address] = *service
+	}
+	b.update(endpoint)
+}
+
+// newState converts endpoints into resolver state, all resolvers share the same attributes
+// so that balancers work regardless of the resolver. Empty serverName means the default authority of grpc.
+func newState(serverName string, endpoint *registry.Endpoints) resolver.State {
+	var state = resolver.State{
+		Addresses: make([]resolver.Address, 0),
+		Attributes: attributes.New(
+			constant.KeyRouteConfig, endpoint.RouteConfigs, // 路由配置
+			constant.KeyProviderConfig, endpoint.ProviderConfigs, // 服务提供方元信息
+			constant.KeyConsumerConfig, endpoint.ConsumerConfigs, // 服务消费方配置信息
+		),
+	}
+	for _, node := range endpoint.Nodes {
+		var address resolver.Address
+		address.Addr = node.Address
+		address.ServerName = serverName
+		address.Attributes = attributes.New(constant.KeyServiceInfo, node)
+		state.Addresses = append(state.Addresses, address)
+	}
+	return state
+}
+
+// newEndpoints creates endpoints of plain addresses which are not from registry
+func newEndpoints(serverName string, addrs []string) *registry.Endpoints {
+	var endpoints = &registry.Endpoints{
+		Nodes:           make(map[string]server.ServiceInfo),
+		RouteConfigs:    make(map[string]registry.RouteConfig),
+		ConsumerConfigs: make(map[string]registry.ConsumerConfig),
+		ProviderConfigs: make(map[string]registry.ProviderConfig),
+	}
+	for _, addr := range addrs {
+		endpoints.Nodes[addr] = server.ServiceInfo{
+			Name:     serverName,
+			Scheme:   "grpc",
+			Address:  addr,
+			Weight:   100,
+			Enable:   true,
+			Healthy:  true,
+			Metadata: make(map[string]string),
+			Kind:     constant.ServiceProvider,
+		}
+	}
+	return endpoints
+}
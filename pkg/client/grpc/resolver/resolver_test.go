@@ -1,7 +1,242 @@
 package resolver
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/constant"
+	"github.com/xqk/ox/pkg/registry"
+	"github.com/xqk/ox/pkg/server"
+	"google.golang.org/grpc/resolver"
+)
+
+type testClientConn struct {
+	resolver.ClientConn
+	mu     sync.Mutex
+	states []resolver.State
+	errs   []error
+}
+
+func (cc *testClientConn) UpdateState(state resolver.State) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.states = append(cc.states, state)
+	return nil
+}
+
+func (cc *testClientConn) ReportError(err error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.errs = append(cc.errs, err)
+}
+
+func (cc *testClientConn) lastState() (resolver.State, int) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if len(cc.states) == 0 {
+		return resolver.State{}, 0
+	}
+	return cc.states[len(cc.states)-1], len(cc.states)
+}
+
+func addrsOf(state resolver.State) []string {
+	var addrs = make([]string, 0)
+	for _, addr := range state.Addresses {
+		addrs = append(addrs, addr.Addr)
+	}
+	return addrs
+}
+
+type testRegistry struct {
+	registry.Registry
+	endpoints chan registry.Endpoints
+	mu        sync.Mutex
+	services  []*server.ServiceInfo
+}
+
+func (reg *testRegistry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
+	return reg.endpoints, nil
+}
+
+func (reg *testRegistry) ListServices(ctx context.Context, name string, scheme string) ([]*server.ServiceInfo, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.services, nil
+}
 
 func Test_baseResolver(t *testing.T) {
+	reg := &testRegistry{endpoints: make(chan registry.Endpoints)}
+	Register("test", reg)
+	cc := &testClientConn{}
+	r, err := resolver.Get("test").Build(resolver.Target{Scheme: "test", Endpoint: "hello"}, cc, resolver.BuildOptions{})
+	assert.Nil(t, err)
+	defer r.Close()
+
+	endpoints := *newEndpoints("hello", []string{"127.0.0.1:9091"})
+	endpoints.RouteConfigs["route"] = registry.RouteConfig{ID: "route"}
+	reg.endpoints <- endpoints
+	assert.Eventually(t, func() bool {
+		_, n := cc.lastState()
+		return n == 1
+	}, time.Second, time.Millisecond*10)
+	state, _ := cc.lastState()
+	assert.Equal(t, []string{"127.0.0.1:9091"}, addrsOf(state))
+	assert.Equal(t, "hello", state.Addresses[0].ServerName)
+
+	// ResolveNow从注册中心重新获取节点, 保留路由等配置
+	reg.mu.Lock()
+	reg.services = []*server.ServiceInfo{{Name: "hello", Address: "127.0.0.1:9092"}}
+	reg.mu.Unlock()
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	assert.Eventually(t, func() bool {
+		_, n := cc.lastState()
+		return n == 2
+	}, time.Second, time.Millisecond*10)
+	state, _ = cc.lastState()
+	assert.Equal(t, []string{"127.0.0.1:9092"}, addrsOf(state))
+	assert.Contains(t, state.Attributes.Value(constant.KeyRouteConfig), "route")
+}
+
+func TestStaticResolver(t *testing.T) {
+	t.Run("static", func(t *testing.T) {
+		cc := &testClientConn{}
+		r, err := resolver.Get(SchemeStatic).Build(resolver.Target{Scheme: SchemeStatic, Endpoint: "127.0.0.1:9091, 127.0.0.1:9092"}, cc, resolver.BuildOptions{})
+		assert.Nil(t, err)
+		defer r.Close()
+
+		state, _ := cc.lastState()
+		assert.ElementsMatch(t, []string{"127.0.0.1:9091", "127.0.0.1:9092"}, addrsOf(state))
+		assert.Empty(t, state.Addresses[0].ServerName)
+		assert.NotNil(t, state.Attributes.Value(constant.KeyRouteConfig))
+		info, ok := state.Addresses[0].Attributes.Value(constant.KeyServiceInfo).(server.ServiceInfo)
+		assert.True(t, ok)
+		assert.Equal(t, state.Addresses[0].Addr, info.Address)
+	})
+
+	t.Run("direct", func(t *testing.T) {
+		cc := &testClientConn{}
+		r, err := resolver.Get(SchemeDirect).Build(resolver.Target{Scheme: SchemeDirect, Endpoint: "127.0.0.1:9091"}, cc, resolver.BuildOptions{})
+		assert.Nil(t, err)
+		defer r.Close()
+
+		state, _ := cc.lastState()
+		assert.Equal(t, []string{"127.0.0.1:9091"}, addrsOf(state))
+
+		_, err = resolver.Get(SchemeDirect).Build(resolver.Target{Scheme: SchemeDirect, Endpoint: "127.0.0.1:9091,127.0.0.1:9092"}, cc, resolver.BuildOptions{})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		_, err := resolver.Get(SchemeStatic).Build(resolver.Target{Scheme: SchemeStatic, Endpoint: ""}, &testClientConn{}, resolver.BuildOptions{})
+		assert.NotNil(t, err)
+	})
+}
+
+type testLookuper struct {
+	mu    sync.Mutex
+	hosts []string
+	srvs  []*net.SRV
+	err   error
+}
+
+func (l *testLookuper) LookupHost(ctx context.Context, host string) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.hosts, l.err
+}
+
+func (l *testLookuper) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return "", l.srvs, l.err
+}
+
+func TestDNSResolver(t *testing.T) {
+	lookup := &testLookuper{
+		hosts: []string{"10.0.0.2", "10.0.0.1"},
+		srvs:  []*net.SRV{{Target: "node1.example.com.", Port: 9091}},
+	}
+	dnsLookup = lookup
+	defer func() { dnsLookup = net.DefaultResolver }()
+
+	t.Run("parse target", func(t *testing.T) {
+		host, port, err := parseDNSTarget("example.com:9091")
+		assert.Nil(t, err)
+		assert.Equal(t, "example.com", host)
+		assert.Equal(t, "9091", port)
+
+		host, port, err = parseDNSTarget("_grpc._tcp.example.com")
+		assert.Nil(t, err)
+		assert.Equal(t, "_grpc._tcp.example.com", host)
+		assert.Equal(t, "", port)
+
+		_, _, err = parseDNSTarget("example.com:grpc")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("a records", func(t *testing.T) {
+		cc := &testClientConn{}
+		r, err := (&dnsBuilder{scheme: SchemeDNS, interval: time.Hour}).Build(resolver.Target{Scheme: SchemeDNS, Endpoint: "example.com:9091"}, cc, resolver.BuildOptions{})
+		assert.Nil(t, err)
+		defer r.Close()
+
+		assert.Eventually(t, func() bool {
+			_, n := cc.lastState()
+			return n == 1
+		}, time.Second, time.Millisecond*10)
+		state, _ := cc.lastState()
+		assert.ElementsMatch(t, []string{"10.0.0.1:9091", "10.0.0.2:9091"}, addrsOf(state))
+		assert.NotNil(t, state.Addresses[0].Attributes.Value(constant.KeyServiceInfo))
+		// TLS校验使用目标的主机名
+		assert.Equal(t, "example.com", state.Addresses[0].ServerName)
+
+		// re-resolve on demand
+		lookup.mu.Lock()
+		lookup.hosts = []string{"10.0.0.3"}
+		lookup.mu.Unlock()
+		r.ResolveNow(resolver.ResolveNowOptions{})
+		assert.Eventually(t, func() bool {
+			state, n := cc.lastState()
+			return n == 2 && addrsOf(state)[0] == "10.0.0.3:9091"
+		}, time.Second, time.Millisecond*10)
+	})
+
+	t.Run("srv records", func(t *testing.T) {
+		cc := &testClientConn{}
+		r, err := (&dnsBuilder{scheme: SchemeDNS, interval: time.Millisecond * 20}).Build(resolver.Target{Scheme: SchemeDNS, Endpoint: "_grpc._tcp.example.com"}, cc, resolver.BuildOptions{})
+		assert.Nil(t, err)
+		defer r.Close()
+
+		// refreshed periodically
+		assert.Eventually(t, func() bool {
+			_, n := cc.lastState()
+			return n >= 2
+		}, time.Second, time.Millisecond*10)
+		state, _ := cc.lastState()
+		assert.Equal(t, []string{"node1.example.com:9091"}, addrsOf(state))
+		// SRV目标不是服务端的主机名, 不用于TLS校验
+		assert.Empty(t, state.Addresses[0].ServerName)
+	})
+
+	t.Run("lookup error", func(t *testing.T) {
+		lookup.mu.Lock()
+		lookup.err = errors.New("lookup failed")
+		lookup.mu.Unlock()
+
+		cc := &testClientConn{}
+		r, err := (&dnsBuilder{scheme: SchemeDNS, interval: time.Hour}).Build(resolver.Target{Scheme: SchemeDNS, Endpoint: "example.com:9091"}, cc, resolver.BuildOptions{})
+		assert.Nil(t, err)
+		defer r.Close()
 
+		assert.Eventually(t, func() bool {
+			cc.mu.Lock()
+			defer cc.mu.Unlock()
+			return len(cc.errs) == 1
+		}, time.Second, time.Millisecond*10)
+	})
 }
@@ -0,0 +1,74 @@
+package resolver
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	// SchemeStatic resolves a fixed address list, eg: static:///127.0.0.1:9091,127.0.0.1:9092
+	SchemeStatic = "static"
+	// SchemeDirect resolves a single address, eg: direct:///127.0.0.1:9091
+	SchemeDirect = "direct"
+)
+
+func init() {
+	resolver.Register(&staticBuilder{scheme: SchemeStatic})
+	resolver.Register(&staticBuilder{scheme: SchemeDirect})
+}
+
+type staticBuilder struct {
+	scheme string
+}
+
+// Build ...
+func (b *staticBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	addrs, err := b.parse(target.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &staticResolver{
+		cc:    cc,
+		state: newState("", newEndpoints(target.Endpoint, addrs)),
+	}
+	r.ResolveNow(resolver.ResolveNowOptions{})
+	return r, nil
+}
+
+// Scheme ...
+func (b *staticBuilder) Scheme() string {
+	return b.scheme
+}
+
+func (b *staticBuilder) parse(endpoint string) ([]string, error) {
+	var addrs = make([]string, 0)
+	for _, addr := range strings.Split(endpoint, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%s resolver: no address in target %q", b.scheme, endpoint)
+	}
+	if b.scheme == SchemeDirect && len(addrs) > 1 {
+		return nil, fmt.Errorf("%s resolver: more than one address in target %q", b.scheme, endpoint)
+	}
+	return addrs, nil
+}
+
+type staticResolver struct {
+	cc    resolver.ClientConn
+	state resolver.State
+}
+
+// ResolveNow ...
+func (r *staticResolver) ResolveNow(options resolver.ResolveNowOptions) {
+	r.cc.UpdateState(r.state)
+}
+
+// Close ...
+func (r *staticResolver) Close() {}
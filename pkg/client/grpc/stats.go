@@ -0,0 +1,67 @@
+package grpc
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/status"
+)
+
+const (
+	callBucketSize  = 10 * time.Second
+	callBucketCount = 6
+)
+
+// callCounter 按10秒分桶, 统计最近一分钟的请求数和按状态码区分的错误数
+type callCounter struct {
+	mu      sync.Mutex
+	buckets [callBucketCount]callBucket
+	now     func() time.Time
+}
+
+type callBucket struct {
+	index    int64
+	requests int64
+	errors   map[string]int64
+}
+
+func newCallCounter() *callCounter {
+	return &callCounter{now: time.Now}
+}
+
+func (c *callCounter) add(err error) {
+	index := c.now().UnixNano() / int64(callBucketSize)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	bucket := &c.buckets[index%callBucketCount]
+	if bucket.index != index {
+		*bucket = callBucket{index: index}
+	}
+	bucket.requests++
+	if err != nil {
+		if bucket.errors == nil {
+			bucket.errors = make(map[string]int64)
+		}
+		bucket.errors[status.Code(err).String()]++
+	}
+}
+
+// snapshot returns requests and errors in last minute
+func (c *callCounter) snapshot() (requests int64, errors map[string]int64) {
+	index := c.now().UnixNano() / int64(callBucketSize)
+	errors = make(map[string]int64)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, bucket := range c.buckets {
+		if index-bucket.index >= callBucketCount {
+			continue
+		}
+		requests += bucket.requests
+		for code, n := range bucket.errors {
+			errors[code] += n
+		}
+	}
+	return
+}
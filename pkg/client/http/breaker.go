@@ -0,0 +1,122 @@
+package http
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen 节点已熔断
+var ErrBreakerOpen = errors.New("http client breaker open")
+
+// breakerError carries address of the broken node, errors.Is(err, ErrBreakerOpen) reports true
+type breakerError struct {
+	addr string
+}
+
+func (e *breakerError) Error() string {
+	return ErrBreakerOpen.Error() + ": " + e.addr
+}
+
+// Is ...
+func (e *breakerError) Is(target error) bool {
+	return target == ErrBreakerOpen
+}
+
+const (
+	stateClosed = iota
+	stateOpen
+	stateHalfOpen
+)
+
+// breakers 按节点地址区分的熔断器
+type breakers struct {
+	config *BreakerConfig
+	mu     sync.Mutex
+	items  map[string]*breaker
+}
+
+func newBreakers(config *BreakerConfig) *breakers {
+	return &breakers{config: config, items: make(map[string]*breaker)}
+}
+
+func (bs *breakers) get(addr string) *breaker {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.items[addr]
+	if !ok {
+		b = &breaker{config: bs.config, now: time.Now}
+		bs.items[addr] = b
+	}
+	return b
+}
+
+// breaker 连续失败FailureThreshold次后熔断, OpenTimeout后放行一个探测请求, 成功则恢复, 失败则继续熔断
+type breaker struct {
+	config   *BreakerConfig
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+	now      func() time.Time
+}
+
+// allow reports whether the request can be sent, must be followed by report or release when true
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case stateOpen:
+		if b.now().Sub(b.openedAt) < b.config.OpenTimeout {
+			return false
+		}
+		b.state = stateHalfOpen
+		b.probing = true
+		return true
+	case stateHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// report records result of the request
+func (b *breaker) report(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == stateHalfOpen {
+		b.probing = false
+		if success {
+			b.state = stateClosed
+			b.failures = 0
+		} else {
+			b.state = stateOpen
+			b.openedAt = b.now()
+		}
+		return
+	}
+
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == stateClosed && b.failures >= b.config.FailureThreshold {
+		b.state = stateOpen
+		b.openedAt = b.now()
+	}
+}
+
+// release gives up the request without result
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == stateHalfOpen {
+		b.probing = false
+	}
+}
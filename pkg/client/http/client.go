@@ -0,0 +1,215 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/xqk/ox/pkg/registry"
+	"github.com/xqk/ox/pkg/util/ogo"
+	"github.com/xqk/ox/pkg/util/otls"
+)
+
+// HeaderIdempotencyKey 带有该请求头的非幂等请求也会重试, 服务端据此去重
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// ErrNoAvailableNode 注册中心中没有可用的节点
+var ErrNoAvailableNode = errors.New("http client no available node")
+
+// resolveTimeout 创建客户端时等待注册中心返回节点的最长时间
+var resolveTimeout = 3 * time.Second
+
+// Client 带有服务发现、拦截器、重试和熔断的http客户端, 使用方式同resty.Client
+type Client struct {
+	*resty.Client
+	config    *Config
+	resolver  *resolver
+	tlsLoader *otls.Loader
+}
+
+func newClient(config *Config) (*Client, error) {
+	client := &Client{
+		Client: resty.New(),
+		config: config,
+	}
+	base := config.transport
+	if base == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if config.TLS != nil {
+			tlsLoader, err := otls.NewLoader(config.TLS)
+			if err != nil {
+				return nil, err
+			}
+			// 证书文件变化时重新加载, Close时停止
+			client.tlsLoader = tlsLoader
+			transport.TLSClientConfig = tlsLoader.ClientConfig()
+		}
+		base = transport
+	}
+
+	hostURL := config.Address
+	if !config.Direct {
+		reg := config.registry
+		if reg == nil {
+			reg = registry.DefaultRegisterer
+		}
+		var err error
+		if client.resolver, err = newResolver(reg, config.Address); err != nil {
+			client.Close()
+			return nil, err
+		}
+		scheme := "http"
+		if config.TLS != nil {
+			scheme = "https"
+		}
+		// host在发送时替换为节点地址
+		hostURL = scheme + "://" + config.Address
+	} else if !strings.Contains(hostURL, "://") {
+		hostURL = "http://" + hostURL
+	}
+
+	client.SetHostURL(hostURL)
+	client.SetTransport(config.buildTransport(base, client.resolver))
+	if config.RetryCount > 0 {
+		// resty v2.2.0的RetryCount为总的请求次数
+		client.SetRetryCount(config.RetryCount + 1)
+	}
+	client.SetRetryWaitTime(config.RetryWaitTime)
+	client.SetRetryMaxWaitTime(config.RetryMaxWaitTime)
+	client.AddRetryCondition(retryCondition)
+	// 记录展开路径参数之前的url, 作为metric和访问日志的method. 重试时url已经展开, 保留首次的值
+	client.OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+		if _, ok := r.Context().Value(routeKey{}).(string); !ok {
+			r.SetContext(context.WithValue(r.Context(), routeKey{}, routeOf(r.URL)))
+		}
+		return nil
+	})
+	return client, nil
+}
+
+// Close 停止监听注册中心和重新加载证书
+func (c *Client) Close() error {
+	if c.resolver != nil {
+		c.resolver.close()
+	}
+	if c.tlsLoader != nil {
+		return c.tlsLoader.Close()
+	}
+	return nil
+}
+
+// retryCondition 只重试幂等的请求, 连接错误、5xx和429时重试. 熔断、时间预算耗尽和context结束时不重试
+func retryCondition(resp *resty.Response, err error) bool {
+	if resp == nil || resp.Request == nil || !idempotent(resp.Request) {
+		return false
+	}
+	if err != nil {
+		return !errors.Is(err, ErrBreakerOpen) && !errors.Is(err, errDeadlineExhausted) &&
+			!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return resp.StatusCode() >= http.StatusInternalServerError || resp.StatusCode() == http.StatusTooManyRequests
+}
+
+// idempotent reports whether r can be retried, requests with Idempotency-Key header are idempotent
+func idempotent(r *resty.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	header := r.Header
+	if r.RawRequest != nil {
+		header = r.RawRequest.Header
+	}
+	return header.Get(HeaderIdempotencyKey) != ""
+}
+
+type routeKey struct{}
+
+// routeOf returns path of the url template, e.g. /users/{id}
+func routeOf(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		rawURL = u.Path
+	}
+	if i := strings.IndexByte(rawURL, '?'); i >= 0 {
+		rawURL = rawURL[:i]
+	}
+	if rawURL == "" {
+		return "/"
+	}
+	return rawURL
+}
+
+// resolver 监听注册中心中应用的http节点, 轮询选择
+type resolver struct {
+	mu     sync.RWMutex
+	addrs  []string
+	next   uint64
+	cancel context.CancelFunc
+}
+
+func newResolver(reg registry.Registry, name string) (*resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	endpoints, err := reg.WatchServices(ctx, name, "http")
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	r := &resolver{cancel: cancel}
+	// 等待首次返回的节点, 避免创建后立即发送的请求没有可用的节点
+	select {
+	case endpoint, ok := <-endpoints:
+		if ok {
+			r.update(&endpoint)
+		}
+	case <-time.After(resolveTimeout):
+	}
+	ogo.Go(func() {
+		for {
+			select {
+			case endpoint, ok := <-endpoints:
+				if !ok {
+					return
+				}
+				r.update(&endpoint)
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+	return r, nil
+}
+
+func (r *resolver) update(endpoints *registry.Endpoints) {
+	addrs := make([]string, 0, len(endpoints.Nodes))
+	for _, node := range endpoints.Nodes {
+		if node.Enable {
+			addrs = append(addrs, node.Address)
+		}
+	}
+	sort.Strings(addrs)
+
+	r.mu.Lock()
+	r.addrs = addrs
+	r.mu.Unlock()
+}
+
+func (r *resolver) pick() (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.addrs) == 0 {
+		return "", ErrNoAvailableNode
+	}
+	return r.addrs[atomic.AddUint64(&r.next, 1)%uint64(len(r.addrs))], nil
+}
+
+func (r *resolver) close() {
+	r.cancel()
+}
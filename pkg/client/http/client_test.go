@@ -0,0 +1,232 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/auth"
+	"github.com/xqk/ox/pkg/registry"
+	"github.com/xqk/ox/pkg/requestid"
+	"github.com/xqk/ox/pkg/server"
+)
+
+func newDirectConfig(addr string) *Config {
+	config := DefaultConfig()
+	config.Name = "test"
+	config.Address = addr
+	config.Direct = true
+	config.RetryWaitTime = time.Millisecond
+	config.RetryMaxWaitTime = time.Millisecond
+	return config
+}
+
+func TestClient_Headers(t *testing.T) {
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	config := newDirectConfig(strings.TrimPrefix(srv.URL, "http://"))
+	config.AppKey = "key"
+	client := config.Build()
+
+	var route string
+	client.SetPreRequestHook(func(_ *resty.Client, r *http.Request) error {
+		route, _ = r.Context().Value(routeKey{}).(string)
+		return nil
+	})
+	resp, err := client.R().
+		SetContext(requestid.NewContext(context.Background(), "abc")).
+		SetPathParams(map[string]string{"id": "1"}).
+		Get("/users/{id}")
+	assert.Nil(t, err)
+	assert.Equal(t, "/users/1", resp.String())
+	assert.Equal(t, "/users/{id}", route)
+	assert.Equal(t, "abc", header.Get(requestid.HeaderRequestID))
+	assert.NotEmpty(t, header.Get(auth.HeaderAIDSign))
+}
+
+func TestClient_Retry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	config := newDirectConfig(srv.URL)
+	config.RetryCount = 2
+	resp, err := config.Build().R().Get("/")
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", resp.String())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// 4xx不重试
+	atomic.StoreInt32(&calls, 0)
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	resp, err = config.Build().R().Get("/")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	// 非幂等的请求只在带有Idempotency-Key时重试
+	atomic.StoreInt32(&calls, 0)
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	client := config.Build()
+	resp, err = client.R().Post("/")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	resp, err = client.R().SetHeader(HeaderIdempotencyKey, "k").Post("/")
+	assert.Nil(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func TestClient_Timeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	config := newDirectConfig(srv.URL)
+	config.ReadTimeout = 10 * time.Millisecond
+	_, err := config.Build().R().Get("/")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestClient_Breaker(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	config := newDirectConfig(srv.URL)
+	config.Breaker = &BreakerConfig{Enable: true, FailureThreshold: 2, OpenTimeout: time.Minute}
+	client := config.Build()
+	for i := 0; i < 2; i++ {
+		resp, err := client.R().Get("/")
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode())
+	}
+	_, err := client.R().Get("/")
+	assert.True(t, errors.Is(err, ErrBreakerOpen))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// 熔断的错误不重试
+	config.RetryCount = 2
+	client = config.Build()
+	for i := 0; i < 3; i++ {
+		_, err = client.R().Get("/")
+	}
+	assert.True(t, errors.Is(err, ErrBreakerOpen))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func TestBreaker(t *testing.T) {
+	var now = time.Now()
+	b := &breaker{
+		config: &BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Second},
+		now:    func() time.Time { return now },
+	}
+	assert.True(t, b.allow())
+	b.report(false)
+	assert.True(t, b.allow())
+	b.report(false)
+	assert.False(t, b.allow())
+
+	// 熔断结束后只放行一个探测请求
+	now = now.Add(time.Second)
+	assert.True(t, b.allow())
+	assert.False(t, b.allow())
+	b.report(false)
+	assert.False(t, b.allow())
+
+	now = now.Add(time.Second)
+	assert.True(t, b.allow())
+	b.report(true)
+	assert.True(t, b.allow())
+	assert.True(t, b.allow())
+}
+
+type fakeRegistry struct {
+	registry.Registry
+	endpoints chan registry.Endpoints
+}
+
+func (r *fakeRegistry) WatchServices(ctx context.Context, name string, scheme string) (chan registry.Endpoints, error) {
+	return r.endpoints, nil
+}
+
+func TestClient_Registry(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	defer func(timeout time.Duration) { resolveTimeout = timeout }(resolveTimeout)
+	resolveTimeout = 10 * time.Millisecond
+	reg := &fakeRegistry{endpoints: make(chan registry.Endpoints)}
+	config := DefaultConfig().WithRegistry(reg)
+	config.Address = "user-svc"
+	client := config.Build()
+	defer client.Close()
+
+	_, err := client.R().Get("/")
+	assert.True(t, errors.Is(err, ErrNoAvailableNode))
+
+	reg.endpoints <- registry.Endpoints{Nodes: map[string]server.ServiceInfo{
+		addr:          {Address: addr, Enable: true},
+		"127.0.0.1:1": {Address: "127.0.0.1:1", Enable: false},
+	}}
+	var resp *resty.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = client.R().Get("/"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, addr, resp.String())
+}
+
+func TestClient_RegistryInitial(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	// 创建时等待首次返回的节点
+	reg := &fakeRegistry{endpoints: make(chan registry.Endpoints, 1)}
+	reg.endpoints <- registry.Endpoints{Nodes: map[string]server.ServiceInfo{
+		addr: {Address: addr, Enable: true},
+	}}
+	config := DefaultConfig().WithRegistry(reg)
+	config.Address = "user-svc"
+	client := config.Build()
+	defer client.Close()
+
+	resp, err := client.R().Get("/")
+	assert.Nil(t, err)
+	assert.Equal(t, addr, resp.String())
+}
@@ -0,0 +1,124 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/xqk/ox/pkg/conf"
+	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/registry"
+	"github.com/xqk/ox/pkg/util/oaccess"
+	"github.com/xqk/ox/pkg/util/otime"
+	"github.com/xqk/ox/pkg/util/otls"
+)
+
+// Config ...
+type Config struct {
+	Name string // config's name
+	// Address 直连时为base url, 如http://127.0.0.1:9091, 否则为注册中心中的应用名
+	Address string
+	// Direct 直连Address, 不经过注册中心
+	Direct bool
+	// ReadTimeout 请求未设置deadline时的超时时间, 包括读取响应
+	ReadTimeout time.Duration
+	// RetryCount 失败后的重试次数, 连接错误、5xx和429时重试, 0为不重试.
+	// 只重试GET、PUT、DELETE等幂等方法, 其他方法需要设置Idempotency-Key请求头
+	RetryCount int
+	// RetryWaitTime 首次重试的等待时间, 之后按指数退避
+	RetryWaitTime time.Duration
+	// RetryMaxWaitTime 重试的最大等待时间
+	RetryMaxWaitTime time.Duration
+	// TLS 开启TLS/mTLS, 通过注册中心访问时使用https
+	TLS *otls.Config
+	// Breaker 按节点熔断, 默认关闭
+	Breaker *BreakerConfig
+
+	SlowThreshold time.Duration
+
+	DisableTraceInterceptor bool
+	DisableAidInterceptor   bool
+	// AppKey 当前应用的签名密钥, 不为空时对aid签名, 服务端据此校验调用方身份
+	AppKey                    string
+	DisableTimeoutInterceptor bool
+	DisableMetricInterceptor  bool
+	DisableAccessInterceptor  bool
+	// Access 访问日志的级别、采样配置, 按"GET./path"匹配
+	Access *oaccess.Config
+
+	logger    *olog.Logger
+	registry  registry.Registry
+	transport http.RoundTripper
+}
+
+// BreakerConfig 熔断配置, 节点连续失败达到阈值后熔断, 熔断期间请求直接失败
+type BreakerConfig struct {
+	Enable bool
+	// FailureThreshold 连续失败次数, 连接错误和5xx视为失败
+	FailureThreshold int
+	// OpenTimeout 熔断持续时间, 之后放行一个探测请求, 成功则恢复
+	OpenTimeout time.Duration
+}
+
+// DefaultBreakerConfig ...
+func DefaultBreakerConfig() *BreakerConfig {
+	return &BreakerConfig{
+		Enable:           false,
+		FailureThreshold: 5,
+		OpenTimeout:      otime.Duration("10s"),
+	}
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		logger:           olog.OxLogger.With(olog.FieldMod(ecode.ModClientHTTP)),
+		ReadTimeout:      otime.Duration("1s"),
+		RetryWaitTime:    otime.Duration("100ms"),
+		RetryMaxWaitTime: otime.Duration("2s"),
+		SlowThreshold:    otime.Duration("600ms"),
+		Breaker:          DefaultBreakerConfig(),
+		Access:           oaccess.DefaultConfig(),
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig("ox.client.http." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil {
+		config.logger.Panic("client http parse config panic", olog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), olog.FieldErr(err), olog.FieldKey(key), olog.FieldValueAny(config))
+	}
+	return config
+}
+
+// WithLogger ...
+func (config *Config) WithLogger(logger *olog.Logger) *Config {
+	config.logger = logger
+	return config
+}
+
+// WithRegistry 设置解析Address的注册中心, 默认为registry.DefaultRegisterer
+func (config *Config) WithRegistry(reg registry.Registry) *Config {
+	config.registry = reg
+	return config
+}
+
+// WithTransport 设置底层的http.RoundTripper, 拦截器包装在其外层
+func (config *Config) WithTransport(transport http.RoundTripper) *Config {
+	config.transport = transport
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Client {
+	client, err := newClient(config)
+	if err != nil {
+		config.logger.Panic("client http build panic", olog.FieldErr(err), olog.FieldName(config.Name), olog.FieldAddr(config.Address))
+	}
+	return client
+}
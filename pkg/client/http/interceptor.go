@@ -0,0 +1,307 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/xqk/ox/pkg"
+	"github.com/xqk/ox/pkg/auth"
+	"github.com/xqk/ox/pkg/deadline"
+	"github.com/xqk/ox/pkg/metric"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/requestid"
+	"github.com/xqk/ox/pkg/trace"
+	"github.com/xqk/ox/pkg/util/oaccess"
+)
+
+var (
+	errSlowCommand       = errors.New("http request slow command")
+	errDeadlineExhausted = errors.New("http request deadline exhausted")
+)
+
+// roundTripperFunc ...
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// interceptor 包装http.RoundTripper, 每次重试都会经过
+type interceptor func(next http.RoundTripper) http.RoundTripper
+
+// buildTransport returns base wrapped with interceptors, the first interceptor is the outermost
+func (config *Config) buildTransport(base http.RoundTripper, resolver *resolver) http.RoundTripper {
+	var interceptors = []interceptor{resolveInterceptor(resolver)}
+	if !config.DisableMetricInterceptor {
+		interceptors = append(interceptors, metricInterceptor(config.Name))
+	}
+	if !config.DisableAccessInterceptor {
+		interceptors = append(interceptors, loggerInterceptor(config.logger, config.Name, config.Access))
+	}
+	if !config.DisableTimeoutInterceptor {
+		interceptors = append(interceptors, timeoutInterceptor(config.logger, config.ReadTimeout, config.SlowThreshold))
+	}
+	if !config.DisableTraceInterceptor {
+		interceptors = append(interceptors, traceInterceptor())
+	}
+	// 请求id始终传递
+	interceptors = append(interceptors, requestIDInterceptor)
+	if !config.DisableAidInterceptor {
+		interceptors = append(interceptors, aidInterceptor(config.AppKey))
+	}
+	if config.Breaker != nil && config.Breaker.Enable {
+		interceptors = append(interceptors, breakerInterceptor(newBreakers(config.Breaker)))
+	}
+
+	var transport = base
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		transport = interceptors[i](transport)
+	}
+	return transport
+}
+
+// routeFromRequest returns url template recorded before request, path of url if absent
+func routeFromRequest(req *http.Request) string {
+	if route, ok := req.Context().Value(routeKey{}).(string); ok {
+		return route
+	}
+	return req.URL.Path
+}
+
+// codeOf returns code label of metric
+func codeOf(resp *http.Response, err error) string {
+	switch {
+	case err == nil:
+		return http.StatusText(resp.StatusCode)
+	case errors.Is(err, ErrBreakerOpen):
+		return "breaker open"
+	case errors.Is(err, ErrNoAvailableNode):
+		return "no available node"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, errDeadlineExhausted):
+		return "deadline exceeded"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "error"
+	}
+}
+
+// resolveInterceptor 复制请求, 通过注册中心访问时将host替换为节点地址
+func resolveInterceptor(resolver *resolver) interceptor {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			// RoundTripper不能修改传入的请求
+			req = req.Clone(req.Context())
+			if resolver == nil {
+				return next.RoundTrip(req)
+			}
+			addr, err := resolver.pick()
+			if err != nil {
+				return nil, err
+			}
+			req.URL.Host = addr
+			req.Host = ""
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// metricInterceptor metric统计
+func metricInterceptor(name string) interceptor {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			beg := time.Now()
+			resp, err := next.RoundTrip(req)
+			method := req.Method + "." + routeFromRequest(req)
+			metric.ClientHandleCounter.Inc(metric.TypeHTTP, name, method, req.URL.Host, codeOf(resp, err))
+			metric.ClientHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeHTTP, name, method, req.URL.Host)
+			return resp, err
+		})
+	}
+}
+
+// loggerInterceptor 访问日志, 失败或5xx时记录Error
+func loggerInterceptor(_logger *olog.Logger, name string, config *oaccess.Config) interceptor {
+	if config == nil {
+		config = oaccess.DefaultConfig()
+	}
+	var access = config.Build()
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			beg := time.Now()
+			resp, err := next.RoundTrip(req)
+
+			method := req.Method + "." + routeFromRequest(req)
+			failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+			rule := access.Rule(method)
+			if !rule.Sampled(failed) {
+				return resp, err
+			}
+
+			var fields = []olog.Field{olog.FieldType("http")}
+			if err != nil {
+				fields = append(fields, olog.FieldErr(err))
+			} else {
+				fields = append(fields, olog.FieldCode(int32(resp.StatusCode)))
+			}
+			fields = append(fields,
+				olog.FieldName(name),
+				olog.FieldMethod(method),
+				olog.FieldAddr(req.URL.Host),
+				olog.FieldCost(time.Since(beg)),
+			)
+			_logger := _logger.WithContext(req.Context())
+			if failed {
+				_logger.Error("access", fields...)
+				return resp, err
+			}
+			rule.Log(_logger, "access", fields...)
+			return resp, err
+		})
+	}
+}
+
+// timeoutInterceptor 未设置deadline时使用timeout, 传递剩余时间预算并记录慢请求
+func timeoutInterceptor(_logger *olog.Logger, timeout time.Duration, slowThreshold time.Duration) interceptor {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			now := time.Now()
+			ctx := req.Context()
+			var cancel context.CancelFunc = func() {}
+			if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				req = req.WithContext(ctx)
+			}
+
+			// 时间预算已耗尽, 没有必要再发起调用
+			if remaining, _ := deadline.Remaining(ctx); remaining <= 0 {
+				cancel()
+				_logger.WithContext(ctx).Warn("deadline exhausted",
+					olog.FieldErr(errDeadlineExhausted),
+					olog.FieldMethod(req.Method+"."+routeFromRequest(req)),
+					olog.FieldAddr(req.URL.Host),
+					olog.Any("hops", deadline.Hops(ctx)),
+				)
+				return nil, errDeadlineExhausted
+			}
+
+			// 将剩余时间预算传递给下游
+			deadline.InjectHeader(ctx, req.Header)
+			resp, err := next.RoundTrip(req)
+			du := time.Since(now)
+			if slowThreshold > time.Duration(0) && du > slowThreshold {
+				_logger.WithContext(ctx).Error("slow",
+					olog.FieldErr(errSlowCommand),
+					olog.FieldMethod(req.Method+"."+routeFromRequest(req)),
+					olog.FieldAddr(req.URL.Host),
+					olog.FieldCost(du),
+				)
+			}
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			// 读取完响应后才能取消context
+			resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+			return resp, nil
+		})
+	}
+}
+
+// cancelBody 关闭时取消请求的context
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close ...
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// traceInterceptor 创建client span, 将span context写入header
+func traceInterceptor() interceptor {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			span, ctx := trace.StartSpanFromContext(
+				req.Context(),
+				req.Method+" "+routeFromRequest(req),
+				trace.TagSpanKind("client"),
+				trace.TagComponent("http"),
+				trace.CustomTag("http.url", req.URL.Path),
+				trace.CustomTag("http.method", req.Method),
+				trace.CustomTag("peer.address", req.URL.Host),
+			)
+			defer span.Finish()
+
+			var carrier = make(map[string][]string)
+			ctx = trace.HeaderInjector(ctx, carrier)
+			for key, vals := range carrier {
+				for _, val := range vals {
+					req.Header.Add(key, val)
+				}
+			}
+
+			resp, err := next.RoundTrip(req.WithContext(ctx))
+			if err != nil {
+				ext.Error.Set(span, true)
+				span.LogFields(trace.String("event", "error"), trace.String("message", err.Error()))
+				return resp, err
+			}
+			ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode))
+			if resp.StatusCode >= http.StatusInternalServerError {
+				ext.Error.Set(span, true)
+			}
+			return resp, err
+		})
+	}
+}
+
+// requestIDInterceptor 传递context中的请求id
+func requestIDInterceptor(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requestid.InjectHeader(req.Context(), req.Header)
+		return next.RoundTrip(req)
+	})
+}
+
+// aidInterceptor 传递当前应用的aid, appKey不为空时附带签名
+func aidInterceptor(appKey string) interceptor {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if appKey != "" {
+				auth.SignCallerHTTP(req, pkg.AppID(), appKey)
+			} else {
+				req.Header.Set(auth.HeaderAID, pkg.AppID())
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// breakerInterceptor 节点熔断时直接返回ErrBreakerOpen
+func breakerInterceptor(breakers *breakers) interceptor {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			b := breakers.get(req.URL.Host)
+			if !b.allow() {
+				return nil, &breakerError{addr: req.URL.Host}
+			}
+			resp, err := next.RoundTrip(req)
+			// 调用方取消的请求不计入
+			if errors.Is(err, context.Canceled) {
+				b.release()
+				return resp, err
+			}
+			b.report(err == nil && resp.StatusCode < http.StatusInternalServerError)
+			return resp, err
+		})
+	}
+}
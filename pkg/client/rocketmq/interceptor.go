@@ -12,6 +12,7 @@ import (
 	"github.com/xqk/ox/pkg/imeta"
 	"github.com/xqk/ox/pkg/istats"
 	"github.com/xqk/ox/pkg/metric"
+	"github.com/xqk/ox/pkg/requestid"
 )
 
 type FlowInfo struct {
@@ -103,6 +104,7 @@ func pushConsumerMDInterceptor(pushConsumer *PushConsumer) primitive.Interceptor
 				}
 			}
 			ctx = imeta.WithContext(ctx, meta)
+			ctx = requestid.NewContext(ctx, requestid.OrNew(msgs[0].GetProperty(requestid.HeaderRequestID)))
 		}
 		err := next(ctx, msgs, reply)
 		return err
@@ -226,7 +228,7 @@ func producerDefaultInterceptor(producer *Producer) primitive.Interceptor {
 	}
 }
 
-// 统一minerva metadata 传递
+// 统一minerva metadata和请求id 传递
 func producerMDInterceptor(producer *Producer) primitive.Interceptor {
 	return func(ctx context.Context, req, reply interface{}, next primitive.Invoker) error {
 		if md, ok := imeta.FromContext(ctx); ok {
@@ -235,6 +237,9 @@ func producerMDInterceptor(producer *Producer) primitive.Interceptor {
 				realReq.WithProperty(k, strings.Join(v, ","))
 			}
 		}
+		if id := requestid.FromContext(ctx); id != "" {
+			req.(*primitive.Message).WithProperty(requestid.HeaderRequestID, id)
+		}
 		err := next(ctx, req, reply)
 		return err
 	}
@@ -0,0 +1,131 @@
+// Package deadline 跨服务传递剩余的时间预算
+// 调用方将剩余时间(毫秒)和调用链写入metadata/header, 服务端据此派生handler的context
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xqk/ox/pkg"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// HeaderTimeout 剩余时间预算, 单位毫秒
+	HeaderTimeout = "X-Ox-Timeout"
+	// HeaderHops 调用链, 逗号分隔的应用名
+	HeaderHops = "X-Ox-Hops"
+
+	// MetadataTimeout grpc metadata key of HeaderTimeout
+	MetadataTimeout = "x-ox-timeout"
+	// MetadataHops grpc metadata key of HeaderHops
+	MetadataHops = "x-ox-hops"
+)
+
+type hopsKey struct{}
+
+// Budget is the time budget of an inbound request
+type Budget struct {
+	// Timeout 剩余时间预算
+	Timeout time.Duration
+	// Hops 上游调用链
+	Hops []string
+}
+
+// Expired reports whether the budget is exhausted when the request arrives
+func (b Budget) Expired() bool {
+	return b.Timeout <= 0
+}
+
+// FromMetadata extracts budget from incoming grpc metadata
+func FromMetadata(md metadata.MD) (Budget, bool) {
+	return parse(first(md.Get(MetadataTimeout)), first(md.Get(MetadataHops)))
+}
+
+// FromHeader extracts budget from http header
+func FromHeader(header http.Header) (Budget, bool) {
+	return parse(header.Get(HeaderTimeout), header.Get(HeaderHops))
+}
+
+// NewContext derives a context which expires when budget runs out, and carries the hop chain.
+func NewContext(ctx context.Context, budget Budget) (context.Context, context.CancelFunc) {
+	ctx = context.WithValue(ctx, hopsKey{}, budget.Hops)
+	return context.WithTimeout(ctx, budget.Timeout)
+}
+
+// Hops returns upstream hop chain carried by ctx
+func Hops(ctx context.Context) []string {
+	hops, _ := ctx.Value(hopsKey{}).([]string)
+	return hops
+}
+
+// Remaining returns remaining time of ctx, false if ctx has no deadline.
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	dl, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(dl), true
+}
+
+// AppendToOutgoingContext writes remaining budget and hop chain of ctx into outgoing grpc metadata.
+func AppendToOutgoingContext(ctx context.Context) context.Context {
+	remaining, ok := Remaining(ctx)
+	if !ok {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx,
+		MetadataTimeout, encode(remaining),
+		MetadataHops, strings.Join(outgoingHops(ctx), ","),
+	)
+}
+
+// InjectHeader writes remaining budget and hop chain of ctx into http header.
+func InjectHeader(ctx context.Context, header http.Header) {
+	remaining, ok := Remaining(ctx)
+	if !ok {
+		return
+	}
+	header.Set(HeaderTimeout, encode(remaining))
+	header.Set(HeaderHops, strings.Join(outgoingHops(ctx), ","))
+}
+
+func outgoingHops(ctx context.Context) []string {
+	hops := Hops(ctx)
+	out := make([]string, 0, len(hops)+1)
+	out = append(out, hops...)
+	return append(out, pkg.Name())
+}
+
+func encode(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	return strconv.FormatInt(int64(d/time.Millisecond), 10)
+}
+
+func parse(timeout string, hops string) (Budget, bool) {
+	if timeout == "" {
+		return Budget{}, false
+	}
+	ms, err := strconv.ParseInt(timeout, 10, 64)
+	if err != nil {
+		return Budget{}, false
+	}
+
+	var budget = Budget{Timeout: time.Duration(ms) * time.Millisecond}
+	if hops != "" {
+		budget.Hops = strings.Split(hops, ",")
+	}
+	return budget, true
+}
+
+func first(vals []string) string {
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
@@ -0,0 +1,70 @@
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestHeader(t *testing.T) {
+	t.Run("no deadline", func(t *testing.T) {
+		header := http.Header{}
+		InjectHeader(context.Background(), header)
+		_, ok := FromHeader(header)
+		assert.False(t, ok)
+	})
+
+	t.Run("propagate budget", func(t *testing.T) {
+		ctx, cancel := NewContext(context.Background(), Budget{Timeout: time.Second, Hops: []string{"gateway"}})
+		defer cancel()
+
+		header := http.Header{}
+		InjectHeader(ctx, header)
+		budget, ok := FromHeader(header)
+		assert.True(t, ok)
+		assert.False(t, budget.Expired())
+		assert.True(t, budget.Timeout <= time.Second && budget.Timeout > time.Millisecond*900)
+		assert.Equal(t, []string{"gateway", pkg.Name()}, budget.Hops)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+		defer cancel()
+
+		header := http.Header{}
+		InjectHeader(ctx, header)
+		budget, ok := FromHeader(header)
+		assert.True(t, ok)
+		assert.True(t, budget.Expired())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		header := http.Header{}
+		header.Set(HeaderTimeout, "1s")
+		_, ok := FromHeader(header)
+		assert.False(t, ok)
+	})
+}
+
+func TestMetadata(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	ctx = AppendToOutgoingContext(ctx)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	budget, ok := FromMetadata(md)
+	assert.True(t, ok)
+	assert.Equal(t, []string{pkg.Name()}, budget.Hops)
+
+	ctx, cancel = NewContext(context.Background(), budget)
+	defer cancel()
+	remaining, ok := Remaining(ctx)
+	assert.True(t, ok)
+	assert.True(t, remaining <= time.Second)
+	assert.Equal(t, []string{pkg.Name()}, Hops(ctx))
+}
@@ -21,6 +21,14 @@ var (
 	_codes           sync.Map
 	// OK ...
 	OK = add(int(codes.OK), "OK")
+	// DeadlineExhausted 请求到达时调用方的时间预算已耗尽
+	DeadlineExhausted = add(1100, "deadline exhausted")
+	// Overloaded 服务过载, 请求被自适应限流提前拒绝, 使用Unavailable以便客户端重试其他节点
+	Overloaded = add(int(codes.Unavailable), "server overloaded")
+	// Unauthenticated 请求未通过认证
+	Unauthenticated = add(int(codes.Unauthenticated), "unauthenticated")
+	// PermissionDenied 调用方没有访问权限
+	PermissionDenied = add(int(codes.PermissionDenied), "permission denied")
 )
 
 func init() {
@@ -0,0 +1,42 @@
+package ecode
+
+import (
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+)
+
+var httpStatus = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           499,
+	codes.Unknown:            http.StatusInternalServerError,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.Aborted:            http.StatusConflict,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.DataLoss:           http.StatusInternalServerError,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+}
+
+// HTTPStatus 返回错误码对应的HTTP状态码.
+// grpc标准码按grpc-gateway的规则转换, 业务错误码(大于EcodeNum)返回200, 由响应中的错误码区分, 其他返回500
+func HTTPStatus(code int) int {
+	if status, ok := httpStatus[codes.Code(code)]; ok {
+		return status
+	}
+	switch {
+	case code == int(DeadlineExhausted.Code):
+		return http.StatusGatewayTimeout
+	case code > int(EcodeNum):
+		return http.StatusOK
+	}
+	return http.StatusInternalServerError
+}
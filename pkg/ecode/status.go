@@ -10,6 +10,7 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes/any"
 	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/status"
 )
 
 // Status ...
@@ -86,6 +87,14 @@ func (s *spbStatus) Proto() *spb.Status {
 	return proto.Clone(s.Status).(*spb.Status)
 }
 
+// Err converts status into grpc error, nil if status is OK
+func (s *spbStatus) Err() error {
+	if s.CauseCode() == 0 {
+		return nil
+	}
+	return status.ErrorProto(s.Proto())
+}
+
 // MustWithDetails ...
 func (s *spbStatus) MustWithDetails(details ...interface{}) *spbStatus {
 	status, err := s.WithDetails(details...)
@@ -103,6 +103,8 @@ const (
 	ModClientETCD = "client.etcd"
 	// ModClientGrpc ...
 	ModClientGrpc = "client.grpc"
+	// ModClientHTTP ...
+	ModClientHTTP = "client.http"
 	// ModClientMySQL ...
 	ModClientMySQL = "client.mysql"
 	// ModXcronETCD ...
@@ -2,6 +2,7 @@ package governor
 
 import (
 	"fmt"
+	"os"
 	"github.com/xqk/ox/pkg/olog"
 
 	"github.com/xqk/ox/pkg/conf"
@@ -13,11 +14,15 @@ const ModName = "govern"
 
 // Config ...
 type Config struct {
-	Host    string
-	Port    int
+	Host string
+	Port int
+	// Network network type, tcp4 by default.
+	// 为unix时Host为socket文件路径, 以"@"开头时为abstract socket
 	Network string `json:"network" toml:"network"`
-	logger  *olog.Logger
-	Enable  bool
+	// SocketMode unix socket文件的权限, 为0时不修改
+	SocketMode os.FileMode `json:"socketMode" toml:"socketMode"`
+	logger     *olog.Logger
+	Enable     bool
 
 	// ServiceAddress service address in registry info, default to 'Host:Port'
 	ServiceAddress string
@@ -69,5 +74,8 @@ func (config *Config) Build() *Server {
 
 // Address ...
 func (config Config) Address() string {
+	if onet.IsUnixNetwork(config.Network) {
+		return config.Host
+	}
 	return fmt.Sprintf("%s:%d", config.Host, config.Port)
 }
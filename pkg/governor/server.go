@@ -8,6 +8,7 @@ import (
 	"github.com/xqk/ox/pkg/constant"
 	"github.com/xqk/ox/pkg/server"
 	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/util/onet"
 )
 
 // Server ...
@@ -18,7 +19,7 @@ type Server struct {
 }
 
 func newServer(config *Config) *Server {
-	var listener, err = net.Listen("tcp4", config.Address())
+	var listener, err = onet.Listen(config.Network, config.Address(), config.SocketMode)
 	if err != nil {
 		olog.Panic("governor start error", olog.FieldErr(err))
 	}
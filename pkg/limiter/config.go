@@ -0,0 +1,81 @@
+// Package limiter 自适应并发限流, 参考BBR: 以窗口内的最大吞吐和最小耗时估算系统容量,
+// 在系统过载且并发超过容量时提前拒绝请求
+package limiter
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// PriorityHigh 高优先级, 并发超过容量的2倍才拒绝
+	PriorityHigh = "high"
+	// PriorityNormal 默认优先级, 并发超过容量时拒绝
+	PriorityNormal = "normal"
+	// PriorityLow 低优先级, 并发超过容量的一半时即拒绝
+	PriorityLow = "low"
+)
+
+// Config 自适应限流配置
+type Config struct {
+	// Enable 开启限流, 默认关闭
+	Enable bool `json:"enable" toml:"enable"`
+	// Window 统计窗口, 默认10s
+	Window time.Duration `json:"window" toml:"window"`
+	// Buckets 窗口内的桶数, 默认100
+	Buckets int `json:"buckets" toml:"buckets"`
+	// MinInFlight 并发数低于该值时不限流, 默认10
+	MinInFlight int64 `json:"minInFlight" toml:"minInFlight"`
+	// LatencyRatio 最近1秒的平均耗时超过窗口内最小耗时的倍数时认为系统过载, 默认2
+	LatencyRatio float64 `json:"latencyRatio" toml:"latencyRatio"`
+	// CoolDown 拒绝请求后, 在该时间内即使耗时恢复也继续按容量判断, 默认1s
+	CoolDown time.Duration `json:"coolDown" toml:"coolDown"`
+	// Priorities 按方法或路由配置优先级
+	Priorities []PriorityConfig `json:"priorities" toml:"priorities"`
+}
+
+// PriorityConfig 方法或路由的优先级
+type PriorityConfig struct {
+	// Method grpc完整方法名或http路由, 以*结尾时按前缀匹配
+	Method string `json:"method" toml:"method"`
+	// Priority high|normal|low
+	Priority string `json:"priority" toml:"priority"`
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Enable:       false,
+		Window:       time.Second * 10,
+		Buckets:      100,
+		MinInFlight:  10,
+		LatencyRatio: 2,
+		CoolDown:     time.Second,
+	}
+}
+
+// priority returns priority of method, exact match first, then longest prefix.
+// exact reports whether method is configured exactly
+func (config *Config) priority(method string) (priority string, exact bool) {
+	priority = PriorityNormal
+	var matched = -1
+	for _, pc := range config.Priorities {
+		if pc.Method == method {
+			return pc.Priority, true
+		}
+		if prefix := strings.TrimSuffix(pc.Method, "*"); prefix != pc.Method && strings.HasPrefix(method, prefix) && len(prefix) > matched {
+			priority, matched = pc.Priority, len(prefix)
+		}
+	}
+	return priority, false
+}
+
+func factor(priority string) float64 {
+	switch priority {
+	case PriorityHigh:
+		return 2
+	case PriorityLow:
+		return 0.5
+	}
+	return 1
+}
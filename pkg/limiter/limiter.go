@@ -0,0 +1,197 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xqk/ox/pkg/metric"
+)
+
+// Stats 限流器的运行时状态
+type Stats struct {
+	InFlight int64 `json:"inFlight"`
+	// MaxInFlight 估算的系统容量, 为0时表示样本不足
+	MaxInFlight float64       `json:"maxInFlight"`
+	MaxPass     int64         `json:"maxPass"`
+	MinRT       time.Duration `json:"minRT"`
+	AvgRT       time.Duration `json:"avgRT"`
+}
+
+type bucket struct {
+	index int64
+	pass  int64
+	rtSum time.Duration
+	minRT time.Duration
+}
+
+// Limiter 自适应并发限流器, 同一个服务的所有方法共享
+type Limiter struct {
+	typ        string
+	config     *Config
+	inFlight   int64
+	lastDrop   int64
+	bucketSize time.Duration
+	now        func() time.Time
+	priorities sync.Map
+
+	mu      sync.Mutex
+	buckets []bucket
+}
+
+// New creates a limiter, typ is the type label of metrics, such as metric.TypeHTTP
+func New(typ string, config *Config) *Limiter {
+	// 复制配置, 不修改调用方的配置
+	var copied = *config
+	var defaultConfig = DefaultConfig()
+	if copied.Window <= 0 {
+		copied.Window = defaultConfig.Window
+	}
+	if copied.Buckets <= 0 {
+		copied.Buckets = defaultConfig.Buckets
+	}
+	// 窗口小于桶数时每个桶的时长为0
+	if copied.Window/time.Duration(copied.Buckets) <= 0 {
+		copied.Window, copied.Buckets = defaultConfig.Window, defaultConfig.Buckets
+	}
+	if copied.LatencyRatio <= 0 {
+		copied.LatencyRatio = defaultConfig.LatencyRatio
+	}
+	return &Limiter{
+		typ:        typ,
+		config:     &copied,
+		bucketSize: copied.Window / time.Duration(copied.Buckets),
+		now:        time.Now,
+		buckets:    make([]bucket, copied.Buckets),
+	}
+}
+
+// Allow reports whether request of method is allowed, done must be called when the request finishes.
+func (l *Limiter) Allow(method string) (done func(), ok bool) {
+	priority := l.priority(method)
+	if l.shouldDrop(priority) {
+		metric.ServerShedCounter.Inc(l.typ, method, priority)
+		return nil, false
+	}
+
+	atomic.AddInt64(&l.inFlight, 1)
+	start := l.now()
+	return func() {
+		l.add(l.now().Sub(start))
+		atomic.AddInt64(&l.inFlight, -1)
+	}, true
+}
+
+// Stats returns snapshot of the limiter
+func (l *Limiter) Stats() Stats {
+	maxPass, minRT, avgRT := l.window()
+	return Stats{
+		InFlight:    atomic.LoadInt64(&l.inFlight),
+		MaxInFlight: l.maxInFlight(maxPass, minRT),
+		MaxPass:     maxPass,
+		MinRT:       minRT,
+		AvgRT:       avgRT,
+	}
+}
+
+func (l *Limiter) priority(method string) string {
+	if val, ok := l.priorities.Load(method); ok {
+		return val.(string)
+	}
+	priority, exact := l.config.priority(method)
+	// 只缓存配置中的路由或方法, 其他key可能无限增长
+	if exact {
+		l.priorities.Store(method, priority)
+	}
+	return priority
+}
+
+func (l *Limiter) shouldDrop(priority string) bool {
+	inFlight := atomic.LoadInt64(&l.inFlight)
+	if inFlight < l.config.MinInFlight {
+		return false
+	}
+
+	maxPass, minRT, avgRT := l.window()
+	maxInFlight := l.maxInFlight(maxPass, minRT)
+	metric.ServerLimiterGauge.Set(maxInFlight, l.typ, "max_inflight")
+	metric.ServerLimiterGauge.Set(float64(inFlight), l.typ, "inflight")
+	if maxInFlight == 0 || float64(inFlight) < maxInFlight*factor(priority) {
+		return false
+	}
+
+	// 最近耗时明显上升, 或刚拒绝过请求时, 认为系统过载
+	now := l.now().UnixNano()
+	overloaded := float64(avgRT) > float64(minRT)*l.config.LatencyRatio ||
+		now-atomic.LoadInt64(&l.lastDrop) < int64(l.config.CoolDown)
+	if !overloaded {
+		return false
+	}
+	atomic.StoreInt64(&l.lastDrop, now)
+	return true
+}
+
+// maxInFlight 容量 = 每秒最大通过数 * 最小耗时
+func (l *Limiter) maxInFlight(maxPass int64, minRT time.Duration) float64 {
+	if maxPass == 0 || minRT == 0 {
+		return 0
+	}
+	perSecond := float64(time.Second) / float64(l.bucketSize)
+	return math.Ceil(float64(maxPass) * perSecond * minRT.Seconds())
+}
+
+func (l *Limiter) add(rt time.Duration) {
+	index := l.now().UnixNano() / int64(l.bucketSize)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := &l.buckets[index%int64(len(l.buckets))]
+	if b.index != index {
+		*b = bucket{index: index}
+	}
+	b.pass++
+	b.rtSum += rt
+	if b.minRT == 0 || rt < b.minRT {
+		b.minRT = rt
+	}
+}
+
+// window returns max pass and min rt of finished buckets in window, and average rt in last second
+func (l *Limiter) window() (maxPass int64, minRT time.Duration, avgRT time.Duration) {
+	index := l.now().UnixNano() / int64(l.bucketSize)
+	recent := int64(time.Second / l.bucketSize)
+	if recent < 1 {
+		recent = 1
+	}
+
+	var recentPass int64
+	var recentRT time.Duration
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, b := range l.buckets {
+		age := index - b.index
+		if b.pass == 0 || age >= int64(len(l.buckets)) {
+			continue
+		}
+		if age < recent {
+			recentPass += b.pass
+			recentRT += b.rtSum
+		}
+		// 当前桶尚未结束, 不参与容量估算
+		if age == 0 {
+			continue
+		}
+		if b.pass > maxPass {
+			maxPass = b.pass
+		}
+		if minRT == 0 || b.minRT < minRT {
+			minRT = b.minRT
+		}
+	}
+	if recentPass > 0 {
+		avgRT = recentRT / time.Duration(recentPass)
+	}
+	return
+}
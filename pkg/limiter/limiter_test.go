@@ -0,0 +1,119 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriority(t *testing.T) {
+	config := DefaultConfig()
+	config.Priorities = []PriorityConfig{
+		{Method: "/pkg.Order/*", Priority: PriorityLow},
+		{Method: "/pkg.Order/Pay*", Priority: PriorityHigh},
+		{Method: "/pkg.Order/Create", Priority: PriorityHigh},
+	}
+	priority := func(method string) string {
+		p, _ := config.priority(method)
+		return p
+	}
+	assert.Equal(t, PriorityNormal, priority("/pkg.User/Get"))
+	assert.Equal(t, PriorityLow, priority("/pkg.Order/List"))
+	assert.Equal(t, PriorityHigh, priority("/pkg.Order/PayCallback"))
+	assert.Equal(t, PriorityHigh, priority("/pkg.Order/Create"))
+
+	// 只缓存配置中的方法
+	l := New("test", config)
+	l.priority("/pkg.Order/Create")
+	l.priority("/pkg.Order/List")
+	l.priority("/unknown")
+	var keys []interface{}
+	l.priorities.Range(func(key, _ interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal(t, []interface{}{"/pkg.Order/Create"}, keys)
+}
+
+func TestLimiter(t *testing.T) {
+	config := DefaultConfig()
+	config.Enable = true
+	config.Window = time.Second
+	config.Buckets = 10
+	config.MinInFlight = 0
+	config.Priorities = []PriorityConfig{
+		{Method: "/low", Priority: PriorityLow},
+		{Method: "/high", Priority: PriorityHigh},
+	}
+	l := New("test", config)
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+
+	// 每100ms通过10个请求, 最小耗时100ms, 容量为 10 * 10 * 0.1 = 10
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 10; j++ {
+			l.add(time.Millisecond * 100)
+		}
+		now = now.Add(time.Millisecond * 100)
+	}
+	stats := l.Stats()
+	assert.Equal(t, int64(10), stats.MaxPass)
+	assert.Equal(t, time.Millisecond*100, stats.MinRT)
+	assert.Equal(t, float64(10), stats.MaxInFlight)
+
+	var dones []func()
+	for i := 0; i < 10; i++ {
+		done, ok := l.Allow("/normal")
+		assert.True(t, ok)
+		dones = append(dones, done)
+	}
+
+	// 耗时未上升, 并发超过容量也不拒绝
+	_, ok := l.Allow("/normal")
+	assert.True(t, ok)
+	l.inFlight--
+
+	// 耗时上升到最小耗时的3倍, 系统过载
+	for j := 0; j < 40; j++ {
+		l.add(time.Millisecond * 400)
+	}
+	_, ok = l.Allow("/normal")
+	assert.False(t, ok)
+	_, ok = l.Allow("/low")
+	assert.False(t, ok)
+	done, ok := l.Allow("/high")
+	assert.True(t, ok)
+	done()
+
+	for _, done := range dones[:6] {
+		done()
+	}
+	// 并发低于容量的一半以下时, 低优先级也可以通过
+	done, ok = l.Allow("/low")
+	assert.True(t, ok)
+	done()
+}
+
+func TestLimiterNoSamples(t *testing.T) {
+	config := DefaultConfig()
+	config.MinInFlight = 0
+	l := New("test", config)
+	for i := 0; i < 100; i++ {
+		_, ok := l.Allow("/normal")
+		assert.True(t, ok)
+	}
+	assert.Equal(t, int64(100), l.Stats().InFlight)
+}
+
+func TestNew_Config(t *testing.T) {
+	config := &Config{Window: 50, Buckets: 100}
+	l := New("test", config)
+	// 不修改调用方的配置, 桶时长为0时使用默认的窗口
+	assert.Equal(t, time.Duration(50), config.Window)
+	assert.Equal(t, float64(0), config.LatencyRatio)
+	assert.Equal(t, DefaultConfig().Window/time.Duration(DefaultConfig().Buckets), l.bucketSize)
+	done, ok := l.Allow("/")
+	assert.True(t, ok)
+	done()
+}
@@ -31,6 +31,8 @@ var (
 	TypeRocketMQ = "rocketmq"
 	// TypeWebsocket ...
 	TypeWebsocket = "ws"
+	// TypeSSE ...
+	TypeSSE = "sse"
 
 	// TypeMySQL ...
 	TypeMySQL = "mysql"
@@ -46,6 +48,10 @@ var (
 	CodeCacheMiss = "miss"
 	// CodeCacheHit ...
 	CodeCacheHit = "hit"
+	// CodeCacheStale 返回过期的缓存, 同时刷新
+	CodeCacheStale = "stale"
+	// CodeCacheBypass 请求要求不使用缓存
+	CodeCacheBypass = "bypass"
 
 	// Namespace
 	DefaultNamespace = "ox"
@@ -66,6 +72,48 @@ var (
 		Labels:    []string{"type", "method", "peer"},
 	}.Build()
 
+	// ServerShedCounter 自适应限流拒绝的请求数
+	ServerShedCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "server_shed_total",
+		Labels:    []string{"type", "method", "priority"},
+	}.Build()
+
+	// ServerCallerDeniedCounter 调用方签名校验或ACL拒绝的请求数
+	ServerCallerDeniedCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "server_caller_denied_total",
+		Labels:    []string{"type", "method", "aid", "reason"},
+	}.Build()
+
+	// ServerLimiterGauge 自适应限流的并发数和估算容量
+	ServerLimiterGauge = GaugeVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "server_limiter",
+		Labels:    []string{"type", "stat"},
+	}.Build()
+
+	// ServerConnGauge 服务当前的连接数
+	ServerConnGauge = GaugeVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "server_conn",
+		Labels:    []string{"type", "name"},
+	}.Build()
+
+	// ServerInflightGauge 服务进行中的请求数
+	ServerInflightGauge = GaugeVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "server_inflight",
+		Labels:    []string{"type", "name"},
+	}.Build()
+
+	// ServerMessageCounter 长连接收发的消息数, direction为in或out
+	ServerMessageCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "server_message_total",
+		Labels:    []string{"type", "name", "direction", "code"},
+	}.Build()
+
 	// ClientHandleCounter ...
 	ClientHandleCounter = CounterVecOpts{
 		Namespace: DefaultNamespace,
@@ -80,6 +128,20 @@ var (
 		Labels:    []string{"type", "name", "method", "peer"},
 	}.Build()
 
+	// ClientEjectionCounter ...
+	ClientEjectionCounter = CounterVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_ejection_total",
+		Labels:    []string{"type", "name", "peer", "reason"},
+	}.Build()
+
+	// ClientEjectedGauge ...
+	ClientEjectedGauge = GaugeVecOpts{
+		Namespace: DefaultNamespace,
+		Name:      "client_ejected",
+		Labels:    []string{"type", "name"},
+	}.Build()
+
 	// JobHandleCounter ...
 	JobHandleCounter = CounterVecOpts{
 		Namespace: DefaultNamespace,
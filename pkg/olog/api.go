@@ -1,6 +1,8 @@
 package olog
 
 import (
+	"context"
+
 	"go.uber.org/zap"
 )
 
@@ -261,3 +263,8 @@ func (fn Func) Log(msg string, fields ...Field) {
 func With(fields ...Field) *Logger {
 	return DefaultLogger.With(fields...)
 }
+
+// WithContext 返回附带ctx中请求id等字段的DefaultLogger
+func WithContext(ctx context.Context) *Logger {
+	return DefaultLogger.WithContext(ctx)
+}
@@ -13,6 +13,11 @@ func FieldAid(value string) Field {
 	return String("aid", value)
 }
 
+// FieldRequestID 请求id
+func FieldRequestID(value string) Field {
+	return String("request_id", value)
+}
+
 // 模块
 func FieldMod(value string) Field {
 	value = strings.Replace(value, " ", ".", -1)
@@ -1,11 +1,13 @@
 package olog
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -386,6 +388,33 @@ func panicDetail(msg string, fields ...Field) {
 
 }
 
+var (
+	contextFieldsMu sync.RWMutex
+	contextFields   []func(ctx context.Context) []Field
+)
+
+// RegisterContextFields registers fn which extracts fields from ctx, eg: request id,
+// the fields are attached by WithContext
+func RegisterContextFields(fn func(ctx context.Context) []Field) {
+	contextFieldsMu.Lock()
+	contextFields = append(contextFields, fn)
+	contextFieldsMu.Unlock()
+}
+
+// WithContext 返回附带ctx中请求id等字段的logger
+func (logger *Logger) WithContext(ctx context.Context) *Logger {
+	var fields []Field
+	contextFieldsMu.RLock()
+	for _, fn := range contextFields {
+		fields = append(fields, fn(ctx)...)
+	}
+	contextFieldsMu.RUnlock()
+	if len(fields) == 0 {
+		return logger
+	}
+	return logger.With(fields...)
+}
+
 // With ...
 func (logger *Logger) With(fields ...Field) *Logger {
 	desugarLogger := logger.desugar.With(fields...)
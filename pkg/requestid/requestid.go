@@ -0,0 +1,102 @@
+// Package requestid 跨服务传递请求id
+// 服务端从X-Request-ID中获取或生成请求id, 写入context, 客户端调用时写入metadata/header/消息属性
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/xqk/ox/pkg/olog"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// HeaderRequestID 请求id的http header, 也用作rocketmq的消息属性
+	HeaderRequestID = "X-Request-ID"
+	// MetadataRequestID grpc metadata key of HeaderRequestID
+	MetadataRequestID = "x-request-id"
+	// TagRequestID 链路中请求id的tag
+	TagRequestID = "request.id"
+
+	// maxLength 请求id的最大长度, 超过时重新生成
+	maxLength = 128
+)
+
+type requestIDKey struct{}
+
+func init() {
+	// 通过olog.WithContext输出日志时附带请求id
+	olog.RegisterContextFields(func(ctx context.Context) []olog.Field {
+		if id := FromContext(ctx); id != "" {
+			return []olog.Field{olog.FieldRequestID(id)}
+		}
+		return nil
+	})
+}
+
+// New 生成新的请求id
+func New() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// NewContext returns a context carrying request id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// FromContext returns request id carried by ctx, empty if absent
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// FromHeader returns request id of http header, generates one if absent or invalid
+func FromHeader(header http.Header) string {
+	return OrNew(header.Get(HeaderRequestID))
+}
+
+// FromMetadata returns request id of incoming grpc metadata, generates one if absent or invalid
+func FromMetadata(md metadata.MD) string {
+	var id string
+	if vals := md.Get(MetadataRequestID); len(vals) > 0 {
+		id = vals[0]
+	}
+	return OrNew(id)
+}
+
+// AppendToOutgoingContext writes request id of ctx into outgoing grpc metadata
+func AppendToOutgoingContext(ctx context.Context) context.Context {
+	id := FromContext(ctx)
+	if id == "" {
+		return ctx
+	}
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok && len(md.Get(MetadataRequestID)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, MetadataRequestID, id)
+}
+
+// InjectHeader writes request id of ctx into http header
+func InjectHeader(ctx context.Context, header http.Header) {
+	if id := FromContext(ctx); id != "" {
+		header.Set(HeaderRequestID, id)
+	}
+}
+
+// OrNew 返回上游传入的id, 缺失或非法时生成新的. 只允许可见的ASCII字符, 避免日志和header注入
+func OrNew(id string) string {
+	if id == "" || len(id) > maxLength {
+		return New()
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return New()
+		}
+	}
+	return id
+}
@@ -0,0 +1,70 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/olog"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestNew(t *testing.T) {
+	id := New()
+	assert.Len(t, id, 32)
+	assert.NotEqual(t, id, New())
+}
+
+func TestFromHeader(t *testing.T) {
+	header := http.Header{}
+	header.Set(HeaderRequestID, "abc-123")
+	assert.Equal(t, "abc-123", FromHeader(header))
+
+	// 缺失或非法时重新生成
+	assert.Len(t, FromHeader(http.Header{}), 32)
+	header.Set(HeaderRequestID, "abc 123")
+	assert.Len(t, FromHeader(header), 32)
+	header.Set(HeaderRequestID, strings.Repeat("a", 129))
+	assert.Len(t, FromHeader(header), 32)
+}
+
+func TestPropagate(t *testing.T) {
+	assert.Equal(t, "", FromContext(context.Background()))
+	ctx := NewContext(context.Background(), "abc")
+	assert.Equal(t, "abc", FromContext(ctx))
+
+	header := http.Header{}
+	InjectHeader(ctx, header)
+	assert.Equal(t, "abc", header.Get(HeaderRequestID))
+
+	outgoing := AppendToOutgoingContext(ctx)
+	md, _ := metadata.FromOutgoingContext(outgoing)
+	assert.Equal(t, "abc", FromMetadata(md))
+	// 已存在时不重复写入
+	md, _ = metadata.FromOutgoingContext(AppendToOutgoingContext(outgoing))
+	assert.Equal(t, []string{"abc"}, md.Get(MetadataRequestID))
+
+	// 没有请求id时不写入
+	_, ok := metadata.FromOutgoingContext(AppendToOutgoingContext(context.Background()))
+	assert.False(t, ok)
+}
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	config := olog.DefaultConfig()
+	config.Debug = true
+	config.Async = false
+	config.Core = core
+	logger := config.Build()
+
+	logger.WithContext(NewContext(context.Background(), "abc")).Info("hello")
+	logger.WithContext(context.Background()).Info("hello")
+	entries := logs.All()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "abc", entries[0].ContextMap()["request_id"])
+	assert.NotContains(t, entries[1].ContextMap(), "request_id")
+}
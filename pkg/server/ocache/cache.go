@@ -0,0 +1,469 @@
+package ocache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	goredis "github.com/go-redis/redis"
+	"github.com/xqk/ox/pkg/client/redis"
+	"github.com/xqk/ox/pkg/metric"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/requestid"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// HeaderCache 缓存状态: HIT, MISS, STALE, BYPASS
+	HeaderCache = "X-Cache"
+
+	varySuffix = ":vary"
+	tagPrefix  = "tag:"
+)
+
+// skipHeaders 不缓存的响应头
+var skipHeaders = map[string]bool{
+	HeaderCache:               true,
+	"Age":                     true,
+	"Date":                    true,
+	"Content-Length":          true,
+	"Connection":              true,
+	"Keep-Alive":              true,
+	"Transfer-Encoding":       true,
+	"Trailer":                 true,
+	"Upgrade":                 true,
+	requestid.HeaderRequestID: true,
+}
+
+// entry 缓存的响应
+type entry struct {
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	StoredAt int64       `json:"storedAt"`
+	// Expires 有效期结束的时间, 之后的StaleWhileRevalidate内仍可返回
+	Expires int64 `json:"expires"`
+}
+
+// Cache 基于redis的GET响应缓存
+type Cache struct {
+	config       *Config
+	redis        *redis.Redis
+	key          template
+	tags         []template
+	statusCodes  map[int]bool
+	group        *singleflight.Group
+	revalidating sync.Map
+	now          func() time.Time
+	// perUser key中包含Authorization请求头
+	perUser bool
+}
+
+// Handler 返回缓存响应的中间件, 可以直接用于ohttp
+func (c *Cache) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 后台刷新的请求执行handler并更新缓存
+		if r.Context().Value(revalidateKey{}) == c {
+			rec := newRecorder(w, c.config.MaxBodySize)
+			next.ServeHTTP(rec, r)
+			c.store(r, c.baseKey(r), rec)
+			return
+		}
+
+		reqCC := parseCacheControl(r.Header.Get("Cache-Control"))
+		if _, ok := reqCC["no-store"]; ok && !c.config.IgnoreRequestCacheControl {
+			metric.CacheHandleCounter.Inc(metric.TypeHTTP, c.config.Name, "get", metric.CodeCacheBypass)
+			w.Header().Set(HeaderCache, "BYPASS")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		base := c.baseKey(r)
+		key := base
+		var e *entry
+		if _, ok := reqCC["no-cache"]; !ok || c.config.IgnoreRequestCacheControl {
+			var err error
+			beg := time.Now()
+			key, e, err = c.load(r, base)
+			metric.CacheHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeHTTP, c.config.Name, "get")
+			if err != nil {
+				c.config.logger.Error("cache load", olog.FieldName(c.config.Name), olog.FieldKey(key), olog.FieldErr(err))
+			}
+		}
+
+		now := c.now().UnixNano()
+		if e != nil && now < e.Expires {
+			metric.CacheHandleCounter.Inc(metric.TypeHTTP, c.config.Name, "get", metric.CodeCacheHit)
+			c.write(w, r, e, "HIT")
+			return
+		}
+		if e != nil && now < e.Expires+int64(c.config.StaleWhileRevalidate) {
+			metric.CacheHandleCounter.Inc(metric.TypeHTTP, c.config.Name, "get", metric.CodeCacheStale)
+			c.write(w, r, e, "STALE")
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			c.revalidate(next, r, base, key)
+			return
+		}
+
+		// 并发的未命中只有一个请求执行handler, 其余等待并返回它的响应
+		metric.CacheHandleCounter.Inc(metric.TypeHTTP, c.config.Name, "get", metric.CodeCacheMiss)
+		var executed bool
+		v, _, _ := c.group.Do(key, func() (interface{}, error) {
+			executed = true
+			w.Header().Set(HeaderCache, "MISS")
+			rec := newRecorder(w, c.config.MaxBodySize)
+			next.ServeHTTP(rec, r)
+			return c.store(r, base, rec), nil
+		})
+		if executed {
+			return
+		}
+		if e, ok := v.(*entry); ok && e != nil {
+			c.write(w, r, e, "MISS")
+			return
+		}
+		// 响应不可缓存, 各自执行
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Invalidate 删除带有标签的缓存
+func (c *Cache) Invalidate(tags ...string) error {
+	for _, tag := range tags {
+		tagKey := c.config.Prefix + tagPrefix + tag
+		keys, err := c.redis.Client.SMembers(tagKey).Result()
+		if err != nil {
+			return err
+		}
+		if err := c.redis.Client.Del(append(keys, tagKey)...).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revalidateKey 后台刷新请求的context key, 值为发起刷新的Cache
+type revalidateKey struct{}
+
+// revalidate 当前请求已经返回旧值, 在后台刷新缓存, 同一个key只有一个请求刷新.
+// 刷新请求使用当前请求的副本和不会被取消的context, 从http.Server的handler重新进入中间件,
+// 避免在请求结束后使用gin和echo复用的context. 没有http.Server时(如直接调用ServeHTTP)在当前请求中刷新
+func (c *Cache) revalidate(next http.Handler, r *http.Request, base, key string) {
+	if _, loaded := c.revalidating.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	server, ok := r.Context().Value(http.ServerContextKey).(*http.Server)
+	if !ok || server.Handler == nil {
+		defer c.revalidating.Delete(key)
+		rec := newRecorder(nil, c.config.MaxBodySize)
+		next.ServeHTTP(rec, r)
+		c.store(r, base, rec)
+		return
+	}
+
+	ctx := context.WithValue(detachedContext{r.Context()}, revalidateKey{}, c)
+	req := r.Clone(ctx)
+	go func() {
+		defer c.revalidating.Delete(key)
+		defer func() {
+			if rec := recover(); rec != nil {
+				c.config.logger.Error("cache revalidate panic", olog.FieldName(c.config.Name), olog.FieldKey(key), olog.Any("recover", rec))
+			}
+		}()
+		server.Handler.ServeHTTP(newRecorder(nil, c.config.MaxBodySize), req)
+	}()
+}
+
+// detachedContext 保留父context的值, 但不会被取消
+type detachedContext struct {
+	parent context.Context
+}
+
+// Deadline ...
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// Done ...
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+// Err ...
+func (detachedContext) Err() error {
+	return nil
+}
+
+// Value ...
+func (ctx detachedContext) Value(key interface{}) interface{} {
+	return ctx.parent.Value(key)
+}
+
+func (c *Cache) baseKey(r *http.Request) string {
+	return c.config.Prefix + c.config.Name + ":" + hash(c.key.render(r))
+}
+
+// load returns key and entry of the request, the key depends on Vary of the stored response
+func (c *Cache) load(r *http.Request, base string) (string, *entry, error) {
+	vals, err := c.redis.Client.MGet(base, base+varySuffix).Result()
+	if err != nil {
+		return base, nil, err
+	}
+	key, data := base, vals[0]
+	if vary, ok := vals[1].(string); ok && vary != "" {
+		key = variantKey(base, vary, r)
+		if data, err = c.redis.Client.Get(key).Result(); err == goredis.Nil {
+			return key, nil, nil
+		} else if err != nil {
+			return key, nil, err
+		}
+	}
+	raw, ok := data.(string)
+	if !ok {
+		return key, nil, nil
+	}
+	var e entry
+	if err := json.Unmarshal([]byte(raw), &e); err != nil {
+		return key, nil, err
+	}
+	return key, &e, nil
+}
+
+// store 缓存可以缓存的响应, 返回nil表示不可缓存
+func (c *Cache) store(r *http.Request, base string, rec *recorder) *entry {
+	// HEAD请求没有响应体, 不能用于GET
+	if r.Method != http.MethodGet || rec.overflow || !c.statusCodes[rec.Status()] {
+		return nil
+	}
+	header := rec.Header()
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	for _, directive := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[directive]; ok {
+			return nil
+		}
+	}
+	if header.Get("Set-Cookie") != "" {
+		return nil
+	}
+	// RFC 7234 3.2: 共享缓存不保存带有Authorization的请求的响应, 除非响应允许(public, s-maxage)
+	// 或者key中包含Authorization, 每个用户单独缓存
+	if r.Header.Get("Authorization") != "" && !c.perUser {
+		_, public := cc["public"]
+		_, sMaxAge := cc["s-maxage"]
+		if !public && !sMaxAge {
+			return nil
+		}
+	}
+	vary := normalizeVary(header.Values("Vary"))
+	if vary == "*" {
+		return nil
+	}
+	ttl := c.config.TTL
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[directive]; ok {
+			if seconds, err := strconv.Atoi(v); err == nil {
+				ttl = time.Duration(seconds) * time.Second
+				break
+			}
+		}
+	}
+	if ttl <= 0 {
+		return nil
+	}
+
+	now := c.now()
+	e := &entry{
+		Status:   rec.Status(),
+		Header:   make(http.Header),
+		Body:     rec.body.Bytes(),
+		StoredAt: now.UnixNano(),
+		Expires:  now.Add(ttl).UnixNano(),
+	}
+	for k, v := range header {
+		if !skipHeaders[k] {
+			e.Header[k] = v
+		}
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil
+	}
+
+	key := base
+	if vary != "" {
+		key = variantKey(base, vary, r)
+	}
+	expire := ttl + c.config.StaleWhileRevalidate
+	_, err = c.redis.Client.TxPipelined(func(pipe goredis.Pipeliner) error {
+		pipe.Set(key, data, expire)
+		if vary != "" {
+			pipe.Set(base+varySuffix, vary, expire)
+		} else {
+			pipe.Del(base + varySuffix)
+		}
+		for _, tpl := range c.tags {
+			tagKey := c.config.Prefix + tagPrefix + tpl.render(r)
+			pipe.SAdd(tagKey, key)
+			pipe.Expire(tagKey, expire)
+		}
+		return nil
+	})
+	if err != nil {
+		c.config.logger.Error("cache store", olog.FieldName(c.config.Name), olog.FieldKey(key), olog.FieldErr(err))
+		return nil
+	}
+	return e
+}
+
+func (c *Cache) write(w http.ResponseWriter, r *http.Request, e *entry, state string) {
+	h := w.Header()
+	for k, v := range e.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set(HeaderCache, state)
+	h.Set("Age", strconv.FormatInt((c.now().UnixNano()-e.StoredAt)/int64(time.Second), 10))
+	h.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	w.WriteHeader(e.Status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(e.Body)
+	}
+}
+
+// recorder 记录handler的响应, w不为nil时同时写入w
+type recorder struct {
+	w        http.ResponseWriter
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func newRecorder(w http.ResponseWriter, limit int) *recorder {
+	rec := &recorder{w: w, limit: limit}
+	if w != nil {
+		rec.header = w.Header()
+	} else {
+		rec.header = make(http.Header)
+	}
+	return rec
+}
+
+// Status returns status code, 200 if not written
+func (rec *recorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// Header ...
+func (rec *recorder) Header() http.Header {
+	return rec.header
+}
+
+// WriteHeader ...
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status != 0 {
+		return
+	}
+	rec.status = status
+	if rec.w != nil {
+		rec.w.WriteHeader(status)
+	}
+}
+
+// Write ...
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.overflow {
+		if rec.limit > 0 && rec.body.Len()+len(b) > rec.limit {
+			rec.overflow = true
+			rec.body.Reset()
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	if rec.w != nil {
+		return rec.w.Write(b)
+	}
+	return len(b), nil
+}
+
+// Flush implements http.Flusher
+func (rec *recorder) Flush() {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if f, ok := rec.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// parseCacheControl returns directives of Cache-Control, names are lower case
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, val := part, ""
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			name, val = part[:i], strings.Trim(part[i+1:], `"`)
+		}
+		directives[strings.ToLower(name)] = val
+	}
+	return directives
+}
+
+// normalizeVary returns sorted canonical header names of Vary, "*" if any
+func normalizeVary(values []string) string {
+	var names []string
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return "*"
+			}
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func variantKey(base, vary string, r *http.Request) string {
+	var sb strings.Builder
+	for _, name := range strings.Split(vary, ",") {
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(strings.Join(r.Header.Values(name), ","))
+		sb.WriteByte('\n')
+	}
+	return base + ":" + hash(sb.String())
+}
+
+func hash(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
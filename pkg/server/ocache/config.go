@@ -0,0 +1,111 @@
+package ocache
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xqk/ox/pkg/client/redis"
+	"github.com/xqk/ox/pkg/conf"
+	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/olog"
+	"golang.org/x/sync/singleflight"
+)
+
+// ModName ..
+const ModName = "server.cache"
+
+// Config 响应缓存配置, 每个路由使用各自的配置
+type Config struct {
+	// Name 名称, 用于监控
+	Name string `json:"name" toml:"name"`
+	// TTL 缓存的有效期, 响应的Cache-Control: s-maxage/max-age优先
+	TTL time.Duration `json:"ttl" toml:"ttl"`
+	// StaleWhileRevalidate 过期后仍返回旧值的时长, 返回旧值后在后台刷新缓存, 为0时不返回旧值
+	StaleWhileRevalidate time.Duration `json:"staleWhileRevalidate" toml:"staleWhileRevalidate"`
+	// Key 缓存key的模板, 支持{method} {host} {path} {query} {query:name} {header:name}.
+	// 带有Authorization的请求的响应只有在Cache-Control为public或s-maxage时缓存,
+	// key中包含{header:Authorization}时按用户缓存所有响应
+	Key string `json:"key" toml:"key"`
+	// Tags 缓存的标签模板, 语法同Key, 通过Invalidate按标签删除缓存
+	Tags []string `json:"tags" toml:"tags"`
+	// Prefix redis key的前缀, 标签在相同前缀内共享
+	Prefix string `json:"prefix" toml:"prefix"`
+	// MaxBodySize 响应超过该大小时不缓存
+	MaxBodySize int `json:"maxBodySize" toml:"maxBodySize"`
+	// StatusCodes 可以缓存的状态码
+	StatusCodes []int `json:"statusCodes" toml:"statusCodes"`
+	// IgnoreRequestCacheControl 忽略请求的Cache-Control: no-cache/no-store, 避免客户端绕过缓存
+	IgnoreRequestCacheControl bool `json:"ignoreRequestCacheControl" toml:"ignoreRequestCacheControl"`
+
+	redis  *redis.Redis
+	logger *olog.Logger
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Name:        "default",
+		TTL:         time.Minute,
+		Key:         "{method}:{path}?{query}",
+		Prefix:      "ox:cache:",
+		MaxBodySize: 1 << 20,
+		StatusCodes: []int{http.StatusOK},
+		logger:      olog.OxLogger.With(olog.FieldMod(ModName)),
+	}
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil &&
+		errors.Cause(err) != conf.ErrInvalidKey {
+		config.logger.Panic("cache parse config panic", olog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), olog.FieldErr(err), olog.FieldKey(key), olog.FieldValueAny(config))
+	}
+	return config
+}
+
+// WithLogger ...
+func (config *Config) WithLogger(logger *olog.Logger) *Config {
+	config.logger = logger
+	return config
+}
+
+// WithRedis 设置存储缓存的redis, 必须设置
+func (config *Config) WithRedis(redis *redis.Redis) *Config {
+	config.redis = redis
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Cache {
+	if config.redis == nil {
+		config.logger.Panic("cache redis not set", olog.FieldName(config.Name))
+	}
+	key, err := parseTemplate(config.Key)
+	if err != nil {
+		config.logger.Panic("cache parse key panic", olog.FieldErr(err), olog.FieldKey(config.Key))
+	}
+	tags := make([]template, 0, len(config.Tags))
+	for _, tag := range config.Tags {
+		tpl, err := parseTemplate(tag)
+		if err != nil {
+			config.logger.Panic("cache parse tag panic", olog.FieldErr(err), olog.FieldKey(tag))
+		}
+		tags = append(tags, tpl)
+	}
+	statusCodes := make(map[int]bool, len(config.StatusCodes))
+	for _, code := range config.StatusCodes {
+		statusCodes[code] = true
+	}
+	return &Cache{
+		config:      config,
+		redis:       config.redis,
+		key:         key,
+		tags:        tags,
+		statusCodes: statusCodes,
+		group:       &singleflight.Group{},
+		now:         time.Now,
+		perUser:     key.has("header", "Authorization"),
+	}
+}
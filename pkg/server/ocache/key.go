@@ -0,0 +1,98 @@
+package ocache
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// template 由文本和占位符组成的key模板
+type template []segment
+
+type segment struct {
+	kind string // 为空时是文本
+	arg  string
+}
+
+// parseTemplate parses template like "{method}:{path}?{query:id}"
+func parseTemplate(text string) (template, error) {
+	var tpl template
+	for len(text) > 0 {
+		start := strings.IndexByte(text, '{')
+		if start < 0 {
+			tpl = append(tpl, segment{arg: text})
+			break
+		}
+		if start > 0 {
+			tpl = append(tpl, segment{arg: text[:start]})
+		}
+		end := strings.IndexByte(text[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder: %s", text[start:])
+		}
+		placeholder := text[start+1 : start+end]
+		kind, arg := placeholder, ""
+		if i := strings.IndexByte(placeholder, ':'); i >= 0 {
+			kind, arg = placeholder[:i], placeholder[i+1:]
+		}
+		switch kind {
+		case "method", "host", "path", "query":
+		case "header":
+			if arg == "" {
+				return nil, fmt.Errorf("header name required: %s", placeholder)
+			}
+			arg = http.CanonicalHeaderKey(arg)
+		default:
+			return nil, fmt.Errorf("unknown placeholder: %s", placeholder)
+		}
+		tpl = append(tpl, segment{kind: kind, arg: arg})
+		text = text[start+end+1:]
+	}
+	return tpl, nil
+}
+
+// render 使用请求填充模板, {query}的参数按名称排序, 参数顺序不同的请求使用相同的key
+func (tpl template) render(r *http.Request) string {
+	var sb strings.Builder
+	for _, seg := range tpl {
+		switch seg.kind {
+		case "":
+			sb.WriteString(seg.arg)
+		case "method":
+			sb.WriteString(r.Method)
+		case "host":
+			sb.WriteString(r.Host)
+		case "path":
+			sb.WriteString(r.URL.Path)
+		case "query":
+			if seg.arg != "" {
+				sb.WriteString(r.URL.Query().Get(seg.arg))
+			} else {
+				sb.WriteString(canonicalQuery(r.URL.Query()))
+			}
+		case "header":
+			sb.WriteString(r.Header.Get(seg.arg))
+		}
+	}
+	return sb.String()
+}
+
+// has returns whether the template contains the placeholder
+func (tpl template) has(kind, arg string) bool {
+	for _, seg := range tpl {
+		if seg.kind == kind && seg.arg == arg {
+			return true
+		}
+	}
+	return false
+}
+
+func canonicalQuery(query url.Values) string {
+	for _, vals := range query {
+		sort.Strings(vals)
+	}
+	// Encode按key排序
+	return query.Encode()
+}
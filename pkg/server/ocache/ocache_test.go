@@ -0,0 +1,260 @@
+package ocache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/client/redis"
+)
+
+func newCache(t *testing.T, setup func(config *Config)) *Cache {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+	redisConfig := redis.DefaultRedisConfig()
+	redisConfig.Addrs = []string{mr.Addr()}
+	redisConfig.Mode = redis.StubMode
+
+	config := DefaultConfig().WithRedis(redisConfig.Build())
+	if setup != nil {
+		setup(config)
+	}
+	return config.Build()
+}
+
+func do(h http.Handler, target string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestTemplate(t *testing.T) {
+	tpl, err := parseTemplate("{method}:{path}?{query}|{query:id}|{header:accept-language}")
+	assert.Nil(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/users?b=2&a=1&id=3", nil)
+	req.Header.Set("Accept-Language", "zh")
+	assert.Equal(t, "GET:/users?a=1&b=2&id=3|3|zh", tpl.render(req))
+
+	_, err = parseTemplate("{cookie:a}")
+	assert.NotNil(t, err)
+	_, err = parseTemplate("{path")
+	assert.NotNil(t, err)
+}
+
+func TestCache(t *testing.T) {
+	var calls int32
+	cache := newCache(t, nil)
+	h := cache.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strconv.Itoa(int(n))))
+	}))
+
+	w := do(h, "/a?x=1&y=2")
+	assert.Equal(t, "MISS", w.Header().Get(HeaderCache))
+	assert.Equal(t, "1", w.Body.String())
+
+	// 参数顺序不影响key
+	w = do(h, "/a?y=2&x=1")
+	assert.Equal(t, "HIT", w.Header().Get(HeaderCache))
+	assert.Equal(t, "1", w.Body.String())
+	assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+
+	// 请求要求不使用缓存
+	w = do(h, "/a?x=1&y=2", "Cache-Control", "no-cache")
+	assert.Equal(t, "2", w.Body.String())
+	w = do(h, "/a?x=1&y=2")
+	assert.Equal(t, "2", w.Body.String())
+	w = do(h, "/a?x=1&y=2", "Cache-Control", "no-store")
+	assert.Equal(t, "BYPASS", w.Header().Get(HeaderCache))
+	assert.Equal(t, "3", w.Body.String())
+}
+
+func TestCache_CacheControl(t *testing.T) {
+	cache := newCache(t, nil)
+	var now = time.Now()
+	cache.now = func() time.Time { return now }
+	var calls int32
+	h := cache.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		if r.URL.Query().Get("status") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+
+	for _, target := range []string{"/?cc=no-store", "/?cc=private", "/?cc=max-age=0", "/?status=500"} {
+		do(h, target)
+		assert.Equal(t, "MISS", do(h, target).Header().Get(HeaderCache), target)
+	}
+
+	// max-age优先于TTL
+	do(h, "/?cc=max-age=1")
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, "HIT", do(h, "/?cc=max-age=1").Header().Get(HeaderCache))
+	now = now.Add(time.Second)
+	assert.Equal(t, "MISS", do(h, "/?cc=max-age=1").Header().Get(HeaderCache))
+}
+
+func TestCache_Vary(t *testing.T) {
+	cache := newCache(t, nil)
+	h := cache.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept-Language")
+		w.Write([]byte(r.Header.Get("Accept-Language")))
+	}))
+
+	assert.Equal(t, "zh", do(h, "/", "Accept-Language", "zh").Body.String())
+	assert.Equal(t, "en", do(h, "/", "Accept-Language", "en").Body.String())
+	w := do(h, "/", "Accept-Language", "zh")
+	assert.Equal(t, "HIT", w.Header().Get(HeaderCache))
+	assert.Equal(t, "zh", w.Body.String())
+}
+
+func TestCache_StaleWhileRevalidate(t *testing.T) {
+	cache := newCache(t, func(config *Config) {
+		config.TTL = time.Second
+		config.StaleWhileRevalidate = time.Minute
+	})
+	var now = time.Now()
+	cache.now = func() time.Time { return now }
+	var calls int32
+	h := cache.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strconv.Itoa(int(atomic.AddInt32(&calls, 1)))))
+	}))
+
+	do(h, "/")
+	now = now.Add(2 * time.Second)
+	// 返回旧值, 没有http.Server时在当前请求中刷新
+	w := do(h, "/")
+	assert.Equal(t, "STALE", w.Header().Get(HeaderCache))
+	assert.Equal(t, "1", w.Body.String())
+	w = do(h, "/")
+	assert.Equal(t, "HIT", w.Header().Get(HeaderCache))
+	assert.Equal(t, "2", w.Body.String())
+}
+
+func TestCache_RevalidateServer(t *testing.T) {
+	cache := newCache(t, func(config *Config) {
+		config.TTL = time.Second
+		config.StaleWhileRevalidate = time.Minute
+	})
+	var now = time.Now()
+	cache.now = func() time.Time { return now }
+	var calls int32
+	var canceled = make(chan error, 1)
+	h := cache.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := atomic.AddInt32(&calls, 1); n > 1 {
+			// 后台刷新不受原请求取消的影响
+			canceled <- r.Context().Err()
+		}
+		w.Write([]byte(strconv.Itoa(int(atomic.LoadInt32(&calls)))))
+	}))
+	server := &http.Server{Handler: h}
+
+	do(h, "/")
+	now = now.Add(2 * time.Second)
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), http.ServerContextKey, server))
+	req := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	cancel()
+	assert.Equal(t, "STALE", w.Header().Get(HeaderCache))
+	select {
+	case err := <-canceled:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("revalidate not called")
+	}
+	assert.Eventually(t, func() bool {
+		return do(h, "/").Body.String() == "2"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCache_Authorization(t *testing.T) {
+	cache := newCache(t, nil)
+	h := cache.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", r.URL.Query().Get("cc"))
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+
+	// 带有Authorization的响应不缓存, 不会返回给其他用户
+	assert.Equal(t, "alice", do(h, "/private", "Authorization", "alice").Body.String())
+	w := do(h, "/private", "Authorization", "bob")
+	assert.Equal(t, "MISS", w.Header().Get(HeaderCache))
+	assert.Equal(t, "bob", w.Body.String())
+
+	// public和s-maxage的响应可以共享
+	for _, target := range []string{"/?cc=public", "/?cc=s-maxage=60"} {
+		do(h, target, "Authorization", "alice")
+		w = do(h, target, "Authorization", "bob")
+		assert.Equal(t, "HIT", w.Header().Get(HeaderCache), target)
+		assert.Equal(t, "alice", w.Body.String(), target)
+	}
+
+	// key中包含Authorization时按用户缓存
+	cache = newCache(t, func(config *Config) {
+		config.Key = "{method}:{path}|{header:authorization}"
+	})
+	h = cache.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	do(h, "/", "Authorization", "alice")
+	assert.Equal(t, "HIT", do(h, "/", "Authorization", "alice").Header().Get(HeaderCache))
+	w = do(h, "/", "Authorization", "bob")
+	assert.Equal(t, "MISS", w.Header().Get(HeaderCache))
+	assert.Equal(t, "bob", w.Body.String())
+}
+
+func TestCache_Singleflight(t *testing.T) {
+	cache := newCache(t, nil)
+	var calls int32
+	release := make(chan struct{})
+	h := cache.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.Write([]byte("ok"))
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, "ok", do(h, "/").Body.String())
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	cache := newCache(t, func(config *Config) {
+		config.Tags = []string{"user:{query:id}"}
+	})
+	var calls int32
+	h := cache.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strconv.Itoa(int(atomic.AddInt32(&calls, 1)))))
+	}))
+
+	do(h, "/?id=1")
+	do(h, "/?id=2")
+	assert.Nil(t, cache.Invalidate("user:1"))
+	assert.Equal(t, "MISS", do(h, "/?id=1").Header().Get(HeaderCache))
+	assert.Equal(t, "HIT", do(h, "/?id=2").Header().Get(HeaderCache))
+}
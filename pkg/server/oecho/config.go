@@ -2,12 +2,20 @@ package oecho
 
 import (
 	"fmt"
+	"net"
+	"os"
 	"github.com/pkg/errors"
+	"github.com/xqk/ox/pkg/auth"
 	"github.com/xqk/ox/pkg/conf"
 	"github.com/xqk/ox/pkg/constant"
 	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/limiter"
+	"github.com/xqk/ox/pkg/metric"
 	"github.com/xqk/ox/pkg/flag"
 	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/server/oerror"
+	"github.com/xqk/ox/pkg/server/otransport"
+	"github.com/xqk/ox/pkg/util/onet"
 )
 
 //ModName named a mod
@@ -15,28 +23,53 @@ const ModName = "server.echo"
 
 //Config HTTP config
 type Config struct {
-	Host          string
-	Port          int
+	Host string
+	Port int
+	// Network network type, tcp by default.
+	// 为unix时Host为socket文件路径, 以"@"开头时为abstract socket
+	Network string
+	// SocketMode unix socket文件的权限, 为0时不修改
+	SocketMode    os.FileMode
 	Deployment    string
 	Debug         bool
 	DisableMetric bool
 	DisableTrace  bool
+	// EnableOpenAPI 挂载/openapi.json并在governor中注册文档, 默认关闭
+	EnableOpenAPI bool
 	// ServiceAddress service address in registry info, default to 'Host:Port'
 	ServiceAddress string
 
 	SlowQueryThresholdInMilli int64
-
-	logger *olog.Logger
+	// Limiter 自适应限流配置, 默认关闭
+	Limiter *limiter.Config
+	// Auth 认证配置, 默认关闭
+	Auth *auth.Config
+	// Caller 调用方签名和ACL校验, 默认关闭, 通过StdConfig创建时支持热更新
+	Caller *auth.CallerConfig
+	// Error 错误响应配置
+	Error *oerror.Config
+	// Transport 超时, 请求头限制和HTTP/2配置
+	Transport *otransport.Config
+
+	key      string
+	listener net.Listener
+	logger   *olog.Logger
 }
 
 // DefaultConfig ...
 func DefaultConfig() *Config {
 	return &Config{
+		Network:                   "tcp",
 		Host:                      flag.String("host"),
 		Port:                      9091,
 		Debug:                     false,
 		Deployment:                constant.DefaultDeployment,
 		SlowQueryThresholdInMilli: 500, // 500ms
+		Limiter:                   limiter.DefaultConfig(),
+		Auth:                      auth.DefaultConfig(),
+		Caller:                    auth.DefaultCallerConfig(),
+		Error:                     oerror.DefaultConfig(),
+		Transport:                 otransport.DefaultConfig(),
 		logger:                    olog.OxLogger.With(olog.FieldMod(ModName)),
 	}
 }
@@ -53,6 +86,7 @@ func RawConfig(key string) *Config {
 		errors.Cause(err) != conf.ErrInvalidKey {
 		config.logger.Panic("http server parse config panic", olog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), olog.FieldErr(err), olog.FieldKey(key), olog.FieldValueAny(config))
 	}
+	config.key = key
 	return config
 }
 
@@ -82,13 +116,41 @@ func (config *Config) MustBuild() *Server {
 	return server
 }
 
+// WithListener serves on listener instead of listening on Address, such as the listener of omux.
+// Cleartext HTTP/2 requests on the listener are served by h2c.
+func (config *Config) WithListener(listener net.Listener) *Config {
+	config.listener = listener
+	return config
+}
+
+func (config *Config) listen() (net.Listener, error) {
+	if config.listener != nil {
+		return config.listener, nil
+	}
+	return onet.Listen(config.Network, config.Address(), config.SocketMode)
+}
+
 // Build create server instance, then initialize it with necessary interceptor
 func (config *Config) Build() (*Server, error) {
+	var contract = oerror.Default
+	if config.Error != nil {
+		var err error
+		if contract, err = config.Error.Build(); err != nil {
+			return nil, err
+		}
+	}
 	server, err := newServer(config)
 	if err != nil {
 		return nil, err
 	}
+	server.contract = contract
+	server.Echo.HTTPErrorHandler = errorHandler(contract)
+	server.Use(requestIDMiddleware())
 	server.Use(recoverMiddleware(config.logger, config.SlowQueryThresholdInMilli))
+	if config.Limiter != nil && config.Limiter.Enable {
+		server.Use(limiterMiddleware(limiter.New(metric.TypeHTTP, config.Limiter)))
+	}
+	server.Use(deadlineMiddleware(config.logger))
 
 	if !config.DisableMetric {
 		server.Use(metricServerInterceptor())
@@ -97,10 +159,35 @@ func (config *Config) Build() (*Server, error) {
 	if !config.DisableTrace {
 		server.Use(traceServerInterceptor())
 	}
+
+	if config.Auth != nil && config.Auth.Enable {
+		a, err := config.Auth.Build()
+		if err != nil {
+			return nil, err
+		}
+		server.Use(authMiddleware(config.logger, a))
+	}
+
+	// 通过StdConfig创建时热更新可以开启校验, 关闭时不校验
+	if config.Caller != nil && (config.Caller.Enable || config.key != "") {
+		server.Use(callerMiddleware(config.logger, config.callerGuard()))
+	}
 	return server, nil
 }
 
 // Address ...
 func (config *Config) Address() string {
+	if onet.IsUnixNetwork(config.Network) {
+		return config.Host
+	}
 	return fmt.Sprintf("%s:%d", config.Host, config.Port)
 }
+
+// callerGuard creates caller guard, which reloads when configuration changes if config is created by RawConfig
+func (config *Config) callerGuard() *auth.CallerGuard {
+	g := auth.NewCallerGuard(metric.TypeHTTP, config.Caller)
+	if config.key != "" {
+		g.Watch(config.key+".caller", config.logger)
+	}
+	return g
+}
@@ -1,8 +1,12 @@
 package oecho
 
 import (
+	"fmt"
 	"net/http"
 
+	"github.com/labstack/echo/v4"
+	"github.com/xqk/ox/pkg/server/oerror"
+
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -50,3 +54,31 @@ var (
 	// ErrGRPCInvokeLen ...
 	ErrGRPCInvokeLen = grpc.Errorf(codes.Internal, "invoke request without len 2 res")
 )
+
+// errorHandler 按错误响应约定写入handler返回的错误
+func errorHandler(contract *oerror.Contract) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		if c.Response().Committed {
+			return
+		}
+		status, body := contract.Resolve(c.Request(), toError(err))
+		if c.Request().Method == http.MethodHead {
+			_ = c.NoContent(status)
+			return
+		}
+		_ = c.JSON(status, body)
+	}
+}
+
+// toError converts echo.HTTPError and HTTPError to oerror.Error with the http status
+func toError(err error) error {
+	switch e := err.(type) {
+	case *echo.HTTPError:
+		return oerror.New(e.Code, fmt.Sprint(e.Message)).WithStatus(e.Code)
+	case *HTTPError:
+		return oerror.New(e.Code, e.Message).WithStatus(e.Code)
+	case HTTPError:
+		return oerror.New(e.Code, e.Message).WithStatus(e.Code)
+	}
+	return err
+}
@@ -10,20 +10,27 @@ import (
 	"github.com/codegangsta/inject"
 	"github.com/golang/protobuf/proto"
 	"github.com/labstack/echo/v4"
+	"github.com/xqk/ox/pkg/server/oerror"
 	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
 )
 
-// ProtoError ...
+// ProtoError 按服务的错误响应约定写入错误, code为HTTP状态码, 小于400时按错误码转换状态码
 func ProtoError(c echo.Context, code int, e error) error {
-	s, ok := status.FromError(e)
 	c.Response().Header().Set(HeaderHRPCErr, "true")
-	if ok {
-		if de, ok := statusFromString(s.Message()); ok {
-			return ProtoJSON(c, code, de.Proto())
-		}
+	c.Error(protoError(code, e))
+	return nil
+}
+
+// protoError converts e to oerror.Error, the "code:msg" prefix of legacy status messages is trimmed
+func protoError(code int, e error) error {
+	err := oerror.FromError(e)
+	if de, ok := statusFromString(err.Message); ok {
+		err.Message = strings.TrimPrefix(de.Proto().GetMessage(), ":")
+	}
+	if code >= http.StatusBadRequest {
+		return err.WithStatus(code)
 	}
-	return ProtoJSON(c, code, e)
+	return err
 }
 
 // ProtoJSON sends a Protobuf JSON response with status code and data.
@@ -32,8 +39,7 @@ func ProtoJSON(c echo.Context, code int, i interface{}) error {
 	var ok bool
 	var m proto.Message
 	if m, ok = i.(proto.Message); !ok {
-		c.Response().Header().Set(HeaderHRPCErr, "true")
-		m = statusMSDefault
+		return ProtoError(c, http.StatusInternalServerError, errMicroResInvalid)
 	}
 	// protobuf output
 	if strings.Contains(acceptEncoding, MIMEApplicationProtobuf) {
@@ -83,7 +89,7 @@ func GRPCProxyWrapper(h interface{}) echo.HandlerFunc {
 		repV, errV := vs[0], vs[1]
 		if !errV.IsNil() || repV.IsNil() {
 			if e, ok := errV.Interface().(error); ok {
-				return ProtoError(c, http.StatusOK, e)
+				return ProtoError(c, 0, e)
 			}
 			return ProtoError(c, http.StatusInternalServerError, errMicroInvokeInvalid)
 		}
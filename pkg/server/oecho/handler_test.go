@@ -0,0 +1,50 @@
+package oecho
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/util/otest/proto/testproto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCProxyWrapper_Error(t *testing.T) {
+	config := DefaultConfig()
+	config.Port = 0
+	server, err := config.Build()
+	assert.Nil(t, err)
+	defer server.listener.Close()
+	server.GRPCProxy(http.MethodPost, "/hello", func(ctx context.Context, req *testproto.HelloRequest) (*testproto.HelloReply, error) {
+		if req.Name == "" {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return &testproto.HelloReply{Message: "hello " + req.Name}, nil
+	})
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/hello", strings.NewReader(body))
+		req.Header.Set("Content-Type", MIMEApplicationJSON)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"name":"ox"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"message":"hello ox"`)
+
+	// handler返回的错误按错误码转换状态码
+	w = post(`{}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "true", w.Header().Get(HeaderHRPCErr))
+	assert.JSONEq(t, `{"code":5,"message":"user not found"}`, w.Body.String())
+
+	// 内置的错误去掉旧的错误码前缀
+	w = post(`{"name":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"code":3,"message":"bad request"}`, w.Body.String())
+}
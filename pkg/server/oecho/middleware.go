@@ -1,13 +1,21 @@
 package oecho
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"github.com/xqk/ox/pkg/olog"
 	"runtime"
 	"time"
 
+	"github.com/xqk/ox/pkg/auth"
+	"github.com/xqk/ox/pkg/deadline"
+	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/limiter"
 	"github.com/xqk/ox/pkg/metric"
+	"github.com/xqk/ox/pkg/requestid"
+	"github.com/xqk/ox/pkg/server"
+	"github.com/xqk/ox/pkg/server/oerror"
 	"github.com/xqk/ox/pkg/trace"
 
 	"github.com/labstack/echo/v4"
@@ -18,6 +26,18 @@ func extractAID(c echo.Context) string {
 	return c.Request().Header.Get("AID")
 }
 
+// requestIDMiddleware 从X-Request-ID中获取或生成请求id, 写入context并通过响应header返回
+func requestIDMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id := requestid.FromHeader(c.Request().Header)
+			c.SetRequest(c.Request().WithContext(requestid.NewContext(c.Request().Context(), id)))
+			c.Response().Header().Set(requestid.HeaderRequestID, id)
+			return next(c)
+		}
+	}
+}
+
 // RecoverMiddleware ...
 func recoverMiddleware(logger *olog.Logger, slowQueryThresholdInMilli int64) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
@@ -27,7 +47,8 @@ func recoverMiddleware(logger *olog.Logger, slowQueryThresholdInMilli int64) ech
 
 			defer func() {
 				fields = append(fields, zap.Float64("cost", time.Since(beg).Seconds()))
-				if rec := recover(); rec != nil {
+				rec := recover()
+				if rec != nil {
 					switch rec := rec.(type) {
 					case error:
 						err = rec
@@ -39,6 +60,14 @@ func recoverMiddleware(logger *olog.Logger, slowQueryThresholdInMilli int64) ech
 					length := runtime.Stack(stack, true)
 					fields = append(fields, zap.ByteString("stack", stack[:length]))
 				}
+				// 先写入错误响应, 访问日志中记录最终的状态码
+				if err != nil {
+					if rec != nil {
+						ctx.Error(oerror.ErrPanic)
+					} else {
+						ctx.Error(err)
+					}
+				}
 				fields = append(fields,
 					zap.String("method", ctx.Request().Method),
 					zap.Int("code", ctx.Response().Status),
@@ -52,10 +81,11 @@ func recoverMiddleware(logger *olog.Logger, slowQueryThresholdInMilli int64) ech
 				}
 				if err != nil {
 					fields = append(fields, zap.String("err", err.Error()))
-					logger.Error("access", fields...)
+					logger.WithContext(ctx.Request().Context()).Error("access", fields...)
+					err = nil
 					return
 				}
-				logger.Info("access", fields...)
+				logger.WithContext(ctx.Request().Context()).Info("access", fields...)
 			}()
 
 			return next(ctx)
@@ -91,6 +121,7 @@ func traceServerInterceptor() echo.MiddlewareFunc {
 				trace.CustomTag("http.url", c.Path()),
 				trace.CustomTag("http.method", c.Request().Method),
 				trace.CustomTag("peer.ipv4", c.RealIP()),
+				trace.CustomTag(requestid.TagRequestID, requestid.FromContext(c.Request().Context())),
 			)
 			c.SetRequest(c.Request().WithContext(ctx))
 			defer span.Finish()
@@ -98,3 +129,115 @@ func traceServerInterceptor() echo.MiddlewareFunc {
 		}
 	}
 }
+
+func deadlineMiddleware(logger *olog.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			budget, ok := deadline.FromHeader(c.Request().Header)
+			if !ok {
+				return next(c)
+			}
+			if budget.Expired() {
+				logger.WithContext(c.Request().Context()).Warn("deadline exhausted",
+					zap.String("method", c.Request().Method),
+					zap.String("path", c.Path()),
+					zap.Strings("hops", budget.Hops),
+					olog.FieldAid(extractAID(c)),
+				)
+				return ecode.DeadlineExhausted.Err()
+			}
+
+			ctx, cancel := deadline.NewContext(c.Request().Context(), budget)
+			defer cancel()
+			c.SetRequest(c.Request().WithContext(ctx))
+
+			var beg = time.Now()
+			err = next(c)
+			if ctx.Err() == context.DeadlineExceeded {
+				logger.WithContext(c.Request().Context()).Warn("deadline exceeded",
+					zap.String("method", c.Request().Method),
+					zap.String("path", c.Path()),
+					zap.Strings("hops", budget.Hops),
+					olog.FieldCost(time.Since(beg)),
+					olog.FieldAid(extractAID(c)),
+				)
+			}
+			return err
+		}
+	}
+}
+
+// limiterMiddleware 自适应限流, 过载时返回503
+func limiterMiddleware(l *limiter.Limiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			route := c.Path()
+			if route == "" {
+				route = server.RouteNotFound
+			}
+			done, ok := l.Allow(route)
+			if !ok {
+				c.Response().Header().Set("Retry-After", "1")
+				return ecode.Overloaded.Err()
+			}
+			defer done()
+			return next(c)
+		}
+	}
+}
+
+// authMiddleware 认证请求, 认证通过后可以通过auth.FromContext获取调用方
+func authMiddleware(logger *olog.Logger, a *auth.Auth) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			route := c.Path()
+			if route == "" {
+				route = c.Request().URL.Path
+			}
+			if a.Skip(route) {
+				return next(c)
+			}
+			principal, err := a.Authenticate(auth.FromHTTP(c.Request()))
+			if err != nil {
+				logger.WithContext(c.Request().Context()).Warn("unauthenticated",
+					zap.String("method", c.Request().Method),
+					zap.String("path", c.Request().URL.Path),
+					olog.FieldAid(extractAID(c)),
+					olog.FieldErr(err),
+				)
+				return ecode.Unauthenticated.Err()
+			}
+			c.SetRequest(c.Request().WithContext(auth.NewContext(c.Request().Context(), principal)))
+			return next(c)
+		}
+	}
+}
+
+// callerMiddleware 校验调用方签名和路由级ACL
+func callerMiddleware(logger *olog.Logger, g *auth.CallerGuard) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			route := c.Path()
+			if route == "" {
+				route = server.RouteNotFound
+			}
+			aid, err := g.Check(route, auth.CallerTarget(c.Request()), c.Request().Header)
+			if err == nil {
+				if aid != "" {
+					c.SetRequest(c.Request().WithContext(auth.NewCallerContext(c.Request().Context(), aid)))
+				}
+				return next(c)
+			}
+			logger.WithContext(c.Request().Context()).Warn("caller denied",
+				zap.String("method", c.Request().Method),
+				zap.String("path", c.Request().URL.Path),
+				olog.FieldAid(extractAID(c)),
+				olog.FieldErr(err),
+			)
+			if err == auth.ErrCallerDenied {
+				return ecode.PermissionDenied.Err()
+			}
+			return ecode.Unauthenticated.Err()
+		}
+	}
+}
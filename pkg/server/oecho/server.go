@@ -4,14 +4,27 @@ import (
 	"context"
 	"net/http"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"github.com/xqk/ox/pkg/olog"
 
 	"net"
 
+	"github.com/xqk/ox/pkg"
 	"github.com/xqk/ox/pkg/constant"
 	"github.com/xqk/ox/pkg/registry"
 	"github.com/xqk/ox/pkg/server"
+	"github.com/xqk/ox/pkg/server/oerror"
+	"github.com/xqk/ox/pkg/server/ogrpc/gateway"
+	"github.com/xqk/ox/pkg/server/ogrpc/grpcweb"
+	"github.com/xqk/ox/pkg/server/oopenapi"
+	"github.com/xqk/ox/pkg/server/osse"
+	"github.com/xqk/ox/pkg/server/otransport"
+	"github.com/xqk/ox/pkg/util/onet"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
 	"github.com/pkg/errors"
 )
 
@@ -21,34 +34,97 @@ type Server struct {
 	config     *Config
 	listener   net.Listener
 	registerer registry.Registry
+	contract   *oerror.Contract
+	// closers 服务停止时关闭的长连接
+	closers []func(context.Context) error
+	openapi *oopenapi.Spec
+	// tracker 统计进行中的请求和连接
+	tracker *otransport.Tracker
+	serving int32
 }
 
 func newServer(config *Config) (*Server, error) {
-	listener, err := net.Listen("tcp", config.Address())
+	listener, err := config.listen()
 	if err != nil {
 		// config.logger.Panic("new oecho server err", olog.FieldErrKind(ecode.ErrKindListenErr), olog.FieldErr(err))
 		return nil, errors.Wrapf(err, "create oecho server failed")
 	}
-	config.Port = listener.Addr().(*net.TCPAddr).Port
+	if port := onet.Port(listener.Addr()); port != 0 {
+		config.Port = port
+	}
+	tracker := otransport.NewTracker(ModName + "@" + config.Address())
 	return &Server{
 		Echo:     echo.New(),
 		config:   config,
-		listener: listener,
+		listener: tracker.Listener(listener),
+		openapi:  oopenapi.NewSpec(),
+		tracker:  tracker,
 	}, nil
 }
 
+// API 注册路由并添加openapi说明
+func (s *Server) API(method, path string, api *oopenapi.API, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	s.openapi.Add(method, path, api)
+	return s.Echo.Add(method, path, h, m...)
+}
+
+// GRPCProxy 使用GRPCProxyWrapper注册路由, 按h的请求和响应类型生成openapi说明
+func (s *Server) GRPCProxy(method, path string, h interface{}, m ...echo.MiddlewareFunc) *echo.Route {
+	wrapped := GRPCProxyWrapper(h)
+	t := reflect.TypeOf(h)
+	return s.API(method, path, &oopenapi.API{
+		// 请求通过echo.Bind绑定, 响应使用jsonpb编码, 错误使用服务的错误响应约定
+		Request:  oopenapi.AsStruct(reflect.New(t.In(1).Elem()).Interface()),
+		Response: reflect.New(t.Out(0).Elem()).Interface(),
+	}, wrapped, m...)
+}
+
+// Document 根据已注册的路由生成openapi文档
+func (s *Server) Document() *oopenapi.Document {
+	var routes []oopenapi.Route
+	for _, route := range s.Echo.Routes() {
+		if route.Path != oopenapi.DocumentPath {
+			routes = append(routes, oopenapi.Route{Method: route.Method, Path: route.Path})
+		}
+	}
+	return s.openapi.Document(oopenapi.Info{Title: pkg.Name(), Version: pkg.AppVersion()}, routes)
+}
+
+// Healthz reports whether the server is serving
 func (s *Server) Healthz() bool {
-	if s.Echo.Listener == nil {
-		return false
+	return atomic.LoadInt32(&s.serving) == 1
+}
+
+// Transcode 挂载grpc服务转换后的HTTP/JSON接口
+func (s *Server) Transcode(g *gateway.Gateway) {
+	if s.contract != nil {
+		g.WithContract(s.contract)
+	}
+	for _, route := range g.Routes() {
+		path := route.Path
+		// echo的通配符没有名字
+		if i := strings.LastIndex(path, "/*"); i >= 0 {
+			path = path[:i+2]
+		}
+		s.API(route.Method, path, g.API(route), echo.WrapHandler(g))
 	}
+}
 
-	conn, err := s.Echo.Listener.Accept()
-	if err != nil {
-		return false
+// GRPCWeb 挂载grpc服务的gRPC-Web接口
+func (s *Server) GRPCWeb(web *grpcweb.GRPCWeb) {
+	for _, route := range web.Routes() {
+		s.Echo.Add(route.Method, route.Path, echo.WrapHandler(web))
 	}
+}
 
-	conn.Close()
-	return true
+// SSE 挂载sse broker, h为nil时推送broker发布的事件, 否则由h通过broker.Open推送.
+// 服务停止时结束broker的所有连接
+func (s *Server) SSE(pattern string, broker *osse.Broker, h echo.HandlerFunc, m ...echo.MiddlewareFunc) *echo.Route {
+	s.closers = append(s.closers, broker.Close)
+	if h == nil {
+		h = echo.WrapHandler(broker)
+	}
+	return s.Echo.GET(pattern, h, m...)
 }
 
 // Server implements server.Server interface.
@@ -57,11 +133,22 @@ func (s *Server) Serve() error {
 	s.Echo.Debug = s.config.Debug
 	s.Echo.HideBanner = true
 	s.Echo.StdLogger = olog.OxLogger.StdLog()
+	if s.config.EnableOpenAPI {
+		s.serveOpenAPI()
+	}
 	for _, route := range s.Echo.Routes() {
 		s.config.logger.Info("add route", olog.FieldMethod(route.Method), olog.String("path", route.Path))
 	}
+	if s.Echo.Debug {
+		s.Echo.Logger.SetLevel(log.DEBUG)
+	}
+	// 不使用Echo.Start, 以便设置超时和h2c, 使用外部listener时总是支持h2c
 	s.Echo.Listener = s.listener
-	err := s.Echo.Start("")
+	s.Echo.Server = s.config.Transport.Server(s.config.Address(), s.tracker.Handler(s.Echo), s.config.listener != nil)
+	s.Echo.Server.ErrorLog = s.Echo.StdLogger
+	s.Echo.Server.ConnState = s.tracker.ConnState
+	atomic.StoreInt32(&s.serving, 1)
+	err := s.Echo.Server.Serve(s.listener)
 	if err != http.ErrServerClosed {
 		return err
 	}
@@ -70,16 +157,52 @@ func (s *Server) Serve() error {
 	return nil
 }
 
+// serveOpenAPI 挂载/openapi.json并在governor中注册文档, 已有同名路由时不挂载
+func (s *Server) serveOpenAPI() {
+	var exists bool
+	for _, route := range s.Echo.Routes() {
+		exists = exists || (route.Method == http.MethodGet && route.Path == oopenapi.DocumentPath)
+	}
+	if !exists {
+		s.Echo.GET(oopenapi.DocumentPath, func(c echo.Context) error {
+			return c.JSON(http.StatusOK, s.Document())
+		})
+	}
+	oopenapi.Register(s.tracker.Name(), s.Document)
+}
+
 // Stop implements server.Server interface
 // it will terminate echo server immediately
 func (s *Server) Stop() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	atomic.StoreInt32(&s.serving, 0)
+	s.closeStreams(ctx)
+	oopenapi.Deregister(s.tracker.Name())
+	defer s.tracker.Close()
 	return s.Echo.Close()
 }
 
 // GracefulStop implements server.Server interface
-// it will stop echo server gracefully
+// it will stop echo server gracefully, hijacked connections are closed after Transport.GracePeriod
 func (s *Server) GracefulStop(ctx context.Context) error {
-	return s.Echo.Shutdown(ctx)
+	atomic.StoreInt32(&s.serving, 0)
+	s.closeStreams(ctx)
+	oopenapi.Deregister(s.tracker.Name())
+	return s.tracker.Shutdown(ctx, s.Echo.Server, s.config.Transport.GracePeriod, s.config.logger)
+}
+
+// closeStreams 关闭长连接, sse连接会阻塞Shutdown
+func (s *Server) closeStreams(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, closer := range s.closers {
+		wg.Add(1)
+		go func(closer func(context.Context) error) {
+			defer wg.Done()
+			_ = closer(ctx)
+		}(closer)
+	}
+	wg.Wait()
 }
 
 // Info returns server info, used by governor and consumer balancer
@@ -0,0 +1,40 @@
+package oecho
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/xqk/ox/pkg/server/ocache"
+	"github.com/xqk/ox/pkg/server/oidempotency"
+)
+
+// WrapMiddleware 将net/http中间件转换为echo中间件, 中间件没有调用next时不执行后续的handler
+func WrapMiddleware(m func(http.Handler) http.Handler) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var resp = c.Response()
+			m(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c.SetRequest(r)
+				if w != http.ResponseWriter(resp) {
+					c.SetResponse(echo.NewResponse(w, c.Echo()))
+				}
+				// 错误响应也需要经过中间件
+				if err := next(c); err != nil {
+					c.Error(err)
+				}
+				c.SetResponse(resp)
+			})).ServeHTTP(resp, c.Request())
+			return nil
+		}
+	}
+}
+
+// Cache 缓存GET响应的中间件
+func Cache(cache *ocache.Cache) echo.MiddlewareFunc {
+	return WrapMiddleware(cache.Handler)
+}
+
+// Idempotency 按Idempotency-Key保存和重放响应的中间件
+func Idempotency(idempotency *oidempotency.Idempotency) echo.MiddlewareFunc {
+	return WrapMiddleware(idempotency.Handler)
+}
@@ -0,0 +1,44 @@
+package oerror
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Config 错误响应配置
+type Config struct {
+	// Status 错误码到HTTP状态码的映射, 如 {"5" = 404, "10001" = 400}, 未配置的错误码按ecode.HTTPStatus转换
+	Status map[string]int `json:"status" toml:"status"`
+
+	envelope Envelope
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{}
+}
+
+// WithEnvelope 自定义错误响应的格式
+func (config *Config) WithEnvelope(envelope Envelope) *Config {
+	config.envelope = envelope
+	return config
+}
+
+// Build ...
+func (config *Config) Build() (*Contract, error) {
+	c := &Contract{
+		status:   make(map[int]int, len(config.Status)),
+		envelope: config.envelope,
+	}
+	for key, status := range config.Status {
+		code, err := strconv.Atoi(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid error code %q: %v", key, err)
+		}
+		if status < 100 || status > 999 {
+			return nil, fmt.Errorf("invalid http status %d of error code %d", status, code)
+		}
+		c.status[code] = status
+	}
+	return c, nil
+}
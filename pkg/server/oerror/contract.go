@@ -0,0 +1,56 @@
+package oerror
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/trace"
+)
+
+// Envelope 自定义错误响应的格式, 返回值以json编码
+type Envelope func(r *http.Request, e *Error) interface{}
+
+// Contract HTTP服务统一的错误响应约定
+type Contract struct {
+	status   map[int]int
+	envelope Envelope
+}
+
+// Default 默认的错误响应约定
+var Default = &Contract{}
+
+// Status 返回错误的HTTP状态码, 依次使用Error.Status, 配置的映射表和ecode.HTTPStatus
+func (c *Contract) Status(e *Error) int {
+	if e.Status != 0 {
+		return e.Status
+	}
+	if status, ok := c.status[e.Code]; ok {
+		return status
+	}
+	return ecode.HTTPStatus(e.Code)
+}
+
+// Resolve 返回错误的HTTP状态码和响应体
+func (c *Contract) Resolve(r *http.Request, err error) (int, interface{}) {
+	if err == nil {
+		err = ErrPanic
+	}
+	e := FromError(err)
+	if e.TraceID == "" && r != nil {
+		e.TraceID = trace.ExtractTraceID(r.Context())
+	}
+	if c.envelope != nil {
+		return c.Status(e), c.envelope(r, e)
+	}
+	return c.Status(e), e
+}
+
+// Write 写入json格式的错误响应
+func (c *Contract) Write(w http.ResponseWriter, r *http.Request, err error) {
+	status, body := c.Resolve(r, err)
+	bs, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(bs)
+}
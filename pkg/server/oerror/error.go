@@ -0,0 +1,63 @@
+package oerror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// ErrPanic 请求处理panic时的错误, 不对外暴露panic的内容
+var ErrPanic = New(int(codes.Internal), "internal server error")
+
+// Error 统一的HTTP错误响应
+type Error struct {
+	// Code ecode错误码
+	Code    int               `json:"code"`
+	Message string            `json:"message"`
+	Details []json.RawMessage `json:"details,omitempty"`
+	TraceID string            `json:"traceId,omitempty"`
+	// Status HTTP状态码, 为0时按错误码转换
+	Status int `json:"-"`
+}
+
+// New ...
+func New(code int, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Error implements error
+func (e *Error) Error() string {
+	return fmt.Sprintf("code = %d, message = %s", e.Code, e.Message)
+}
+
+// WithStatus returns a copy of e with HTTP status
+func (e *Error) WithStatus(status int) *Error {
+	ne := *e
+	ne.Status = status
+	return &ne
+}
+
+// FromError 把error转换为Error, 支持Error, grpc status和ecode的错误, 其他错误的错误码为codes.Unknown
+func FromError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		ne := *e
+		return &ne
+	}
+
+	st := status.Convert(err)
+	e = New(int(st.Code()), st.Message())
+	for _, detail := range st.Proto().GetDetails() {
+		if bs, err := protojson.Marshal(detail); err == nil {
+			e.Details = append(e.Details, bs)
+		}
+	}
+	return e
+}
@@ -0,0 +1,81 @@
+package oerror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/ecode"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFromError(t *testing.T) {
+	assert.Nil(t, FromError(nil))
+
+	e := FromError(errors.New("boom"))
+	assert.Equal(t, int(codes.Unknown), e.Code)
+	assert.Equal(t, "boom", e.Message)
+
+	e = FromError(ecode.Overloaded.Err())
+	assert.Equal(t, int(codes.Unavailable), e.Code)
+	assert.Equal(t, "server overloaded", e.Message)
+
+	st, err := status.New(codes.InvalidArgument, "bad name").WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: "name", Description: "empty"}},
+	})
+	assert.Nil(t, err)
+	e = FromError(st.Err())
+	assert.Equal(t, int(codes.InvalidArgument), e.Code)
+	assert.Len(t, e.Details, 1)
+	assert.Contains(t, string(e.Details[0]), `"field":"name"`)
+
+	orig := New(10001, "custom").WithStatus(http.StatusTeapot)
+	e = FromError(orig)
+	assert.Equal(t, orig, e)
+	e.Message = "changed"
+	assert.Equal(t, "custom", orig.Message)
+}
+
+func TestContract_Status(t *testing.T) {
+	contract, err := (&Config{Status: map[string]int{"5": 410}}).Build()
+	assert.Nil(t, err)
+
+	assert.Equal(t, 410, contract.Status(New(int(codes.NotFound), "")))
+	assert.Equal(t, http.StatusBadRequest, contract.Status(New(int(codes.InvalidArgument), "")))
+	assert.Equal(t, http.StatusTeapot, contract.Status(New(int(codes.NotFound), "").WithStatus(http.StatusTeapot)))
+
+	_, err = (&Config{Status: map[string]int{"x": 400}}).Build()
+	assert.NotNil(t, err)
+	_, err = (&Config{Status: map[string]int{"5": 42}}).Build()
+	assert.NotNil(t, err)
+}
+
+func TestContract_Write(t *testing.T) {
+	w := httptest.NewRecorder()
+	Default.Write(w, httptest.NewRequest(http.MethodGet, "/", nil), status.Error(codes.NotFound, "user not found"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"code":5,"message":"user not found"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	Default.Write(w, nil, nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"code":13,"message":"internal server error"}`, w.Body.String())
+}
+
+func TestContract_Envelope(t *testing.T) {
+	contract, err := DefaultConfig().WithEnvelope(func(r *http.Request, e *Error) interface{} {
+		return map[string]interface{}{"error": e.Code, "msg": e.Message, "path": r.URL.Path}
+	}).Build()
+	assert.Nil(t, err)
+
+	status, body := contract.Resolve(httptest.NewRequest(http.MethodGet, "/users", nil), ecode.Overloaded.Err())
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	bs, _ := json.Marshal(body)
+	assert.JSONEq(t, `{"error":14,"msg":"server overloaded","path":"/users"}`, string(bs))
+}
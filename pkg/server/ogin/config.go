@@ -2,11 +2,19 @@ package ogin
 
 import (
 	"fmt"
+	"net"
+	"os"
 	"github.com/gin-gonic/gin"
 	"github.com/pkg/errors"
+	"github.com/xqk/ox/pkg/auth"
 	"github.com/xqk/ox/pkg/conf"
 	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/limiter"
+	"github.com/xqk/ox/pkg/metric"
 	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/server/oerror"
+	"github.com/xqk/ox/pkg/server/otransport"
+	"github.com/xqk/ox/pkg/util/onet"
 )
 
 //ModName ..
@@ -14,27 +22,52 @@ const ModName = "server.gin"
 
 // Config HTTP config
 type Config struct {
-	Host          string
-	Port          int
+	Host string
+	Port int
+	// Network network type, tcp by default.
+	// 为unix时Host为socket文件路径, 以"@"开头时为abstract socket
+	Network string
+	// SocketMode unix socket文件的权限, 为0时不修改
+	SocketMode    os.FileMode
 	Deployment    string
 	Mode          string
 	DisableMetric bool
 	DisableTrace  bool
+	// EnableOpenAPI 挂载/openapi.json并在governor中注册文档, 默认关闭
+	EnableOpenAPI bool
 	// ServiceAddress service address in registry info, default to 'Host:Port'
 	ServiceAddress string
 
 	SlowQueryThresholdInMilli int64
+	// Limiter 自适应限流配置, 默认关闭
+	Limiter *limiter.Config
+	// Auth 认证配置, 默认关闭
+	Auth *auth.Config
+	// Caller 调用方签名和ACL校验, 默认关闭, 通过StdConfig创建时支持热更新
+	Caller *auth.CallerConfig
+	// Error 错误响应配置
+	Error *oerror.Config
+	// Transport 超时, 请求头限制和HTTP/2配置
+	Transport *otransport.Config
 
-	logger *olog.Logger
+	key      string
+	listener net.Listener
+	logger   *olog.Logger
 }
 
 // DefaultConfig ...
 func DefaultConfig() *Config {
 	return &Config{
+		Network:                   "tcp",
 		Host:                      "127.0.0.1",
 		Port:                      9091,
 		Mode:                      gin.ReleaseMode,
 		SlowQueryThresholdInMilli: 500, // 500ms
+		Limiter:                   limiter.DefaultConfig(),
+		Auth:                      auth.DefaultConfig(),
+		Caller:                    auth.DefaultCallerConfig(),
+		Error:                     oerror.DefaultConfig(),
+		Transport:                 otransport.DefaultConfig(),
 		logger:                    olog.OxLogger.With(olog.FieldMod(ModName)),
 	}
 }
@@ -51,6 +84,7 @@ func RawConfig(key string) *Config {
 		errors.Cause(err) != conf.ErrInvalidKey {
 		config.logger.Panic("http server parse config panic", olog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), olog.FieldErr(err), olog.FieldKey(key), olog.FieldValueAny(config))
 	}
+	config.key = key
 	return config
 }
 
@@ -72,10 +106,34 @@ func (config *Config) WithPort(port int) *Config {
 	return config
 }
 
+// WithListener serves on listener instead of listening on Address, such as the listener of omux.
+// Cleartext HTTP/2 requests on the listener are served by h2c.
+func (config *Config) WithListener(listener net.Listener) *Config {
+	config.listener = listener
+	return config
+}
+
+func (config *Config) listen() (net.Listener, error) {
+	if config.listener != nil {
+		return config.listener, nil
+	}
+	return onet.Listen(config.Network, config.Address(), config.SocketMode)
+}
+
 // Build create server instance, then initialize it with necessary interceptor
 func (config *Config) Build() *Server {
 	server := newServer(config)
-	server.Use(recoverMiddleware(config.logger, config.SlowQueryThresholdInMilli))
+	server.contract = config.contract()
+	server.Use(requestIDMiddleware())
+	server.Use(recoverMiddleware(config.logger, config.SlowQueryThresholdInMilli, server.contract))
+	server.Use(errorMiddleware(server.contract))
+	server.NoRoute(func(c *gin.Context) {
+		WriteError(c, NewHTTPError(StatusNotFound))
+	})
+	if config.Limiter != nil && config.Limiter.Enable {
+		server.Use(limiterMiddleware(limiter.New(metric.TypeHTTP, config.Limiter)))
+	}
+	server.Use(deadlineMiddleware(config.logger))
 
 	if !config.DisableMetric {
 		server.Use(metricServerInterceptor())
@@ -84,10 +142,47 @@ func (config *Config) Build() *Server {
 	if !config.DisableTrace {
 		server.Use(traceServerInterceptor())
 	}
+
+	if config.Auth != nil && config.Auth.Enable {
+		a, err := config.Auth.Build()
+		if err != nil {
+			config.logger.Panic("build auth panic", olog.FieldErr(err))
+		}
+		server.Use(authMiddleware(config.logger, a))
+	}
+
+	// 通过StdConfig创建时热更新可以开启校验, 关闭时不校验
+	if config.Caller != nil && (config.Caller.Enable || config.key != "") {
+		server.Use(callerMiddleware(config.logger, config.callerGuard()))
+	}
 	return server
 }
 
 // Address ...
 func (config *Config) Address() string {
+	if onet.IsUnixNetwork(config.Network) {
+		return config.Host
+	}
 	return fmt.Sprintf("%s:%d", config.Host, config.Port)
 }
+
+// contract builds error response contract
+func (config *Config) contract() *oerror.Contract {
+	if config.Error == nil {
+		return oerror.Default
+	}
+	contract, err := config.Error.Build()
+	if err != nil {
+		config.logger.Panic("build error contract panic", olog.FieldErr(err))
+	}
+	return contract
+}
+
+// callerGuard creates caller guard, which reloads when configuration changes if config is created by RawConfig
+func (config *Config) callerGuard() *auth.CallerGuard {
+	g := auth.NewCallerGuard(metric.TypeHTTP, config.Caller)
+	if config.key != "" {
+		g.Watch(config.key+".caller", config.logger)
+	}
+	return g
+}
@@ -1,6 +1,8 @@
 package ogin
 
 import (
+	"github.com/gin-gonic/gin"
+	"github.com/xqk/ox/pkg/server/oerror"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -48,3 +50,27 @@ var (
 	// ErrGRPCInvokeLen ...
 	ErrGRPCInvokeLen = grpc.Errorf(codes.Internal, "invoke request without len 2 res")
 )
+
+// contractKey 请求上下文中错误响应约定的key
+const contractKey = "ox.error.contract"
+
+// WriteError 按错误响应约定写入错误并中止请求, 也可以调用c.Error(err)后返回, 由中间件统一写入
+func WriteError(c *gin.Context, err error) {
+	contract := oerror.Default
+	if v, ok := c.Get(contractKey); ok {
+		contract = v.(*oerror.Contract)
+	}
+	status, body := contract.Resolve(c.Request, toError(err))
+	c.AbortWithStatusJSON(status, body)
+}
+
+// toError converts HTTPError to oerror.Error with the http status
+func toError(err error) error {
+	switch e := err.(type) {
+	case *HTTPError:
+		return oerror.New(e.Code, e.Message).WithStatus(e.Code)
+	case HTTPError:
+		return oerror.New(e.Code, e.Message).WithStatus(e.Code)
+	}
+	return err
+}
@@ -0,0 +1,493 @@
+package ogin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/xqk/ox/pkg/conf"
+	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/metric"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/util/ostring"
+)
+
+var (
+	// ErrConnNotFound 连接不存在
+	ErrConnNotFound = errors.New("websocket connection not found")
+	// ErrConnClosed 连接已关闭
+	ErrConnClosed = errors.New("websocket connection closed")
+	// ErrSendQueueFull 连接的发送队列已满
+	ErrSendQueueFull = errors.New("websocket send queue full")
+	// ErrHubClosed hub已关闭
+	ErrHubClosed = errors.New("websocket hub closed")
+)
+
+// HubConfig websocket连接管理配置
+type HubConfig struct {
+	// Name 名称, 用于监控
+	Name string `json:"name" toml:"name"`
+	// SendQueueSize 每个连接的发送队列长度
+	SendQueueSize int `json:"sendQueueSize" toml:"sendQueueSize"`
+	// DropOnFull 发送队列满时丢弃消息, 默认关闭跟不上的连接
+	DropOnFull bool `json:"dropOnFull" toml:"dropOnFull"`
+	// WriteTimeout 单条消息的写超时, 为0时不限制
+	WriteTimeout time.Duration `json:"writeTimeout" toml:"writeTimeout"`
+	// PingInterval 发送ping的间隔, 应小于IdleTimeout, 为0时不发送
+	PingInterval time.Duration `json:"pingInterval" toml:"pingInterval"`
+	// IdleTimeout 超过时间没有收到消息或pong时关闭连接, 为0时不关闭空闲连接
+	IdleTimeout time.Duration `json:"idleTimeout" toml:"idleTimeout"`
+	// MaxMessageSize 读取消息的最大字节数, 为0时不限制
+	MaxMessageSize int64 `json:"maxMessageSize" toml:"maxMessageSize"`
+
+	logger *olog.Logger
+}
+
+// DefaultHubConfig ...
+func DefaultHubConfig() *HubConfig {
+	return &HubConfig{
+		Name:           "default",
+		SendQueueSize:  256,
+		WriteTimeout:   10 * time.Second,
+		PingInterval:   30 * time.Second,
+		IdleTimeout:    60 * time.Second,
+		MaxMessageSize: 1 << 20,
+		logger:         olog.OxLogger.With(olog.FieldMod(ModName)),
+	}
+}
+
+// RawHubConfig ...
+func RawHubConfig(key string) *HubConfig {
+	var config = DefaultHubConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil &&
+		pkgerrors.Cause(err) != conf.ErrInvalidKey {
+		config.logger.Panic("websocket hub parse config panic", olog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), olog.FieldErr(err), olog.FieldKey(key), olog.FieldValueAny(config))
+	}
+	return config
+}
+
+// WithLogger ...
+func (config *HubConfig) WithLogger(logger *olog.Logger) *HubConfig {
+	config.logger = logger
+	return config
+}
+
+// deadline returns deadline after timeout, zero time means no deadline when timeout is 0
+func (config *HubConfig) deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// Build ...
+func (config *HubConfig) Build() *Hub {
+	if config.SendQueueSize <= 0 {
+		config.SendQueueSize = 1
+	}
+	return &Hub{
+		Upgrader: &websocket.Upgrader{},
+		config:   config,
+		conns:    make(map[string]*HubConn),
+		rooms:    make(map[string]map[string]*HubConn),
+	}
+}
+
+// Hub 管理websocket连接, 支持房间和广播, 通过Server.Hub挂载后在GracefulStop时关闭所有连接
+type Hub struct {
+	// Upgrader 升级websocket使用的Upgrader, 在挂载前修改
+	Upgrader *websocket.Upgrader
+
+	config    *HubConfig
+	onConnect func(*HubConn)
+	onMessage func(*HubConn, int, []byte)
+	onClose   func(*HubConn)
+
+	mu     sync.RWMutex
+	conns  map[string]*HubConn
+	rooms  map[string]map[string]*HubConn
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// OnConnect 设置连接建立后的回调, 可以在回调中加入房间
+func (h *Hub) OnConnect(fn func(c *HubConn)) *Hub {
+	h.onConnect = fn
+	return h
+}
+
+// OnMessage 设置收到消息的回调, 同一连接的回调按顺序执行
+func (h *Hub) OnMessage(fn func(c *HubConn, messageType int, data []byte)) *Hub {
+	h.onMessage = fn
+	return h
+}
+
+// OnClose 设置连接关闭后的回调
+func (h *Hub) OnClose(fn func(c *HubConn)) *Hub {
+	h.onClose = fn
+	return h
+}
+
+// ServeHTTP 升级websocket连接并阻塞到连接关闭
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	closed := h.closed
+	h.mu.RUnlock()
+	if closed {
+		http.Error(w, ErrHubClosed.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := h.Upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.config.logger.Warn("websocket upgrade", olog.String("path", r.URL.Path), olog.FieldErr(err))
+		return
+	}
+
+	c := &HubConn{
+		ID:          ostring.GenerateUUID(time.Now()),
+		hub:         h,
+		conn:        conn,
+		request:     r,
+		send:        make(chan *websocket.PreparedMessage, h.config.SendQueueSize),
+		done:        make(chan struct{}),
+		writeExited: make(chan struct{}),
+		rooms:       make(map[string]struct{}),
+	}
+	if !h.register(c) {
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown"), h.config.deadline(h.config.WriteTimeout))
+		_ = conn.Close()
+		return
+	}
+	defer h.wg.Done()
+
+	go c.writePump()
+	if h.onConnect != nil {
+		h.onConnect(c)
+	}
+	c.readPump()
+	c.close(0, "")
+	<-c.writeExited
+
+	h.unregister(c)
+	if h.onClose != nil {
+		h.onClose(c)
+	}
+}
+
+func (h *Hub) register(c *HubConn) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.wg.Add(1)
+	h.conns[c.ID] = c
+	metric.ServerConnGauge.Inc(metric.TypeWebsocket, h.config.Name)
+	return true
+}
+
+func (h *Hub) unregister(c *HubConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, c.ID)
+	for room := range c.rooms {
+		h.leave(c, room)
+	}
+	metric.ServerConnGauge.Add(-1, metric.TypeWebsocket, h.config.Name)
+}
+
+func (h *Hub) leave(c *HubConn, room string) {
+	if conns, ok := h.rooms[room]; ok {
+		delete(conns, c.ID)
+		if len(conns) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+}
+
+// Len 返回当前的连接数
+func (h *Hub) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.conns)
+}
+
+// Conn 返回指定id的连接
+func (h *Hub) Conn(id string) (*HubConn, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	c, ok := h.conns[id]
+	return c, ok
+}
+
+// Rooms 返回当前有连接的房间
+func (h *Hub) Rooms() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	rooms := make([]string, 0, len(h.rooms))
+	for room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// RoomLen 返回房间的连接数
+func (h *Hub) RoomLen(room string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.rooms[room])
+}
+
+// Send 向指定id的连接发送消息
+func (h *Hub) Send(id string, messageType int, data []byte) error {
+	c, ok := h.Conn(id)
+	if !ok {
+		return ErrConnNotFound
+	}
+	return c.Send(messageType, data)
+}
+
+// Broadcast 向所有连接发送消息, 发送队列已满的连接按DropOnFull处理, 不影响其他连接
+func (h *Hub) Broadcast(messageType int, data []byte) error {
+	h.mu.RLock()
+	conns := make([]*HubConn, 0, len(h.conns))
+	for _, c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.mu.RUnlock()
+	return h.broadcast(conns, messageType, data)
+}
+
+// BroadcastRoom 向房间内的所有连接发送消息
+func (h *Hub) BroadcastRoom(room string, messageType int, data []byte) error {
+	h.mu.RLock()
+	conns := make([]*HubConn, 0, len(h.rooms[room]))
+	for _, c := range h.rooms[room] {
+		conns = append(conns, c)
+	}
+	h.mu.RUnlock()
+	return h.broadcast(conns, messageType, data)
+}
+
+func (h *Hub) broadcast(conns []*HubConn, messageType int, data []byte) error {
+	if len(conns) == 0 {
+		return nil
+	}
+	pm, err := websocket.NewPreparedMessage(messageType, data)
+	if err != nil {
+		return err
+	}
+	for _, c := range conns {
+		_ = c.enqueue(pm)
+	}
+	return nil
+}
+
+// Close 关闭hub, 拒绝新的连接, 向所有连接发送going away并等待连接关闭, ctx结束时强制关闭连接
+func (h *Hub) Close(ctx context.Context) error {
+	h.mu.Lock()
+	h.closed = true
+	conns := make([]*HubConn, 0, len(h.conns))
+	for _, c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.mu.Unlock()
+
+	for _, c := range conns {
+		c.close(websocket.CloseGoingAway, "server shutdown")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		h.config.logger.Warn("websocket hub force close", olog.String("name", h.config.Name), olog.Int("conns", h.Len()))
+		for _, c := range conns {
+			_ = c.conn.Close()
+		}
+		<-done
+		return ctx.Err()
+	}
+}
+
+// HubConn hub管理的websocket连接
+type HubConn struct {
+	// ID 连接的唯一id
+	ID string
+
+	hub         *Hub
+	conn        *websocket.Conn
+	request     *http.Request
+	send        chan *websocket.PreparedMessage
+	done        chan struct{}
+	writeExited chan struct{}
+	closeOnce   sync.Once
+	closeCode   int
+	closeText   string
+	rooms       map[string]struct{}
+}
+
+// Request 返回升级连接的请求
+func (c *HubConn) Request() *http.Request {
+	return c.request
+}
+
+// Conn 返回底层的websocket连接, 不应直接读写消息
+func (c *HubConn) Conn() WebSocketConn {
+	return c.conn
+}
+
+// Send 发送消息, 消息进入发送队列后立即返回
+func (c *HubConn) Send(messageType int, data []byte) error {
+	pm, err := websocket.NewPreparedMessage(messageType, data)
+	if err != nil {
+		return err
+	}
+	return c.enqueue(pm)
+}
+
+func (c *HubConn) enqueue(pm *websocket.PreparedMessage) error {
+	select {
+	case <-c.done:
+		return ErrConnClosed
+	default:
+	}
+	select {
+	case c.send <- pm:
+		return nil
+	default:
+	}
+	metric.ServerMessageCounter.Inc(metric.TypeWebsocket, c.hub.config.Name, "out", "dropped")
+	if !c.hub.config.DropOnFull {
+		c.hub.config.logger.Warn("websocket send queue full, close connection", olog.String("id", c.ID), olog.String("peer", c.conn.RemoteAddr().String()))
+		c.close(websocket.ClosePolicyViolation, "send queue full")
+	}
+	return ErrSendQueueFull
+}
+
+// Join 加入房间
+func (c *HubConn) Join(room string) {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	if _, ok := c.hub.conns[c.ID]; !ok {
+		return
+	}
+	c.rooms[room] = struct{}{}
+	conns, ok := c.hub.rooms[room]
+	if !ok {
+		conns = make(map[string]*HubConn)
+		c.hub.rooms[room] = conns
+	}
+	conns[c.ID] = c
+}
+
+// Leave 离开房间
+func (c *HubConn) Leave(room string) {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	delete(c.rooms, room)
+	c.hub.leave(c, room)
+}
+
+// Rooms 返回连接加入的房间
+func (c *HubConn) Rooms() []string {
+	c.hub.mu.RLock()
+	defer c.hub.mu.RUnlock()
+	rooms := make([]string, 0, len(c.rooms))
+	for room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// Close 正常关闭连接
+func (c *HubConn) Close() error {
+	c.close(websocket.CloseNormalClosure, "")
+	return nil
+}
+
+// close 通知写协程发送关闭帧后关闭连接, code为0时不发送关闭帧
+func (c *HubConn) close(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.closeCode, c.closeText = code, text
+		close(c.done)
+	})
+}
+
+func (c *HubConn) readPump() {
+	config := c.hub.config
+	if config.MaxMessageSize > 0 {
+		c.conn.SetReadLimit(config.MaxMessageSize)
+	}
+	_ = c.conn.SetReadDeadline(config.deadline(config.IdleTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(config.deadline(config.IdleTimeout))
+	})
+	for {
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+				select {
+				case <-c.done:
+				default:
+					config.logger.Warn("websocket read", olog.String("id", c.ID), olog.FieldErr(err))
+				}
+			}
+			return
+		}
+		_ = c.conn.SetReadDeadline(config.deadline(config.IdleTimeout))
+		metric.ServerMessageCounter.Inc(metric.TypeWebsocket, config.Name, "in", "ok")
+		if c.hub.onMessage != nil {
+			c.hub.onMessage(c, messageType, data)
+		}
+	}
+}
+
+func (c *HubConn) writePump() {
+	config := c.hub.config
+	var ping <-chan time.Time
+	if config.PingInterval > 0 {
+		ticker := time.NewTicker(config.PingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+	defer func() {
+		_ = c.conn.Close()
+		close(c.writeExited)
+	}()
+	for {
+		select {
+		case pm := <-c.send:
+			_ = c.conn.SetWriteDeadline(config.deadline(config.WriteTimeout))
+			if err := c.conn.WritePreparedMessage(pm); err != nil {
+				metric.ServerMessageCounter.Inc(metric.TypeWebsocket, config.Name, "out", "error")
+				c.close(0, "")
+				return
+			}
+			metric.ServerMessageCounter.Inc(metric.TypeWebsocket, config.Name, "out", "ok")
+		case <-ping:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, config.deadline(config.WriteTimeout)); err != nil {
+				c.close(0, "")
+				return
+			}
+		case <-c.done:
+			if c.closeCode != 0 {
+				_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeText), config.deadline(config.WriteTimeout))
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package ogin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func dialHub(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/?"+query, nil)
+	assert.Nil(t, err)
+	return conn
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition not satisfied")
+}
+
+func readText(t *testing.T, conn *websocket.Conn) string {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+	assert.Nil(t, err)
+	return string(data)
+}
+
+func TestHub(t *testing.T) {
+	hub := DefaultHubConfig().Build()
+	closed := make(chan string, 4)
+	hub.OnConnect(func(c *HubConn) {
+		if room := c.Request().URL.Query().Get("room"); room != "" {
+			c.Join(room)
+		}
+	}).OnMessage(func(c *HubConn, messageType int, data []byte) {
+		_ = c.Send(messageType, append([]byte("echo "), data...))
+	}).OnClose(func(c *HubConn) {
+		closed <- c.ID
+	})
+	server := httptest.NewServer(hub)
+	defer server.Close()
+
+	a := dialHub(t, server, "room=a")
+	defer a.Close()
+	b := dialHub(t, server, "room=b")
+	defer b.Close()
+	waitFor(t, func() bool { return hub.RoomLen("a") == 1 && hub.RoomLen("b") == 1 })
+	assert.Equal(t, 2, hub.Len())
+	assert.Equal(t, []string{"a", "b"}, hub.Rooms())
+
+	assert.Nil(t, a.WriteMessage(websocket.TextMessage, []byte("hi")))
+	assert.Equal(t, "echo hi", readText(t, a))
+
+	assert.Nil(t, hub.BroadcastRoom("b", websocket.TextMessage, []byte("to b")))
+	assert.Nil(t, hub.Broadcast(websocket.TextMessage, []byte("to all")))
+	assert.Equal(t, "to all", readText(t, a))
+	assert.Equal(t, "to b", readText(t, b))
+	assert.Equal(t, "to all", readText(t, b))
+
+	assert.Equal(t, ErrConnNotFound, hub.Send("none", websocket.TextMessage, nil))
+
+	// 客户端关闭后离开房间
+	assert.Nil(t, b.Close())
+	<-closed
+	waitFor(t, func() bool { return hub.Len() == 1 })
+	assert.Equal(t, []string{"a"}, hub.Rooms())
+}
+
+func TestHub_Close(t *testing.T) {
+	hub := DefaultHubConfig().Build()
+	server := httptest.NewServer(hub)
+	defer server.Close()
+
+	conn := dialHub(t, server, "")
+	defer conn.Close()
+	waitFor(t, func() bool { return hub.Len() == 1 })
+
+	go func() {
+		// 客户端读取到关闭帧后回复关闭帧
+		_, _, _ = conn.ReadMessage()
+	}()
+	assert.Nil(t, hub.Close(context.Background()))
+	assert.Equal(t, 0, hub.Len())
+
+	resp, err := http.Get(server.URL)
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestHub_CloseGoingAway(t *testing.T) {
+	hub := DefaultHubConfig().Build()
+	server := httptest.NewServer(hub)
+	defer server.Close()
+
+	conn := dialHub(t, server, "")
+	defer conn.Close()
+	waitFor(t, func() bool { return hub.Len() == 1 })
+
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadMessage()
+		errs <- err
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, hub.Close(ctx))
+	assert.True(t, websocket.IsCloseError(<-errs, websocket.CloseGoingAway))
+}
+
+func TestHub_IdleTimeout(t *testing.T) {
+	config := DefaultHubConfig()
+	config.PingInterval = 20 * time.Millisecond
+	config.IdleTimeout = 50 * time.Millisecond
+	hub := config.Build()
+	server := httptest.NewServer(hub)
+	defer server.Close()
+
+	// 不读取消息的客户端不会回复pong
+	conn := dialHub(t, server, "")
+	defer conn.Close()
+	waitFor(t, func() bool { return hub.Len() == 1 })
+	waitFor(t, func() bool { return hub.Len() == 0 })
+}
+
+func TestHub_ZeroTimeout(t *testing.T) {
+	config := DefaultHubConfig()
+	config.WriteTimeout = 0
+	config.PingInterval = 0
+	config.IdleTimeout = 0
+	hub := config.Build()
+	hub.OnMessage(func(c *HubConn, messageType int, data []byte) {
+		_ = c.Send(messageType, data)
+	})
+	server := httptest.NewServer(hub)
+	defer server.Close()
+
+	// 为0时不发送ping, 也不关闭空闲连接
+	conn := dialHub(t, server, "")
+	defer conn.Close()
+	waitFor(t, func() bool { return hub.Len() == 1 })
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, hub.Len())
+	assert.Nil(t, conn.WriteMessage(websocket.TextMessage, []byte("hi")))
+	assert.Equal(t, "hi", readText(t, conn))
+}
+
+func TestHubConn_Backpressure(t *testing.T) {
+	for _, dropOnFull := range []bool{true, false} {
+		dropOnFull := dropOnFull
+		config := DefaultHubConfig()
+		config.SendQueueSize = 1
+		config.DropOnFull = dropOnFull
+		hub := config.Build()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := hub.Upgrader.Upgrade(w, r, nil)
+			assert.Nil(t, err)
+			// 没有写协程的连接, 发送队列不会被消费
+			c := &HubConn{hub: hub, conn: conn, send: make(chan *websocket.PreparedMessage, 1), done: make(chan struct{})}
+			assert.Nil(t, c.Send(websocket.TextMessage, []byte("1")))
+			assert.Equal(t, ErrSendQueueFull, c.Send(websocket.TextMessage, []byte("2")))
+			if dropOnFull {
+				assert.Equal(t, ErrSendQueueFull, c.Send(websocket.TextMessage, []byte("3")))
+			} else {
+				assert.Equal(t, ErrConnClosed, c.Send(websocket.TextMessage, []byte("3")))
+			}
+			_ = conn.Close()
+		}))
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		assert.Nil(t, err)
+		_, _, _ = conn.ReadMessage()
+		_ = conn.Close()
+		server.Close()
+	}
+}
@@ -2,6 +2,7 @@ package ogin
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -15,7 +16,14 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"go.uber.org/zap"
+	"github.com/xqk/ox/pkg/auth"
+	"github.com/xqk/ox/pkg/deadline"
+	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/limiter"
 	"github.com/xqk/ox/pkg/metric"
+	"github.com/xqk/ox/pkg/requestid"
+	"github.com/xqk/ox/pkg/server"
+	"github.com/xqk/ox/pkg/server/oerror"
 	"github.com/xqk/ox/pkg/trace"
 )
 
@@ -30,7 +38,7 @@ func extractAID(ctx *gin.Context) string {
 	return ctx.Request.Header.Get("AID")
 }
 
-func recoverMiddleware(logger *olog.Logger, slowQueryThresholdInMilli int64) gin.HandlerFunc {
+func recoverMiddleware(logger *olog.Logger, slowQueryThresholdInMilli int64, contract *oerror.Contract) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var beg = time.Now()
 		var fields = make([]olog.Field, 0, 8)
@@ -51,17 +59,21 @@ func recoverMiddleware(logger *olog.Logger, slowQueryThresholdInMilli int64) gin
 						}
 					}
 				}
-				var err = rec.(error)
+				err, ok := rec.(error)
+				if !ok {
+					err = fmt.Errorf("%v", rec)
+				}
 				fields = append(fields, zap.ByteString("stack", stack(3)))
 				fields = append(fields, zap.String("err", err.Error()))
-				logger.Error("access", fields...)
+				logger.WithContext(c.Request.Context()).Error("access", fields...)
 				// If the connection is dead, we can't write a status to it.
 				if brokenPipe {
 					c.Error(err) // nolint: errcheck
 					c.Abort()
 					return
 				}
-				c.AbortWithStatus(http.StatusInternalServerError)
+				status, body := contract.Resolve(c.Request, oerror.ErrPanic)
+				c.AbortWithStatusJSON(status, body)
 				return
 			}
 			// httpRequest, _ := httputil.DumpRequest(c.Request, false)
@@ -75,12 +87,33 @@ func recoverMiddleware(logger *olog.Logger, slowQueryThresholdInMilli int64) gin
 				zap.String("ip", c.ClientIP()),
 				zap.String("err", c.Errors.ByType(gin.ErrorTypePrivate).String()),
 			)
-			logger.Info("access", fields...)
+			logger.WithContext(c.Request.Context()).Info("access", fields...)
 		}()
 		c.Next()
 	}
 }
 
+// requestIDMiddleware 从X-Request-ID中获取或生成请求id, 写入context并通过响应header返回
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := requestid.FromHeader(c.Request.Header)
+		c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), id))
+		c.Header(requestid.HeaderRequestID, id)
+		c.Next()
+	}
+}
+
+// errorMiddleware 请求处理中通过c.Error记录的错误, 在没有写入响应时按错误响应约定写入
+func errorMiddleware(contract *oerror.Contract) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(contractKey, contract)
+		c.Next()
+		if len(c.Errors) > 0 && !c.Writer.Written() {
+			WriteError(c, c.Errors.Last().Err)
+		}
+	}
+}
+
 // stack returns a nicely formatted stack frame, skipping skip frames.
 func stack(skip int) []byte {
 	buf := new(bytes.Buffer) // the returned data
@@ -168,9 +201,120 @@ func traceServerInterceptor() gin.HandlerFunc {
 			trace.CustomTag("http.url", c.Request.URL.Path),
 			trace.CustomTag("http.method", c.Request.Method),
 			trace.CustomTag("peer.ipv4", c.ClientIP()),
+			trace.CustomTag(requestid.TagRequestID, requestid.FromContext(c.Request.Context())),
 		)
 		c.Request = c.Request.WithContext(ctx)
 		defer span.Finish()
 		c.Next()
 	}
 }
+
+func deadlineMiddleware(logger *olog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		budget, ok := deadline.FromHeader(c.Request.Header)
+		if !ok {
+			c.Next()
+			return
+		}
+		if budget.Expired() {
+			logger.WithContext(c.Request.Context()).Warn("deadline exhausted",
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.Strings("hops", budget.Hops),
+				olog.FieldAid(extractAID(c)),
+			)
+			WriteError(c, ecode.DeadlineExhausted.Err())
+			return
+		}
+
+		ctx, cancel := deadline.NewContext(c.Request.Context(), budget)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		var beg = time.Now()
+		c.Next()
+		if ctx.Err() == context.DeadlineExceeded {
+			logger.WithContext(c.Request.Context()).Warn("deadline exceeded",
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				zap.Strings("hops", budget.Hops),
+				olog.FieldCost(time.Since(beg)),
+				olog.FieldAid(extractAID(c)),
+			)
+		}
+	}
+}
+
+// limiterMiddleware 自适应限流, 过载时返回503
+func limiterMiddleware(l *limiter.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = server.RouteNotFound
+		}
+		done, ok := l.Allow(route)
+		if !ok {
+			c.Header("Retry-After", "1")
+			WriteError(c, ecode.Overloaded.Err())
+			return
+		}
+		defer done()
+		c.Next()
+	}
+}
+
+// authMiddleware 认证请求, 认证通过后可以通过auth.FromContext获取调用方
+func authMiddleware(logger *olog.Logger, a *auth.Auth) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		if a.Skip(route) {
+			c.Next()
+			return
+		}
+		principal, err := a.Authenticate(auth.FromHTTP(c.Request))
+		if err != nil {
+			logger.WithContext(c.Request.Context()).Warn("unauthenticated",
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+				olog.FieldAid(extractAID(c)),
+				olog.FieldErr(err),
+			)
+			WriteError(c, ecode.Unauthenticated.Err())
+			return
+		}
+		c.Request = c.Request.WithContext(auth.NewContext(c.Request.Context(), principal))
+		c.Next()
+	}
+}
+
+// callerMiddleware 校验调用方签名和路由级ACL
+func callerMiddleware(logger *olog.Logger, g *auth.CallerGuard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = server.RouteNotFound
+		}
+		aid, err := g.Check(route, auth.CallerTarget(c.Request), c.Request.Header)
+		if err == nil {
+			if aid != "" {
+				c.Request = c.Request.WithContext(auth.NewCallerContext(c.Request.Context(), aid))
+			}
+			c.Next()
+			return
+		}
+		logger.WithContext(c.Request.Context()).Warn("caller denied",
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			olog.FieldAid(extractAID(c)),
+			olog.FieldErr(err),
+		)
+		if err == auth.ErrCallerDenied {
+			WriteError(c, ecode.PermissionDenied.Err())
+			return
+		}
+		WriteError(c, ecode.Unauthenticated.Err())
+	}
+}
@@ -3,14 +3,24 @@ package ogin
 import (
 	"context"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"github.com/xqk/ox/pkg/olog"
 
 	"net"
 
 	"github.com/gin-gonic/gin"
+	"github.com/xqk/ox/pkg"
 	"github.com/xqk/ox/pkg/constant"
 	"github.com/xqk/ox/pkg/ecode"
 	"github.com/xqk/ox/pkg/server"
+	"github.com/xqk/ox/pkg/server/oerror"
+	"github.com/xqk/ox/pkg/server/ogrpc/gateway"
+	"github.com/xqk/ox/pkg/server/ogrpc/grpcweb"
+	"github.com/xqk/ox/pkg/server/oopenapi"
+	"github.com/xqk/ox/pkg/server/osse"
+	"github.com/xqk/ox/pkg/server/otransport"
+	"github.com/xqk/ox/pkg/util/onet"
 )
 
 // Server ...
@@ -19,22 +29,51 @@ type Server struct {
 	Server   *http.Server
 	config   *Config
 	listener net.Listener
+	contract *oerror.Contract
+	// closers 服务停止时关闭的长连接, 如websocket和sse
+	closers []func(context.Context) error
+	openapi *oopenapi.Spec
+	// tracker 统计进行中的请求和连接
+	tracker *otransport.Tracker
+	serving int32
 }
 
 func newServer(config *Config) *Server {
-	listener, err := net.Listen("tcp", config.Address())
+	listener, err := config.listen()
 	if err != nil {
 		config.logger.Panic("new ogin server err", olog.FieldErrKind(ecode.ErrKindListenErr), olog.FieldErr(err))
 	}
-	config.Port = listener.Addr().(*net.TCPAddr).Port
+	if port := onet.Port(listener.Addr()); port != 0 {
+		config.Port = port
+	}
 	gin.SetMode(config.Mode)
+	tracker := otransport.NewTracker(ModName + "@" + config.Address())
 	return &Server{
 		Engine:   gin.New(),
 		config:   config,
-		listener: listener,
+		listener: tracker.Listener(listener),
+		openapi:  oopenapi.NewSpec(),
+		tracker:  tracker,
 	}
 }
 
+// API 注册路由并添加openapi说明
+func (s *Server) API(method, path string, api *oopenapi.API, handlers ...gin.HandlerFunc) gin.IRoutes {
+	s.openapi.Add(method, path, api)
+	return s.Handle(method, path, handlers...)
+}
+
+// Document 根据已注册的路由生成openapi文档
+func (s *Server) Document() *oopenapi.Document {
+	var routes []oopenapi.Route
+	for _, route := range s.Engine.Routes() {
+		if route.Path != oopenapi.DocumentPath {
+			routes = append(routes, oopenapi.Route{Method: route.Method, Path: route.Path})
+		}
+	}
+	return s.openapi.Document(oopenapi.Info{Title: pkg.Name(), Version: pkg.AppVersion()}, routes)
+}
+
 //Upgrade protocol to WebSocket
 func (s *Server) Upgrade(ws *WebSocket) gin.IRoutes {
 	return s.GET(ws.Pattern, func(c *gin.Context) {
@@ -42,16 +81,52 @@ func (s *Server) Upgrade(ws *WebSocket) gin.IRoutes {
 	})
 }
 
+// Hub 挂载websocket hub, 服务停止时关闭hub的所有连接
+func (s *Server) Hub(pattern string, hub *Hub) gin.IRoutes {
+	s.closers = append(s.closers, hub.Close)
+	return s.GET(pattern, gin.WrapH(hub))
+}
+
+// SSE 挂载sse broker, handlers为空时推送broker发布的事件, 否则由handlers通过broker.Open推送.
+// 服务停止时结束broker的所有连接
+func (s *Server) SSE(pattern string, broker *osse.Broker, handlers ...gin.HandlerFunc) gin.IRoutes {
+	s.closers = append(s.closers, broker.Close)
+	if len(handlers) == 0 {
+		handlers = append(handlers, gin.WrapH(broker))
+	}
+	return s.GET(pattern, handlers...)
+}
+
+// Transcode 挂载grpc服务转换后的HTTP/JSON接口
+func (s *Server) Transcode(g *gateway.Gateway) {
+	if s.contract != nil {
+		g.WithContract(s.contract)
+	}
+	for _, route := range g.Routes() {
+		s.API(route.Method, route.Path, g.API(route), gin.WrapH(g))
+	}
+}
+
+// GRPCWeb 挂载grpc服务的gRPC-Web接口
+func (s *Server) GRPCWeb(web *grpcweb.GRPCWeb) {
+	for _, route := range web.Routes() {
+		s.Handle(route.Method, route.Path, gin.WrapH(web))
+	}
+}
+
 // Serve implements server.Server interface.
 func (s *Server) Serve() error {
 	// s.Gin.StdLogger = olog.OxLogger.StdLog()
+	if s.config.EnableOpenAPI {
+		s.serveOpenAPI()
+	}
 	for _, route := range s.Engine.Routes() {
 		s.config.logger.Info("add route", olog.FieldMethod(route.Method), olog.String("path", route.Path))
 	}
-	s.Server = &http.Server{
-		Addr:    s.config.Address(),
-		Handler: s,
-	}
+	// 使用外部listener时总是支持h2c
+	s.Server = s.config.Transport.Server(s.config.Address(), s.tracker.Handler(s), s.config.listener != nil)
+	s.Server.ConnState = s.tracker.ConnState
+	atomic.StoreInt32(&s.serving, 1)
 	err := s.Server.Serve(s.listener)
 	if err == http.ErrServerClosed {
 		s.config.logger.Info("close gin", olog.FieldAddr(s.config.Address()))
@@ -61,16 +136,52 @@ func (s *Server) Serve() error {
 	return err
 }
 
+// serveOpenAPI 挂载/openapi.json并在governor中注册文档, 已有同名路由时不挂载
+func (s *Server) serveOpenAPI() {
+	var exists bool
+	for _, route := range s.Engine.Routes() {
+		exists = exists || (route.Method == http.MethodGet && route.Path == oopenapi.DocumentPath)
+	}
+	if !exists {
+		s.GET(oopenapi.DocumentPath, func(c *gin.Context) {
+			c.JSON(http.StatusOK, s.Document())
+		})
+	}
+	oopenapi.Register(s.tracker.Name(), s.Document)
+}
+
 // Stop implements server.Server interface
 // it will terminate gin server immediately
 func (s *Server) Stop() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	atomic.StoreInt32(&s.serving, 0)
+	s.closeStreams(ctx)
+	oopenapi.Deregister(s.tracker.Name())
+	defer s.tracker.Close()
 	return s.Server.Close()
 }
 
 // GracefulStop implements server.Server interface
-// it will stop gin server gracefully
+// it will stop gin server gracefully, hijacked connections are closed after Transport.GracePeriod
 func (s *Server) GracefulStop(ctx context.Context) error {
-	return s.Server.Shutdown(ctx)
+	atomic.StoreInt32(&s.serving, 0)
+	s.closeStreams(ctx)
+	oopenapi.Deregister(s.tracker.Name())
+	return s.tracker.Shutdown(ctx, s.Server, s.config.Transport.GracePeriod, s.config.logger)
+}
+
+// closeStreams 关闭长连接, websocket连接被hijack后不受http.Server管理, sse连接会阻塞Shutdown
+func (s *Server) closeStreams(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, closer := range s.closers {
+		wg.Add(1)
+		go func(closer func(context.Context) error) {
+			defer wg.Done()
+			_ = closer(ctx)
+		}(closer)
+	}
+	wg.Wait()
 }
 
 // Info returns server info, used by governor and consumer balancer
@@ -89,16 +200,7 @@ func (s *Server) Info() *server.ServiceInfo {
 	return &info
 }
 
+// Healthz reports whether the server is serving
 func (s *Server) Healthz() bool {
-	if s.listener == nil {
-		return false
-	}
-
-	conn, err := s.listener.Accept()
-	if err != nil {
-		return false
-	}
-
-	conn.Close()
-	return true
+	return atomic.LoadInt32(&s.serving) == 1
 }
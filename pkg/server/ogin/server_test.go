@@ -0,0 +1,223 @@
+package ogin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/auth"
+	"github.com/xqk/ox/pkg/client/redis"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/requestid"
+	"github.com/xqk/ox/pkg/server/ocache"
+	"github.com/xqk/ox/pkg/server/ogrpc"
+	"github.com/xqk/ox/pkg/server/ogrpc/gateway"
+	"github.com/xqk/ox/pkg/server/oopenapi"
+	"github.com/xqk/ox/pkg/server/osse"
+	"github.com/xqk/ox/pkg/util/otest/proto/testproto"
+	"github.com/xqk/ox/pkg/util/otest/server/yell"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestServer_SSE(t *testing.T) {
+	config := DefaultConfig()
+	config.Port = 0
+	server := config.Build()
+	broker := osse.DefaultConfig().Build()
+	server.SSE("/events", broker)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve()
+	}()
+
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		if resp, err = http.Get("http://" + server.listener.Addr().String() + "/events"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	line, _ := reader.ReadString('\n')
+	assert.Equal(t, "retry: 3000\n", line)
+
+	broker.Publish(&osse.Event{ID: "1", Data: "hello"})
+	_, _ = reader.ReadString('\n')
+	line, _ = reader.ReadString('\n')
+	assert.Equal(t, "id: 1\n", line)
+
+	// 未结束的sse连接不会阻塞GracefulStop
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, server.GracefulStop(ctx))
+	assert.Nil(t, <-served)
+}
+
+func TestServer_RequestID(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	logConfig := olog.DefaultConfig()
+	logConfig.Debug = true
+	logConfig.Async = false
+	logConfig.Core = core
+	config := DefaultConfig()
+	config.Port = 0
+	server := config.WithLogger(logConfig.Build()).Build()
+	defer server.listener.Close()
+	server.GET("/id", func(c *gin.Context) {
+		c.String(http.StatusOK, requestid.FromContext(c.Request.Context()))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/id", nil)
+	req.Header.Set(requestid.HeaderRequestID, "abc")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	assert.Equal(t, "abc", w.Body.String())
+	assert.Equal(t, "abc", w.Header().Get(requestid.HeaderRequestID))
+	// 访问日志自动附带请求id
+	assert.Equal(t, 1, logs.FilterField(olog.FieldRequestID("abc")).Len())
+
+	// 错误响应也带有生成的请求id
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/none", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Len(t, w.Header().Get(requestid.HeaderRequestID), 32)
+}
+
+func TestServer_Cache(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.Nil(t, err)
+	defer mr.Close()
+	redisConfig := redis.DefaultRedisConfig()
+	redisConfig.Addrs = []string{mr.Addr()}
+	redisConfig.Mode = redis.StubMode
+	cache := ocache.DefaultConfig().WithRedis(redisConfig.Build()).Build()
+
+	config := DefaultConfig()
+	config.Port = 0
+	server := config.Build()
+	defer server.listener.Close()
+	var calls int
+	server.GET("/cached", Cache(cache), func(c *gin.Context) {
+		calls++
+		c.String(http.StatusCreated, "hello")
+	})
+	server.GET("/cached/error", Cache(cache), func(c *gin.Context) {
+		calls++
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cached", nil))
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "hello", w.Body.String())
+	}
+	// 201不在默认的StatusCodes中
+	assert.Equal(t, 2, calls)
+
+	server.GET("/cached/ok", Cache(cache), func(c *gin.Context) {
+		calls++
+		c.String(http.StatusOK, "ok")
+	})
+	for _, state := range []string{"MISS", "HIT"} {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cached/ok", nil))
+		assert.Equal(t, "ok", w.Body.String())
+		assert.Equal(t, state, w.Header().Get(ocache.HeaderCache))
+	}
+	assert.Equal(t, 3, calls)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/cached/error", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	}
+	assert.Equal(t, 5, calls)
+}
+
+func TestServer_OpenAPI(t *testing.T) {
+	config := DefaultConfig()
+	config.Port = 0
+	server := config.Build()
+	defer server.listener.Close()
+	type user struct {
+		Name string `json:"name"`
+	}
+	server.API(http.MethodGet, "/users/:id", &oopenapi.API{Summary: "get user", Response: user{}}, func(c *gin.Context) {
+		c.JSON(http.StatusOK, user{Name: c.Param("id")})
+	})
+	server.serveOpenAPI()
+	defer oopenapi.Deregister(server.tracker.Name())
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, oopenapi.DocumentPath, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var doc oopenapi.Document
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, "get user", doc.Paths["/users/{id}"]["get"].Summary)
+	assert.NotContains(t, doc.Paths, oopenapi.DocumentPath)
+
+	_, ok := oopenapi.Get(server.tracker.Name())
+	assert.True(t, ok)
+}
+
+func TestServer_TranscodeCaller(t *testing.T) {
+	grpcConfig := ogrpc.DefaultConfig()
+	grpcConfig.Host = "127.0.0.1"
+	grpcConfig.Port = 0
+	grpcConfig.Caller.Enable = true
+	grpcConfig.Caller.ACL = []auth.ACLRule{{Method: "/testproto.Greeter/SayHello", AIDs: []string{"app1"}}}
+	grpcServer := grpcConfig.MustBuild()
+	defer grpcServer.Stop()
+	testproto.RegisterGreeterServer(grpcServer.Server, &yell.FooServer{})
+	g := gateway.DefaultConfig().MustBuild(grpcServer)
+	defer g.Close()
+
+	newServer := func(caller *auth.CallerConfig) *Server {
+		config := DefaultConfig()
+		config.Port = 0
+		config.Caller = caller
+		server := config.Build()
+		t.Cleanup(func() { server.listener.Close() })
+		server.Transcode(g)
+		return server
+	}
+	call := func(server *Server, sign func(r *http.Request)) int {
+		r := httptest.NewRequest(http.MethodPost, "/testproto.Greeter/SayHello", strings.NewReader(`{"name":"ox"}`))
+		sign(r)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// HTTP服务没有开启调用方校验时, 伪造的aid不能通过grpc方法的ACL
+	server := newServer(auth.DefaultCallerConfig())
+	assert.Equal(t, http.StatusForbidden, call(server, func(r *http.Request) {
+		r.Header.Set(auth.HeaderAID, "app1")
+	}))
+
+	// HTTP服务校验签名后按grpc方法的ACL校验调用方
+	caller := auth.DefaultCallerConfig()
+	caller.Enable = true
+	caller.Keys = []auth.AppKey{{AID: "app1", Key: "k1"}, {AID: "app2", Key: "k2"}}
+	server = newServer(caller)
+	assert.Equal(t, http.StatusForbidden, call(server, func(r *http.Request) {
+		auth.SignCallerHTTP(r, "app2", "k2")
+	}))
+	assert.Equal(t, http.StatusOK, call(server, func(r *http.Request) {
+		auth.SignCallerHTTP(r, "app1", "k1")
+	}))
+}
@@ -0,0 +1,107 @@
+package ogin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xqk/ox/pkg/server/ocache"
+	"github.com/xqk/ox/pkg/server/oidempotency"
+)
+
+// WrapMiddleware 将net/http中间件转换为gin中间件, 中间件没有调用next时终止后续的handler
+func WrapMiddleware(m func(http.Handler) http.Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var writer = c.Writer
+		var called bool
+		defer func() {
+			c.Writer = writer
+		}()
+		m(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			c.Request = r
+			if w != http.ResponseWriter(writer) {
+				c.Writer = &httpWriter{ResponseWriter: writer, w: w, size: noWritten, status: http.StatusOK}
+			}
+			c.Next()
+		})).ServeHTTP(writer, c.Request)
+		if !called {
+			c.Abort()
+		}
+	}
+}
+
+// Cache 缓存GET响应的中间件
+func Cache(cache *ocache.Cache) gin.HandlerFunc {
+	return WrapMiddleware(cache.Handler)
+}
+
+// Idempotency 按Idempotency-Key保存和重放响应的中间件
+func Idempotency(idempotency *oidempotency.Idempotency) gin.HandlerFunc {
+	return WrapMiddleware(idempotency.Handler)
+}
+
+const noWritten = -1
+
+// httpWriter 将gin的响应写入中间件提供的http.ResponseWriter
+type httpWriter struct {
+	gin.ResponseWriter
+	w      http.ResponseWriter
+	size   int
+	status int
+}
+
+// Header ...
+func (w *httpWriter) Header() http.Header {
+	return w.w.Header()
+}
+
+// WriteHeader ...
+func (w *httpWriter) WriteHeader(code int) {
+	if code > 0 && w.status != code && !w.Written() {
+		w.status = code
+	}
+}
+
+// WriteHeaderNow ...
+func (w *httpWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.size = 0
+		w.w.WriteHeader(w.status)
+	}
+}
+
+// Write ...
+func (w *httpWriter) Write(data []byte) (n int, err error) {
+	w.WriteHeaderNow()
+	n, err = w.w.Write(data)
+	w.size += n
+	return
+}
+
+// WriteString ...
+func (w *httpWriter) WriteString(s string) (n int, err error) {
+	return w.Write([]byte(s))
+}
+
+// Status ...
+func (w *httpWriter) Status() int {
+	return w.status
+}
+
+// Size ...
+func (w *httpWriter) Size() int {
+	return w.size
+}
+
+// Written ...
+func (w *httpWriter) Written() bool {
+	return w.size != noWritten
+}
+
+// Flush ...
+func (w *httpWriter) Flush() {
+	w.WriteHeaderNow()
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -7,6 +7,10 @@ import (
 	"github.com/pkg/errors"
 	"github.com/xqk/ox/pkg/conf"
 	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/limiter"
+	"github.com/xqk/ox/pkg/metric"
+	"github.com/xqk/ox/pkg/server/oerror"
+	"github.com/xqk/ox/pkg/server/otransport"
 )
 
 //ModName mod name
@@ -14,8 +18,10 @@ const ModName = "server.goframe"
 
 //Config  HTTP config
 type Config struct {
-	Host          string
-	Port          int
+	Host string
+	Port int
+	// Network network type, goframe内部固定使用tcp监听, 不支持unix domain socket, 配置为其他值时Build失败
+	Network       string
 	Debug         bool
 	DisableMetric bool
 	DisableTrace  bool
@@ -23,6 +29,12 @@ type Config struct {
 	ServiceAddress string
 
 	SlowQueryThresholdInMilli int64
+	// Limiter 自适应限流配置, 默认关闭
+	Limiter *limiter.Config
+	// Error 错误响应配置
+	Error *oerror.Config
+	// Transport 超时, 请求头限制和HTTP/2配置
+	Transport *otransport.Config
 
 	logger *olog.Logger
 }
@@ -32,8 +44,12 @@ func DefaultConfig() *Config {
 	return &Config{
 		Host:                      "127.0.0.1",
 		Port:                      8099,
+		Network:                   "tcp",
 		Debug:                     false,
 		SlowQueryThresholdInMilli: 500, // 500ms
+		Limiter:                   limiter.DefaultConfig(),
+		Error:                     oerror.DefaultConfig(),
+		Transport:                 otransport.DefaultConfig(),
 		logger:                    olog.OxLogger.With(olog.FieldMod(ModName)),
 	}
 }
@@ -73,9 +89,23 @@ func (config *Config) WithPort(port int) *Config {
 
 // Build create server instance, then initialize it with necessary interceptor
 func (config *Config) Build() *Server {
+	if config.Network != "" && config.Network != "tcp" {
+		config.logger.Panic("new goframe server err", olog.FieldErrKind(ecode.ErrKindListenErr), olog.FieldErr(fmt.Errorf("unsupported network %q", config.Network)))
+	}
+	var contract = oerror.Default
+	if config.Error != nil {
+		var err error
+		if contract, err = config.Error.Build(); err != nil {
+			config.logger.Panic("build error contract panic", olog.FieldErr(err))
+		}
+	}
 	serve := newServer(config)
 
-	serve.Use(recoverMiddleware(config.logger, config.SlowQueryThresholdInMilli))
+	serve.Use(requestIDMiddleware())
+	serve.Use(recoverMiddleware(config.logger, config.SlowQueryThresholdInMilli, contract))
+	if config.Limiter != nil && config.Limiter.Enable {
+		serve.Use(limiterMiddleware(limiter.New(metric.TypeHTTP, config.Limiter)))
+	}
 	//
 	if !config.DisableMetric {
 		serve.Use(metricServerInterceptor())
@@ -1,8 +1,29 @@
 package ogoframe
 
 import (
+	"github.com/gogf/gf/net/ghttp"
+	"github.com/xqk/ox/pkg/server/oerror"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 var errMicroDefault = status.Errorf(codes.Internal, createStatusErr(codeMS, "micro default"))
+
+// contractKey 请求参数中错误响应约定的key
+const contractKey = "ox.error.contract"
+
+// WriteError 按错误响应约定写入错误并中止请求
+func WriteError(r *ghttp.Request, err error) {
+	contract, ok := r.GetParam(contractKey).(*oerror.Contract)
+	if !ok {
+		contract = oerror.Default
+	}
+	writeError(r, contract, err)
+	r.ExitAll()
+}
+
+func writeError(r *ghttp.Request, contract *oerror.Contract, err error) {
+	status, body := contract.Resolve(r.Request, err)
+	r.Response.WriteHeader(status)
+	_ = r.Response.WriteJson(body)
+}
@@ -4,18 +4,40 @@ import (
 	"github.com/gogf/gf/net/ghttp"
 	"go.uber.org/zap"
 	"net/http"
+	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/limiter"
 	"github.com/xqk/ox/pkg/metric"
 	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/requestid"
+	"github.com/xqk/ox/pkg/server"
+	"github.com/xqk/ox/pkg/server/oerror"
 	"github.com/xqk/ox/pkg/trace"
 	"time"
 )
 
-// recoverMiddleware ...
-func recoverMiddleware(logger *olog.Logger, slowQueryThresholdInMilli int64) ghttp.HandlerFunc {
+// requestIDMiddleware 从X-Request-ID中获取或生成请求id, 写入context并通过响应header返回
+func requestIDMiddleware() ghttp.HandlerFunc {
 	return func(r *ghttp.Request) {
+		id := requestid.FromHeader(r.Header)
+		r.Request = r.Request.WithContext(requestid.NewContext(r.Context(), id))
+		r.Response.Header().Set(requestid.HeaderRequestID, id)
+		r.Middleware.Next()
+	}
+}
+
+// recoverMiddleware 记录访问日志, goframe捕获的panic和通过WriteError以外返回的错误按错误响应约定写入
+func recoverMiddleware(logger *olog.Logger, slowQueryThresholdInMilli int64, contract *oerror.Contract) ghttp.HandlerFunc {
+	return func(r *ghttp.Request) {
+		var beg = time.Now()
+		r.SetParam(contractKey, contract)
 		defer func() {
-			var beg = time.Now()
 			var fields = make([]olog.Field, 0, 8)
+			// goframe捕获panic后写入了500和panic的内容
+			if err := r.GetError(); err != nil {
+				r.Response.ClearBuffer()
+				writeError(r, contract, oerror.ErrPanic)
+				fields = append(fields, zap.String("err", err.Error()))
+			}
 
 			fields = append(fields, zap.Float64("cost", time.Since(beg).Seconds()))
 
@@ -35,8 +57,12 @@ func recoverMiddleware(logger *olog.Logger, slowQueryThresholdInMilli int64) ght
 				zap.String("remote_addr", r.RemoteAddr),
 			)
 
+			logger := logger.WithContext(r.Context())
+			if r.GetError() != nil {
+				logger.Error("access", fields...)
+				return
+			}
 			logger.Info("access", fields...)
-			return
 		}()
 		r.Middleware.Next()
 	}
@@ -63,9 +89,28 @@ func traceServerInterceptor() ghttp.HandlerFunc {
 			trace.CustomTag("http.url", r.URL.Path),
 			trace.CustomTag("http.method", r.Method),
 			trace.CustomTag("peer.ipv4", r.GetClientIp()),
+			trace.CustomTag(requestid.TagRequestID, requestid.FromContext(r.Context())),
 		)
 		r.Request = r.WithContext(ctx)
 		defer span.Finish()
 		r.Middleware.Next()
 	}
 }
+
+// limiterMiddleware 自适应限流, 过载时返回503
+func limiterMiddleware(l *limiter.Limiter) ghttp.HandlerFunc {
+	return func(r *ghttp.Request) {
+		route := server.RouteNotFound
+		if r.Router != nil {
+			route = r.Router.Uri
+		}
+		done, ok := l.Allow(route)
+		if !ok {
+			r.Response.Header().Set("Retry-After", "1")
+			WriteError(r, ecode.Overloaded.Err())
+			return
+		}
+		defer done()
+		r.Middleware.Next()
+	}
+}
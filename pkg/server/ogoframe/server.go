@@ -22,10 +22,40 @@ func newServer(config *Config) *Server {
 
 	s.Server = serve
 	s.config = config
+	s.applyTransport()
 
 	return s
 }
 
+// applyTransport 设置超时和请求头限制, 为0时保留goframe的默认值. goframe不支持ReadHeaderTimeout
+func (s *Server) applyTransport() {
+	transport := s.config.Transport
+	if transport == nil {
+		return
+	}
+	if transport.ReadTimeout > 0 {
+		s.SetReadTimeout(transport.ReadTimeout)
+	}
+	if transport.WriteTimeout > 0 {
+		s.SetWriteTimeout(transport.WriteTimeout)
+	}
+	if transport.IdleTimeout > 0 {
+		s.SetIdleTimeout(transport.IdleTimeout)
+	}
+	if transport.MaxHeaderBytes > 0 {
+		s.SetMaxHeaderBytes(transport.MaxHeaderBytes)
+	}
+	if transport.H2C {
+		// goframe内部创建http.Server, prior knowledge方式的HTTP/2连接使用默认的请求头限制
+		err := s.SetConfigWithMap(map[string]interface{}{
+			"Handler": transport.H2CHandler(s.Server, nil),
+		})
+		if err != nil {
+			s.config.logger.Panic("set goframe h2c handler panic", olog.FieldErr(err))
+		}
+	}
+}
+
 //Serve ..
 func (s *Server) Serve() error {
 	routes := s.GetRouterArray()
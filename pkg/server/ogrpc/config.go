@@ -2,12 +2,20 @@ package ogrpc
 
 import (
 	"fmt"
+	"net"
+	"os"
 	"google.golang.org/grpc"
+	"github.com/xqk/ox/pkg/auth"
 	"github.com/xqk/ox/pkg/conf"
 	"github.com/xqk/ox/pkg/constant"
 	"github.com/xqk/ox/pkg/ecode"
 	"github.com/xqk/ox/pkg/flag"
+	"github.com/xqk/ox/pkg/limiter"
+	"github.com/xqk/ox/pkg/metric"
 	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/util/oaccess"
+	"github.com/xqk/ox/pkg/util/onet"
+	"github.com/xqk/ox/pkg/util/otls"
 )
 
 // Config ...
@@ -16,8 +24,11 @@ type Config struct {
 	Host       string `json:"host"`
 	Port       int    `json:"port"`
 	Deployment string `json:"deployment"`
-	// Network network type, tcp4 by default
+	// Network network type, tcp4 by default.
+	// 为unix时Host为socket文件路径, 以"@"开头时为abstract socket
 	Network string `json:"network" toml:"network"`
+	// SocketMode unix socket文件的权限, 为0时不修改
+	SocketMode os.FileMode `json:"socketMode" toml:"socketMode"`
 	// DisableTrace disbale Trace Interceptor, false by default
 	DisableTrace bool
 	// DisableMetric disable Metric Interceptor, false by default
@@ -29,11 +40,35 @@ type Config struct {
 
 	Labels map[string]string `json:"labels"`
 
+	// DisableHealth 不注册grpc.health.v1健康检查服务
+	DisableHealth bool `json:"disableHealth" toml:"disableHealth"`
+	// EnableReflection 注册reflection服务, 注册后可以使用grpcurl调试, 会暴露服务定义, 默认关闭
+	EnableReflection bool `json:"enableReflection" toml:"enableReflection"`
+	// EnableChannelz 注册channelz服务
+	EnableChannelz bool `json:"enableChannelz" toml:"enableChannelz"`
+
+	// Limiter 自适应限流配置, 默认关闭
+	Limiter *limiter.Config `json:"limiter" toml:"limiter"`
+
+	// Auth 认证配置, 默认关闭
+	Auth *auth.Config `json:"auth" toml:"auth"`
+
+	// Caller 调用方签名和ACL校验, 默认关闭, 通过StdConfig创建时支持热更新
+	Caller *auth.CallerConfig `json:"caller" toml:"caller"`
+
+	// Access 访问日志的级别、采样、截断和脱敏配置, 默认不记录请求和响应体
+	Access *oaccess.Config `json:"access" toml:"access"`
+
+	// TLS 开启TLS/mTLS, 为空时使用明文传输
+	TLS *otls.Config `json:"tls" toml:"tls"`
+
 	serverOptions      []grpc.ServerOption
 	streamInterceptors []grpc.StreamServerInterceptor
 	unaryInterceptors  []grpc.UnaryServerInterceptor
 
-	logger *olog.Logger
+	logger   *olog.Logger
+	key      string
+	listener net.Listener
 }
 
 // StdConfig represents Standard gRPC Server config
@@ -53,6 +88,7 @@ func RawConfig(key string) *Config {
 			olog.FieldValueAny(config),
 		)
 	}
+	config.key = key
 	return config
 }
 
@@ -67,6 +103,10 @@ func DefaultConfig() *Config {
 		DisableMetric:             false,
 		DisableTrace:              false,
 		SlowQueryThresholdInMilli: 500,
+		Access:                    defaultAccessConfig(),
+		Limiter:                   limiter.DefaultConfig(),
+		Auth:                      auth.DefaultConfig(),
+		Caller:                    auth.DefaultCallerConfig(),
 		logger:                    olog.OxLogger.With(olog.FieldMod("server.grpc")),
 		serverOptions:             []grpc.ServerOption{},
 		streamInterceptors:        []grpc.StreamServerInterceptor{},
@@ -74,6 +114,12 @@ func DefaultConfig() *Config {
 	}
 }
 
+func defaultAccessConfig() *oaccess.Config {
+	var access = oaccess.DefaultConfig()
+	access.DisablePayload = true
+	return access
+}
+
 // WithServerOption inject server option to grpc server
 // User should not inject interceptor option, which is recommend by WithStreamInterceptor
 // and WithUnaryInterceptor
@@ -113,6 +159,19 @@ func (config *Config) MustBuild() *Server {
 	return server
 }
 
+// WithListener serves on listener instead of listening on Address, such as the listener of omux
+func (config *Config) WithListener(listener net.Listener) *Config {
+	config.listener = listener
+	return config
+}
+
+func (config *Config) listen() (net.Listener, error) {
+	if config.listener != nil {
+		return config.listener, nil
+	}
+	return onet.Listen(config.Network, config.Address(), config.SocketMode)
+}
+
 // Build ...
 func (config *Config) Build() (*Server, error) {
 	if !config.DisableTrace {
@@ -136,5 +195,17 @@ func (config *Config) WithLogger(logger *olog.Logger) *Config {
 
 // Address ...
 func (config Config) Address() string {
+	if onet.IsUnixNetwork(config.Network) {
+		return config.Host
+	}
 	return fmt.Sprintf("%s:%d", config.Host, config.Port)
 }
+
+// callerGuard creates caller guard, which reloads when configuration changes if config is created by RawConfig
+func (config *Config) callerGuard() *auth.CallerGuard {
+	g := auth.NewCallerGuard(metric.TypeGRPCUnary, config.Caller)
+	if config.key != "" {
+		g.Watch(config.key+".caller", config.logger)
+	}
+	return g
+}
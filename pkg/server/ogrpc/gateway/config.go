@@ -0,0 +1,79 @@
+package gateway
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xqk/ox/pkg/conf"
+	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/server/oerror"
+	"github.com/xqk/ox/pkg/server/ogrpc"
+)
+
+// ModName ..
+const ModName = "server.grpc.gateway"
+
+// Config grpc服务HTTP/JSON转换配置
+type Config struct {
+	// Prefix 路由前缀, 如 /api
+	Prefix string `json:"prefix" toml:"prefix"`
+	// Services 需要转换的服务全名, 为空时转换所有服务, 健康检查和反射等grpc.开头的内置服务除外
+	Services []string `json:"services" toml:"services"`
+	// UseProtoNames json字段使用proto中的字段名, 默认使用lowerCamelCase
+	UseProtoNames bool `json:"useProtoNames" toml:"useProtoNames"`
+	// EmitUnpopulated 输出零值字段, 默认开启
+	EmitUnpopulated bool `json:"emitUnpopulated" toml:"emitUnpopulated"`
+	// DiscardUnknown 忽略请求中的未知字段, 默认开启
+	DiscardUnknown bool `json:"discardUnknown" toml:"discardUnknown"`
+	// MaxBodySize 请求体的最大字节数, 默认4MB, 与grpc服务默认的MaxRecvMsgSize一致
+	MaxBodySize int64 `json:"maxBodySize" toml:"maxBodySize"`
+	// Error 错误响应配置, 挂载到ogin或oecho时使用服务的配置
+	Error *oerror.Config `json:"error" toml:"error"`
+
+	logger *olog.Logger
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		EmitUnpopulated: true,
+		DiscardUnknown:  true,
+		MaxBodySize:     4 << 20,
+		Error:           oerror.DefaultConfig(),
+		logger:          olog.OxLogger.With(olog.FieldMod(ModName)),
+	}
+}
+
+// StdConfig 读取grpc服务配置下的gateway配置, 如 ox.server.grpc.gateway
+func StdConfig(name string) *Config {
+	return RawConfig("ox.server." + name + ".gateway")
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil &&
+		errors.Cause(err) != conf.ErrInvalidKey {
+		config.logger.Panic("grpc gateway parse config panic", olog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), olog.FieldErr(err), olog.FieldKey(key), olog.FieldValueAny(config))
+	}
+	return config
+}
+
+// WithLogger ...
+func (config *Config) WithLogger(logger *olog.Logger) *Config {
+	config.logger = logger
+	return config
+}
+
+// Build 为server上已注册的服务创建路由, 服务需要在Build之前注册
+func (config *Config) Build(server *ogrpc.Server) (*Gateway, error) {
+	return newGateway(config, server)
+}
+
+// MustBuild ...
+func (config *Config) MustBuild(server *ogrpc.Server) *Gateway {
+	gateway, err := config.Build(server)
+	if err != nil {
+		config.logger.Panic("build grpc gateway panic", olog.FieldErr(err))
+	}
+	return gateway
+}
@@ -0,0 +1,251 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/server/oerror"
+	"github.com/xqk/ox/pkg/server/ogrpc"
+	"github.com/xqk/ox/pkg/util/onet"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Route 转换后的HTTP路由, Path为ogin和oecho的路由格式
+type Route struct {
+	Method string
+	Path   string
+	// Pattern google.api.http中的路径模板
+	Pattern string
+	// FullMethod grpc方法全名, 如 /pkg.Service/Method
+	FullMethod string
+}
+
+type route struct {
+	Route
+	template     *template
+	method       protoreflect.MethodDescriptor
+	body         string
+	responseBody string
+}
+
+// Gateway 把ogrpc.Server上注册的服务转换为HTTP/JSON接口.
+// 有google.api.http注解的方法按注解转换, 否则转换为 POST /pkg.Service/Method, 请求体为整个请求消息.
+// 请求通过内存连接交给同一个grpc.Server处理, 服务端拦截器(认证, 限流, 监控, 链路等)全部生效.
+// 不支持客户端流和双向流, 服务端流按行输出json.
+type Gateway struct {
+	config    *Config
+	server    *ogrpc.Server
+	routes    []*route
+	contract  *oerror.Contract
+	marshal   protojson.MarshalOptions
+	unmarshal protojson.UnmarshalOptions
+
+	listener *onet.PipeListener
+	once     sync.Once
+	conn     *grpc.ClientConn
+	err      error
+}
+
+func newGateway(config *Config, server *ogrpc.Server) (*Gateway, error) {
+	g := &Gateway{
+		config:    config,
+		server:    server,
+		marshal:   protojson.MarshalOptions{UseProtoNames: config.UseProtoNames, EmitUnpopulated: config.EmitUnpopulated},
+		unmarshal: protojson.UnmarshalOptions{DiscardUnknown: config.DiscardUnknown},
+		listener:  onet.NewPipeListener(),
+		contract:  oerror.Default,
+	}
+	if config.Error != nil {
+		contract, err := config.Error.Build()
+		if err != nil {
+			return nil, err
+		}
+		g.contract = contract
+	}
+
+	var names []string
+	for name := range server.GetServiceInfo() {
+		if g.include(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+		if err != nil {
+			config.logger.Warn("grpc gateway skip service", olog.String("service", name), olog.FieldErr(err))
+			continue
+		}
+		sd, ok := desc.(protoreflect.ServiceDescriptor)
+		if !ok {
+			continue
+		}
+		methods := sd.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			if err := g.addMethod(methods.Get(i)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// 带verb的路由优先匹配
+	sort.SliceStable(g.routes, func(i, j int) bool {
+		return g.routes[i].template.verb != "" && g.routes[j].template.verb == ""
+	})
+	return g, nil
+}
+
+func (g *Gateway) include(service string) bool {
+	if len(g.config.Services) == 0 {
+		return !strings.HasPrefix(service, "grpc.")
+	}
+	for _, s := range g.config.Services {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Gateway) addMethod(md protoreflect.MethodDescriptor) error {
+	if md.IsStreamingClient() {
+		return nil
+	}
+	fullMethod := fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+	rule, _ := proto.GetExtension(md.Options(), annotations.E_Http).(*annotations.HttpRule)
+	if rule == nil || rule.GetPattern() == nil {
+		rule = &annotations.HttpRule{
+			Pattern: &annotations.HttpRule_Post{Post: fullMethod},
+			Body:    "*",
+		}
+	}
+
+	for _, r := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+		method, pattern := httpPattern(r)
+		if pattern == "" {
+			return fmt.Errorf("invalid http rule of %s", fullMethod)
+		}
+		t, err := parseTemplate(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid http rule of %s: %v", fullMethod, err)
+		}
+		g.routes = append(g.routes, &route{
+			Route: Route{
+				Method:     method,
+				Path:       g.config.Prefix + t.routePath(),
+				Pattern:    g.config.Prefix + pattern,
+				FullMethod: fullMethod,
+			},
+			template:     t,
+			method:       md,
+			body:         r.GetBody(),
+			responseBody: r.GetResponseBody(),
+		})
+	}
+	return nil
+}
+
+func httpPattern(rule *annotations.HttpRule) (string, string) {
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		return http.MethodGet, p.Get
+	case *annotations.HttpRule_Put:
+		return http.MethodPut, p.Put
+	case *annotations.HttpRule_Post:
+		return http.MethodPost, p.Post
+	case *annotations.HttpRule_Delete:
+		return http.MethodDelete, p.Delete
+	case *annotations.HttpRule_Patch:
+		return http.MethodPatch, p.Patch
+	case *annotations.HttpRule_Custom:
+		return p.Custom.GetKind(), p.Custom.GetPath()
+	}
+	return "", ""
+}
+
+// WithContract 使用指定的错误响应约定
+func (g *Gateway) WithContract(contract *oerror.Contract) *Gateway {
+	g.contract = contract
+	return g
+}
+
+// Routes 返回所有路由, 同一个Method和Path只返回一次
+func (g *Gateway) Routes() []Route {
+	var routes []Route
+	var seen = make(map[string]bool)
+	for _, r := range g.routes {
+		key := r.Method + " " + r.Path
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		routes = append(routes, r.Route)
+	}
+	return routes
+}
+
+// ServeHTTP implements http.Handler
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	if !strings.HasPrefix(path, g.config.Prefix) {
+		g.writeError(w, r, status.Error(codes.NotFound, "not found"))
+		return
+	}
+	path = strings.TrimPrefix(path, g.config.Prefix)
+	for _, rt := range g.routes {
+		if rt.Method != r.Method {
+			continue
+		}
+		if vars, ok := rt.template.match(path); ok {
+			g.serve(w, r, rt, vars)
+			return
+		}
+	}
+	g.writeError(w, r, status.Error(codes.NotFound, "not found"))
+}
+
+// dial 在第一次请求时启动内存连接上的服务, 此时所有服务都已注册
+func (g *Gateway) dial() (*grpc.ClientConn, error) {
+	g.once.Do(func() {
+		go func() {
+			_ = g.server.Server.Serve(g.listener)
+		}()
+		// 内存连接不经过网络, 服务端对内存连接不使用TLS
+		g.conn, g.err = grpc.DialContext(context.Background(), "pipe",
+			grpc.WithInsecure(),
+			grpc.WithContextDialer(g.listener.DialContext),
+		)
+	})
+	return g.conn, g.err
+}
+
+// Close closes the in-memory connection
+func (g *Gateway) Close() error {
+	_ = g.listener.Close()
+	if g.conn != nil {
+		return g.conn.Close()
+	}
+	return nil
+}
+
+// newMessage 优先使用生成的类型, grpc的编解码只支持protov1的消息
+func newMessage(md protoreflect.MessageDescriptor) protov1.Message {
+	if mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName()); err == nil {
+		return protov1.MessageV1(mt.New().Interface())
+	}
+	return protov1.MessageV1(dynamicpb.NewMessage(md))
+}
@@ -0,0 +1,370 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/auth"
+	"github.com/xqk/ox/pkg/deadline"
+	"github.com/xqk/ox/pkg/requestid"
+	"github.com/xqk/ox/pkg/server/ogrpc"
+	"github.com/xqk/ox/pkg/server/oopenapi"
+	"github.com/xqk/ox/pkg/util/otest/proto/testproto"
+	"github.com/xqk/ox/pkg/util/otest/server/yell"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var userDesc = registerUserService()
+
+// registerUserService 注册带google.api.http注解的测试服务描述
+func registerUserService() protoreflect.MessageDescriptor {
+	optional := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	repeated := descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	field := func(name string, number int32, label *descriptorpb.FieldDescriptorProto_Label, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		fd := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    label,
+			Type:     typ.Enum(),
+		}
+		if typeName != "" {
+			fd.TypeName = proto.String(typeName)
+		}
+		return fd
+	}
+	method := func(name string, rule *annotations.HttpRule, serverStreaming bool) *descriptorpb.MethodDescriptorProto {
+		md := &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(name),
+			InputType:       proto.String(".ox.gateway.test.User"),
+			OutputType:      proto.String(".ox.gateway.test.User"),
+			ServerStreaming: proto.Bool(serverStreaming),
+		}
+		if rule != nil {
+			md.Options = &descriptorpb.MethodOptions{}
+			proto.SetExtension(md.Options, annotations.E_Http, rule)
+		}
+		return md
+	}
+
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("ox/gateway/test/user.proto"),
+		Package: proto.String("ox.gateway.test"),
+		Syntax:  proto.String("proto3"),
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Status"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+				{Name: proto.String("ACTIVE"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Profile"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("age", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+					field("city", 2, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				},
+			},
+			{
+				Name: proto.String("User"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("id", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+					field("name", 2, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("tags", 3, repeated, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("profile", 4, optional, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".ox.gateway.test.Profile"),
+					field("status", 5, optional, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".ox.gateway.test.Status"),
+				},
+			},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Users"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("Get", &annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: "/v1/users/{id}"}}, false),
+				method("UpdateProfile", &annotations.HttpRule{
+					Pattern:      &annotations.HttpRule_Patch{Patch: "/v1/users/{id}/profile"},
+					Body:         "profile",
+					ResponseBody: "profile",
+				}, false),
+				method("Search", &annotations.HttpRule{
+					Pattern: &annotations.HttpRule_Post{Post: "/v1/users:search"},
+					Body:    "*",
+					AdditionalBindings: []*annotations.HttpRule{
+						{Pattern: &annotations.HttpRule_Get{Get: "/v1/{name=groups/*/users}"}},
+					},
+				}, false),
+				method("Fail", nil, false),
+				method("List", &annotations.HttpRule{Pattern: &annotations.HttpRule_Get{Get: "/v1/users"}}, true),
+			},
+		}},
+	}
+	fd, err := protodesc.NewFile(fdp, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	if err := protoregistry.GlobalFiles.RegisterFile(fd); err != nil {
+		panic(err)
+	}
+	return fd.Messages().ByName("User")
+}
+
+func unaryHandler(fn func(ctx context.Context, in *dynamicpb.Message) (*dynamicpb.Message, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := dynamicpb.NewMessage(userDesc)
+		if err := dec(protov1.MessageV1(in)); err != nil {
+			return nil, err
+		}
+		out, err := fn(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		return protov1.MessageV1(out), nil
+	}
+}
+
+var usersServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ox.gateway.test.Users",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Get", Handler: unaryHandler(func(ctx context.Context, in *dynamicpb.Message) (*dynamicpb.Message, error) {
+			md, _ := metadata.FromIncomingContext(ctx)
+			if tenant := md.Get("x-tenant"); len(tenant) > 0 {
+				in.Set(userDesc.Fields().ByName("name"), protoreflect.ValueOfString(tenant[0]))
+			}
+			_ = grpc.SetHeader(ctx, metadata.Pairs("x-served-by", "users"))
+			for _, key := range []string{"aid", "aid-sign", "x-request-id", "x-ox-timeout"} {
+				if vals := md.Get(key); len(vals) > 0 {
+					_ = grpc.SetHeader(ctx, metadata.Pairs("x-echo-"+key, strings.Join(vals, ",")))
+				}
+			}
+			return in, nil
+		})},
+		{MethodName: "UpdateProfile", Handler: unaryHandler(func(ctx context.Context, in *dynamicpb.Message) (*dynamicpb.Message, error) {
+			return in, nil
+		})},
+		{MethodName: "Search", Handler: unaryHandler(func(ctx context.Context, in *dynamicpb.Message) (*dynamicpb.Message, error) {
+			return in, nil
+		})},
+		{MethodName: "Fail", Handler: unaryHandler(func(ctx context.Context, in *dynamicpb.Message) (*dynamicpb.Message, error) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		})},
+	},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "List",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			in := dynamicpb.NewMessage(userDesc)
+			if err := stream.RecvMsg(protov1.MessageV1(in)); err != nil {
+				return err
+			}
+			for i := int64(1); i <= 3; i++ {
+				out := dynamicpb.NewMessage(userDesc)
+				out.Set(userDesc.Fields().ByName("id"), protoreflect.ValueOfInt64(i))
+				if err := stream.SendMsg(protov1.MessageV1(out)); err != nil {
+					return err
+				}
+			}
+			return status.Error(codes.Aborted, "list aborted")
+		},
+	}},
+}
+
+func newTestGateway(t *testing.T, config *Config) *Gateway {
+	grpcConfig := ogrpc.DefaultConfig()
+	grpcConfig.Host = "127.0.0.1"
+	grpcConfig.Port = 0
+	server, err := grpcConfig.Build()
+	assert.Nil(t, err)
+	server.RegisterService(&usersServiceDesc, nil)
+	testproto.RegisterGreeterServer(server.Server, &yell.FooServer{})
+	t.Cleanup(func() { server.Stop() })
+
+	g, err := config.Build(server)
+	assert.Nil(t, err)
+	t.Cleanup(func() { g.Close() })
+	return g
+}
+
+func do(g http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("X-Tenant", "ox")
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, r)
+	return w
+}
+
+func TestGateway(t *testing.T) {
+	g := newTestGateway(t, DefaultConfig())
+
+	var routes []string
+	for _, r := range g.Routes() {
+		routes = append(routes, r.Method+" "+r.Path)
+	}
+	assert.ElementsMatch(t, []string{
+		"POST /v1/:p1",
+		"GET /v1/users/:p2",
+		"PATCH /v1/users/:p2/profile",
+		"GET /v1/groups/:p2/users",
+		"POST /ox.gateway.test.Users/Fail",
+		"GET /v1/users",
+		"POST /testproto.Greeter/SayHello",
+		"POST /testproto.Greeter/WhoServer",
+	}, routes)
+
+	// 路径变量, 查询参数和转发的请求头
+	w := do(g, http.MethodGet, "/v1/users/7?tags=a&tags=b&profile.age=18&status=ACTIVE&id=8&unknown=1", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "users", w.Header().Get("Grpc-Metadata-X-Served-By"))
+	assert.JSONEq(t, `{"id":"7","name":"ox","tags":["a","b"],"profile":{"age":18,"city":""},"status":"ACTIVE"}`, w.Body.String())
+
+	// 请求体绑定到字段, 只返回指定字段
+	w = do(g, http.MethodPatch, "/v1/users/7/profile", `{"city":"sz"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"age":0,"city":"sz"}`, w.Body.String())
+
+	// 自定义verb和附加绑定
+	w = do(g, http.MethodPost, "/v1/users:search", `{"name":"x","tags":["t"]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id":"0","name":"x","tags":["t"],"profile":null,"status":"UNKNOWN"}`, w.Body.String())
+	w = do(g, http.MethodGet, "/v1/groups/g1/users", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"name":"groups/g1/users"`)
+
+	// 没有注解的方法
+	w = do(g, http.MethodPost, "/testproto.Greeter/SayHello", `{"name":"ox"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"message":"`+yell.RespFantasy.Message+`"`)
+
+	// 错误码转换
+	w = do(g, http.MethodPost, "/ox.gateway.test.Users/Fail", `{}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"code":5,"message":"user not found"}`, w.Body.String())
+	w = do(g, http.MethodGet, "/v1/users/abc", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = do(g, http.MethodPost, "/v1/users:search", `{"name":`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = do(g, http.MethodDelete, "/v1/users/7", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGateway_Metadata(t *testing.T) {
+	g := newTestGateway(t, DefaultConfig())
+
+	// 外部请求头不能伪造服务内部的metadata
+	r := httptest.NewRequest(http.MethodGet, "/v1/users/7", nil)
+	r.Header.Set(auth.HeaderAID, "admin")
+	r.Header.Set(auth.HeaderAIDSign, "forged")
+	r.Header.Set(requestid.HeaderRequestID, "spoofed")
+	r.Header.Set(deadline.HeaderTimeout, "3600000")
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	for _, key := range []string{"Aid", "Aid-Sign", "X-Request-Id", "X-Ox-Timeout"} {
+		assert.Empty(t, w.Header().Get("Grpc-Metadata-X-Echo-"+key), key)
+	}
+
+	// 由HTTP服务的context重新生成
+	ctx := auth.NewCallerContext(context.Background(), "app1")
+	ctx = requestid.NewContext(ctx, "abc")
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, r.WithContext(ctx))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "app1", w.Header().Get("Grpc-Metadata-X-Echo-Aid"))
+	assert.Equal(t, "abc", w.Header().Get("Grpc-Metadata-X-Echo-X-Request-Id"))
+	timeout, err := strconv.Atoi(w.Header().Get("Grpc-Metadata-X-Echo-X-Ox-Timeout"))
+	assert.Nil(t, err)
+	assert.True(t, timeout > 0 && timeout <= 60000, timeout)
+}
+
+func TestGateway_MaxBodySize(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxBodySize = 16
+	g := newTestGateway(t, config)
+
+	w := do(g, http.MethodPost, "/v1/users:search", `{"name":"x"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = do(g, http.MethodPost, "/v1/users:search", `{"name":"`+strings.Repeat("x", 16)+`"}`)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":8`)
+}
+
+func TestGatewayServerStream(t *testing.T) {
+	config := DefaultConfig()
+	config.Prefix = "/api"
+	config.EmitUnpopulated = false
+	config.Services = []string{"ox.gateway.test.Users"}
+	g := newTestGateway(t, config)
+	for _, r := range g.Routes() {
+		assert.True(t, strings.HasPrefix(r.Path, "/api/"), r.Path)
+	}
+
+	w := do(g, http.MethodGet, "/api/v1/users", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, contentTypeNDJSON, w.Header().Get("Content-Type"))
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	assert.Equal(t, []map[string]interface{}{
+		{"id": "1"}, {"id": "2"}, {"id": "3"},
+		{"code": float64(codes.Aborted), "message": "list aborted"},
+	}, lines)
+
+	w = do(g, http.MethodGet, "/v1/users", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGateway_API(t *testing.T) {
+	g := newTestGateway(t, DefaultConfig())
+
+	spec := oopenapi.NewSpec()
+	var routes []oopenapi.Route
+	for _, r := range g.Routes() {
+		spec.Add(r.Method, r.Path, g.API(r))
+		routes = append(routes, oopenapi.Route{Method: r.Method, Path: r.Path})
+	}
+	doc := spec.Document(oopenapi.Info{Title: "test"}, routes)
+
+	// 路径使用注解中的模板, 查询参数为非message字段
+	get := doc.Paths["/v1/users/{id}"]["get"]
+	assert.Equal(t, "ox.gateway.test.Users/Get", get.Summary)
+	var params []string
+	for _, p := range get.Parameters {
+		params = append(params, p.In+":"+p.Name)
+	}
+	assert.Equal(t, []string{"path:id", "query:name", "query:tags", "query:status"}, params)
+	assert.Equal(t, "#/components/schemas/ox.gateway.test.User", get.Responses["200"].Content["application/json"].Schema.Ref)
+
+	// 请求体和响应为profile字段
+	patch := doc.Paths["/v1/users/{id}/profile"]["patch"]
+	assert.Equal(t, "#/components/schemas/ox.gateway.test.Profile", patch.RequestBody.Content["application/json"].Schema.Ref)
+	assert.Equal(t, "#/components/schemas/ox.gateway.test.Profile", patch.Responses["200"].Content["application/json"].Schema.Ref)
+
+	assert.NotNil(t, doc.Paths["/v1/users:search"]["post"].RequestBody)
+	assert.Contains(t, doc.Paths, "/v1/{name}")
+	assert.Equal(t, &oopenapi.Schema{Type: "string", Format: "int64"}, doc.Components.Schemas["ox.gateway.test.User"].Properties["id"])
+}
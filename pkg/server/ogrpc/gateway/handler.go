@@ -0,0 +1,292 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/xqk/ox/pkg/auth"
+	"github.com/xqk/ox/pkg/deadline"
+	"github.com/xqk/ox/pkg/requestid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	// MetadataHeaderPrefix grpc响应头在HTTP响应头中的前缀
+	MetadataHeaderPrefix = "Grpc-Metadata-"
+	contentTypeJSON      = "application/json"
+	contentTypeNDJSON    = "application/x-ndjson"
+)
+
+// internalMetadata 服务之间使用的metadata, 不转发外部调用方的请求头, 由服务端的context重新生成
+var internalMetadata = map[string]bool{
+	strings.ToLower(auth.HeaderAID):          true,
+	strings.ToLower(auth.HeaderAIDTimestamp): true,
+	strings.ToLower(auth.HeaderAIDSign):      true,
+	deadline.MetadataTimeout:                 true,
+	deadline.MetadataHops:                    true,
+	requestid.MetadataRequestID:              true,
+}
+
+// skipHeaders 不转发到grpc的HTTP请求头
+var skipHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+	"te":                true,
+	"trailer":           true,
+	"host":              true,
+	"content-length":    true,
+	"content-type":      true,
+	"accept-encoding":   true,
+}
+
+func (g *Gateway) serve(w http.ResponseWriter, r *http.Request, rt *route, vars map[string]string) {
+	req := newMessage(rt.method.Input())
+	if err := g.decode(r, rt, vars, protov1.MessageV2(req).ProtoReflect()); err != nil {
+		if _, ok := status.FromError(err); !ok {
+			err = status.Error(codes.InvalidArgument, err.Error())
+		}
+		g.writeError(w, r, err)
+		return
+	}
+	conn, err := g.dial()
+	if err != nil {
+		g.writeError(w, r, status.Error(codes.Unavailable, err.Error()))
+		return
+	}
+
+	ctx := metadata.NewOutgoingContext(r.Context(), outgoingMetadata(r))
+	if rt.method.IsStreamingServer() {
+		g.serveStream(ctx, w, r, conn, rt, req)
+		return
+	}
+
+	var header metadata.MD
+	resp := newMessage(rt.method.Output())
+	err = conn.Invoke(ctx, rt.FullMethod, req, resp, grpc.Header(&header))
+	writeMetadata(w, header)
+	if err != nil {
+		g.writeError(w, r, err)
+		return
+	}
+	bs, err := g.encode(resp, rt.responseBody)
+	if err != nil {
+		g.writeError(w, r, status.Error(codes.Internal, err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(bs)
+}
+
+// serveStream 服务端流每条消息输出一行json, 出错时最后一行为错误信息
+func (g *Gateway) serveStream(ctx context.Context, w http.ResponseWriter, r *http.Request, conn *grpc.ClientConn, rt *route, req protov1.Message) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, rt.FullMethod)
+	if err != nil {
+		g.writeError(w, r, err)
+		return
+	}
+	// SendMsg失败时由RecvMsg返回真正的错误
+	if err := stream.SendMsg(req); err == nil {
+		_ = stream.CloseSend()
+	}
+	header, _ := stream.Header()
+	writeMetadata(w, header)
+
+	flusher, _ := w.(http.Flusher)
+	var wrote bool
+	for {
+		resp := newMessage(rt.method.Output())
+		err := stream.RecvMsg(resp)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			if !wrote {
+				g.writeError(w, r, err)
+				return
+			}
+			_, _ = w.Write(g.errorBody(r, err))
+			return
+		}
+		bs, err := g.encode(resp, rt.responseBody)
+		if err != nil {
+			bs = g.errorBody(r, status.Error(codes.Internal, err.Error()))
+		}
+		if !wrote {
+			w.Header().Set("Content-Type", contentTypeNDJSON)
+			w.WriteHeader(http.StatusOK)
+			wrote = true
+		}
+		_, _ = w.Write(append(bs, '\n'))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// decode 依次从请求体, 路径变量和查询参数解析请求消息
+func (g *Gateway) decode(r *http.Request, rt *route, vars map[string]string, msg protoreflect.Message) error {
+	var bound []string
+	if rt.body != "" {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, g.config.MaxBodySize+1))
+		if err != nil {
+			return err
+		}
+		if int64(len(body)) > g.config.MaxBodySize {
+			return status.Errorf(codes.ResourceExhausted, "request body larger than %d bytes", g.config.MaxBodySize)
+		}
+		if len(body) > 0 {
+			if rt.body != "*" {
+				fd := findField(msg.Descriptor(), rt.body)
+				if fd == nil {
+					return fmt.Errorf("body field %s not found in %s", rt.body, msg.Descriptor().FullName())
+				}
+				body = []byte(fmt.Sprintf(`{%q:%s}`, fd.JSONName(), body))
+			}
+			if err := g.unmarshal.Unmarshal(body, msg.Interface()); err != nil {
+				return fmt.Errorf("invalid body: %v", err)
+			}
+		}
+		bound = append(bound, rt.body)
+	}
+
+	for field, value := range vars {
+		if err := setField(msg, field, value); err != nil {
+			return err
+		}
+		bound = append(bound, field)
+	}
+
+	// 请求体为整个消息时忽略查询参数
+	if rt.body == "*" {
+		return nil
+	}
+	for key, values := range r.URL.Query() {
+		if isBound(key, bound) {
+			continue
+		}
+		for _, value := range values {
+			if err := setField(msg, key, value); err != nil {
+				if errors.Is(err, errUnknownField) {
+					break
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isBound(key string, bound []string) bool {
+	for _, b := range bound {
+		if key == b || strings.HasPrefix(key, b+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// encode 使用protojson编码响应, responseBody不为空时只输出该字段
+func (g *Gateway) encode(msg protov1.Message, responseBody string) ([]byte, error) {
+	m := protov1.MessageV2(msg)
+	bs, err := g.marshal.Marshal(m)
+	if err != nil || responseBody == "" {
+		return bs, err
+	}
+
+	fd := findField(m.ProtoReflect().Descriptor(), responseBody)
+	if fd == nil {
+		return nil, fmt.Errorf("response body field %s not found in %s", responseBody, m.ProtoReflect().Descriptor().FullName())
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(bs, &fields); err != nil {
+		return nil, err
+	}
+	name := fd.JSONName()
+	if g.config.UseProtoNames {
+		name = string(fd.Name())
+	}
+	if v, ok := fields[name]; ok {
+		return v, nil
+	}
+	return []byte("null"), nil
+}
+
+// errorBody 服务端流中途出错时, 最后一行为错误响应
+func (g *Gateway) errorBody(r *http.Request, err error) []byte {
+	_, body := g.contract.Resolve(r, err)
+	bs, _ := json.Marshal(body)
+	return bs
+}
+
+// writeError 按错误响应约定写入错误, 错误码通过ecode转换为HTTP状态码
+func (g *Gateway) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	g.contract.Write(w, r, err)
+}
+
+// outgoingMetadata 把HTTP请求头转发为grpc metadata, 认证和调用方签名等请求头在grpc服务端同样生效
+func outgoingMetadata(r *http.Request) metadata.MD {
+	md := metadata.MD{}
+	for key, values := range r.Header {
+		key = strings.ToLower(key)
+		if skipHeaders[key] || internalMetadata[key] || strings.HasPrefix(key, "grpc-") {
+			continue
+		}
+		md.Append(key, values...)
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+			host = prior + ", " + host
+		}
+		md.Set("x-forwarded-for", host)
+	}
+	md.Set("x-forwarded-host", r.Host)
+
+	ctx := r.Context()
+	// http服务生成的请求id只在context中
+	if id := requestid.FromContext(ctx); id != "" {
+		md.Set(requestid.MetadataRequestID, id)
+	}
+	// HTTP服务校验过的调用方
+	if aid, ok := auth.CallerFromContext(ctx); ok {
+		md.Set(strings.ToLower(auth.HeaderAID), aid)
+	}
+	// HTTP服务按时间预算派生的deadline
+	if remaining, ok := deadline.Remaining(ctx); ok {
+		if remaining < 0 {
+			remaining = 0
+		}
+		md.Set(deadline.MetadataTimeout, strconv.FormatInt(int64(remaining/time.Millisecond), 10))
+		if hops := deadline.Hops(ctx); len(hops) > 0 {
+			md.Set(deadline.MetadataHops, strings.Join(hops, ","))
+		}
+	}
+	return md
+}
+
+func writeMetadata(w http.ResponseWriter, md metadata.MD) {
+	for key, values := range md {
+		for _, value := range values {
+			w.Header().Add(MetadataHeaderPrefix+key, value)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/xqk/ox/pkg/server/oopenapi"
+)
+
+// API 返回路由的openapi说明, 请求和响应的字段与google.api.http注解一致
+func (g *Gateway) API(r Route) *oopenapi.API {
+	for _, rt := range g.routes {
+		if rt.Route == r {
+			return g.api(rt)
+		}
+	}
+	return nil
+}
+
+func (g *Gateway) api(rt *route) *oopenapi.API {
+	md := rt.method
+	api := &oopenapi.API{
+		Summary:    strings.TrimPrefix(rt.FullMethod, "/"),
+		Tags:       []string{string(md.Parent().FullName())},
+		Path:       rt.Pattern,
+		ProtoNames: g.config.UseProtoNames,
+	}
+	api.Description = strings.TrimSpace(md.ParentFile().SourceLocations().ByDescriptor(md).LeadingComments)
+	if md.IsStreamingServer() {
+		api.Description = strings.TrimSpace(api.Description + "\n\n服务端流, 每行一个json响应")
+	}
+
+	switch rt.body {
+	case "*":
+		api.Request = md.Input()
+	case "":
+		api.Query = md.Input()
+	default:
+		api.Query = md.Input()
+		if fd := findField(md.Input(), rt.body); fd != nil {
+			api.Request = oopenapi.ProtoField(fd)
+		}
+	}
+
+	api.Response = md.Output()
+	if rt.responseBody != "" {
+		if fd := findField(md.Output(), rt.responseBody); fd != nil {
+			api.Response = oopenapi.ProtoField(fd)
+		}
+	}
+	return api
+}
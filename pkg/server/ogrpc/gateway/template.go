@@ -0,0 +1,221 @@
+package gateway
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type segmentKind int
+
+const (
+	segmentLiteral segmentKind = iota
+	// segmentSingle 匹配一段路径, 即 *
+	segmentSingle
+	// segmentDeep 匹配剩余的任意段路径, 即 **
+	segmentDeep
+)
+
+type segment struct {
+	kind    segmentKind
+	literal string
+}
+
+// variable 绑定到字段的路径变量, 对应segments[start:end], end为-1时匹配到路径末尾
+type variable struct {
+	field string
+	start int
+	end   int
+}
+
+// template google.api.http的路径模板:
+//
+//	Template = "/" Segments [ Verb ] ;
+//	Segments = Segment { "/" Segment } ;
+//	Segment  = "*" | "**" | LITERAL | Variable ;
+//	Variable = "{" FieldPath [ "=" Segments ] "}" ;
+//	Verb     = ":" LITERAL ;
+type template struct {
+	raw       string
+	segments  []segment
+	variables []variable
+	verb      string
+}
+
+func parseTemplate(raw string) (*template, error) {
+	if !strings.HasPrefix(raw, "/") {
+		return nil, fmt.Errorf("invalid path template %q: must start with /", raw)
+	}
+	p := &templateParser{input: raw[1:], t: &template{raw: raw}}
+	if err := p.parse(); err != nil {
+		return nil, fmt.Errorf("invalid path template %q: %v", raw, err)
+	}
+	return p.t, nil
+}
+
+type templateParser struct {
+	input string
+	pos   int
+	t     *template
+}
+
+func (p *templateParser) parse() error {
+	if err := p.parseSegments(false); err != nil {
+		return err
+	}
+	if p.consume(':') {
+		p.t.verb = p.parseLiteral()
+		if p.t.verb == "" {
+			return fmt.Errorf("empty verb")
+		}
+	}
+	if p.pos != len(p.input) {
+		return fmt.Errorf("unexpected %q at %d", p.input[p.pos], p.pos+1)
+	}
+	for i, seg := range p.t.segments {
+		if seg.kind == segmentDeep && i != len(p.t.segments)-1 {
+			return fmt.Errorf("** must be the last segment")
+		}
+	}
+	return nil
+}
+
+func (p *templateParser) parseSegments(inVariable bool) error {
+	for {
+		if err := p.parseSegment(inVariable); err != nil {
+			return err
+		}
+		if !p.consume('/') {
+			return nil
+		}
+	}
+}
+
+func (p *templateParser) parseSegment(inVariable bool) error {
+	switch {
+	case strings.HasPrefix(p.input[p.pos:], "**"):
+		p.pos += 2
+		p.t.segments = append(p.t.segments, segment{kind: segmentDeep})
+	case p.consume('*'):
+		p.t.segments = append(p.t.segments, segment{kind: segmentSingle})
+	case p.consume('{'):
+		if inVariable {
+			return fmt.Errorf("nested variable")
+		}
+		return p.parseVariable()
+	default:
+		literal := p.parseLiteral()
+		if literal == "" {
+			return fmt.Errorf("empty segment at %d", p.pos+1)
+		}
+		p.t.segments = append(p.t.segments, segment{kind: segmentLiteral, literal: literal})
+	}
+	return nil
+}
+
+func (p *templateParser) parseVariable() error {
+	end := strings.IndexAny(p.input[p.pos:], "=}")
+	if end <= 0 {
+		return fmt.Errorf("invalid variable at %d", p.pos+1)
+	}
+	v := variable{field: p.input[p.pos : p.pos+end], start: len(p.t.segments)}
+	p.pos += end
+	if p.consume('=') {
+		if err := p.parseSegments(true); err != nil {
+			return err
+		}
+	} else {
+		p.t.segments = append(p.t.segments, segment{kind: segmentSingle})
+	}
+	if !p.consume('}') {
+		return fmt.Errorf("unclosed variable %s", v.field)
+	}
+	v.end = len(p.t.segments)
+	if p.t.segments[v.end-1].kind == segmentDeep {
+		v.end = -1
+	}
+	p.t.variables = append(p.t.variables, v)
+	return nil
+}
+
+func (p *templateParser) parseLiteral() string {
+	start := p.pos
+	for p.pos < len(p.input) && !strings.ContainsRune("/:{}*=", rune(p.input[p.pos])) {
+		p.pos++
+	}
+	return p.input[start:p.pos]
+}
+
+func (p *templateParser) consume(c byte) bool {
+	if p.pos < len(p.input) && p.input[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// match 匹配转义后的请求路径, 返回路径变量的值
+func (t *template) match(path string) (map[string]string, bool) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, false
+	}
+	path = path[1:]
+	if t.verb != "" {
+		if !strings.HasSuffix(path, ":"+t.verb) {
+			return nil, false
+		}
+		path = strings.TrimSuffix(path, ":"+t.verb)
+	}
+
+	parts := strings.Split(path, "/")
+	for i := range parts {
+		part, err := url.PathUnescape(parts[i])
+		if err != nil {
+			return nil, false
+		}
+		parts[i] = part
+	}
+	deep := t.segments[len(t.segments)-1].kind == segmentDeep
+	if (deep && len(parts) < len(t.segments)-1) || (!deep && len(parts) != len(t.segments)) {
+		return nil, false
+	}
+	for i, seg := range t.segments {
+		switch seg.kind {
+		case segmentLiteral:
+			if parts[i] != seg.literal {
+				return nil, false
+			}
+		case segmentSingle:
+			if parts[i] == "" {
+				return nil, false
+			}
+		}
+	}
+
+	vars := make(map[string]string, len(t.variables))
+	for _, v := range t.variables {
+		end := v.end
+		if end < 0 || end > len(parts) {
+			end = len(parts)
+		}
+		vars[v.field] = strings.Join(parts[v.start:end], "/")
+	}
+	return vars, true
+}
+
+// routePath 返回ogin和oecho使用的路由, 变量和带verb的最后一段转换为路由参数, 由match做最终的匹配
+func (t *template) routePath() string {
+	var b strings.Builder
+	for i, seg := range t.segments {
+		b.WriteByte('/')
+		switch {
+		case seg.kind == segmentDeep:
+			fmt.Fprintf(&b, "*p%d", i)
+		case seg.kind == segmentSingle, t.verb != "" && i == len(t.segments)-1:
+			fmt.Fprintf(&b, ":p%d", i)
+		default:
+			b.WriteString(seg.literal)
+		}
+	}
+	return b.String()
+}
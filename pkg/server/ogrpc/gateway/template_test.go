@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+		vars     map[string]string
+		ok       bool
+		route    string
+	}{
+		{"/v1/users/{id}", "/v1/users/1", map[string]string{"id": "1"}, true, "/v1/users/:p2"},
+		{"/v1/users/{id}", "/v1/users/a%2Fb", map[string]string{"id": "a/b"}, true, "/v1/users/:p2"},
+		{"/v1/users/{id}", "/v1/users/", nil, false, "/v1/users/:p2"},
+		{"/v1/users/{id}", "/v1/users/1/x", nil, false, "/v1/users/:p2"},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/books/2", map[string]string{"name": "shelves/1/books/2"}, true, "/v1/shelves/:p2/books/:p4"},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/notes/2", nil, false, "/v1/shelves/:p2/books/:p4"},
+		{"/v1/{name=files/**}", "/v1/files/a/b/c", map[string]string{"name": "files/a/b/c"}, true, "/v1/files/*p2"},
+		{"/v1/users:search", "/v1/users:search", map[string]string{}, true, "/v1/:p1"},
+		{"/v1/users:search", "/v1/users", nil, false, "/v1/:p1"},
+		{"/v1/users/{user.id}:cancel", "/v1/users/1:cancel", map[string]string{"user.id": "1"}, true, "/v1/users/:p2"},
+		{"/pkg.Service/Method", "/pkg.Service/Method", map[string]string{}, true, "/pkg.Service/Method"},
+		{"/v1/*/items", "/v1/x/items", map[string]string{}, true, "/v1/:p1/items"},
+	}
+	for _, tt := range tests {
+		tmpl, err := parseTemplate(tt.template)
+		assert.Nil(t, err, tt.template)
+		vars, ok := tmpl.match(tt.path)
+		assert.Equal(t, tt.ok, ok, tt.template+" "+tt.path)
+		if tt.ok {
+			assert.Equal(t, tt.vars, vars, tt.template+" "+tt.path)
+		}
+		assert.Equal(t, tt.route, tmpl.routePath(), tt.template)
+	}
+
+	for _, invalid := range []string{"v1/users", "/v1/{id", "/v1/**/x", "/v1/{a={b}}", "/v1//x", "/v1/x:"} {
+		_, err := parseTemplate(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
@@ -0,0 +1,89 @@
+package grpcweb
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xqk/ox/pkg/conf"
+	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/server/ogrpc"
+)
+
+// ModName ..
+const ModName = "server.grpc.grpcweb"
+
+// Config gRPC-Web配置
+type Config struct {
+	// Prefix 路由前缀, 如 /grpc, 请求路径为 Prefix/pkg.Service/Method
+	Prefix string `json:"prefix" toml:"prefix"`
+	// Services 需要暴露的服务全名, 为空时暴露所有服务, 健康检查和反射等grpc.开头的内置服务除外
+	Services []string `json:"services" toml:"services"`
+	// CORS 跨域配置, 浏览器直接调用时需要开启
+	CORS *CORSConfig `json:"cors" toml:"cors"`
+
+	logger *olog.Logger
+}
+
+// CORSConfig 跨域配置
+type CORSConfig struct {
+	Enable bool `json:"enable" toml:"enable"`
+	// AllowOrigins 允许的来源, 默认为空, 包含*时允许所有来源, 不能与AllowCredentials同时使用
+	AllowOrigins []string `json:"allowOrigins" toml:"allowOrigins"`
+	// AllowHeaders 额外允许的请求头, grpc-web客户端使用的请求头默认允许
+	AllowHeaders []string `json:"allowHeaders" toml:"allowHeaders"`
+	// ExposeHeaders 额外暴露的响应头, grpc-status等默认暴露
+	ExposeHeaders    []string      `json:"exposeHeaders" toml:"exposeHeaders"`
+	AllowCredentials bool          `json:"allowCredentials" toml:"allowCredentials"`
+	MaxAge           time.Duration `json:"maxAge" toml:"maxAge"`
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		CORS:   DefaultCORSConfig(),
+		logger: olog.OxLogger.With(olog.FieldMod(ModName)),
+	}
+}
+
+// DefaultCORSConfig 默认关闭, 开启时需要配置允许的来源
+func DefaultCORSConfig() *CORSConfig {
+	return &CORSConfig{
+		MaxAge: 10 * time.Minute,
+	}
+}
+
+// StdConfig 读取grpc服务配置下的grpcweb配置, 如 ox.server.grpc.grpcweb
+func StdConfig(name string) *Config {
+	return RawConfig("ox.server." + name + ".grpcweb")
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil &&
+		errors.Cause(err) != conf.ErrInvalidKey {
+		config.logger.Panic("grpc web parse config panic", olog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), olog.FieldErr(err), olog.FieldKey(key), olog.FieldValueAny(config))
+	}
+	return config
+}
+
+// WithLogger ...
+func (config *Config) WithLogger(logger *olog.Logger) *Config {
+	config.logger = logger
+	return config
+}
+
+// Build 为server上已注册的服务创建路由, 服务需要在Build之前注册
+func (config *Config) Build(server *ogrpc.Server) (*GRPCWeb, error) {
+	return newGRPCWeb(config, server)
+}
+
+// MustBuild ...
+func (config *Config) MustBuild(server *ogrpc.Server) *GRPCWeb {
+	web, err := config.Build(server)
+	if err != nil {
+		config.logger.Panic("build grpc web panic", olog.FieldErr(err))
+	}
+	return web
+}
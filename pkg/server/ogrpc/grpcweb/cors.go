@@ -0,0 +1,77 @@
+package grpcweb
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	// defaultAllowHeaders grpc-web客户端使用的请求头
+	defaultAllowHeaders = []string{"Content-Type", "X-Grpc-Web", "X-User-Agent", "Grpc-Timeout"}
+	// defaultExposeHeaders 只有状态的响应中状态在响应头中
+	defaultExposeHeaders = []string{"Grpc-Status", "Grpc-Message", "Grpc-Status-Details-Bin"}
+)
+
+// validate 允许所有来源时不能携带凭证, 否则任意网站都能以用户身份调用
+func (config *CORSConfig) validate() error {
+	if !config.Enable {
+		return nil
+	}
+	if len(config.AllowOrigins) == 0 {
+		return errors.New("grpc web cors requires allowOrigins")
+	}
+	if config.AllowCredentials && config.allowAll() {
+		return errors.New("grpc web cors can not allow credentials for all origins")
+	}
+	return nil
+}
+
+// handle 设置跨域响应头, 返回是否为预检请求
+func (config *CORSConfig) handle(w http.ResponseWriter, r *http.Request) bool {
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	origin := r.Header.Get("Origin")
+	if origin == "" || !config.allowOrigin(origin) {
+		return preflight
+	}
+
+	h := w.Header()
+	if config.allowAll() {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if config.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		h.Set("Access-Control-Expose-Headers", strings.Join(append(defaultExposeHeaders, config.ExposeHeaders...), ", "))
+		return false
+	}
+	h.Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	h.Set("Access-Control-Allow-Headers", strings.Join(append(defaultAllowHeaders, config.AllowHeaders...), ", "))
+	if config.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(config.MaxAge.Seconds())))
+	}
+	return true
+}
+
+func (config *CORSConfig) allowAll() bool {
+	for _, o := range config.AllowOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (config *CORSConfig) allowOrigin(origin string) bool {
+	for _, o := range config.AllowOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+// Package grpcweb 在HTTP服务上提供gRPC-Web接口, 浏览器不需要Envoy等代理即可调用grpc服务.
+// 请求转换为HTTP/2的grpc请求后交给grpc.Server处理, 服务端拦截器(认证, 限流, 监控, 链路等)全部生效,
+// 监控和链路使用grpc方法名. 支持二进制(application/grpc-web)和文本(application/grpc-web-text)两种格式,
+// 支持一元调用和服务端流, 不支持客户端流和双向流
+package grpcweb
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/xqk/ox/pkg/server/ogrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Route gRPC-Web路由
+type Route struct {
+	Method string
+	Path   string
+	// FullMethod grpc方法全名, 如 /pkg.Service/Method
+	FullMethod string
+}
+
+// GRPCWeb gRPC-Web处理器
+type GRPCWeb struct {
+	config  *Config
+	server  *ogrpc.Server
+	methods map[string]bool
+	routes  []Route
+}
+
+func newGRPCWeb(config *Config, server *ogrpc.Server) (*GRPCWeb, error) {
+	if config.Prefix != "" && (!strings.HasPrefix(config.Prefix, "/") || strings.HasSuffix(config.Prefix, "/")) {
+		return nil, fmt.Errorf("invalid grpc web prefix %q", config.Prefix)
+	}
+	if config.CORS != nil {
+		if err := config.CORS.validate(); err != nil {
+			return nil, err
+		}
+	}
+	g := &GRPCWeb{
+		config:  config,
+		server:  server,
+		methods: make(map[string]bool),
+	}
+
+	services := server.GetServiceInfo()
+	var names []string
+	for name := range services {
+		if g.include(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// GetServiceInfo中方法的顺序不固定, 按名称排序以保证路由顺序一致
+		methods := append([]grpc.MethodInfo{}, services[name].Methods...)
+		sort.Slice(methods, func(i, j int) bool {
+			return methods[i].Name < methods[j].Name
+		})
+		for _, method := range methods {
+			if method.IsClientStream {
+				continue
+			}
+			fullMethod := "/" + name + "/" + method.Name
+			g.methods[fullMethod] = true
+			g.routes = append(g.routes, Route{Method: http.MethodPost, Path: config.Prefix + fullMethod, FullMethod: fullMethod})
+			if config.CORS != nil && config.CORS.Enable {
+				g.routes = append(g.routes, Route{Method: http.MethodOptions, Path: config.Prefix + fullMethod, FullMethod: fullMethod})
+			}
+		}
+	}
+	return g, nil
+}
+
+func (g *GRPCWeb) include(service string) bool {
+	if len(g.config.Services) == 0 {
+		return !strings.HasPrefix(service, "grpc.")
+	}
+	for _, s := range g.config.Services {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
+
+// Routes 返回所有路由, 开启CORS时包括预检请求的OPTIONS路由
+func (g *GRPCWeb) Routes() []Route {
+	return g.routes
+}
+
+// ServeHTTP implements http.Handler
+func (g *GRPCWeb) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if cors := g.config.CORS; cors != nil && cors.Enable {
+		if cors.handle(w, r) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	fullMethod := strings.TrimPrefix(r.URL.Path, g.config.Prefix)
+	if !g.methods[fullMethod] {
+		writeStatus(w, codes.Unimplemented, "unknown method "+fullMethod)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	text, ok := parseContentType(r.Header.Get("Content-Type"))
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// 转换为grpc.Server.ServeHTTP要求的HTTP/2请求
+	req := r.Clone(r.Context())
+	req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2.0"
+	req.URL.Path = fullMethod
+	req.RequestURI = fullMethod
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Del("Content-Length")
+	req.ContentLength = -1
+	if text {
+		req.Body = newTextReader(r.Body)
+	}
+
+	rw := newResponseWriter(w, text)
+	g.server.Server.ServeHTTP(rw, req)
+	rw.finish()
+}
+
+// parseContentType returns whether content type is grpc-web-text
+func parseContentType(contentType string) (text bool, ok bool) {
+	switch contentType {
+	case "application/grpc-web", "application/grpc-web+proto":
+		return false, true
+	case "application/grpc-web-text", "application/grpc-web-text+proto":
+		return true, true
+	}
+	return false, false
+}
+
+// writeStatus 写入只有状态的响应
+func writeStatus(w http.ResponseWriter, code codes.Code, message string) {
+	h := w.Header()
+	h.Set("Content-Type", "application/grpc-web+proto")
+	h.Set("Grpc-Status", fmt.Sprintf("%d", code))
+	h.Set("Grpc-Message", message)
+	w.WriteHeader(http.StatusOK)
+}
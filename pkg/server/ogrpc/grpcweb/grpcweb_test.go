@@ -0,0 +1,230 @@
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/server/ogrpc"
+	"github.com/xqk/ox/pkg/util/otest/proto/testproto"
+	"github.com/xqk/ox/pkg/util/otest/server/yell"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// streamServiceDesc 服务端流的测试服务
+var streamServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ox.grpcweb.test.Stream",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Hello",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			var in testproto.HelloRequest
+			if err := stream.RecvMsg(&in); err != nil {
+				return err
+			}
+			for i := 0; i < 2; i++ {
+				if err := stream.SendMsg(&testproto.HelloReply{Message: in.Name, Id32: int32(i)}); err != nil {
+					return err
+				}
+			}
+			return status.Error(codes.Aborted, "stream aborted")
+		},
+	}},
+}
+
+func newTestGRPCWeb(t *testing.T, config *Config) *GRPCWeb {
+	grpcConfig := ogrpc.DefaultConfig()
+	grpcConfig.Host = "127.0.0.1"
+	grpcConfig.Port = 0
+	server, err := grpcConfig.Build()
+	assert.Nil(t, err)
+	testproto.RegisterGreeterServer(server.Server, &yell.FooServer{})
+	server.RegisterService(&streamServiceDesc, nil)
+	t.Cleanup(func() { server.Stop() })
+
+	web, err := config.Build(server)
+	assert.Nil(t, err)
+	return web
+}
+
+func frame(msg proto.Message) []byte {
+	data, _ := proto.Marshal(msg)
+	b := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(data)))
+	return append(b, data...)
+}
+
+// parseFrames returns data frames and trailers
+func parseFrames(t *testing.T, body []byte) ([][]byte, map[string]string) {
+	var messages [][]byte
+	var trailers = make(map[string]string)
+	for len(body) >= 5 {
+		n := binary.BigEndian.Uint32(body[1:5])
+		payload := body[5 : 5+n]
+		if body[0]&trailerFlag != 0 {
+			for _, line := range strings.Split(strings.TrimSpace(string(payload)), "\r\n") {
+				kv := strings.SplitN(line, ": ", 2)
+				trailers[kv[0]] = kv[1]
+			}
+		} else {
+			messages = append(messages, payload)
+		}
+		body = body[5+n:]
+	}
+	assert.Empty(t, body)
+	return messages, trailers
+}
+
+func call(web http.Handler, path, contentType string, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	r.Header.Set("X-Grpc-Web", "1")
+	w := httptest.NewRecorder()
+	web.ServeHTTP(w, r)
+	return w
+}
+
+func TestGRPCWeb(t *testing.T) {
+	web := newTestGRPCWeb(t, DefaultConfig())
+
+	var routes []string
+	for _, r := range web.Routes() {
+		routes = append(routes, r.Method+" "+r.Path)
+	}
+	// 双向流不支持
+	assert.Equal(t, []string{
+		"POST /ox.grpcweb.test.Stream/Hello",
+		"POST /testproto.Greeter/SayHello",
+		"POST /testproto.Greeter/WhoServer",
+	}, routes)
+
+	w := call(web, "/testproto.Greeter/SayHello", "application/grpc-web+proto", frame(&testproto.HelloRequest{Name: "ox"}))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/grpc-web+proto", w.Header().Get("Content-Type"))
+	assert.Empty(t, w.Header().Get("Trailer"))
+	messages, trailers := parseFrames(t, w.Body.Bytes())
+	assert.Len(t, messages, 1)
+	var reply testproto.HelloReply
+	assert.Nil(t, proto.Unmarshal(messages[0], &reply))
+	assert.Equal(t, yell.RespFantasy.Message, reply.Message)
+	assert.Equal(t, "0", trailers["grpc-status"])
+
+	// 错误状态在trailer中
+	w = call(web, "/testproto.Greeter/SayHello", "application/grpc-web", frame(&testproto.HelloRequest{Name: "needErr"}))
+	messages, trailers = parseFrames(t, w.Body.Bytes())
+	assert.Empty(t, messages)
+	assert.Equal(t, "15", trailers["grpc-status"])
+
+	// 未知方法
+	w = call(web, "/testproto.Greeter/Unknown", "application/grpc-web", nil)
+	assert.Equal(t, "12", w.Header().Get("Grpc-Status"))
+
+	// 不支持的格式
+	w = call(web, "/testproto.Greeter/SayHello", "application/json", nil)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestGRPCWeb_TextStream(t *testing.T) {
+	config := DefaultConfig()
+	config.Prefix = "/grpc"
+	web := newTestGRPCWeb(t, config)
+
+	// 请求体由两段带填充的base64组成
+	req := frame(&testproto.HelloRequest{Name: "stream"})
+	body := base64.StdEncoding.EncodeToString(req[:4]) + base64.StdEncoding.EncodeToString(req[4:])
+	w := call(web, "/grpc/ox.grpcweb.test.Stream/Hello", "application/grpc-web-text", []byte(body))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/grpc-web-text+proto", w.Header().Get("Content-Type"))
+
+	decoded, err := ioutil.ReadAll(newTextReader(ioutil.NopCloser(w.Body)))
+	assert.Nil(t, err)
+	messages, trailers := parseFrames(t, decoded)
+	assert.Len(t, messages, 2)
+	var reply testproto.HelloReply
+	assert.Nil(t, proto.Unmarshal(messages[1], &reply))
+	assert.Equal(t, "stream", reply.Message)
+	assert.Equal(t, int32(1), reply.Id32)
+	assert.Equal(t, "10", trailers["grpc-status"])
+	assert.Equal(t, "stream aborted", trailers["grpc-message"])
+}
+
+func TestGRPCWeb_CORS(t *testing.T) {
+	config := DefaultConfig()
+	config.CORS.Enable = true
+	config.CORS.AllowOrigins = []string{"https://ox.dev"}
+	config.CORS.AllowHeaders = []string{"Authorization"}
+	web := newTestGRPCWeb(t, config)
+	assert.Len(t, web.Routes(), 6)
+
+	r := httptest.NewRequest(http.MethodOptions, "/testproto.Greeter/SayHello", nil)
+	r.Header.Set("Origin", "https://ox.dev")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	w := httptest.NewRecorder()
+	web.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://ox.dev", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Authorization")
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+
+	req := httptest.NewRequest(http.MethodPost, "/testproto.Greeter/SayHello", bytes.NewReader(frame(&testproto.HelloRequest{Name: "ox"})))
+	req.Header.Set("Content-Type", "application/grpc-web")
+	req.Header.Set("Origin", "https://ox.dev")
+	w = httptest.NewRecorder()
+	web.ServeHTTP(w, req)
+	assert.Contains(t, w.Header().Get("Access-Control-Expose-Headers"), "Grpc-Status")
+
+	// 不允许的来源
+	r.Header.Set("Origin", "https://evil.dev")
+	w = httptest.NewRecorder()
+	web.ServeHTTP(w, r)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSConfig_Validate(t *testing.T) {
+	config := DefaultConfig()
+	config.CORS.Enable = true
+	_, err := config.Build(nil)
+	assert.NotNil(t, err)
+
+	config.CORS.AllowOrigins = []string{"*"}
+	config.CORS.AllowCredentials = true
+	_, err = config.Build(nil)
+	assert.NotNil(t, err)
+
+	// 允许所有来源时不回显Origin
+	config.CORS.AllowCredentials = false
+	web := newTestGRPCWeb(t, config)
+	r := httptest.NewRequest(http.MethodOptions, "/testproto.Greeter/SayHello", nil)
+	r.Header.Set("Origin", "https://ox.dev")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	w := httptest.NewRecorder()
+	web.ServeHTTP(w, r)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestTextReader(t *testing.T) {
+	read := func(text string) (string, error) {
+		bs, err := ioutil.ReadAll(newTextReader(ioutil.NopCloser(strings.NewReader(text))))
+		return string(bs), err
+	}
+	data, err := read("aGVs\r\nbG8=" + base64.StdEncoding.EncodeToString([]byte("ok")))
+	assert.Nil(t, err)
+	assert.Equal(t, "hellook", data)
+
+	_, err = read("aGVsbG8")
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, err = read("aG!s")
+	assert.NotNil(t, err)
+}
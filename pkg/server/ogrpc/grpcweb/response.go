@@ -0,0 +1,169 @@
+package grpcweb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// trailerFlag gRPC-Web中trailer帧的标志位
+const trailerFlag = 0x80
+
+// responseWriter 把grpc.Server的HTTP/2响应转换为gRPC-Web响应, trailer作为最后一帧写入响应体
+type responseWriter struct {
+	w           http.ResponseWriter
+	header      http.Header
+	text        bool
+	wroteHeader bool
+}
+
+func newResponseWriter(w http.ResponseWriter, text bool) *responseWriter {
+	return &responseWriter{w: w, header: make(http.Header), text: text}
+}
+
+// Header ...
+func (rw *responseWriter) Header() http.Header {
+	return rw.header
+}
+
+// WriteHeader 写入除trailer声明外的响应头
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.wroteHeader = true
+	h := rw.w.Header()
+	for k, v := range rw.header {
+		if k == "Trailer" || strings.HasPrefix(k, http.TrailerPrefix) {
+			continue
+		}
+		h[k] = append(h[k], v...)
+	}
+	if strings.HasPrefix(h.Get("Content-Type"), "application/grpc") {
+		if rw.text {
+			h.Set("Content-Type", "application/grpc-web-text+proto")
+		} else {
+			h.Set("Content-Type", "application/grpc-web+proto")
+		}
+	}
+	rw.w.WriteHeader(code)
+}
+
+// Write ...
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.WriteHeader(http.StatusOK)
+	if !rw.text {
+		return rw.w.Write(b)
+	}
+	// 文本格式每次写入单独编码, 客户端按4字节一组解码
+	if _, err := rw.w.Write([]byte(base64.StdEncoding.EncodeToString(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Flush implements http.Flusher, 服务端流的每个响应立即发送
+func (rw *responseWriter) Flush() {
+	rw.WriteHeader(http.StatusOK)
+	if f, ok := rw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish 写入trailer帧, 请求没有到达grpc服务(如请求格式错误)时没有trailer
+func (rw *responseWriter) finish() {
+	var trailers = make(map[string][]string)
+	for _, k := range rw.header["Trailer"] {
+		if v, ok := rw.header[http.CanonicalHeaderKey(k)]; ok {
+			trailers[strings.ToLower(k)] = v
+		}
+	}
+	for k, v := range rw.header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			trailers[strings.ToLower(strings.TrimPrefix(k, http.TrailerPrefix))] = v
+		}
+	}
+	if _, ok := trailers["grpc-status"]; !ok {
+		return
+	}
+
+	var keys []string
+	for k := range trailers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var payload bytes.Buffer
+	for _, k := range keys {
+		for _, v := range trailers[k] {
+			payload.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	frame := make([]byte, 5, 5+payload.Len())
+	frame[0] = trailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(payload.Len()))
+	frame = append(frame, payload.Bytes()...)
+	_, _ = rw.Write(frame)
+	rw.Flush()
+}
+
+// textReader 按4个字符一组流式解码grpc-web-text请求体, 请求体可能由多段带填充的base64拼接而成,
+// 不缓存整个请求体, 消息大小由grpc服务的MaxRecvMsgSize限制
+type textReader struct {
+	body    io.ReadCloser
+	src     *bufio.Reader
+	quantum [4]byte
+	decoded [3]byte
+	buf     []byte
+	err     error
+}
+
+func newTextReader(body io.ReadCloser) io.ReadCloser {
+	return &textReader{body: body, src: bufio.NewReader(body)}
+}
+
+// Read ...
+func (r *textReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next 读取并解码下一组字符, 忽略其中的换行和空格
+func (r *textReader) next() error {
+	for i := 0; i < len(r.quantum); {
+		c, err := r.src.ReadByte()
+		if err != nil {
+			if err == io.EOF && i > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if c == '\r' || c == '\n' || c == ' ' {
+			continue
+		}
+		r.quantum[i] = c
+		i++
+	}
+	n, err := base64.StdEncoding.Decode(r.decoded[:], r.quantum[:])
+	if err != nil {
+		return err
+	}
+	r.buf = r.decoded[:n]
+	return nil
+}
+
+// Close ...
+func (r *textReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,91 @@
+package ogrpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xqk/ox/pkg/server"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthServer implements grpc.health.v1, the status of every service turns NOT_SERVING
+// when the server is not serving or the app is not ready.
+type healthServer struct {
+	*health.Server
+	server *Server
+
+	mu sync.Mutex
+	// 用户显式设置的服务状态, 就绪时恢复为该状态
+	statuses map[string]healthpb.HealthCheckResponse_ServingStatus
+	unwatch  func()
+}
+
+func newHealthServer(s *Server) *healthServer {
+	h := &healthServer{
+		Server:   health.NewServer(),
+		server:   s,
+		statuses: make(map[string]healthpb.HealthCheckResponse_ServingStatus),
+	}
+	// 未启动前不对外提供服务
+	h.Server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	h.unwatch = server.WatchReady(func(bool) {
+		h.refresh()
+	})
+	return h
+}
+
+// close stops watching readiness of the app, called when the server stops
+func (h *healthServer) close() {
+	h.unwatch()
+}
+
+// Check implements grpc_health_v1.HealthServer
+func (h *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	resp, err := h.Server.Check(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if !h.available() {
+		resp.Status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return resp, nil
+}
+
+// setServingStatus sets status of service, empty service means the whole server
+func (h *healthServer) setServingStatus(service string, status healthpb.HealthCheckResponse_ServingStatus) {
+	h.mu.Lock()
+	h.statuses[service] = status
+	h.mu.Unlock()
+	h.refresh()
+}
+
+// refresh pushes current status of every service to watchers
+func (h *healthServer) refresh() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var available = h.available()
+	var services = map[string]struct{}{"": {}}
+	for name := range h.server.GetServiceInfo() {
+		services[name] = struct{}{}
+	}
+	for name := range h.statuses {
+		services[name] = struct{}{}
+	}
+
+	for name := range services {
+		status := healthpb.HealthCheckResponse_SERVING
+		if s, ok := h.statuses[name]; ok {
+			status = s
+		}
+		if !available {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		h.Server.SetServingStatus(name, status)
+	}
+}
+
+func (h *healthServer) available() bool {
+	return h.server.Healthz() && server.Ready()
+}
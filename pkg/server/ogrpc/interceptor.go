@@ -16,9 +16,15 @@ import (
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"github.com/xqk/ox/pkg/deadline"
+	"github.com/xqk/ox/pkg/auth"
 	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/limiter"
 	"github.com/xqk/ox/pkg/metric"
+	"github.com/xqk/ox/pkg/requestid"
 	"github.com/xqk/ox/pkg/trace"
+	"github.com/xqk/ox/pkg/util/oaccess"
+	"github.com/xqk/ox/pkg/util/onet"
 )
 
 func prometheusUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
@@ -46,6 +52,7 @@ func traceUnaryServerInterceptor(ctx context.Context, req interface{}, info *grp
 		trace.FromIncomingContext(ctx),
 		trace.TagComponent("gRPC"),
 		trace.TagSpanKind("server.unary"),
+		trace.CustomTag(requestid.TagRequestID, requestid.FromContext(ctx)),
 	)
 
 	defer span.Finish()
@@ -82,6 +89,7 @@ func traceStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *g
 		trace.TagComponent("gRPC"),
 		trace.TagSpanKind("server.stream"),
 		trace.CustomTag("isServerStream", info.IsServerStream),
+		trace.CustomTag(requestid.TagRequestID, requestid.FromContext(ss.Context())),
 	)
 	defer span.Finish()
 
@@ -91,6 +99,25 @@ func traceStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *g
 	})
 }
 
+// requestIDUnaryServerInterceptor 从metadata中获取或生成请求id, 写入context并通过header返回
+func requestIDUnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := requestid.FromMetadata(md)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestid.MetadataRequestID, id))
+	return handler(requestid.NewContext(ctx, id), req)
+}
+
+// requestIDStreamServerInterceptor 从metadata中获取或生成请求id, 写入context并通过header返回
+func requestIDStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	md, _ := metadata.FromIncomingContext(ss.Context())
+	id := requestid.FromMetadata(md)
+	_ = ss.SetHeader(metadata.Pairs(requestid.MetadataRequestID, id))
+	return handler(srv, contextedServerStream{
+		ServerStream: ss,
+		ctx:          requestid.NewContext(ss.Context(), id),
+	})
+}
+
 func extractAID(ctx context.Context) string {
 	if md, ok := metadata.FromIncomingContext(ctx); ok {
 		return strings.Join(md.Get("aid"), ",")
@@ -98,7 +125,7 @@ func extractAID(ctx context.Context) string {
 	return "unknown"
 }
 
-func defaultStreamServerInterceptor(logger *olog.Logger, slowQueryThresholdInMilli int64) grpc.StreamServerInterceptor {
+func defaultStreamServerInterceptor(logger *olog.Logger, slowQueryThresholdInMilli int64, access *oaccess.Access) grpc.StreamServerInterceptor {
 	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
 		var beg = time.Now()
 		var fields = make([]olog.Field, 0, 8)
@@ -123,8 +150,14 @@ func defaultStreamServerInterceptor(logger *olog.Logger, slowQueryThresholdInMil
 				event = "recover"
 			}
 
+			// 出错和慢请求不参与采样
+			rule := access.Rule(info.FullMethod)
+			if !rule.Sampled(err != nil || event != "normal") {
+				return
+			}
+
 			fields = append(fields,
-				olog.Any("grpc interceptor type", "unary"),
+				olog.Any("grpc interceptor type", "stream"),
 				olog.FieldMethod(info.FullMethod),
 				olog.FieldCost(time.Since(beg)),
 				olog.FieldEvent(event),
@@ -134,18 +167,19 @@ func defaultStreamServerInterceptor(logger *olog.Logger, slowQueryThresholdInMil
 				fields = append(fields, olog.Any(key, val))
 			}
 
+			logger := logger.WithContext(stream.Context())
 			if err != nil {
 				fields = append(fields, zap.String("err", err.Error()))
 				logger.Error("access", fields...)
 				return
 			}
-			logger.Info("access", fields...)
+			rule.Log(logger, "access", fields...)
 		}()
 		return handler(srv, stream)
 	}
 }
 
-func defaultUnaryServerInterceptor(logger *olog.Logger, slowQueryThresholdInMilli int64) grpc.UnaryServerInterceptor {
+func defaultUnaryServerInterceptor(logger *olog.Logger, slowQueryThresholdInMilli int64, access *oaccess.Access) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
 		var beg = time.Now()
 		var fields = make([]olog.Field, 0, 8)
@@ -170,6 +204,12 @@ func defaultUnaryServerInterceptor(logger *olog.Logger, slowQueryThresholdInMill
 				event = "recover"
 			}
 
+			// 出错和慢请求不参与采样
+			rule := access.Rule(info.FullMethod)
+			if !rule.Sampled(err != nil || event != "normal") {
+				return
+			}
+
 			fields = append(fields,
 				olog.Any("grpc interceptor type", "unary"),
 				olog.FieldMethod(info.FullMethod),
@@ -180,13 +220,15 @@ func defaultUnaryServerInterceptor(logger *olog.Logger, slowQueryThresholdInMill
 			for key, val := range getPeer(ctx) {
 				fields = append(fields, olog.Any(key, val))
 			}
+			fields = append(fields, rule.PayloadFields(err != nil, req, resp)...)
 
+			logger := logger.WithContext(ctx)
 			if err != nil {
 				fields = append(fields, zap.String("err", err.Error()))
 				logger.Error("access", fields...)
 				return
 			}
-			logger.Info("access", fields...)
+			rule.Log(logger, "access", fields...)
 		}()
 		return handler(ctx, req)
 	}
@@ -227,3 +269,181 @@ func getPeer(ctx context.Context) map[string]string {
 	return peerMeta
 
 }
+
+// deadlineUnaryServerInterceptor derives handler context from the time budget of caller
+func deadlineUnaryServerInterceptor(logger *olog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, cancel, err := withBudget(ctx, logger, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer cancel()
+
+		var beg = time.Now()
+		resp, err := handler(ctx, req)
+		logDeadlineExceeded(ctx, logger, info.FullMethod, time.Since(beg))
+		return resp, err
+	}
+}
+
+// deadlineStreamServerInterceptor derives stream context from the time budget of caller
+func deadlineStreamServerInterceptor(logger *olog.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, cancel, err := withBudget(ss.Context(), logger, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer cancel()
+
+		var beg = time.Now()
+		err = handler(srv, contextedServerStream{
+			ServerStream: ss,
+			ctx:          ctx,
+		})
+		logDeadlineExceeded(ctx, logger, info.FullMethod, time.Since(beg))
+		return err
+	}
+}
+
+func withBudget(ctx context.Context, logger *olog.Logger, method string) (context.Context, context.CancelFunc, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx, func() {}, nil
+	}
+	budget, ok := deadline.FromMetadata(md)
+	if !ok {
+		return ctx, func() {}, nil
+	}
+	if budget.Expired() {
+		logger.WithContext(ctx).Warn("deadline exhausted", olog.FieldMethod(method), olog.Any("hops", budget.Hops), olog.FieldAid(extractAID(ctx)))
+		return ctx, nil, ecode.DeadlineExhausted.Err()
+	}
+	ctx, cancel := deadline.NewContext(ctx, budget)
+	return ctx, cancel, nil
+}
+
+func logDeadlineExceeded(ctx context.Context, logger *olog.Logger, method string, cost time.Duration) {
+	if ctx.Err() != context.DeadlineExceeded {
+		return
+	}
+	logger.WithContext(ctx).Warn("deadline exceeded",
+		olog.FieldMethod(method),
+		olog.FieldCost(cost),
+		olog.Any("hops", deadline.Hops(ctx)),
+		olog.FieldAid(extractAID(ctx)),
+	)
+}
+
+// limiterUnaryServerInterceptor 自适应限流, 过载时返回可重试的ecode.Overloaded
+func limiterUnaryServerInterceptor(l *limiter.Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		done, ok := l.Allow(info.FullMethod)
+		if !ok {
+			return nil, ecode.Overloaded.Err()
+		}
+		defer done()
+		return handler(ctx, req)
+	}
+}
+
+// limiterStreamServerInterceptor 自适应限流, 过载时返回可重试的ecode.Overloaded
+func limiterStreamServerInterceptor(l *limiter.Limiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		done, ok := l.Allow(info.FullMethod)
+		if !ok {
+			return ecode.Overloaded.Err()
+		}
+		defer done()
+		return handler(srv, ss)
+	}
+}
+
+// authUnaryServerInterceptor 认证请求, 认证通过后可以通过auth.FromContext获取调用方
+func authUnaryServerInterceptor(logger *olog.Logger, a *auth.Auth) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, logger, a, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// authStreamServerInterceptor 认证请求, 认证通过后可以通过auth.FromContext获取调用方
+func authStreamServerInterceptor(logger *olog.Logger, a *auth.Auth) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), logger, a, info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		return handler(srv, contextedServerStream{
+			ServerStream: ss,
+			ctx:          ctx,
+		})
+	}
+}
+
+// authenticate req为一元调用的请求消息, 用于校验hmac签名, 流为nil
+func authenticate(ctx context.Context, logger *olog.Logger, a *auth.Auth, method string, req interface{}) (context.Context, error) {
+	// 健康检查不需要认证
+	if a.Skip(method) || strings.HasPrefix(method, "/grpc.health.v1.Health/") {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var request = auth.FromMetadata(method, md)
+	if req != nil {
+		request = auth.FromGRPC(method, md, req)
+	}
+	principal, err := a.Authenticate(request)
+	if err != nil {
+		logger.WithContext(ctx).Warn("unauthenticated", olog.FieldMethod(method), olog.FieldAid(extractAID(ctx)), olog.FieldErr(err))
+		return ctx, ecode.Unauthenticated.Err()
+	}
+	return auth.NewContext(ctx, principal), nil
+}
+
+// callerUnaryServerInterceptor 校验调用方签名和方法级ACL
+func callerUnaryServerInterceptor(logger *olog.Logger, g *auth.CallerGuard) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := checkCaller(ctx, logger, g, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// callerStreamServerInterceptor 校验调用方签名和方法级ACL
+func callerStreamServerInterceptor(logger *olog.Logger, g *auth.CallerGuard) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkCaller(ss.Context(), logger, g, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func checkCaller(ctx context.Context, logger *olog.Logger, g *auth.CallerGuard, method string) error {
+	if strings.HasPrefix(method, "/grpc.health.v1.Health/") {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var err error
+	if p, ok := peer.FromContext(ctx); ok && onet.IsPipe(p.Addr) {
+		// 进程内gateway的请求, 签名已由HTTP服务校验, aid为HTTP服务校验过的调用方, 仍然按方法校验ACL
+		var aid string
+		if vals := md.Get(strings.ToLower(auth.HeaderAID)); len(vals) > 0 {
+			aid = vals[0]
+		}
+		err = g.CheckVerified(method, aid)
+	} else {
+		_, err = g.Check(method, method, auth.FromMetadata(method, md).Header)
+	}
+	if err == nil {
+		return nil
+	}
+	logger.WithContext(ctx).Warn("caller denied", olog.FieldMethod(method), olog.FieldAid(extractAID(ctx)), olog.FieldErr(err))
+	if err == auth.ErrCallerDenied {
+		return ecode.PermissionDenied.Err()
+	}
+	return ecode.Unauthenticated.Err()
+}
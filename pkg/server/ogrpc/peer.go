@@ -0,0 +1,23 @@
+package ogrpc
+
+import (
+	"context"
+
+	"github.com/xqk/ox/pkg/util/otls"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// PeerIdentity returns identity of the client certificate, it is only available when
+// the server is configured with TLS and the client presents a certificate.
+func PeerIdentity(ctx context.Context) (*otls.Identity, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil, false
+	}
+	return otls.IdentityFromState(info.State)
+}
@@ -4,80 +4,202 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sync/atomic"
 
 	"google.golang.org/grpc"
+	channelz "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 	"github.com/xqk/ox/pkg/constant"
+	"github.com/xqk/ox/pkg/limiter"
+	"github.com/xqk/ox/pkg/metric"
 	"github.com/xqk/ox/pkg/server"
+	"github.com/xqk/ox/pkg/util/onet"
+	"github.com/xqk/ox/pkg/util/otls"
 )
 
 // Server ...
 type Server struct {
 	*grpc.Server
-	listener net.Listener
+	listener  net.Listener
+	tlsLoader *otls.Loader
+	health    *healthServer
+	serving   int32
 	*Config
 }
 
 func newServer(config *Config) (*Server, error) {
-	var streamInterceptors = append(
-		[]grpc.StreamServerInterceptor{defaultStreamServerInterceptor(config.logger, config.SlowQueryThresholdInMilli)},
-		config.streamInterceptors...,
-	)
+	if config.Access == nil {
+		config.Access = defaultAccessConfig()
+	}
+	var access = config.Access.Build()
+	var streamInterceptors = []grpc.StreamServerInterceptor{
+		defaultStreamServerInterceptor(config.logger, config.SlowQueryThresholdInMilli, access),
+		deadlineStreamServerInterceptor(config.logger),
+	}
+	var unaryInterceptors = []grpc.UnaryServerInterceptor{
+		defaultUnaryServerInterceptor(config.logger, config.SlowQueryThresholdInMilli, access),
+		deadlineUnaryServerInterceptor(config.logger),
+	}
 
-	var unaryInterceptors = append(
-		[]grpc.UnaryServerInterceptor{defaultUnaryServerInterceptor(config.logger, config.SlowQueryThresholdInMilli)},
-		config.unaryInterceptors...,
-	)
+	if config.Auth != nil && config.Auth.Enable {
+		a, err := config.Auth.Build()
+		if err != nil {
+			return nil, fmt.Errorf("create grpc server failed: %v", err)
+		}
+		streamInterceptors = append(streamInterceptors, authStreamServerInterceptor(config.logger, a))
+		unaryInterceptors = append(unaryInterceptors, authUnaryServerInterceptor(config.logger, a))
+	}
+	// 通过StdConfig创建时热更新可以开启校验, 关闭时不校验
+	if config.Caller != nil && (config.Caller.Enable || config.key != "") {
+		g := config.callerGuard()
+		streamInterceptors = append(streamInterceptors, callerStreamServerInterceptor(config.logger, g))
+		unaryInterceptors = append(unaryInterceptors, callerUnaryServerInterceptor(config.logger, g))
+	}
+	streamInterceptors = append(streamInterceptors, config.streamInterceptors...)
+	unaryInterceptors = append(unaryInterceptors, config.unaryInterceptors...)
+
+	// 限流放在最前面, 被拒绝的请求只记录监控, 不打印访问日志
+	if config.Limiter != nil && config.Limiter.Enable {
+		l := limiter.New(metric.TypeGRPCUnary, config.Limiter)
+		streamInterceptors = append([]grpc.StreamServerInterceptor{limiterStreamServerInterceptor(l)}, streamInterceptors...)
+		unaryInterceptors = append([]grpc.UnaryServerInterceptor{limiterUnaryServerInterceptor(l)}, unaryInterceptors...)
+	}
+	// 请求id放在最前面, 之后的拦截器和访问日志都可以获取
+	streamInterceptors = append([]grpc.StreamServerInterceptor{requestIDStreamServerInterceptor}, streamInterceptors...)
+	unaryInterceptors = append([]grpc.UnaryServerInterceptor{requestIDUnaryServerInterceptor}, unaryInterceptors...)
 
 	config.serverOptions = append(config.serverOptions,
 		grpc.StreamInterceptor(StreamInterceptorChain(streamInterceptors...)),
 		grpc.UnaryInterceptor(UnaryInterceptorChain(unaryInterceptors...)),
 	)
 
+	var tlsLoader *otls.Loader
+	if config.TLS != nil {
+		var err error
+		tlsLoader, err = otls.NewLoader(config.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("create grpc server failed: %v", err)
+		}
+		tlsConfig, err := tlsLoader.ServerConfig()
+		if err != nil {
+			tlsLoader.Close()
+			return nil, fmt.Errorf("create grpc server failed: %v", err)
+		}
+		// credentials.NewTLS复制config后才追加h2, 而校验客户端证书时握手使用的是ServerConfig返回的config
+		tlsConfig.NextProtos = []string{"h2"}
+		config.serverOptions = append(config.serverOptions, grpc.Creds(pipeCreds{credentials.NewTLS(tlsConfig)}))
+	}
+
 	newServer := grpc.NewServer(config.serverOptions...)
-	listener, err := net.Listen(config.Network, config.Address())
+	listener, err := config.listen()
 	if err != nil {
 		// config.logger.Panic("new grpc server err", xlog.FieldErrKind(ecode.ErrKindListenErr), xlog.FieldErr(err))
+		if tlsLoader != nil {
+			tlsLoader.Close()
+		}
 		return nil, fmt.Errorf("create grpc server failed: %v", err)
 	}
-	config.Port = listener.Addr().(*net.TCPAddr).Port
+	if port := onet.Port(listener.Addr()); port != 0 {
+		config.Port = port
+	}
+
+	s := &Server{
+		Server:    newServer,
+		listener:  listener,
+		tlsLoader: tlsLoader,
+		Config:    config,
+	}
+	s.registerBuiltinServices()
+	return s, nil
+}
 
-	return &Server{
-		Server:   newServer,
-		listener: listener,
-		Config:   config,
-	}, nil
+// registerBuiltinServices registers health, reflection and channelz services by config
+func (s *Server) registerBuiltinServices() {
+	if !s.Config.DisableHealth {
+		s.health = newHealthServer(s)
+		healthpb.RegisterHealthServer(s.Server, s.health)
+	}
+	if s.Config.EnableReflection {
+		reflection.Register(s.Server)
+	}
+	if s.Config.EnableChannelz {
+		channelz.RegisterChannelzServiceToServer(s.Server)
+	}
 }
 
+// Healthz reports whether the server is serving
 func (s *Server) Healthz() bool {
-	conn, err := s.listener.Accept()
-	if err != nil {
-		return false
+	return atomic.LoadInt32(&s.serving) == 1
+}
+
+// SetServingStatus sets serving status of service in health service, empty service means the whole server.
+// Status of all services is NOT_SERVING when the server is not serving or the app is not ready.
+func (s *Server) SetServingStatus(service string, serving bool) {
+	if s.health == nil {
+		return
+	}
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
 	}
+	s.health.setServingStatus(service, status)
+}
 
-	conn.Close()
-	return true
+func (s *Server) setServing(serving bool) {
+	var val int32
+	if serving {
+		val = 1
+	}
+	atomic.StoreInt32(&s.serving, val)
+	if s.health != nil {
+		s.health.refresh()
+	}
 }
 
 // Server implements server.Server interface.
 func (s *Server) Serve() error {
+	s.setServing(true)
 	err := s.Server.Serve(s.listener)
+	s.setServing(false)
 	return err
 }
 
 // Stop implements server.Server interface
 // it will terminate echo server immediately
 func (s *Server) Stop() error {
+	s.setServing(false)
 	s.Server.Stop()
+	s.closeHealth()
+	s.closeTLSLoader()
 	return nil
 }
 
 // GracefulStop implements server.Server interface
 // it will stop echo server gracefully
 func (s *Server) GracefulStop(ctx context.Context) error {
+	// 先将健康检查置为NOT_SERVING, 再等待进行中的请求结束
+	s.setServing(false)
 	s.Server.GracefulStop()
+	s.closeHealth()
+	s.closeTLSLoader()
 	return nil
 }
 
+func (s *Server) closeHealth() {
+	if s.health != nil {
+		s.health.close()
+	}
+}
+
+func (s *Server) closeTLSLoader() {
+	if s.tlsLoader != nil {
+		_ = s.tlsLoader.Close()
+	}
+}
+
 // Info returns server info, used by governor and consumer balancer
 func (s *Server) Info() *server.ServiceInfo {
 	serviceAddress := s.listener.Addr().String()
@@ -90,5 +212,26 @@ func (s *Server) Info() *server.ServiceInfo {
 		server.WithAddress(serviceAddress),
 		server.WithKind(constant.ServiceProvider),
 	)
+	if s.Config.TLS != nil {
+		info.Metadata["tls"] = "true"
+	}
 	return &info
 }
+
+// pipeCreds 进程内的内存连接(如gateway)不使用TLS, 其他连接使用TransportCredentials
+type pipeCreds struct {
+	credentials.TransportCredentials
+}
+
+// ServerHandshake ...
+func (c pipeCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if onet.IsPipe(conn.LocalAddr()) {
+		return insecure.NewCredentials().ServerHandshake(conn)
+	}
+	return c.TransportCredentials.ServerHandshake(conn)
+}
+
+// Clone ...
+func (c pipeCreds) Clone() credentials.TransportCredentials {
+	return pipeCreds{c.TransportCredentials.Clone()}
+}
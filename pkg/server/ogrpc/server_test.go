@@ -2,13 +2,25 @@ package ogrpc
 
 import (
 	"context"
+	"crypto/tls"
 	"github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"github.com/xqk/ox/pkg/auth"
 	"github.com/xqk/ox/pkg/constant"
+	"github.com/xqk/ox/pkg/deadline"
+	"github.com/xqk/ox/pkg/ecode"
 	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/requestid"
+	"github.com/xqk/ox/pkg/server"
 	"testing"
 	"time"
 )
@@ -148,3 +160,195 @@ func errorDesc(err error) string {
 	}
 	return err.Error()
 }
+
+func TestDeadlineUnaryServerInterceptor(t *testing.T) {
+	interceptor := deadlineUnaryServerInterceptor(olog.OxLogger)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Greeter/SayHello"}
+
+	t.Run("expired", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(deadline.MetadataTimeout, "0"))
+		_, err := interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			t.Fatal("handler should not be called")
+			return nil, nil
+		})
+		assert.Equal(t, ecode.DeadlineExhausted.Code, int32(status.Code(err)))
+	})
+
+	t.Run("derive context", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(deadline.MetadataTimeout, "1000", deadline.MetadataHops, "a,b"))
+		_, err := interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			remaining, ok := deadline.Remaining(ctx)
+			assert.True(t, ok)
+			assert.True(t, remaining <= time.Second)
+			assert.Equal(t, []string{"a", "b"}, deadline.Hops(ctx))
+			return nil, nil
+		})
+		assert.Nil(t, err)
+	})
+}
+
+func TestHealthService(t *testing.T) {
+	config := DefaultConfig()
+	config.Host = "127.0.0.1"
+	config.Port = 0
+	config.EnableReflection = true
+	config.EnableChannelz = true
+	ns, err := newServer(config)
+	assert.Nil(t, err)
+	assert.False(t, ns.Healthz())
+
+	services := ns.GetServiceInfo()
+	assert.Contains(t, services, "grpc.health.v1.Health")
+	assert.Contains(t, services, "grpc.reflection.v1alpha.ServerReflection")
+	assert.Contains(t, services, "grpc.channelz.v1.Channelz")
+
+	go ns.Serve()
+	defer ns.Stop()
+
+	cc, err := grpc.Dial(ns.listener.Addr().String(), grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer cc.Close()
+	client := healthpb.NewHealthClient(cc)
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			return healthpb.HealthCheckResponse_UNKNOWN
+		}
+		return resp.Status
+	}
+
+	assert.Eventually(t, ns.Healthz, time.Second, time.Millisecond*10)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check("grpc.reflection.v1alpha.ServerReflection"))
+
+	ns.SetServingStatus("grpc.reflection.v1alpha.ServerReflection", false)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check("grpc.reflection.v1alpha.ServerReflection"))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+
+	server.SetReady(false)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+	server.SetReady(true)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+}
+
+func TestBuiltinServices_Default(t *testing.T) {
+	config := DefaultConfig()
+	config.Host = "127.0.0.1"
+	config.Port = 0
+	ns, err := newServer(config)
+	assert.Nil(t, err)
+	defer ns.Stop()
+
+	// reflection和channelz默认不注册
+	services := ns.GetServiceInfo()
+	assert.Contains(t, services, "grpc.health.v1.Health")
+	assert.NotContains(t, services, "grpc.reflection.v1alpha.ServerReflection")
+	assert.NotContains(t, services, "grpc.channelz.v1.Channelz")
+}
+
+func TestAuthUnaryServerInterceptor(t *testing.T) {
+	a, err := (&auth.Config{
+		Skip:   []string{"/test.Greeter/Public"},
+		APIKey: &auth.APIKeyConfig{Keys: []auth.APIKey{{Key: "k1", Subject: "job"}}},
+	}).Build()
+	assert.Nil(t, err)
+	interceptor := authUnaryServerInterceptor(olog.OxLogger, a)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		principal, ok := auth.FromContext(ctx)
+		if !ok {
+			return "", nil
+		}
+		return principal.Subject, nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-api-key", "k1"))
+	resp, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Greeter/SayHello"}, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, "job", resp)
+
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Greeter/SayHello"}, handler)
+	assert.Equal(t, ecode.Unauthenticated.Code, int32(status.Code(err)))
+
+	resp, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/test.Greeter/Public"}, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, "", resp)
+}
+
+func TestServer_Unix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ogrpc")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := DefaultConfig()
+	config.Network = "unix"
+	config.Host = filepath.Join(dir, "grpc.sock")
+	config.SocketMode = 0660
+	ns, err := newServer(config)
+	assert.Nil(t, err)
+	assert.Equal(t, config.Host, ns.Info().Address)
+	fi, err := os.Stat(config.Host)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0660), fi.Mode().Perm())
+
+	go ns.Serve()
+	cc, err := grpc.Dial("unix://"+config.Host, grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer cc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	assert.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	// 停止后删除socket文件
+	assert.Nil(t, ns.GracefulStop(context.Background()))
+	_, err = os.Stat(config.Host)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestServer_RequestID(t *testing.T) {
+	config := DefaultConfig()
+	config.Port = 0
+	ns, err := newServer(config)
+	assert.Nil(t, err)
+	go ns.Serve()
+	defer ns.Stop()
+
+	cc, err := grpc.Dial(ns.Address(), grpc.WithInsecure())
+	assert.Nil(t, err)
+	defer cc.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// 传入的请求id通过header返回
+	var header metadata.MD
+	_, err = healthpb.NewHealthClient(cc).Check(metadata.AppendToOutgoingContext(ctx, requestid.MetadataRequestID, "abc"), &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true), grpc.Header(&header))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abc"}, header.Get(requestid.MetadataRequestID))
+
+	// 没有请求id时生成
+	_, err = healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+	assert.Nil(t, err)
+	assert.Len(t, header.Get(requestid.MetadataRequestID)[0], 32)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Greeter/SayHello"}
+	_, _ = requestIDUnaryServerInterceptor(metadata.NewIncomingContext(ctx, metadata.Pairs(requestid.MetadataRequestID, "abc")), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		assert.Equal(t, "abc", requestid.FromContext(ctx))
+		return nil, nil
+	})
+}
+
+func TestPipeCreds(t *testing.T) {
+	creds := pipeCreds{credentials.NewTLS(&tls.Config{})}
+	conn, peerConn := net.Pipe()
+	defer conn.Close()
+	defer peerConn.Close()
+
+	// 内存连接不进行TLS握手
+	_, info, err := creds.ServerHandshake(conn)
+	assert.Nil(t, err)
+	assert.Equal(t, "insecure", info.AuthType())
+	assert.Equal(t, "tls", creds.Clone().Info().SecurityProtocol)
+}
@@ -0,0 +1,131 @@
+package ohttp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/xqk/ox/pkg/conf"
+	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/server/oerror"
+	"github.com/xqk/ox/pkg/server/otransport"
+	"github.com/xqk/ox/pkg/util/onet"
+)
+
+// ModName ..
+const ModName = "server.http"
+
+// Config HTTP config
+type Config struct {
+	Host string `json:"host" toml:"host"`
+	Port int    `json:"port" toml:"port"`
+	// Network network type, tcp by default.
+	// 为unix时Host为socket文件路径, 以"@"开头时为abstract socket
+	Network string `json:"network" toml:"network"`
+	// SocketMode unix socket文件的权限, 为0时不修改
+	SocketMode    os.FileMode `json:"socketMode" toml:"socketMode"`
+	Deployment    string      `json:"deployment" toml:"deployment"`
+	DisableMetric bool        `json:"disableMetric" toml:"disableMetric"`
+	DisableTrace  bool        `json:"disableTrace" toml:"disableTrace"`
+	// ServiceAddress service address in registry info, default to 'Host:Port'
+	ServiceAddress string `json:"serviceAddress" toml:"serviceAddress"`
+
+	SlowQueryThresholdInMilli int64 `json:"slowQueryThresholdInMilli" toml:"slowQueryThresholdInMilli"`
+	// Error 错误响应配置
+	Error *oerror.Config `json:"error" toml:"error"`
+	// Transport 超时, 请求头限制和HTTP/2配置
+	Transport *otransport.Config `json:"transport" toml:"transport"`
+
+	handler  http.Handler
+	listener net.Listener
+	logger   *olog.Logger
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Network:                   "tcp",
+		Host:                      "127.0.0.1",
+		Port:                      9091,
+		SlowQueryThresholdInMilli: 500, // 500ms
+		Error:                     oerror.DefaultConfig(),
+		Transport:                 otransport.DefaultConfig(),
+		logger:                    olog.OxLogger.With(olog.FieldMod(ModName)),
+	}
+}
+
+// StdConfig Ox Standard HTTP Server config
+func StdConfig(name string) *Config {
+	return RawConfig("ox.server." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil &&
+		errors.Cause(err) != conf.ErrInvalidKey {
+		config.logger.Panic("http server parse config panic", olog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), olog.FieldErr(err), olog.FieldKey(key), olog.FieldValueAny(config))
+	}
+	return config
+}
+
+// WithLogger ...
+func (config *Config) WithLogger(logger *olog.Logger) *Config {
+	config.logger = logger
+	return config
+}
+
+// WithHost ...
+func (config *Config) WithHost(host string) *Config {
+	config.Host = host
+	return config
+}
+
+// WithPort ...
+func (config *Config) WithPort(port int) *Config {
+	config.Port = port
+	return config
+}
+
+// WithHandler serves handler, such as chi.Router, instead of the builtin http.ServeMux.
+func (config *Config) WithHandler(handler http.Handler) *Config {
+	config.handler = handler
+	return config
+}
+
+// WithListener serves on listener instead of listening on Address, such as the listener of omux.
+// Cleartext HTTP/2 requests on the listener are served by h2c.
+func (config *Config) WithListener(listener net.Listener) *Config {
+	config.listener = listener
+	return config
+}
+
+func (config *Config) listen() (net.Listener, error) {
+	if config.listener != nil {
+		return config.listener, nil
+	}
+	return onet.Listen(config.Network, config.Address(), config.SocketMode)
+}
+
+// Build create server instance
+func (config *Config) Build() *Server {
+	var contract = oerror.Default
+	if config.Error != nil {
+		var err error
+		if contract, err = config.Error.Build(); err != nil {
+			config.logger.Panic("build error contract panic", olog.FieldErr(err))
+		}
+	}
+	return newServer(config, contract)
+}
+
+// Address ...
+func (config *Config) Address() string {
+	if onet.IsUnixNetwork(config.Network) {
+		return config.Host
+	}
+	return fmt.Sprintf("%s:%d", config.Host, config.Port)
+}
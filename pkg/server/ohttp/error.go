@@ -0,0 +1,19 @@
+package ohttp
+
+import (
+	"net/http"
+
+	"github.com/xqk/ox/pkg/server/oerror"
+)
+
+// contractKey 请求上下文中错误响应约定的key
+type contractKey struct{}
+
+// WriteError 按服务的错误响应约定写入错误
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	contract, ok := r.Context().Value(contractKey{}).(*oerror.Contract)
+	if !ok {
+		contract = oerror.Default
+	}
+	contract.Write(w, r, err)
+}
@@ -0,0 +1,184 @@
+package ohttp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/xqk/ox/pkg/metric"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/requestid"
+	"github.com/xqk/ox/pkg/server/oerror"
+	"github.com/xqk/ox/pkg/trace"
+	"go.uber.org/zap"
+)
+
+func extractAID(r *http.Request) string {
+	return r.Header.Get("AID")
+}
+
+// responseWriter 记录响应的状态码和大小, 保留Flusher和Hijacker
+type responseWriter struct {
+	http.ResponseWriter
+	status   int
+	size     int
+	hijacked bool
+}
+
+func wrapWriter(w http.ResponseWriter) *responseWriter {
+	if rw, ok := w.(*responseWriter); ok {
+		return rw
+	}
+	return &responseWriter{ResponseWriter: w}
+}
+
+// Status 返回响应的状态码, 未写入时为200
+func (w *responseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Written 是否已经写入响应头
+func (w *responseWriter) Written() bool {
+	return w.status != 0 || w.hijacked
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// Flush implements http.Flusher
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("http.Hijacker is not supported")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.hijacked = true
+		if w.status == 0 {
+			w.status = http.StatusSwitchingProtocols
+		}
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the original http.ResponseWriter
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func recoverMiddleware(logger *olog.Logger, slowQueryThresholdInMilli int64, contract *oerror.Contract, route func(*http.Request) string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var beg = time.Now()
+			var fields = make([]olog.Field, 0, 12)
+			var rw = wrapWriter(w)
+			r = r.WithContext(context.WithValue(r.Context(), contractKey{}, contract))
+			id := requestid.FromHeader(r.Header)
+			r = r.WithContext(requestid.NewContext(r.Context(), id))
+			rw.Header().Set(requestid.HeaderRequestID, id)
+			defer func() {
+				rec := recover()
+				// 客户端断开连接, 与net/http一样不记录日志
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				fields = append(fields, zap.Float64("cost", time.Since(beg).Seconds()))
+				if slowQueryThresholdInMilli > 0 {
+					if cost := int64(time.Since(beg)) / 1e6; cost > slowQueryThresholdInMilli {
+						fields = append(fields, zap.Int64("slow", cost))
+					}
+				}
+				if rec != nil && !rw.Written() {
+					contract.Write(rw, r, oerror.ErrPanic)
+				}
+				fields = append(fields,
+					zap.String("method", r.Method),
+					zap.String("route", route(r)),
+					zap.Int("code", rw.Status()),
+					zap.Int("size", rw.size),
+					zap.String("host", r.Host),
+					zap.String("path", r.URL.Path),
+					zap.String("remote_addr", r.RemoteAddr),
+					olog.FieldAid(extractAID(r)),
+				)
+				if rec != nil {
+					err, ok := rec.(error)
+					if !ok {
+						err = fmt.Errorf("%v", rec)
+					}
+					stack := make([]byte, 4096)
+					length := runtime.Stack(stack, false)
+					fields = append(fields, zap.ByteString("stack", stack[:length]), zap.String("err", err.Error()))
+					logger.WithContext(r.Context()).Error("access", fields...)
+					return
+				}
+				logger.WithContext(r.Context()).Info("access", fields...)
+			}()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+func metricMiddleware(route func(*http.Request) string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var beg = time.Now()
+			var rw = wrapWriter(w)
+			next.ServeHTTP(rw, r)
+			method := r.Method + "." + route(r)
+			metric.ServerHandleHistogram.Observe(time.Since(beg).Seconds(), metric.TypeHTTP, method, extractAID(r))
+			metric.ServerHandleCounter.Inc(metric.TypeHTTP, method, extractAID(r), http.StatusText(rw.Status()))
+		})
+	}
+}
+
+func traceMiddleware(route func(*http.Request) string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			span, ctx := trace.StartSpanFromContext(
+				r.Context(),
+				r.Method+" "+route(r),
+				trace.TagComponent("http"),
+				trace.TagSpanKind("server"),
+				trace.HeaderExtractor(r.Header),
+				trace.CustomTag("http.url", r.URL.Path),
+				trace.CustomTag("http.method", r.Method),
+				trace.CustomTag("peer.ipv4", r.RemoteAddr),
+				trace.CustomTag(requestid.TagRequestID, requestid.FromContext(r.Context())),
+			)
+			defer span.Finish()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package ohttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/xqk/ox/pkg/constant"
+	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/server"
+	"github.com/xqk/ox/pkg/server/oerror"
+	"github.com/xqk/ox/pkg/server/otransport"
+	"github.com/xqk/ox/pkg/util/onet"
+)
+
+// Middleware 标准库风格的中间件
+type Middleware func(http.Handler) http.Handler
+
+// Server 包装标准库http.Handler的服务, 默认使用http.ServeMux注册路由
+type Server struct {
+	*http.ServeMux
+	Server      *http.Server
+	config      *Config
+	listener    net.Listener
+	contract    *oerror.Contract
+	middlewares []Middleware
+	// tracker 统计进行中的请求和连接
+	tracker *otransport.Tracker
+}
+
+func newServer(config *Config, contract *oerror.Contract) *Server {
+	listener, err := config.listen()
+	if err != nil {
+		config.logger.Panic("new ohttp server err", olog.FieldErrKind(ecode.ErrKindListenErr), olog.FieldErr(err))
+	}
+	if port := onet.Port(listener.Addr()); port != 0 {
+		config.Port = port
+	}
+	tracker := otransport.NewTracker(ModName + "@" + config.Address())
+	return &Server{
+		ServeMux: http.NewServeMux(),
+		config:   config,
+		listener: tracker.Listener(listener),
+		contract: contract,
+		tracker:  tracker,
+	}
+}
+
+// Use 添加中间件, 在内置的recover, metric和trace中间件之后执行
+func (s *Server) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+// Handler 返回包装了中间件的handler
+func (s *Server) Handler() http.Handler {
+	var handler http.Handler = s.ServeMux
+	if s.config.handler != nil {
+		handler = s.config.handler
+	}
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		handler = s.middlewares[i](handler)
+	}
+	if !s.config.DisableTrace {
+		handler = traceMiddleware(s.route)(handler)
+	}
+	if !s.config.DisableMetric {
+		handler = metricMiddleware(s.route)(handler)
+	}
+	return recoverMiddleware(s.config.logger, s.config.SlowQueryThresholdInMilli, s.contract, s.route)(handler)
+}
+
+// route 返回请求匹配的路由, 用于日志, 监控和链路的名称
+func (s *Server) route(r *http.Request) string {
+	if s.config.handler == nil {
+		if _, pattern := s.ServeMux.Handler(r); pattern != "" {
+			return pattern
+		}
+	}
+	return r.URL.Path
+}
+
+// Serve implements server.Server interface.
+func (s *Server) Serve() error {
+	// 使用外部listener时总是支持h2c
+	s.Server = s.config.Transport.Server(s.config.Address(), s.tracker.Handler(s.Handler()), s.config.listener != nil)
+	s.Server.ConnState = s.tracker.ConnState
+	err := s.Server.Serve(s.listener)
+	if err == http.ErrServerClosed {
+		s.config.logger.Info("close http", olog.FieldAddr(s.config.Address()))
+		return nil
+	}
+
+	return err
+}
+
+// Stop implements server.Server interface
+// it will terminate http server immediately
+func (s *Server) Stop() error {
+	defer s.tracker.Close()
+	return s.Server.Close()
+}
+
+// GracefulStop implements server.Server interface
+// it will stop http server gracefully, hijacked connections are closed after Transport.GracePeriod
+func (s *Server) GracefulStop(ctx context.Context) error {
+	return s.tracker.Shutdown(ctx, s.Server, s.config.Transport.GracePeriod, s.config.logger)
+}
+
+// Info returns server info, used by governor and consumer balancer
+func (s *Server) Info() *server.ServiceInfo {
+	serviceAddr := s.listener.Addr().String()
+	if s.config.ServiceAddress != "" {
+		serviceAddr = s.config.ServiceAddress
+	}
+
+	info := server.ApplyOptions(
+		server.WithScheme("http"),
+		server.WithAddress(serviceAddr),
+		server.WithKind(constant.ServiceProvider),
+	)
+	return &info
+}
+
+// Healthz implements server.Server interface
+func (s *Server) Healthz() bool {
+	return s.listener != nil
+}
@@ -0,0 +1,111 @@
+package ohttp
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestServer(t *testing.T, config *Config) *Server {
+	config.Port = 0
+	server := config.Build()
+	t.Cleanup(func() { _ = server.listener.Close() })
+	return server
+}
+
+func TestServer_Handler(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	})
+	server.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	server.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, status.Error(codes.InvalidArgument, "bad name"))
+	})
+	var order []string
+	server.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			order = append(order, "first")
+			next.ServeHTTP(w, r)
+		})
+	}, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			order = append(order, "second")
+			next.ServeHTTP(w, r)
+		})
+	})
+	handler := server.Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello", w.Body.String())
+	assert.Equal(t, []string{"first", "second"}, order)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"code":13,"message":"internal server error"}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/error", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"code":3,"message":"bad name"}`, w.Body.String())
+}
+
+func TestServer_WithHandler(t *testing.T) {
+	server := newTestServer(t, DefaultConfig().WithHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})))
+	assert.Equal(t, "/any", server.route(httptest.NewRequest(http.MethodGet, "/any", nil)))
+
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/any", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+}
+
+func TestServer_Route(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {})
+	assert.Equal(t, "/users/", server.route(httptest.NewRequest(http.MethodGet, "/users/1", nil)))
+	assert.Equal(t, "/none", server.route(httptest.NewRequest(http.MethodGet, "/none", nil)))
+}
+
+func TestServer_Serve(t *testing.T) {
+	server := newTestServer(t, DefaultConfig())
+	server.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	})
+	assert.True(t, server.Healthz())
+	assert.Equal(t, "http", server.Info().Scheme)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve()
+	}()
+
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		if resp, err = http.Get("http://" + server.listener.Addr().String() + "/hello"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, "hello", string(body))
+
+	assert.Nil(t, server.GracefulStop(context.Background()))
+	assert.Nil(t, <-served)
+}
@@ -0,0 +1,107 @@
+package oidempotency
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xqk/ox/pkg/client/redis"
+	"github.com/xqk/ox/pkg/conf"
+	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/server/oerror"
+)
+
+// ModName ..
+const ModName = "server.idempotency"
+
+// Config 幂等配置
+type Config struct {
+	// Name 名称, 不同名称和不同调用方(认证的调用方或签名校验通过的aid)的幂等key互不影响
+	Name string `json:"name" toml:"name"`
+	// Header 幂等key的请求头
+	Header string `json:"header" toml:"header"`
+	// Methods 需要幂等的请求方法
+	Methods []string `json:"methods" toml:"methods"`
+	// Required 缺少幂等key时返回400, 否则直接执行
+	Required bool `json:"required" toml:"required"`
+	// TTL 响应的保存时间, 期间使用相同key的请求返回保存的响应
+	TTL time.Duration `json:"ttl" toml:"ttl"`
+	// LockTTL 处理中状态的保存时间, 应大于handler的最长执行时间
+	LockTTL time.Duration `json:"lockTTL" toml:"lockTTL"`
+	// Wait 并发的重复请求等待首个请求完成的时间, 超时或为0时返回409
+	Wait time.Duration `json:"wait" toml:"wait"`
+	// MaxBodySize 请求体超过该大小时返回413
+	MaxBodySize int64 `json:"maxBodySize" toml:"maxBodySize"`
+	// Prefix redis key的前缀
+	Prefix string `json:"prefix" toml:"prefix"`
+
+	redis    *redis.Redis
+	contract *oerror.Contract
+	logger   *olog.Logger
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Name:        "default",
+		Header:      HeaderIdempotencyKey,
+		Methods:     []string{http.MethodPost},
+		TTL:         24 * time.Hour,
+		LockTTL:     time.Minute,
+		MaxBodySize: 1 << 20,
+		Prefix:      "ox:idempotency:",
+		contract:    oerror.Default,
+		logger:      olog.OxLogger.With(olog.FieldMod(ModName)),
+	}
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil &&
+		errors.Cause(err) != conf.ErrInvalidKey {
+		config.logger.Panic("idempotency parse config panic", olog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), olog.FieldErr(err), olog.FieldKey(key), olog.FieldValueAny(config))
+	}
+	return config
+}
+
+// WithLogger ...
+func (config *Config) WithLogger(logger *olog.Logger) *Config {
+	config.logger = logger
+	return config
+}
+
+// WithRedis 设置保存响应的redis, 必须设置
+func (config *Config) WithRedis(redis *redis.Redis) *Config {
+	config.redis = redis
+	return config
+}
+
+// WithContract 设置错误响应的约定, 应与服务的约定一致, 默认oerror.Default
+func (config *Config) WithContract(contract *oerror.Contract) *Config {
+	config.contract = contract
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Idempotency {
+	if config.redis == nil {
+		config.logger.Panic("idempotency redis not set", olog.FieldName(config.Name))
+	}
+	methods := make(map[string]bool, len(config.Methods))
+	for _, method := range config.Methods {
+		methods[method] = true
+	}
+	contract := config.contract
+	if contract == nil {
+		contract = oerror.Default
+	}
+	return &Idempotency{
+		config:   config,
+		redis:    config.redis,
+		contract: contract,
+		methods:  methods,
+		poll:     50 * time.Millisecond,
+	}
+}
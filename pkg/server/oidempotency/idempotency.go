@@ -0,0 +1,280 @@
+// Package oidempotency 基于Idempotency-Key请求头的幂等中间件
+// 首个请求的响应保存在redis中, 使用相同key的重试返回保存的响应, 并发的重复请求等待或返回409
+package oidempotency
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	goredis "github.com/go-redis/redis"
+	"github.com/xqk/ox/pkg/auth"
+	"github.com/xqk/ox/pkg/client/redis"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/requestid"
+	"github.com/xqk/ox/pkg/server/oerror"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// HeaderIdempotencyKey 幂等key的请求头
+	HeaderIdempotencyKey = "Idempotency-Key"
+	// HeaderReplayed 返回保存的响应时设置为true
+	HeaderReplayed = "Idempotent-Replayed"
+)
+
+var (
+	// completeScript 仍持有锁时保存响应
+	completeScript = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+end
+return false`)
+
+	// releaseScript 仍持有锁时删除, 以便重试
+	releaseScript = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// skipHeaders 不保存的响应头
+var skipHeaders = map[string]bool{
+	"Date":                    true,
+	"Content-Length":          true,
+	"Connection":              true,
+	"Keep-Alive":              true,
+	"Transfer-Encoding":       true,
+	"Trailer":                 true,
+	"Upgrade":                 true,
+	"Set-Cookie":              true,
+	requestid.HeaderRequestID: true,
+}
+
+// record 保存在redis中的状态, Status为0时首个请求仍在处理
+type record struct {
+	Token       string      `json:"token,omitempty"`
+	Fingerprint string      `json:"fingerprint"`
+	Status      int         `json:"status,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+}
+
+// Idempotency 幂等中间件
+type Idempotency struct {
+	config   *Config
+	redis    *redis.Redis
+	contract *oerror.Contract
+	methods  map[string]bool
+	poll     time.Duration
+}
+
+// Handler 返回幂等中间件, 可以直接用于ohttp
+func (i *Idempotency) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !i.methods[r.Method] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		idempotencyKey := r.Header.Get(i.config.Header)
+		if idempotencyKey == "" {
+			if i.config.Required {
+				i.error(w, r, codes.InvalidArgument, http.StatusBadRequest, i.config.Header+" header required")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, i.config.MaxBodySize+1))
+		if err != nil {
+			i.error(w, r, codes.InvalidArgument, http.StatusBadRequest, "read request body failed")
+			return
+		}
+		if int64(len(body)) > i.config.MaxBodySize {
+			i.error(w, r, codes.ResourceExhausted, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		// 不同调用方的幂等key互不影响
+		key := i.config.Prefix + i.config.Name + ":" + hash([]byte(scope(r)+"\n"+idempotencyKey))
+		fingerprint := hash([]byte(r.Method + "\n" + r.URL.RequestURI() + "\n" + string(body)))
+		waitUntil := time.Now().Add(i.config.Wait)
+		for {
+			processing := &record{Token: newToken(), Fingerprint: fingerprint}
+			lock, _ := json.Marshal(processing)
+			acquired, err := i.redis.Client.SetNX(key, lock, i.config.LockTTL).Result()
+			if err != nil {
+				i.config.logger.Error("idempotency lock", olog.FieldName(i.config.Name), olog.FieldKey(idempotencyKey), olog.FieldErr(err))
+				i.error(w, r, codes.Unavailable, http.StatusServiceUnavailable, "idempotency store unavailable")
+				return
+			}
+			if acquired {
+				i.execute(next, w, r, key, string(lock), fingerprint)
+				return
+			}
+
+			stored, err := i.load(key)
+			if err != nil {
+				i.config.logger.Error("idempotency load", olog.FieldName(i.config.Name), olog.FieldKey(idempotencyKey), olog.FieldErr(err))
+				i.error(w, r, codes.Unavailable, http.StatusServiceUnavailable, "idempotency store unavailable")
+				return
+			}
+			switch {
+			case stored == nil:
+				// 刚好过期或被释放, 重新获取
+				continue
+			case stored.Fingerprint != fingerprint:
+				i.error(w, r, codes.InvalidArgument, http.StatusUnprocessableEntity, i.config.Header+" reused with a different request")
+				return
+			case stored.Status != 0:
+				replay(w, stored)
+				return
+			case !time.Now().Before(waitUntil):
+				i.error(w, r, codes.Aborted, http.StatusConflict, "request with the same "+i.config.Header+" is in progress")
+				return
+			}
+
+			select {
+			case <-time.After(i.poll):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+// execute 执行首个请求, 5xx和panic时释放key以便重试, 其余响应保存TTL, 不保存Set-Cookie
+func (i *Idempotency) execute(next http.Handler, w http.ResponseWriter, r *http.Request, key, lock, fingerprint string) {
+	var completed bool
+	defer func() {
+		if !completed {
+			i.release(key, lock)
+		}
+	}()
+
+	rec := &recorder{ResponseWriter: w}
+	next.ServeHTTP(rec, r)
+	if rec.Status() >= http.StatusInternalServerError {
+		return
+	}
+
+	done := &record{
+		Fingerprint: fingerprint,
+		Status:      rec.Status(),
+		Header:      make(http.Header),
+		Body:        rec.body.Bytes(),
+	}
+	for k, v := range w.Header() {
+		if !skipHeaders[k] {
+			done.Header[k] = v
+		}
+	}
+	data, _ := json.Marshal(done)
+	err := completeScript.Run(i.redis.Client, []string{key}, lock, data, int64(i.config.TTL/time.Millisecond)).Err()
+	if err != nil && err != goredis.Nil {
+		i.config.logger.Error("idempotency complete", olog.FieldName(i.config.Name), olog.FieldKey(key), olog.FieldErr(err))
+		return
+	}
+	completed = true
+}
+
+// error 按oerror的约定写入错误响应
+func (i *Idempotency) error(w http.ResponseWriter, r *http.Request, code codes.Code, status int, message string) {
+	i.contract.Write(w, r, oerror.New(int(code), message).WithStatus(status))
+}
+
+// scope 幂等key的作用域, 依次为认证的调用方和签名校验通过的调用方aid, 未经校验的aid请求头不可信
+func scope(r *http.Request) string {
+	if principal, ok := auth.FromContext(r.Context()); ok {
+		return "principal:" + principal.Scheme + ":" + principal.Subject
+	}
+	if aid, ok := auth.CallerFromContext(r.Context()); ok {
+		return "aid:" + aid
+	}
+	return ""
+}
+
+func (i *Idempotency) release(key, lock string) {
+	if err := releaseScript.Run(i.redis.Client, []string{key}, lock).Err(); err != nil {
+		i.config.logger.Error("idempotency release", olog.FieldName(i.config.Name), olog.FieldKey(key), olog.FieldErr(err))
+	}
+}
+
+func (i *Idempotency) load(key string) (*record, error) {
+	data, err := i.redis.Client.Get(key).Bytes()
+	if err == goredis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored record
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+func replay(w http.ResponseWriter, stored *record) {
+	h := w.Header()
+	for k, v := range stored.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set(HeaderReplayed, "true")
+	h.Set("Content-Length", strconv.Itoa(len(stored.Body)))
+	w.WriteHeader(stored.Status)
+	_, _ = w.Write(stored.Body)
+}
+
+// recorder 记录写入的响应
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// Status returns status code, 200 if not written
+func (rec *recorder) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// WriteHeader ...
+func (rec *recorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write ...
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+func newToken() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func hash(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
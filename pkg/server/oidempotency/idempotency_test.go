@@ -0,0 +1,190 @@
+package oidempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/auth"
+	"github.com/xqk/ox/pkg/client/redis"
+)
+
+func newIdempotency(t *testing.T, setup func(config *Config)) *Idempotency {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+	redisConfig := redis.DefaultRedisConfig()
+	redisConfig.Addrs = []string{mr.Addr()}
+	redisConfig.Mode = redis.StubMode
+
+	config := DefaultConfig().WithRedis(redisConfig.Build())
+	if setup != nil {
+		setup(config)
+	}
+	return config.Build()
+}
+
+func post(h http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotency(t *testing.T) {
+	var calls int32
+	h := newIdempotency(t, nil).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("Location", "/orders/"+strconv.Itoa(int(n)))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(strconv.Itoa(int(n))))
+	}))
+
+	w := post(h, "k1", "a")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "1", w.Body.String())
+	assert.Equal(t, "", w.Header().Get(HeaderReplayed))
+
+	// 重试返回保存的响应
+	w = post(h, "k1", "a")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "1", w.Body.String())
+	assert.Equal(t, "/orders/1", w.Header().Get("Location"))
+	assert.Equal(t, "true", w.Header().Get(HeaderReplayed))
+
+	// 相同key不同请求体
+	assert.Equal(t, http.StatusUnprocessableEntity, post(h, "k1", "b").Code)
+
+	// 不同key或没有key时执行
+	assert.Equal(t, "2", post(h, "k2", "a").Body.String())
+	assert.Equal(t, "3", post(h, "", "a").Body.String())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestIdempotency_Required(t *testing.T) {
+	h := newIdempotency(t, func(config *Config) {
+		config.Required = true
+		config.MaxBodySize = 4
+	}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	assert.Equal(t, http.StatusBadRequest, post(h, "", "a").Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(h, "k", "abcde").Code)
+}
+
+func TestIdempotency_ServerError(t *testing.T) {
+	var calls int32
+	h := newIdempotency(t, nil).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+
+	// 5xx不保存, 可以重试
+	assert.Equal(t, http.StatusInternalServerError, post(h, "k", "a").Code)
+	assert.Equal(t, "ok", post(h, "k", "a").Body.String())
+	assert.Equal(t, "true", post(h, "k", "a").Header().Get(HeaderReplayed))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestIdempotency_Concurrent(t *testing.T) {
+	for _, wait := range []time.Duration{0, time.Second} {
+		wait := wait
+		var calls int32
+		started := make(chan struct{})
+		release := make(chan struct{})
+		h := newIdempotency(t, func(config *Config) {
+			config.Wait = wait
+		}).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			close(started)
+			<-release
+			w.Write([]byte("ok"))
+		}))
+
+		first := make(chan *httptest.ResponseRecorder)
+		go func() {
+			first <- post(h, "k", "a")
+		}()
+		<-started
+
+		if wait == 0 {
+			assert.Equal(t, http.StatusConflict, post(h, "k", "a").Code)
+			close(release)
+		} else {
+			// 等待首个请求完成后重放
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				close(release)
+			}()
+			w := post(h, "k", "a")
+			assert.Equal(t, "ok", w.Body.String())
+			assert.Equal(t, "true", w.Header().Get(HeaderReplayed))
+		}
+		assert.Equal(t, "ok", (<-first).Body.String())
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	}
+}
+
+func TestIdempotency_Scope(t *testing.T) {
+	var calls int32
+	h := newIdempotency(t, nil).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: strconv.Itoa(int(n))})
+		w.Write([]byte(strconv.Itoa(int(n))))
+	}))
+	postAs := func(aid string, ctx context.Context) *httptest.ResponseRecorder {
+		if aid != "" {
+			ctx = auth.NewCallerContext(ctx, aid)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("a")).WithContext(ctx)
+		req.Header.Set(HeaderIdempotencyKey, "k")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, "1", postAs("app1", context.Background()).Body.String())
+	// 其他调用方使用相同的key和请求体时不返回保存的响应
+	assert.Equal(t, "2", postAs("app2", context.Background()).Body.String())
+	alice := auth.NewContext(context.Background(), &auth.Principal{Subject: "alice", Scheme: auth.SchemeHMAC})
+	assert.Equal(t, "3", postAs("app1", alice).Body.String())
+
+	// 重放的响应不包括Set-Cookie
+	w := postAs("app1", context.Background())
+	assert.Equal(t, "1", w.Body.String())
+	assert.Equal(t, "true", w.Header().Get(HeaderReplayed))
+	assert.Empty(t, w.Header().Get("Set-Cookie"))
+
+	// 未经校验的aid请求头不区分作用域
+	assert.Equal(t, "4", postAs("", context.Background()).Body.String())
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("a"))
+	req.Header.Set(HeaderIdempotencyKey, "k")
+	req.Header.Set(auth.HeaderAID, "app3")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, "4", w.Body.String())
+	assert.Equal(t, "true", w.Header().Get(HeaderReplayed))
+}
+
+func TestIdempotency_Error(t *testing.T) {
+	h := newIdempotency(t, nil).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	post(h, "k", "a")
+	w := post(h, "k", "b")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"code":3,"message":"Idempotency-Key reused with a different request"}`, w.Body.String())
+}
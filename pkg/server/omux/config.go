@@ -0,0 +1,95 @@
+package omux
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xqk/ox/pkg/conf"
+	"github.com/xqk/ox/pkg/constant"
+	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/flag"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/util/onet"
+	"github.com/xqk/ox/pkg/util/otls"
+)
+
+// ModName ..
+const ModName = "server.mux"
+
+// Config 共享监听端口的grpc和http服务配置
+type Config struct {
+	Host       string `json:"host" toml:"host"`
+	Port       int    `json:"port" toml:"port"`
+	Deployment string `json:"deployment" toml:"deployment"`
+	// Network network type, tcp4 by default.
+	// 为unix时Host为socket文件路径, 以"@"开头时为abstract socket
+	Network string `json:"network" toml:"network"`
+	// SocketMode unix socket文件的权限, 为0时不修改
+	SocketMode os.FileMode `json:"socketMode" toml:"socketMode"`
+	// ServiceAddress service address in registry info, default to 'Host:Port'
+	ServiceAddress string `json:"serviceAddress" toml:"serviceAddress"`
+	// MatchTimeout 识别连接协议的超时时间, 默认5s
+	MatchTimeout time.Duration `json:"matchTimeout" toml:"matchTimeout"`
+	// TLS 在共享端口上终止TLS, 按ALPN和HTTP/2的content-type分发连接, 为空时使用明文传输.
+	// 开启后grpc和http服务本身不应再配置TLS
+	TLS *otls.Config `json:"tls" toml:"tls"`
+
+	logger *olog.Logger
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Network:      "tcp4",
+		Host:         flag.String("host"),
+		Port:         9090,
+		Deployment:   constant.DefaultDeployment,
+		MatchTimeout: 5 * time.Second,
+		logger:       olog.OxLogger.With(olog.FieldMod(ModName)),
+	}
+}
+
+// StdConfig ...
+func StdConfig(name string) *Config {
+	return RawConfig("ox.server." + name)
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil &&
+		errors.Cause(err) != conf.ErrInvalidKey {
+		config.logger.Panic("mux server parse config panic", olog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), olog.FieldErr(err), olog.FieldKey(key), olog.FieldValueAny(config))
+	}
+	return config
+}
+
+// WithLogger ...
+func (config *Config) WithLogger(logger *olog.Logger) *Config {
+	config.logger = logger
+	return config
+}
+
+// MustBuild ...
+func (config *Config) MustBuild() *Server {
+	server, err := config.Build()
+	if err != nil {
+		olog.Panicf("build mux server: %v", err)
+	}
+	return server
+}
+
+// Build listens on Address, grpc and http servers are built with GRPCListener and HTTPListener
+func (config *Config) Build() (*Server, error) {
+	return newServer(config)
+}
+
+// Address ...
+func (config *Config) Address() string {
+	if onet.IsUnixNetwork(config.Network) {
+		return config.Host
+	}
+	return fmt.Sprintf("%s:%d", config.Host, config.Port)
+}
@@ -0,0 +1,151 @@
+package omux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// listener 分发到grpc或http服务的虚拟监听
+type listener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newListener(addr net.Addr) *listener {
+	return &listener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Accept implements net.Listener
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close implements net.Listener, the shared listener is not closed
+func (l *listener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+// Addr implements net.Listener
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *listener) push(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// conn 重放识别协议时已读取的数据
+type conn struct {
+	net.Conn
+	reader io.Reader
+}
+
+// Read implements net.Conn
+func (c *conn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+const (
+	protoHTTP1 = iota
+	protoHTTP2
+	protoGRPC
+)
+
+// match reads the client preface and the first HEADERS frame of HTTP/2 connection,
+// returns protocol by content-type and data read.
+// grpc客户端收到服务端的SETTINGS后才会发送请求, 所以读取到preface后先发送一个空的SETTINGS
+func match(c net.Conn) (int, []byte, error) {
+	var buf bytes.Buffer
+	var r = io.TeeReader(c, &buf)
+	var preface = []byte(http2.ClientPreface)
+
+	// 逐段读取, 短于preface的HTTP/1请求不会阻塞到超时
+	var b = make([]byte, len(preface))
+	for buf.Len() < len(preface) {
+		n, err := r.Read(b[:len(preface)-buf.Len()])
+		if n > 0 && !bytes.HasPrefix(preface, buf.Bytes()) {
+			return protoHTTP1, buf.Bytes(), nil
+		}
+		if err != nil {
+			return protoHTTP1, buf.Bytes(), err
+		}
+	}
+
+	framer := http2.NewFramer(c, r)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+	if err := framer.WriteSettings(); err != nil {
+		return protoHTTP2, buf.Bytes(), err
+	}
+	for {
+		f, err := framer.ReadFrame()
+		if err != nil {
+			return protoHTTP2, buf.Bytes(), err
+		}
+		if headers, ok := f.(*http2.MetaHeadersFrame); ok {
+			for _, field := range headers.Fields {
+				if field.Name == "content-type" && strings.HasPrefix(field.Value, "application/grpc") {
+					return protoGRPC, buf.Bytes(), nil
+				}
+			}
+			return protoHTTP2, buf.Bytes(), nil
+		}
+	}
+}
+
+// ackFilter 丢弃客户端对match发送的SETTINGS的确认, 避免服务端收到多余的确认
+type ackFilter struct {
+	r       io.Reader
+	pending []byte
+	done    bool
+}
+
+// Read implements io.Reader
+func (f *ackFilter) Read(b []byte) (int, error) {
+	for len(f.pending) == 0 && !f.done {
+		var header [9]byte
+		if _, err := io.ReadFull(f.r, header[:]); err != nil {
+			return 0, err
+		}
+		length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+		frame := make([]byte, 9+length)
+		copy(frame, header[:])
+		if _, err := io.ReadFull(f.r, frame[9:]); err != nil {
+			return 0, err
+		}
+		if http2.FrameType(header[3]) == http2.FrameSettings && http2.Flags(header[4]).Has(http2.FlagSettingsAck) {
+			f.done = true
+			continue
+		}
+		f.pending = frame
+	}
+	if len(f.pending) > 0 {
+		n := copy(b, f.pending)
+		f.pending = f.pending[n:]
+		return n, nil
+	}
+	return f.r.Read(b)
+}
@@ -0,0 +1,272 @@
+package omux
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/xqk/ox/pkg/constant"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/server"
+	"github.com/xqk/ox/pkg/util/onet"
+	"github.com/xqk/ox/pkg/util/otls"
+	"golang.org/x/net/http2"
+	"golang.org/x/sync/errgroup"
+)
+
+// Server 在同一个监听端口上同时提供grpc和http服务:
+// HTTP/2且content-type为application/grpc的连接交给grpc服务, 其余连接交给http服务
+type Server struct {
+	config    *Config
+	listener  net.Listener
+	tlsLoader *otls.Loader
+	tlsConfig *tls.Config
+	grpcL     *listener
+	httpL     *listener
+	grpc      server.Server
+	http      server.Server
+	serving   int32
+	closing   int32
+}
+
+func newServer(config *Config) (*Server, error) {
+	s := &Server{config: config}
+	if config.TLS != nil {
+		loader, err := otls.NewLoader(config.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("create mux server failed: %v", err)
+		}
+		tlsConfig, err := loader.ServerConfig()
+		if err != nil {
+			loader.Close()
+			return nil, fmt.Errorf("create mux server failed: %v", err)
+		}
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+		s.tlsLoader, s.tlsConfig = loader, tlsConfig
+	}
+
+	l, err := onet.Listen(config.Network, config.Address(), config.SocketMode)
+	if err != nil {
+		s.closeTLSLoader()
+		return nil, fmt.Errorf("create mux server failed: %v", err)
+	}
+	if port := onet.Port(l.Addr()); port != 0 {
+		config.Port = port
+	}
+	s.listener = l
+	s.grpcL = newListener(l.Addr())
+	s.httpL = newListener(l.Addr())
+	return s, nil
+}
+
+// GRPCListener returns listener for grpc server, see ogrpc.Config.WithListener
+func (s *Server) GRPCListener() net.Listener {
+	return s.grpcL
+}
+
+// HTTPListener returns listener for http server, see ogin.Config.WithListener and oecho.Config.WithListener
+func (s *Server) HTTPListener() net.Listener {
+	return s.httpL
+}
+
+// Mount sets grpc and http servers built with GRPCListener and HTTPListener, either can be nil
+func (s *Server) Mount(grpc server.Server, http server.Server) *Server {
+	s.grpc = grpc
+	s.http = http
+	return s
+}
+
+// Serve implements server.Server interface.
+func (s *Server) Serve() error {
+	var eg errgroup.Group
+	for _, srv := range s.servers() {
+		srv := srv
+		eg.Go(func() error {
+			err := srv.Serve()
+			// 任意一个服务异常退出时停止所有服务
+			if err != nil && atomic.LoadInt32(&s.closing) == 0 {
+				s.config.logger.Error("serve failed", olog.FieldErr(err), olog.FieldAddr(srv.Info().Label()))
+				_ = s.Stop()
+			}
+			return err
+		})
+	}
+	eg.Go(func() error {
+		err := s.accept()
+		// accept异常退出时停止所有服务, 否则eg.Wait一直阻塞
+		if err != nil {
+			s.config.logger.Error("accept failed", olog.FieldErr(err), olog.FieldAddr(s.listener.Addr().String()))
+			_ = s.Stop()
+		}
+		return err
+	})
+	return eg.Wait()
+}
+
+// Stop implements server.Server interface
+// it will terminate all servers immediately
+func (s *Server) Stop() error {
+	s.close()
+	for _, srv := range s.servers() {
+		_ = srv.Stop()
+	}
+	s.grpcL.Close()
+	s.httpL.Close()
+	s.closeTLSLoader()
+	return nil
+}
+
+// GracefulStop implements server.Server interface
+// it stops accepting connections, then waits for grpc and http servers to drain
+func (s *Server) GracefulStop(ctx context.Context) error {
+	s.close()
+	var eg errgroup.Group
+	for _, srv := range s.servers() {
+		srv := srv
+		eg.Go(func() error {
+			return srv.GracefulStop(ctx)
+		})
+	}
+	err := eg.Wait()
+	s.grpcL.Close()
+	s.httpL.Close()
+	s.closeTLSLoader()
+	return err
+}
+
+// Info returns server info, scheme is grpc if grpc server is mounted,
+// schemes of all mounted servers are in metadata
+func (s *Server) Info() *server.ServiceInfo {
+	serviceAddress := s.listener.Addr().String()
+	if s.config.ServiceAddress != "" {
+		serviceAddress = s.config.ServiceAddress
+	}
+
+	var schemes []string
+	if s.grpc != nil {
+		schemes = append(schemes, "grpc")
+	}
+	if s.http != nil {
+		schemes = append(schemes, "http")
+	}
+	if len(schemes) == 0 {
+		schemes = append(schemes, "http")
+	}
+	info := server.ApplyOptions(
+		server.WithScheme(schemes[0]),
+		server.WithAddress(serviceAddress),
+		server.WithKind(constant.ServiceProvider),
+		server.WithMetaData("schemes", strings.Join(schemes, ",")),
+	)
+	if s.config.TLS != nil {
+		info.Metadata["tls"] = "true"
+	}
+	return &info
+}
+
+// Healthz reports whether the server is accepting connections
+func (s *Server) Healthz() bool {
+	return atomic.LoadInt32(&s.serving) == 1
+}
+
+func (s *Server) servers() []server.Server {
+	var servers []server.Server
+	if s.grpc != nil {
+		servers = append(servers, s.grpc)
+	}
+	if s.http != nil {
+		servers = append(servers, s.http)
+	}
+	return servers
+}
+
+func (s *Server) close() {
+	atomic.StoreInt32(&s.closing, 1)
+	atomic.StoreInt32(&s.serving, 0)
+	_ = s.listener.Close()
+}
+
+func (s *Server) closeTLSLoader() {
+	if s.tlsLoader != nil {
+		_ = s.tlsLoader.Close()
+	}
+}
+
+func (s *Server) accept() error {
+	atomic.StoreInt32(&s.serving, 1)
+	defer atomic.StoreInt32(&s.serving, 0)
+
+	var tempDelay time.Duration
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			if atomic.LoadInt32(&s.closing) == 1 {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else if tempDelay *= 2; tempDelay > time.Second {
+					tempDelay = time.Second
+				}
+				time.Sleep(tempDelay)
+				continue
+			}
+			return err
+		}
+		tempDelay = 0
+		go s.route(c)
+	}
+}
+
+// route dispatches connection by ALPN and content-type of HTTP/2
+func (s *Server) route(c net.Conn) {
+	_ = c.SetDeadline(time.Now().Add(s.config.MatchTimeout))
+	var rw = c
+	var proto string
+	if s.tlsConfig != nil {
+		tc := tls.Server(c, s.tlsConfig)
+		if err := tc.Handshake(); err != nil {
+			s.config.logger.Debug("tls handshake failed", olog.FieldAddr(c.RemoteAddr().String()), olog.FieldErr(err))
+			c.Close()
+			return
+		}
+		proto = tc.ConnectionState().NegotiatedProtocol
+		rw = tc
+	}
+
+	var target = s.httpL
+	var reader io.Reader = rw
+	if proto != "http/1.1" {
+		protocol, read, err := match(rw)
+		if err != nil {
+			s.config.logger.Debug("match protocol failed", olog.FieldAddr(c.RemoteAddr().String()), olog.FieldErr(err))
+			rw.Close()
+			return
+		}
+		if protocol == protoGRPC {
+			target = s.grpcL
+		}
+		reader = io.MultiReader(bytes.NewReader(read), rw)
+		if protocol != protoHTTP1 {
+			n := len(http2.ClientPreface)
+			reader = io.MultiReader(bytes.NewReader(read[:n]), &ackFilter{r: io.MultiReader(bytes.NewReader(read[n:]), rw)})
+		}
+	}
+	if (target == s.grpcL && s.grpc == nil) || (target == s.httpL && s.http == nil) {
+		rw.Close()
+		return
+	}
+
+	_ = c.SetDeadline(time.Time{})
+	target.push(&conn{
+		Conn:   rw,
+		reader: reader,
+	})
+}
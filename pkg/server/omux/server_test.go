@@ -0,0 +1,139 @@
+package omux
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/server/ogin"
+	"github.com/xqk/ox/pkg/server/ogrpc"
+	"github.com/xqk/ox/pkg/util/otest/proto/testproto"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+)
+
+type greeter struct{}
+
+func (greeter) SayHello(ctx context.Context, req *testproto.HelloRequest) (*testproto.HelloReply, error) {
+	return &testproto.HelloReply{Message: "hello " + req.Name}, nil
+}
+
+func (greeter) WhoServer(ctx context.Context, req *testproto.WhoServerReq) (*testproto.WhoServerReply, error) {
+	return &testproto.WhoServerReply{}, nil
+}
+
+func (greeter) StreamHello(testproto.Greeter_StreamHelloServer) error {
+	return nil
+}
+
+func TestServer(t *testing.T) {
+	config := DefaultConfig()
+	config.Host = "127.0.0.1"
+	config.Port = 0
+	mux, err := config.Build()
+	assert.Nil(t, err)
+
+	grpcConfig := ogrpc.DefaultConfig()
+	grpcServer := grpcConfig.WithListener(mux.GRPCListener()).MustBuild()
+	testproto.RegisterGreeterServer(grpcServer.Server, greeter{})
+
+	httpConfig := ogin.DefaultConfig()
+	httpServer := httpConfig.WithListener(mux.HTTPListener()).Build()
+	httpServer.GET("/hello", func(c *gin.Context) {
+		c.String(http.StatusOK, "hello "+c.Request.Proto)
+	})
+
+	mux.Mount(grpcServer, httpServer)
+	info := mux.Info()
+	assert.Equal(t, "grpc", info.Scheme)
+	assert.Equal(t, "grpc,http", info.Metadata["schemes"])
+
+	served := make(chan error, 1)
+	go func() {
+		served <- mux.Serve()
+	}()
+	assert.Eventually(t, mux.Healthz, time.Second, 10*time.Millisecond)
+	addr := mux.listener.Addr().String()
+
+	t.Run("grpc", func(t *testing.T) {
+		cc, err := grpc.Dial(addr, grpc.WithInsecure())
+		assert.Nil(t, err)
+		defer cc.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		reply, err := testproto.NewGreeterClient(cc).SayHello(ctx, &testproto.HelloRequest{Name: "ox"})
+		assert.Nil(t, err)
+		assert.Equal(t, "hello ox", reply.Message)
+	})
+
+	t.Run("http1", func(t *testing.T) {
+		resp, err := http.Get("http://" + addr + "/hello")
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		bs, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, "hello HTTP/1.1", string(bs))
+	})
+
+	t.Run("h2c", func(t *testing.T) {
+		client := http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		}}
+		resp, err := client.Get("http://" + addr + "/hello")
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		bs, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, "hello HTTP/2.0", string(bs))
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, mux.GracefulStop(ctx))
+	select {
+	case err := <-served:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("serve should return after graceful stop")
+	}
+	assert.False(t, mux.Healthz())
+}
+
+type brokenListener struct {
+	net.Listener
+}
+
+func (l brokenListener) Accept() (net.Conn, error) {
+	return nil, errors.New("accept broken")
+}
+
+func TestServer_AcceptError(t *testing.T) {
+	config := DefaultConfig()
+	config.Host = "127.0.0.1"
+	config.Port = 0
+	mux, err := config.Build()
+	assert.Nil(t, err)
+	grpcServer := ogrpc.DefaultConfig().WithListener(mux.GRPCListener()).MustBuild()
+	mux.Mount(grpcServer, nil)
+	mux.listener = brokenListener{mux.listener}
+
+	// accept失败时停止所有服务并返回错误
+	served := make(chan error, 1)
+	go func() {
+		served <- mux.Serve()
+	}()
+	select {
+	case err := <-served:
+		assert.NotNil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("serve should return after accept failed")
+	}
+}
@@ -0,0 +1,85 @@
+package oopenapi
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"sort"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/xqk/ox/pkg/governor"
+)
+
+var (
+	documents sync.Map
+	// uiFS 内嵌的文档页面和静态资源, 不从外部加载
+	//go:embed ui
+	uiFS       embed.FS
+	uiTemplate = template.Must(template.ParseFS(uiFS, "ui/index.html"))
+)
+
+// Register 注册文档, 通过governor查看, name重复时覆盖
+func Register(name string, document func() *Document) {
+	documents.Store(name, document)
+}
+
+// Deregister ...
+func Deregister(name string) {
+	documents.Delete(name)
+}
+
+// Names 返回已注册文档的名称
+func Names() []string {
+	var names []string
+	documents.Range(func(key, _ interface{}) bool {
+		names = append(names, key.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// Get 返回指定名称的文档
+func Get(name string) (*Document, bool) {
+	document, ok := documents.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return document.(func() *Document)(), true
+}
+
+func init() {
+	governor.HandleFunc("/debug/openapi", func(w http.ResponseWriter, r *http.Request) {
+		_ = jsoniter.NewEncoder(w).Encode(Names())
+	})
+	governor.HandleFunc("/debug/openapi/spec", func(w http.ResponseWriter, r *http.Request) {
+		document, ok := Get(r.URL.Query().Get("name"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = jsoniter.NewEncoder(w).Encode(document)
+	})
+	// 文档页面, /debug/openapi/ui/?name=xxx
+	static, _ := fs.Sub(uiFS, "ui")
+	assets := http.StripPrefix("/debug/openapi/ui/", http.FileServer(http.FS(static)))
+	governor.HandleFunc("/debug/openapi/ui/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/debug/openapi/ui/" {
+			assets.ServeHTTP(w, r)
+			return
+		}
+		name := r.URL.Query().Get("name")
+		if _, ok := documents.Load(name); !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = uiTemplate.Execute(w, map[string]string{
+			"Name": name,
+			"URL":  "../spec?name=" + template.URLQueryEscaper(name),
+		})
+	})
+}
@@ -0,0 +1,140 @@
+package oopenapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/governor"
+	"github.com/xqk/ox/pkg/util/otest/proto/testproto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+type page struct {
+	Page int `form:"page" doc:"页码"`
+	Size int `form:"size" binding:"required"`
+}
+
+type address struct {
+	City string `json:"city"`
+}
+
+type user struct {
+	ID        int64                  `json:"id,string"`
+	Name      string                 `json:"name" binding:"required" doc:"用户名"`
+	Tags      []string               `json:"tags,omitempty"`
+	Address   *address               `json:"address"`
+	Extra     map[string]interface{} `json:"extra"`
+	CreatedAt time.Time              `json:"createdAt"`
+	password  string
+}
+
+func TestSpec_Struct(t *testing.T) {
+	spec := NewSpec()
+	spec.Add(http.MethodGet, "/users/:id", &API{Summary: "get user", Request: page{}, Response: user{}})
+	spec.Add(http.MethodPost, "/users", &API{Request: &user{}, Response: user{}})
+
+	doc := spec.Document(Info{Title: "test"}, []Route{
+		{Method: http.MethodGet, Path: "/users/:id"},
+		{Method: http.MethodPost, Path: "/users"},
+		{Method: http.MethodGet, Path: "/static/*filepath"},
+	})
+	assert.Equal(t, Version, doc.OpenAPI)
+
+	get := doc.Paths["/users/{id}"]["get"]
+	assert.Equal(t, "get user", get.Summary)
+	assert.Nil(t, get.RequestBody)
+	assert.Equal(t, []*Parameter{
+		{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "string"}},
+		{Name: "page", In: "query", Description: "页码", Schema: &Schema{Type: "integer", Format: "int64"}},
+		{Name: "size", In: "query", Required: true, Schema: &Schema{Type: "integer", Format: "int64"}},
+	}, get.Parameters)
+	assert.Equal(t, refPrefix+"user", get.Responses["200"].Content["application/json"].Schema.Ref)
+	assert.Equal(t, refPrefix+"Error", get.Responses["default"].Content["application/json"].Schema.Ref)
+
+	post := doc.Paths["/users"]["post"]
+	assert.Equal(t, refPrefix+"user", post.RequestBody.Content["application/json"].Schema.Ref)
+	assert.Equal(t, []string{"filepath"}, []string{doc.Paths["/static/{filepath}"]["get"].Parameters[0].Name})
+
+	s := doc.Components.Schemas["user"]
+	assert.Equal(t, []string{"name"}, s.Required)
+	assert.Len(t, s.Properties, 6)
+	assert.Equal(t, &Schema{Type: "string"}, s.Properties["id"])
+	assert.Equal(t, &Schema{Type: "string", Description: "用户名"}, s.Properties["name"])
+	assert.Equal(t, &Schema{Type: "array", Items: &Schema{Type: "string"}}, s.Properties["tags"])
+	assert.Equal(t, refPrefix+"address", s.Properties["address"].Ref)
+	assert.Equal(t, &Schema{Type: "object", AdditionalProperties: &Schema{}}, s.Properties["extra"])
+	assert.Equal(t, &Schema{Type: "string", Format: "date-time"}, s.Properties["createdAt"])
+	assert.Contains(t, doc.Components.Schemas, "address")
+	assert.Contains(t, doc.Components.Schemas["Error"].Properties, "traceId")
+}
+
+func TestSpec_Proto(t *testing.T) {
+	spec := NewSpec()
+	spec.Add(http.MethodPost, "/hello", &API{Request: AsStruct(&testproto.HelloRequest{}), Response: &testproto.HelloReply{}})
+	spec.Add(http.MethodGet, "/v1/fields/:p2", &API{
+		Path:       "/v1/{name=fields/*}",
+		Query:      (&descriptorpb.FieldDescriptorProto{}).ProtoReflect().Descriptor(),
+		Response:   &descriptorpb.FieldDescriptorProto{},
+		ProtoNames: true,
+	})
+
+	doc := spec.Document(Info{Title: "test"}, []Route{
+		{Method: http.MethodPost, Path: "/hello"},
+		{Method: http.MethodGet, Path: "/v1/fields/:p2"},
+	})
+
+	// 请求按结构体生成, 忽略XXX_字段
+	req := doc.Components.Schemas["HelloRequest"]
+	assert.Equal(t, map[string]*Schema{"name": {Type: "string"}}, req.Properties)
+
+	reply := doc.Components.Schemas["testproto.HelloReply"]
+	assert.Equal(t, &Schema{Type: "string", Format: "int64"}, reply.Properties["id64"])
+	assert.Equal(t, &Schema{Type: "integer", Format: "int32"}, reply.Properties["id32"])
+	assert.Equal(t, &Schema{Type: "string", Format: "byte"}, reply.Properties["name"])
+
+	get := doc.Paths["/v1/{name}"]["get"]
+	assert.Equal(t, "name", get.Parameters[0].Name)
+	assert.Equal(t, "path", get.Parameters[0].In)
+	var query = make(map[string]*Schema)
+	for _, p := range get.Parameters[1:] {
+		query[p.Name] = p.Schema
+	}
+	assert.Contains(t, query, "json_name")
+	assert.NotContains(t, query, "options")
+	assert.Contains(t, query["type"].Enum, "TYPE_STRING")
+
+	field := doc.Components.Schemas["google.protobuf.FieldDescriptorProto_ProtoNames"]
+	assert.Contains(t, field.Properties, "json_name")
+	assert.Contains(t, field.Properties, "oneof_index")
+}
+
+func TestConvertTemplate(t *testing.T) {
+	path, params := convertTemplate("/v1/{parent=shelves/*}/books/{book.id}:publish")
+	assert.Equal(t, "/v1/{parent}/books/{book.id}:publish", path)
+	assert.Equal(t, []string{"parent", "book.id"}, params)
+}
+
+func TestUI(t *testing.T) {
+	Register("test", func() *Document { return NewSpec().Document(Info{Title: "test"}, nil) })
+	defer Deregister("test")
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		governor.DefaultServeMux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+
+	w := get("/debug/openapi/ui/?name=test")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"../spec?name=test"`)
+	assert.NotContains(t, w.Body.String(), "https://")
+	assert.Equal(t, http.StatusNotFound, get("/debug/openapi/ui/?name=none").Code)
+
+	// 内嵌的静态资源
+	w = get("/debug/openapi/ui/ui.js")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "specURL")
+	assert.Equal(t, http.StatusOK, get("/debug/openapi/ui/ui.css").Code)
+}
@@ -0,0 +1,399 @@
+package oopenapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	protov1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const refPrefix = "#/components/schemas/"
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// goStruct 按encoding/json的规则生成schema
+type goStruct struct {
+	v interface{}
+}
+
+// AsStruct 按encoding/json的规则生成proto message的schema, 用于echo.Bind绑定的请求
+func AsStruct(v interface{}) interface{} {
+	return goStruct{v: v}
+}
+
+// protoField proto message的字段
+type protoField struct {
+	fd protoreflect.FieldDescriptor
+}
+
+// ProtoField 使用proto message字段的schema, 用于google.api.http中body为字段名的请求
+func ProtoField(fd protoreflect.FieldDescriptor) interface{} {
+	return protoField{fd: fd}
+}
+
+// generator 生成schema, 结构体和proto message放入components
+type generator struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+	owners  map[string]reflect.Type
+	// protoNames 当前接口的proto message使用proto字段名
+	protoNames bool
+}
+
+func newGenerator() *generator {
+	return &generator{
+		schemas: make(map[string]*Schema),
+		names:   make(map[reflect.Type]string),
+		owners:  make(map[string]reflect.Type),
+	}
+}
+
+// schemaOf 支持*Schema, 结构体, proto message和protoreflect.MessageDescriptor
+func (g *generator) schemaOf(v interface{}) *Schema {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case *Schema:
+		return v
+	case goStruct:
+		return g.goSchema(reflect.TypeOf(v.v))
+	case protoField:
+		return g.protoField(v.fd)
+	case protoreflect.MessageDescriptor:
+		return g.protoMessage(v)
+	case protoreflect.ProtoMessage:
+		return g.protoMessage(v.ProtoReflect().Descriptor())
+	case protov1.Message:
+		return g.protoMessage(protov1.MessageV2(v).ProtoReflect().Descriptor())
+	}
+	return g.goSchema(reflect.TypeOf(v))
+}
+
+func (g *generator) goSchema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		return &Schema{}
+	case t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType):
+		return &Schema{}
+	case t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.goSchema(t.Elem())}
+	case reflect.Array:
+		return &Schema{Type: "array", Items: g.goSchema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.goSchema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.goStruct(t)
+		}
+		return g.goRef(t)
+	default:
+		return &Schema{}
+	}
+}
+
+// goRef 具名结构体放入components, 重名时使用包路径区分
+func (g *generator) goRef(t reflect.Type) *Schema {
+	name, ok := g.names[t]
+	if !ok {
+		name = t.Name()
+		if owner, ok := g.owners[name]; ok && owner != t {
+			name = strings.Replace(t.PkgPath(), "/", ".", -1) + "." + t.Name()
+		}
+		g.names[t] = name
+		g.owners[name] = t
+		// 先占位, 支持递归的结构体
+		g.schemas[name] = &Schema{}
+		*g.schemas[name] = *g.goStruct(t)
+	}
+	return &Schema{Ref: refPrefix + name}
+}
+
+func (g *generator) goStruct(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	g.goFields(t, s)
+	return s
+}
+
+func (g *generator) goFields(t reflect.Type, s *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts := parseTag(field.Tag.Get("json"))
+		if name == "-" && opts == "" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.goFields(ft, s)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		var fs *Schema
+		if strings.Contains(opts, "string") {
+			fs = &Schema{Type: "string"}
+		} else {
+			fs = g.goSchema(field.Type)
+		}
+		if doc := field.Tag.Get("doc"); doc != "" {
+			fs = describe(fs, doc)
+		}
+		s.Properties[name] = fs
+		if required(field) {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// queryParameters 结构体的字段作为query参数, 名称依次使用form, query标签和字段名, 与gin和echo的绑定一致
+func (g *generator) queryParameters(v interface{}) []*Parameter {
+	switch m := v.(type) {
+	case nil:
+		return nil
+	case goStruct:
+		v = m.v
+	case protoreflect.MessageDescriptor:
+		return g.protoParameters(m)
+	case protoreflect.ProtoMessage:
+		return g.protoParameters(m.ProtoReflect().Descriptor())
+	case protov1.Message:
+		return g.protoParameters(protov1.MessageV2(m).ProtoReflect().Descriptor())
+	}
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var params []*Parameter
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+			continue
+		}
+		name, _ := parseTag(field.Tag.Get("form"))
+		if name == "" {
+			name, _ = parseTag(field.Tag.Get("query"))
+		}
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		params = append(params, &Parameter{
+			Name:        name,
+			In:          "query",
+			Description: field.Tag.Get("doc"),
+			Required:    required(field),
+			Schema:      g.goSchema(field.Type),
+		})
+	}
+	return params
+}
+
+// protoParameters 非message类型的顶层字段作为查询参数, 与gateway的解析一致
+func (g *generator) protoParameters(md protoreflect.MessageDescriptor) []*Parameter {
+	var params []*Parameter
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.IsMap() || fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+			continue
+		}
+		params = append(params, &Parameter{
+			Name:   g.protoName(fd),
+			In:     "query",
+			Schema: g.protoField(fd),
+		})
+	}
+	return params
+}
+
+func parseTag(tag string) (string, string) {
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, ""
+}
+
+// required 由gin的binding标签或validator的validate标签确定
+func required(field reflect.StructField) bool {
+	for _, key := range []string{"binding", "validate"} {
+		for _, rule := range strings.Split(field.Tag.Get(key), ",") {
+			if rule == "required" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// describe 设置字段说明, $ref不能有兄弟字段, 引用的schema不设置
+func describe(s *Schema, doc string) *Schema {
+	if s.Ref != "" {
+		return s
+	}
+	ns := *s
+	ns.Description = doc
+	return &ns
+}
+
+// protoMessage 按protojson的规则生成schema, 使用proto字段名时放入不同的component
+func (g *generator) protoMessage(md protoreflect.MessageDescriptor) *Schema {
+	name := string(md.FullName())
+	if s, ok := wellKnownSchema(name); ok {
+		return s
+	}
+	if g.protoNames {
+		name += "_ProtoNames"
+	}
+	if _, ok := g.schemas[name]; !ok {
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		g.schemas[name] = s
+		fields := md.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			fd := fields.Get(i)
+			fs := g.protoField(fd)
+			if doc := comments(fd); doc != "" {
+				fs = describe(fs, doc)
+			}
+			s.Properties[g.protoName(fd)] = fs
+		}
+	}
+	return &Schema{Ref: refPrefix + name}
+}
+
+// comments returns leading comments of descriptor, 需要生成代码时保留source info
+func comments(d protoreflect.Descriptor) string {
+	return strings.TrimSpace(d.ParentFile().SourceLocations().ByDescriptor(d).LeadingComments)
+}
+
+func (g *generator) protoName(fd protoreflect.FieldDescriptor) string {
+	if g.protoNames {
+		return string(fd.Name())
+	}
+	return fd.JSONName()
+}
+
+func (g *generator) protoField(fd protoreflect.FieldDescriptor) *Schema {
+	switch {
+	case fd.IsMap():
+		return &Schema{Type: "object", AdditionalProperties: g.protoKind(fd.MapValue())}
+	case fd.IsList():
+		return &Schema{Type: "array", Items: g.protoKind(fd)}
+	default:
+		return g.protoKind(fd)
+	}
+}
+
+func (g *generator) protoKind(fd protoreflect.FieldDescriptor) *Schema {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return &Schema{Type: "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return &Schema{Type: "integer", Format: "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return &Schema{Type: "integer", Format: "int64"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		// protojson使用字符串表示64位整数
+		return &Schema{Type: "string", Format: "int64"}
+	case protoreflect.FloatKind:
+		return &Schema{Type: "number", Format: "float"}
+	case protoreflect.DoubleKind:
+		return &Schema{Type: "number", Format: "double"}
+	case protoreflect.StringKind:
+		return &Schema{Type: "string"}
+	case protoreflect.BytesKind:
+		return &Schema{Type: "string", Format: "byte"}
+	case protoreflect.EnumKind:
+		s := &Schema{Type: "string"}
+		values := fd.Enum().Values()
+		for i := 0; i < values.Len(); i++ {
+			s.Enum = append(s.Enum, string(values.Get(i).Name()))
+		}
+		return s
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return g.protoMessage(fd.Message())
+	default:
+		return &Schema{}
+	}
+}
+
+// wellKnownSchema returns schema of well known types which have special json mapping
+func wellKnownSchema(name string) (*Schema, bool) {
+	switch name {
+	case "google.protobuf.Timestamp":
+		return &Schema{Type: "string", Format: "date-time"}, true
+	case "google.protobuf.Duration", "google.protobuf.FieldMask", "google.protobuf.StringValue":
+		return &Schema{Type: "string"}, true
+	case "google.protobuf.Empty":
+		return &Schema{Type: "object"}, true
+	case "google.protobuf.Struct":
+		return &Schema{Type: "object", AdditionalProperties: &Schema{}}, true
+	case "google.protobuf.Value":
+		return &Schema{}, true
+	case "google.protobuf.ListValue":
+		return &Schema{Type: "array", Items: &Schema{}}, true
+	case "google.protobuf.Any":
+		return &Schema{Type: "object", Properties: map[string]*Schema{"@type": {Type: "string"}}}, true
+	case "google.protobuf.DoubleValue":
+		return &Schema{Type: "number", Format: "double"}, true
+	case "google.protobuf.FloatValue":
+		return &Schema{Type: "number", Format: "float"}, true
+	case "google.protobuf.Int64Value", "google.protobuf.UInt64Value":
+		return &Schema{Type: "string", Format: "int64"}, true
+	case "google.protobuf.Int32Value", "google.protobuf.UInt32Value":
+		return &Schema{Type: "integer", Format: "int32"}, true
+	case "google.protobuf.BoolValue":
+		return &Schema{Type: "boolean"}, true
+	case "google.protobuf.BytesValue":
+		return &Schema{Type: "string", Format: "byte"}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,227 @@
+// Package oopenapi 根据ogin和oecho的路由注册生成openapi 3.0文档.
+// 请求和响应的schema来自结构体(json, doc, binding/validate标签)或proto message(protojson规则),
+// 错误响应默认为oerror.Error
+package oopenapi
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/xqk/ox/pkg/server/oerror"
+)
+
+const (
+	// Version openapi版本
+	Version = "3.0.3"
+	// DocumentPath ogin和oecho挂载文档的路径
+	DocumentPath = "/openapi.json"
+)
+
+// API 接口说明
+type API struct {
+	Summary     string
+	Description string
+	Tags        []string
+	// Query 查询参数, 结构体或proto message的字段作为查询参数
+	Query interface{}
+	// Request 请求体, 可以是结构体, proto message, protoreflect.MessageDescriptor或*Schema.
+	// GET, DELETE和HEAD没有请求体, Query为空时Request作为查询参数
+	Request interface{}
+	// Response 成功响应
+	Response interface{}
+	// Error 错误响应, 默认为oerror.Error
+	Error interface{}
+	// Parameters 额外的参数, 如请求头, 路径参数自动生成
+	Parameters []*Parameter
+	// ProtoNames proto message使用proto中的字段名, 默认使用lowerCamelCase
+	ProtoNames bool
+	// Path 文档中的路径模板, 如 /v1/{name=shelves/*}, 为空时由路由转换
+	Path string
+}
+
+// Route 已注册的路由, Path为gin或echo的路由格式
+type Route struct {
+	Method string
+	Path   string
+}
+
+// Spec 收集接口说明
+type Spec struct {
+	mu   sync.RWMutex
+	apis map[string]*API
+}
+
+// NewSpec ...
+func NewSpec() *Spec {
+	return &Spec{apis: make(map[string]*API)}
+}
+
+// Add 添加接口说明, path为gin或echo的路由格式
+func (s *Spec) Add(method, path string, api *API) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apis[method+" "+path] = api
+}
+
+// Document 生成routes的文档, 没有接口说明的路由只有默认响应
+func (s *Spec) Document(info Info, routes []Route) *Document {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	g := newGenerator()
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   make(map[string]PathItem),
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	for _, route := range routes {
+		api := s.apis[route.Method+" "+route.Path]
+		if api == nil {
+			api = &API{}
+		}
+		path, params := convertPath(route.Path)
+		if api.Path != "" {
+			path, params = convertTemplate(api.Path)
+		}
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = make(PathItem)
+			doc.Paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = g.operation(route.Method, params, api)
+	}
+	if len(g.schemas) > 0 {
+		doc.Components.Schemas = g.schemas
+	}
+	return doc
+}
+
+func (g *generator) operation(method string, params []string, api *API) *Operation {
+	g.protoNames = api.ProtoNames
+	op := &Operation{
+		Summary:     api.Summary,
+		Description: api.Description,
+		Tags:        api.Tags,
+		Responses:   make(map[string]*Response),
+	}
+	var exists = make(map[string]bool)
+	for _, name := range params {
+		exists[name] = true
+		op.Parameters = append(op.Parameters, &Parameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+	}
+	for _, p := range api.Parameters {
+		if p.In == "path" && exists[p.Name] {
+			// 替换自动生成的路径参数
+			for i, pp := range op.Parameters {
+				if pp.In == "path" && pp.Name == p.Name {
+					op.Parameters[i] = p
+				}
+			}
+			continue
+		}
+		op.Parameters = append(op.Parameters, p)
+	}
+
+	query, request := api.Query, api.Request
+	if !hasBody(method) {
+		if query == nil {
+			query = request
+		}
+		request = nil
+	}
+	for _, p := range g.queryParameters(query) {
+		if !exists[p.Name] {
+			op.Parameters = append(op.Parameters, p)
+		}
+	}
+	if schema := g.schemaOf(request); schema != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  jsonContent(schema),
+		}
+	}
+
+	ok := &Response{Description: http.StatusText(http.StatusOK)}
+	if schema := g.schemaOf(api.Response); schema != nil {
+		ok.Content = jsonContent(schema)
+	}
+	op.Responses["200"] = ok
+
+	var errSchema = api.Error
+	if errSchema == nil {
+		errSchema = (*oerror.Error)(nil)
+	}
+	op.Responses["default"] = &Response{
+		Description: "错误响应, code为ecode错误码",
+		Content:     jsonContent(g.schemaOf(errSchema)),
+	}
+	return op
+}
+
+func hasBody(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodDelete, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+func jsonContent(schema *Schema) map[string]*MediaType {
+	return map[string]*MediaType{"application/json": {Schema: schema}}
+}
+
+// convertPath 转换gin和echo的路由格式, 如 /users/:id/*path 转换为 /users/{id}/{path}, echo的匿名通配符命名为path
+func convertPath(path string) (string, []string) {
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			params = append(params, segment[1:])
+			segments[i] = "{" + segment[1:] + "}"
+		case strings.HasPrefix(segment, "*"):
+			name := segment[1:]
+			if name == "" {
+				name = "path"
+			}
+			params = append(params, name)
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// convertTemplate 转换google.api.http的路径模板, 如 /v1/{name=shelves/*} 转换为 /v1/{name}
+func convertTemplate(pattern string) (string, []string) {
+	var b strings.Builder
+	var params []string
+	for {
+		start := strings.IndexByte(pattern, '{')
+		end := strings.IndexByte(pattern, '}')
+		if start < 0 || end < start {
+			b.WriteString(pattern)
+			break
+		}
+		name := pattern[start+1 : end]
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name = name[:i]
+		}
+		params = append(params, name)
+		b.WriteString(pattern[:start] + "{" + name + "}")
+		pattern = pattern[end+1:]
+	}
+	return b.String(), params
+}
@@ -0,0 +1,91 @@
+// 渲染openapi文档, 不依赖外部资源
+(function () {
+  var methods = ["get", "post", "put", "patch", "delete", "head", "options"];
+
+  function el(tag, attrs, children) {
+    var node = document.createElement(tag);
+    Object.keys(attrs || {}).forEach(function (k) { node.setAttribute(k, attrs[k]); });
+    (children || []).forEach(function (c) {
+      node.appendChild(typeof c === "string" ? document.createTextNode(c) : c);
+    });
+    return node;
+  }
+
+  // resolve 展开$ref, 循环引用时保留$ref
+  function resolve(schema, components, seen) {
+    if (!schema) return schema;
+    seen = seen || {};
+    if (schema.$ref) {
+      var name = schema.$ref.replace("#/components/schemas/", "");
+      if (seen[name]) return { $ref: schema.$ref };
+      seen = Object.assign({}, seen);
+      seen[name] = true;
+      return resolve(components[name], components, seen);
+    }
+    var out = Object.assign({}, schema);
+    if (out.items) out.items = resolve(out.items, components, seen);
+    if (out.additionalProperties) out.additionalProperties = resolve(out.additionalProperties, components, seen);
+    if (out.properties) {
+      out.properties = {};
+      Object.keys(schema.properties).forEach(function (k) {
+        out.properties[k] = resolve(schema.properties[k], components, seen);
+      });
+    }
+    return out;
+  }
+
+  function schemaBlock(title, content, components) {
+    var section = el("section", {}, [el("h4", {}, [title])]);
+    Object.keys(content || {}).forEach(function (type) {
+      var schema = resolve(content[type].schema, components);
+      section.appendChild(el("div", {}, [type]));
+      section.appendChild(el("pre", {}, [JSON.stringify(schema, null, 2)]));
+    });
+    return section;
+  }
+
+  function operation(path, method, op, components) {
+    var details = el("details", {}, [el("summary", {}, [
+      el("span", { "class": "method " + method }, [method]),
+      path,
+      el("span", { "class": "summary" }, [op.summary || ""])
+    ])]);
+    if (op.description) details.appendChild(el("section", {}, [op.description]));
+    if (op.parameters && op.parameters.length) {
+      var rows = op.parameters.map(function (p) {
+        var schema = resolve(p.schema, components) || {};
+        return el("tr", {}, [
+          el("td", {}, [p.name + (p.required ? " *" : "")]),
+          el("td", {}, [p.in]),
+          el("td", {}, [schema.type + (schema.format ? " (" + schema.format + ")" : "")]),
+          el("td", {}, [p.description || ""])
+        ]);
+      });
+      details.appendChild(el("section", {}, [el("h4", {}, ["Parameters"]),
+        el("table", {}, [el("tr", {}, [el("th", {}, ["Name"]), el("th", {}, ["In"]), el("th", {}, ["Type"]), el("th", {}, ["Description"])])].concat(rows))]));
+    }
+    if (op.requestBody) details.appendChild(schemaBlock("Request body", op.requestBody.content, components));
+    Object.keys(op.responses || {}).sort().forEach(function (code) {
+      var resp = op.responses[code];
+      details.appendChild(schemaBlock("Response " + code + " " + (resp.description || ""), resp.content, components));
+    });
+    return details;
+  }
+
+  function render(doc) {
+    var components = (doc.components && doc.components.schemas) || {};
+    document.getElementById("title").textContent = doc.info.title;
+    document.getElementById("version").textContent = doc.info.version;
+    var container = document.getElementById("paths");
+    Object.keys(doc.paths || {}).sort().forEach(function (path) {
+      methods.forEach(function (method) {
+        var op = doc.paths[path][method];
+        if (op) container.appendChild(operation(path, method, op, components));
+      });
+    });
+  }
+
+  fetch(window.specURL).then(function (resp) { return resp.json(); }).then(render).catch(function (err) {
+    document.getElementById("paths").textContent = "load openapi document failed: " + err;
+  });
+})();
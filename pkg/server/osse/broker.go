@@ -0,0 +1,268 @@
+package osse
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xqk/ox/pkg/metric"
+	"github.com/xqk/ox/pkg/olog"
+)
+
+var (
+	// ErrStreamClosed 连接已关闭
+	ErrStreamClosed = errors.New("sse stream closed")
+	// ErrBrokerClosed broker已关闭
+	ErrBrokerClosed = errors.New("sse broker closed")
+	// ErrFlushNotSupported ResponseWriter不支持Flush
+	ErrFlushNotSupported = errors.New("sse flush not supported")
+)
+
+// Broker 管理SSE连接, 向所有订阅的连接发布事件, 关闭时结束所有连接
+type Broker struct {
+	config *Config
+	replay ReplayBuffer
+	seq    int64
+
+	mu      sync.Mutex
+	streams map[*Stream]struct{}
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// Len 返回当前的连接数
+func (b *Broker) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.streams)
+}
+
+// Publish 发布事件到所有订阅的连接并保存到回放缓冲, 发送队列已满的连接会被关闭
+func (b *Broker) Publish(e *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e.ID == "" {
+		b.seq++
+		e.ID = strconv.FormatInt(b.seq, 10)
+	}
+	if b.replay != nil {
+		b.replay.Add(e)
+	}
+	for s := range b.streams {
+		if s.events == nil {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			metric.ServerMessageCounter.Inc(metric.TypeSSE, b.config.Name, "out", "dropped")
+			b.config.logger.Warn("sse queue full, close stream", olog.String("remote_addr", s.request.RemoteAddr))
+			s.cancel()
+		}
+	}
+}
+
+// ServeHTTP 订阅broker发布的事件, 请求带Last-Event-ID时先补发之后的事件
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s, err := b.Open(w, r)
+	if err != nil {
+		return
+	}
+	defer s.Close()
+
+	var replay []*Event
+	events := make(chan *Event, b.config.QueueSize)
+	// 持有锁补发和订阅, 保证事件不重复也不遗漏
+	b.mu.Lock()
+	if b.replay != nil && s.LastEventID() != "" {
+		replay, _ = b.replay.Since(s.LastEventID())
+	}
+	s.events = events
+	b.mu.Unlock()
+
+	for _, e := range replay {
+		if err := s.Send(e); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case e := <-events:
+			if err := s.Send(e); err != nil {
+				return
+			}
+		case <-s.Done():
+			return
+		}
+	}
+}
+
+// Open 开始SSE响应, 用于自定义推送逻辑的handler, 返回的连接在broker关闭时结束, 使用后需要调用Close
+func (b *Broker) Open(w http.ResponseWriter, r *http.Request) (*Stream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, ErrFlushNotSupported.Error(), http.StatusInternalServerError)
+		return nil, ErrFlushNotSupported
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	s := &Stream{
+		broker:      b,
+		w:           w,
+		flusher:     flusher,
+		request:     r,
+		ctx:         ctx,
+		cancel:      cancel,
+		lastEventID: r.Header.Get("Last-Event-ID"),
+	}
+	if s.lastEventID == "" {
+		s.lastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		cancel()
+		http.Error(w, ErrBrokerClosed.Error(), http.StatusServiceUnavailable)
+		return nil, ErrBrokerClosed
+	}
+	b.streams[s] = struct{}{}
+	b.wg.Add(1)
+	b.mu.Unlock()
+	metric.ServerConnGauge.Inc(metric.TypeSSE, b.config.Name)
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// 关闭nginx的缓冲
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if b.config.Retry > 0 {
+		_ = s.write([]byte("retry: " + strconv.FormatInt(int64(b.config.Retry/time.Millisecond), 10) + "\n\n"))
+	} else {
+		s.flusher.Flush()
+	}
+	if b.config.KeepAlive > 0 {
+		go s.keepalive(b.config.KeepAlive)
+	}
+	return s, nil
+}
+
+// Close 关闭broker, 拒绝新的连接, 结束已有连接并等待handler返回, ctx结束时不再等待
+func (b *Broker) Close(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	for s := range b.streams {
+		s.cancel()
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		b.config.logger.Warn("sse broker close timeout", olog.String("name", b.config.Name), olog.Int("streams", b.Len()))
+		return ctx.Err()
+	}
+}
+
+// Stream 一个SSE连接
+type Stream struct {
+	broker      *Broker
+	w           http.ResponseWriter
+	flusher     http.Flusher
+	request     *http.Request
+	ctx         context.Context
+	cancel      context.CancelFunc
+	lastEventID string
+	events      chan *Event
+
+	mu        sync.Mutex
+	closeOnce sync.Once
+}
+
+// Request 返回连接的请求
+func (s *Stream) Request() *http.Request {
+	return s.request
+}
+
+// LastEventID 返回客户端重连时带回的最后一个事件id
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done 客户端断开, 发送失败或broker关闭时关闭
+func (s *Stream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Send 发送事件并立即flush
+func (s *Stream) Send(e *Event) error {
+	bs, _ := e.MarshalText()
+	if err := s.write(bs); err != nil {
+		metric.ServerMessageCounter.Inc(metric.TypeSSE, s.broker.config.Name, "out", "error")
+		return err
+	}
+	metric.ServerMessageCounter.Inc(metric.TypeSSE, s.broker.config.Name, "out", "ok")
+	return nil
+}
+
+// Comment 发送注释, 客户端会忽略
+func (s *Stream) Comment(text string) error {
+	return s.write([]byte(": " + stripNewline(text) + "\n\n"))
+}
+
+// Close 结束连接, handler返回前调用
+func (s *Stream) Close() {
+	s.closeOnce.Do(func() {
+		s.cancel()
+		// 等待正在进行的写入结束, 之后不再写入ResponseWriter
+		s.mu.Lock()
+		s.mu.Unlock() // nolint: staticcheck
+
+		b := s.broker
+		b.mu.Lock()
+		delete(b.streams, s)
+		b.mu.Unlock()
+		metric.ServerConnGauge.Add(-1, metric.TypeSSE, b.config.Name)
+		b.wg.Done()
+	})
+}
+
+func (s *Stream) write(bs []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return ErrStreamClosed
+	}
+	if _, err := s.w.Write(bs); err != nil {
+		s.cancel()
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *Stream) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Comment("keepalive"); err != nil {
+				return
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
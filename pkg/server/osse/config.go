@@ -0,0 +1,81 @@
+package osse
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xqk/ox/pkg/conf"
+	"github.com/xqk/ox/pkg/ecode"
+	"github.com/xqk/ox/pkg/olog"
+)
+
+// ModName ..
+const ModName = "server.sse"
+
+// Config SSE配置
+type Config struct {
+	// Name 名称, 用于监控
+	Name string `json:"name" toml:"name"`
+	// Retry 建议客户端重连的间隔, 为0时不发送
+	Retry time.Duration `json:"retry" toml:"retry"`
+	// KeepAlive 发送保活注释的间隔, 避免代理关闭空闲连接, 为0时不发送
+	KeepAlive time.Duration `json:"keepAlive" toml:"keepAlive"`
+	// QueueSize 每个连接的发送队列长度, 队列满的连接会被关闭, 客户端重连后从回放缓冲补发
+	QueueSize int `json:"queueSize" toml:"queueSize"`
+	// ReplaySize 内存回放缓冲的事件数, 为0时不回放, 使用WithReplayBuffer时忽略
+	ReplaySize int `json:"replaySize" toml:"replaySize"`
+
+	replay ReplayBuffer
+	logger *olog.Logger
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Name:       "default",
+		Retry:      3 * time.Second,
+		KeepAlive:  15 * time.Second,
+		QueueSize:  64,
+		ReplaySize: 1000,
+		logger:     olog.OxLogger.With(olog.FieldMod(ModName)),
+	}
+}
+
+// RawConfig ...
+func RawConfig(key string) *Config {
+	var config = DefaultConfig()
+	if err := conf.UnmarshalKey(key, &config); err != nil &&
+		errors.Cause(err) != conf.ErrInvalidKey {
+		config.logger.Panic("sse parse config panic", olog.FieldErrKind(ecode.ErrKindUnmarshalConfigErr), olog.FieldErr(err), olog.FieldKey(key), olog.FieldValueAny(config))
+	}
+	return config
+}
+
+// WithLogger ...
+func (config *Config) WithLogger(logger *olog.Logger) *Config {
+	config.logger = logger
+	return config
+}
+
+// WithReplayBuffer 使用自定义的回放缓冲, 如基于redis的实现, 以便多个实例间补发事件
+func (config *Config) WithReplayBuffer(replay ReplayBuffer) *Config {
+	config.replay = replay
+	return config
+}
+
+// Build ...
+func (config *Config) Build() *Broker {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1
+	}
+	replay := config.replay
+	if replay == nil && config.ReplaySize > 0 {
+		replay = NewMemoryBuffer(config.ReplaySize)
+	}
+	return &Broker{
+		config:  config,
+		replay:  replay,
+		seq:     time.Now().UnixNano(),
+		streams: make(map[*Stream]struct{}),
+	}
+}
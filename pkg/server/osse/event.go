@@ -0,0 +1,119 @@
+package osse
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event 服务端推送的事件
+type Event struct {
+	// ID 事件id, 客户端重连时通过Last-Event-ID带回, Broker.Publish时为空则自动生成
+	ID string
+	// Event 事件类型, 为空时客户端按message处理
+	Event string
+	// Data 事件内容, 多行内容按行拆分
+	Data string
+	// Retry 客户端重连的间隔, 为0时不发送
+	Retry time.Duration
+}
+
+// MarshalText 按text/event-stream格式编码事件
+func (e *Event) MarshalText() ([]byte, error) {
+	var buf bytes.Buffer
+	if e.ID != "" {
+		buf.WriteString("id: ")
+		buf.WriteString(stripNewline(e.ID))
+		buf.WriteByte('\n')
+	}
+	if e.Event != "" {
+		buf.WriteString("event: ")
+		buf.WriteString(stripNewline(e.Event))
+		buf.WriteByte('\n')
+	}
+	if e.Retry > 0 {
+		buf.WriteString("retry: ")
+		buf.WriteString(strconv.FormatInt(int64(e.Retry/time.Millisecond), 10))
+		buf.WriteByte('\n')
+	}
+	// \r\n, \r和\n都是事件流的行分隔符
+	for _, line := range strings.Split(lineBreaks.Replace(e.Data), "\n") {
+		buf.WriteString("data: ")
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+var lineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+func stripNewline(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// ReplayBuffer 保存最近发布的事件, 用于客户端带Last-Event-ID重连时补发
+type ReplayBuffer interface {
+	// Add 保存事件
+	Add(e *Event)
+	// Since 返回lastID之后的事件, lastID已不在缓冲中时返回缓冲中的全部事件, ok为false
+	Since(lastID string) (events []*Event, ok bool)
+}
+
+// memoryBuffer 内存中固定长度的环形缓冲
+type memoryBuffer struct {
+	mu     sync.Mutex
+	events []*Event
+	start  int
+	size   int
+}
+
+// NewMemoryBuffer 返回保存最近size个事件的内存缓冲, size小于等于0时不保存事件
+func NewMemoryBuffer(size int) ReplayBuffer {
+	if size < 0 {
+		size = 0
+	}
+	return &memoryBuffer{events: make([]*Event, size)}
+}
+
+// Add implements ReplayBuffer
+func (b *memoryBuffer) Add(e *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.events) == 0 {
+		return
+	}
+	if b.size < len(b.events) {
+		b.events[(b.start+b.size)%len(b.events)] = e
+		b.size++
+		return
+	}
+	b.events[b.start] = e
+	b.start = (b.start + 1) % len(b.events)
+}
+
+// Since implements ReplayBuffer
+func (b *memoryBuffer) Since(lastID string) ([]*Event, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i := b.size - 1; i >= 0; i-- {
+		if b.at(i).ID == lastID {
+			return b.slice(i + 1), true
+		}
+	}
+	return b.slice(0), false
+}
+
+func (b *memoryBuffer) at(i int) *Event {
+	return b.events[(b.start+i)%len(b.events)]
+}
+
+func (b *memoryBuffer) slice(from int) []*Event {
+	events := make([]*Event, 0, b.size-from)
+	for i := from; i < b.size; i++ {
+		events = append(events, b.at(i))
+	}
+	return events
+}
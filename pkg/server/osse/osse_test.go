@@ -0,0 +1,193 @@
+package osse
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvent_MarshalText(t *testing.T) {
+	bs, _ := (&Event{ID: "1", Event: "update", Data: "a\nb", Retry: 2 * time.Second}).MarshalText()
+	assert.Equal(t, "id: 1\nevent: update\nretry: 2000\ndata: a\ndata: b\n\n", string(bs))
+
+	bs, _ = (&Event{ID: "x\ny", Data: ""}).MarshalText()
+	assert.Equal(t, "id: xy\ndata: \n\n", string(bs))
+
+	// 单独的\r也是行分隔符, 不能注入其他字段
+	bs, _ = (&Event{Data: "a\rid: 9\r\nb"}).MarshalText()
+	assert.Equal(t, "data: a\ndata: id: 9\ndata: b\n\n", string(bs))
+}
+
+func TestMemoryBuffer_Empty(t *testing.T) {
+	b := NewMemoryBuffer(-1)
+	b.Add(&Event{ID: "1"})
+	events, ok := b.Since("1")
+	assert.False(t, ok)
+	assert.Empty(t, events)
+}
+
+func TestMemoryBuffer(t *testing.T) {
+	b := NewMemoryBuffer(3)
+	for _, id := range []string{"1", "2", "3", "4"} {
+		b.Add(&Event{ID: id})
+	}
+	ids := func(events []*Event) (ids []string) {
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+		return
+	}
+
+	events, ok := b.Since("2")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"3", "4"}, ids(events))
+
+	events, ok = b.Since("4")
+	assert.True(t, ok)
+	assert.Empty(t, events)
+
+	// 1已经被覆盖
+	events, ok = b.Since("1")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"2", "3", "4"}, ids(events))
+}
+
+type client struct {
+	resp   *http.Response
+	reader *bufio.Reader
+}
+
+func connect(t *testing.T, url string, lastEventID string) *client {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return &client{resp: resp, reader: bufio.NewReader(resp.Body)}
+}
+
+// next 读取下一个事件块
+func (c *client) next(t *testing.T) string {
+	var lines []string
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return strings.Join(lines, "\n")
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return strings.Join(lines, "\n")
+		}
+		lines = append(lines, line)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition not satisfied")
+}
+
+func TestBroker(t *testing.T) {
+	config := DefaultConfig()
+	config.KeepAlive = 0
+	broker := config.Build()
+	server := httptest.NewServer(broker)
+	defer server.Close()
+
+	c := connect(t, server.URL, "")
+	defer c.resp.Body.Close()
+	assert.Equal(t, "retry: 3000", c.next(t))
+	waitFor(t, func() bool { return broker.Len() == 1 })
+
+	broker.Publish(&Event{ID: "1", Data: "one"})
+	broker.Publish(&Event{ID: "2", Event: "update", Data: "two"})
+	broker.Publish(&Event{ID: "3", Data: "three"})
+	assert.Equal(t, "id: 1\ndata: one", c.next(t))
+	assert.Equal(t, "id: 2\nevent: update\ndata: two", c.next(t))
+	assert.Equal(t, "id: 3\ndata: three", c.next(t))
+
+	// 重连时补发Last-Event-ID之后的事件
+	resumed := connect(t, server.URL, "1")
+	defer resumed.resp.Body.Close()
+	assert.Equal(t, "retry: 3000", resumed.next(t))
+	assert.Equal(t, "id: 2\nevent: update\ndata: two", resumed.next(t))
+	assert.Equal(t, "id: 3\ndata: three", resumed.next(t))
+
+	broker.Publish(&Event{Data: "auto"})
+	assert.True(t, strings.HasSuffix(resumed.next(t), "\ndata: auto"))
+	assert.True(t, strings.HasPrefix(c.next(t), "id: "))
+}
+
+func TestBroker_KeepAlive(t *testing.T) {
+	config := DefaultConfig()
+	config.Retry = 0
+	config.KeepAlive = 10 * time.Millisecond
+	server := httptest.NewServer(config.Build())
+	defer server.Close()
+
+	c := connect(t, server.URL, "")
+	defer c.resp.Body.Close()
+	assert.Equal(t, ": keepalive", c.next(t))
+}
+
+func TestBroker_Close(t *testing.T) {
+	broker := DefaultConfig().Build()
+	server := httptest.NewServer(broker)
+	defer server.Close()
+
+	c := connect(t, server.URL, "")
+	defer c.resp.Body.Close()
+	c.next(t)
+	waitFor(t, func() bool { return broker.Len() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, broker.Close(ctx))
+	assert.Equal(t, 0, broker.Len())
+	// 连接结束
+	assert.Equal(t, "", c.next(t))
+
+	resp, err := http.Get(server.URL)
+	assert.Nil(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestBroker_SlowConsumer(t *testing.T) {
+	config := DefaultConfig()
+	config.QueueSize = 1
+	broker := config.Build()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 不消费队列的订阅者
+		s, err := broker.Open(w, r)
+		assert.Nil(t, err)
+		defer s.Close()
+		broker.mu.Lock()
+		s.events = make(chan *Event, 1)
+		broker.mu.Unlock()
+		<-s.Done()
+	}))
+	defer server.Close()
+
+	c := connect(t, server.URL, "")
+	defer c.resp.Body.Close()
+	c.next(t)
+	waitFor(t, func() bool { return broker.Len() == 1 })
+
+	broker.Publish(&Event{Data: "1"})
+	broker.Publish(&Event{Data: "2"})
+	waitFor(t, func() bool { return broker.Len() == 0 })
+}
@@ -0,0 +1,91 @@
+// Package otransport HTTP服务的超时, 请求头限制和HTTP/2(h2c)配置, 用于ohttp, ogin, oecho和ogoframe
+package otransport
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// Config 服务端连接配置, 超时为0时不限制
+type Config struct {
+	// H2C 明文HTTP/2, 支持prior knowledge和Upgrade两种方式. 使用外部listener(如omux)时总是开启
+	H2C bool `json:"h2c" toml:"h2c"`
+	// ReadTimeout 读取整个请求(包括请求体)的超时
+	ReadTimeout time.Duration `json:"readTimeout" toml:"readTimeout"`
+	// ReadHeaderTimeout 读取请求头的超时, 为0时使用ReadTimeout
+	ReadHeaderTimeout time.Duration `json:"readHeaderTimeout" toml:"readHeaderTimeout"`
+	// WriteTimeout 写响应的超时, 长连接(如sse)需要为0
+	WriteTimeout time.Duration `json:"writeTimeout" toml:"writeTimeout"`
+	// IdleTimeout keep-alive和HTTP/2连接的空闲超时, 为0时使用ReadTimeout
+	IdleTimeout time.Duration `json:"idleTimeout" toml:"idleTimeout"`
+	// MaxHeaderBytes 请求头的最大字节数, HTTP/2中为解码后的请求头列表大小, 为0时为1MB
+	MaxHeaderBytes int `json:"maxHeaderBytes" toml:"maxHeaderBytes"`
+	// HTTP2 HTTP/2参数
+	HTTP2 *HTTP2Config `json:"http2" toml:"http2"`
+	// GracePeriod 优雅停止时等待hijack的连接(如websocket)关闭的时间, 超过后强制关闭
+	GracePeriod time.Duration `json:"gracePeriod" toml:"gracePeriod"`
+}
+
+// HTTP2Config HTTP/2参数, 为0时使用golang.org/x/net/http2的默认值
+type HTTP2Config struct {
+	// MaxConcurrentStreams 每个连接的最大并发流, 默认250
+	MaxConcurrentStreams uint32 `json:"maxConcurrentStreams" toml:"maxConcurrentStreams"`
+	// MaxReadFrameSize 读取帧的最大字节数, 默认1MB
+	MaxReadFrameSize uint32 `json:"maxReadFrameSize" toml:"maxReadFrameSize"`
+	// MaxUploadBufferPerConnection 连接的初始流控窗口, 默认1MB
+	MaxUploadBufferPerConnection int32 `json:"maxUploadBufferPerConnection" toml:"maxUploadBufferPerConnection"`
+	// MaxUploadBufferPerStream 流的初始流控窗口, 默认1MB
+	MaxUploadBufferPerStream int32 `json:"maxUploadBufferPerStream" toml:"maxUploadBufferPerStream"`
+}
+
+// DefaultConfig 与http.Server的默认值一致, 优雅停止时等待hijack的连接10秒
+func DefaultConfig() *Config {
+	return &Config{
+		HTTP2:       &HTTP2Config{},
+		GracePeriod: 10 * time.Second,
+	}
+}
+
+// Server 创建http.Server, h2c为true时即使没有开启H2C也支持明文HTTP/2. config为nil时使用默认配置
+func (config *Config) Server(addr string, handler http.Handler, h2c bool) *http.Server {
+	config = config.orDefault()
+	server := &http.Server{Addr: addr}
+	config.Apply(server)
+	server.Handler = handler
+	if h2c || config.H2C {
+		server.Handler = config.H2CHandler(handler, server)
+	}
+	return server
+}
+
+// Apply 设置server的超时和请求头限制
+func (config *Config) Apply(server *http.Server) {
+	config = config.orDefault()
+	server.ReadTimeout = config.ReadTimeout
+	server.ReadHeaderTimeout = config.ReadHeaderTimeout
+	server.WriteTimeout = config.WriteTimeout
+	server.IdleTimeout = config.IdleTimeout
+	server.MaxHeaderBytes = config.MaxHeaderBytes
+}
+
+// HTTP2Server returns http2 server with configured limits
+func (config *Config) HTTP2Server() *http2.Server {
+	config = config.orDefault()
+	h2s := &http2.Server{IdleTimeout: config.IdleTimeout}
+	if c := config.HTTP2; c != nil {
+		h2s.MaxConcurrentStreams = c.MaxConcurrentStreams
+		h2s.MaxReadFrameSize = c.MaxReadFrameSize
+		h2s.MaxUploadBufferPerConnection = c.MaxUploadBufferPerConnection
+		h2s.MaxUploadBufferPerStream = c.MaxUploadBufferPerStream
+	}
+	return h2s
+}
+
+func (config *Config) orDefault() *Config {
+	if config == nil {
+		return DefaultConfig()
+	}
+	return config
+}
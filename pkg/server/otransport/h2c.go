@@ -0,0 +1,105 @@
+package otransport
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// h2cHandler 与h2c.NewHandler相同, prior knowledge方式的连接使用base的请求头限制和空闲超时
+type h2cHandler struct {
+	handler http.Handler
+	h2s     *http2.Server
+	base    *http.Server
+	upgrade http.Handler
+}
+
+// H2CHandler 包装handler, 支持明文HTTP/2, base为handler所在的http.Server.
+// base不为nil时, base.Shutdown向HTTP/2连接发送GOAWAY, 连接在请求结束后关闭
+func (config *Config) H2CHandler(handler http.Handler, base *http.Server) http.Handler {
+	h2s := config.HTTP2Server()
+	if base != nil {
+		_ = http2.ConfigureServer(base, h2s)
+	}
+	return &h2cHandler{
+		handler: handler,
+		h2s:     h2s,
+		base:    base,
+		upgrade: h2c.NewHandler(handler, h2s),
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (h *h2cHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "PRI" || len(r.Header) != 0 || r.URL.Path != "*" || r.Proto != "HTTP/2.0" {
+		// 普通请求和Upgrade方式
+		h.upgrade.ServeHTTP(w, r)
+		return
+	}
+	conn, err := hijackPriorKnowledge(w)
+	if err != nil {
+		return
+	}
+	// ServeConn返回后关闭连接, 不再由Tracker统计
+	defer conn.Close()
+	h.h2s.ServeConn(conn, &http2.ServeConnOpts{
+		Context:    r.Context(),
+		Handler:    h.handler,
+		BaseConfig: h.base,
+	})
+}
+
+// hijackPriorKnowledge 读取客户端preface的剩余部分, 返回的连接重新输出完整的preface
+func hijackPriorKnowledge(w http.ResponseWriter) (net.Conn, error) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "h2c not supported", http.StatusInternalServerError)
+		return nil, errors.New("hijack not supported")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// 清除http.Server设置的读写超时, HTTP/2连接由IdleTimeout控制
+	_ = conn.SetDeadline(time.Time{})
+
+	const rest = "SM\r\n\r\n"
+	buf := make([]byte, len(rest))
+	if _, err := io.ReadFull(rw, buf); err != nil || string(buf) != rest {
+		conn.Close()
+		return nil, errors.New("invalid client preface")
+	}
+	return &rwConn{
+		Conn:   conn,
+		reader: io.MultiReader(strings.NewReader(http2.ClientPreface), rw),
+		writer: rw.Writer,
+	}, nil
+}
+
+// rwConn 从hijack返回的缓冲区读写
+type rwConn struct {
+	net.Conn
+	reader io.Reader
+	writer *bufio.Writer
+}
+
+// Read ...
+func (c *rwConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// Write ...
+func (c *rwConn) Write(p []byte) (int, error) {
+	n, err := c.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.writer.Flush()
+}
@@ -0,0 +1,31 @@
+package otransport
+
+import (
+	"net/http"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/xqk/ox/pkg/governor"
+)
+
+// trackers 运行中的服务, name => *Tracker
+var trackers sync.Map
+
+// Range 遍历运行中服务的tracker
+func Range(fn func(name string, tracker *Tracker) bool) {
+	trackers.Range(func(key, value interface{}) bool {
+		return fn(key.(string), value.(*Tracker))
+	})
+}
+
+func init() {
+	// 停止服务时可以查看正在等待的请求和连接
+	governor.HandleFunc("/debug/server/http/inflight", func(w http.ResponseWriter, r *http.Request) {
+		var rets = make(map[string]Stats)
+		Range(func(name string, tracker *Tracker) bool {
+			rets[name] = tracker.Stats()
+			return true
+		})
+		_ = jsoniter.NewEncoder(w).Encode(rets)
+	})
+}
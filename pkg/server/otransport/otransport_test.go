@@ -0,0 +1,180 @@
+package otransport
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/olog"
+	"golang.org/x/net/http2"
+)
+
+func serve(t *testing.T, config *Config) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := config.Server("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}), false)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return "http://" + listener.Addr().String()
+}
+
+// h2cClient 使用prior knowledge方式的HTTP/2客户端
+func h2cClient() *http.Client {
+	return &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+}
+
+func get(client *http.Client, url string, header http.Header) (int, string, error) {
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), err
+}
+
+func TestServer_H2C(t *testing.T) {
+	config := DefaultConfig()
+	config.H2C = true
+	config.ReadTimeout = 100 * time.Millisecond
+	config.MaxHeaderBytes = 1024
+	url := serve(t, config)
+
+	client := h2cClient()
+	code, proto, err := get(client, url, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "HTTP/2.0", proto)
+
+	// hijack后清除了ReadTimeout, 连接可以继续使用
+	time.Sleep(200 * time.Millisecond)
+	_, proto, err = get(client, url, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "HTTP/2.0", proto)
+
+	// 请求头超过MaxHeaderBytes
+	code, _, err = get(client, url, http.Header{"X-Large": {strings.Repeat("a", 4096)}})
+	assert.True(t, err != nil || code == http.StatusRequestHeaderFieldsTooLarge)
+
+	// HTTP/1.1仍然可用
+	_, proto, err = get(http.DefaultClient, url, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "HTTP/1.1", proto)
+}
+
+func TestServer_HTTP1(t *testing.T) {
+	url := serve(t, nil)
+	_, _, err := get(h2cClient(), url, nil)
+	assert.NotNil(t, err)
+	_, proto, err := get(http.DefaultClient, url, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "HTTP/1.1", proto)
+}
+
+func TestTracker_Shutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker("test@" + listener.Addr().String())
+	var (
+		hijacked = make(chan net.Conn, 1)
+		slow     = make(chan struct{})
+	)
+	server := DefaultConfig().Server("", tracker.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hijack":
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			hijacked <- conn
+		case "/slow":
+			<-slow
+		}
+	})), false)
+	server.ConnState = tracker.ConnState
+	go server.Serve(tracker.Listener(listener))
+
+	// hijack的连接, 如websocket
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /hijack HTTP/1.1\r\nHost: test\r\n\r\n"))
+	assert.Nil(t, err)
+	<-hijacked
+
+	// 进行中的请求
+	go get(http.DefaultClient, "http://"+listener.Addr().String()+"/slow", nil)
+	assert.Eventually(t, func() bool {
+		stats := tracker.Stats()
+		return stats.Requests == 1 && stats.Active == 1 && stats.Hijacked == 1
+	}, time.Second, 10*time.Millisecond)
+	_, ok := trackers.Load(tracker.Name())
+	assert.True(t, ok)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(slow)
+	}()
+	start := time.Now()
+	assert.Nil(t, tracker.Shutdown(context.Background(), server, 200*time.Millisecond, olog.DefaultLogger))
+	assert.True(t, time.Since(start) >= 200*time.Millisecond)
+	assert.Equal(t, Stats{}, tracker.Stats())
+	_, ok = trackers.Load(tracker.Name())
+	assert.False(t, ok)
+
+	// hijack的连接被强制关闭
+	_, err = conn.Read(make([]byte, 1))
+	assert.NotNil(t, err)
+}
+
+func TestTracker_ShutdownH2C(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker := NewTracker("test-h2c@" + listener.Addr().String())
+	config := DefaultConfig()
+	config.H2C = true
+	server := config.Server("", tracker.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	})), false)
+	server.ConnState = tracker.ConnState
+	go server.Serve(tracker.Listener(listener))
+
+	_, proto, err := get(h2cClient(), "http://"+listener.Addr().String(), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "HTTP/2.0", proto)
+	// ServeConn接管的连接不统计为hijacked
+	assert.Eventually(t, func() bool {
+		stats := tracker.Stats()
+		return stats.Conns == 1 && stats.Idle == 1 && stats.Hijacked == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// 空闲的HTTP/2连接在发送GOAWAY后不阻塞Shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	assert.Nil(t, tracker.Shutdown(ctx, server, 10*time.Second, olog.DefaultLogger))
+
+	// ServeConn返回后连接不再统计
+	assert.Eventually(t, func() bool {
+		return tracker.Stats().Conns == 0
+	}, 3*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,221 @@
+package otransport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xqk/ox/pkg/metric"
+	"github.com/xqk/ox/pkg/olog"
+)
+
+// Stats 进行中的请求和连接数
+type Stats struct {
+	// Requests 进行中的请求, 包括websocket和sse等长连接的处理函数
+	Requests int64 `json:"requests"`
+	// Conns 连接数, 按状态分为new, active, idle和hijacked
+	Conns    int `json:"conns"`
+	New      int `json:"new"`
+	Active   int `json:"active"`
+	Idle     int `json:"idle"`
+	Hijacked int `json:"hijacked"`
+}
+
+// Tracker 统计服务进行中的请求和连接, 停止服务时等待请求结束, 超时后强制关闭hijack的连接
+type Tracker struct {
+	name     string
+	requests int64
+
+	mu    sync.Mutex
+	conns map[net.Conn]http.ConnState
+}
+
+// NewTracker 创建并注册到governor(/debug/server/http/inflight), name为服务名, 如 server.gin@127.0.0.1:9091
+func NewTracker(name string) *Tracker {
+	t := &Tracker{
+		name:  name,
+		conns: make(map[net.Conn]http.ConnState),
+	}
+	trackers.Store(name, t)
+	return t
+}
+
+// Name ...
+func (t *Tracker) Name() string {
+	return t.name
+}
+
+// Listener 包装listener, hijack的连接关闭时不再统计
+func (t *Tracker) Listener(listener net.Listener) net.Listener {
+	return &trackedListener{Listener: listener, tracker: t}
+}
+
+// Handler 统计进行中的请求
+func (t *Tracker) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&t.requests, 1)
+		metric.ServerInflightGauge.Inc(metric.TypeHTTP, t.name)
+		defer func() {
+			atomic.AddInt64(&t.requests, -1)
+			metric.ServerInflightGauge.Add(-1, metric.TypeHTTP, t.name)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ConnState 用于http.Server.ConnState. h2c prior knowledge方式的连接被hijack后由http2.ServeConn报告
+// active和idle状态, 不再统计为hijacked, 发送GOAWAY后空闲的连接不会阻塞Shutdown
+func (t *Tracker) ConnState(conn net.Conn, state http.ConnState) {
+	if c, ok := conn.(*rwConn); ok {
+		conn = c.Conn
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// 已关闭的连接不再统计, 如ServeConn在连接被强制关闭后报告的状态
+	if c, ok := conn.(*trackedConn); ok && atomic.LoadInt32(&c.closed) == 1 {
+		return
+	}
+	if state == http.StateClosed {
+		t.remove(conn)
+		return
+	}
+	if _, ok := t.conns[conn]; !ok {
+		metric.ServerConnGauge.Inc(metric.TypeHTTP, t.name)
+	}
+	t.conns[conn] = state
+}
+
+// remove must be called with lock held
+func (t *Tracker) remove(conn net.Conn) {
+	if _, ok := t.conns[conn]; ok {
+		delete(t.conns, conn)
+		metric.ServerConnGauge.Add(-1, metric.TypeHTTP, t.name)
+	}
+}
+
+// Stats returns current stats
+func (t *Tracker) Stats() Stats {
+	stats := Stats{Requests: atomic.LoadInt64(&t.requests)}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats.Conns = len(t.conns)
+	for _, state := range t.conns {
+		switch state {
+		case http.StateNew:
+			stats.New++
+		case http.StateActive:
+			stats.Active++
+		case http.StateIdle:
+			stats.Idle++
+		case http.StateHijacked:
+			stats.Hijacked++
+		}
+	}
+	return stats
+}
+
+// Close 从governor注销并强制关闭hijack的连接, 用于立即停止服务
+func (t *Tracker) Close() {
+	trackers.Delete(t.name)
+	t.closeHijacked()
+}
+
+// closeHijacked 强制关闭hijack的连接, 返回关闭的数量
+func (t *Tracker) closeHijacked() int {
+	t.mu.Lock()
+	var conns []net.Conn
+	for conn, state := range t.conns {
+		if state == http.StateHijacked {
+			conns = append(conns, conn)
+		}
+	}
+	t.mu.Unlock()
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	return len(conns)
+}
+
+// Shutdown 优雅停止server并打印进度. server.Shutdown返回后继续等待进行中的请求和hijack的连接,
+// 超过grace后强制关闭hijack的连接, ctx结束时强制关闭所有连接
+func (t *Tracker) Shutdown(ctx context.Context, server *http.Server, grace time.Duration, logger *olog.Logger) error {
+	defer trackers.Delete(t.name)
+	var done = make(chan error, 1)
+	go func() {
+		done <- server.Shutdown(ctx)
+	}()
+
+	var (
+		err      error
+		shutdown bool
+		forced   = time.NewTimer(grace)
+		poll     = time.NewTicker(50 * time.Millisecond)
+		progress = time.NewTicker(time.Second)
+	)
+	defer forced.Stop()
+	defer poll.Stop()
+	defer progress.Stop()
+	for {
+		select {
+		case err = <-done:
+			shutdown = true
+			done = nil
+		case <-poll.C:
+		case <-progress.C:
+			stats := t.Stats()
+			logger.Info("draining http server", olog.FieldName(t.name), olog.Int64("requests", stats.Requests),
+				olog.Int("active", stats.Active), olog.Int("idle", stats.Idle), olog.Int("hijacked", stats.Hijacked))
+		case <-forced.C:
+			if n := t.closeHijacked(); n > 0 {
+				logger.Warn("force close hijacked connections", olog.FieldName(t.name), olog.Int("conns", n), olog.Duration("grace", grace))
+			}
+		case <-ctx.Done():
+			stats := t.Stats()
+			logger.Warn("drain http server timeout, force close", olog.FieldName(t.name), olog.Int64("requests", stats.Requests), olog.Int("conns", stats.Conns))
+			_ = server.Close()
+			t.closeHijacked()
+			return ctx.Err()
+		}
+		if shutdown {
+			if stats := t.Stats(); stats.Requests == 0 && stats.Hijacked == 0 {
+				logger.Info("http server drained", olog.FieldName(t.name))
+				return err
+			}
+		}
+	}
+}
+
+// trackedListener 包装accept的连接, 以便统计hijack后关闭的连接
+type trackedListener struct {
+	net.Listener
+	tracker *Tracker
+}
+
+// Accept ...
+func (l *trackedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &trackedConn{Conn: conn, tracker: l.tracker}, nil
+}
+
+type trackedConn struct {
+	net.Conn
+	tracker *Tracker
+	closed  int32
+}
+
+// Close ...
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		c.tracker.mu.Lock()
+		c.tracker.remove(c)
+		c.tracker.mu.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"sync"
+)
+
+var (
+	readyMu       sync.RWMutex
+	ready         = true
+	readyWatchers = make(map[int]func(ready bool))
+	readyWatchID  int
+)
+
+// Ready reports whether the app is ready to serve requests, it turns false when the app begins to stop
+func Ready() bool {
+	readyMu.RLock()
+	defer readyMu.RUnlock()
+	return ready
+}
+
+// SetReady sets readiness of the app and notifies watchers
+func SetReady(r bool) {
+	readyMu.Lock()
+	if ready == r {
+		readyMu.Unlock()
+		return
+	}
+	ready = r
+	var watchers = make([]func(bool), 0, len(readyWatchers))
+	for _, fn := range readyWatchers {
+		watchers = append(watchers, fn)
+	}
+	readyMu.Unlock()
+
+	for _, fn := range watchers {
+		fn(r)
+	}
+}
+
+// WatchReady registers fn which is called when readiness of the app changes,
+// the returned func unregisters fn
+func WatchReady(fn func(ready bool)) (unwatch func()) {
+	readyMu.Lock()
+	readyWatchID++
+	id := readyWatchID
+	readyWatchers[id] = fn
+	readyMu.Unlock()
+
+	return func() {
+		readyMu.Lock()
+		delete(readyWatchers, id)
+		readyMu.Unlock()
+	}
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchReady(t *testing.T) {
+	defer SetReady(true)
+
+	var calls []bool
+	unwatch := WatchReady(func(ready bool) {
+		calls = append(calls, ready)
+	})
+	SetReady(false)
+	SetReady(false)
+	SetReady(true)
+	assert.Equal(t, []bool{false, true}, calls)
+
+	// 注销后不再通知
+	unwatch()
+	SetReady(false)
+	assert.Equal(t, []bool{false, true}, calls)
+}
@@ -8,6 +8,9 @@ import (
 	"github.com/xqk/ox/pkg/constant"
 )
 
+// RouteNotFound 没有匹配路由的请求在限流和监控中使用的路由名, 不使用原始路径, 避免缓存和监控标签无限增长
+const RouteNotFound = "NOT_FOUND"
+
 type Option func(c *ServiceInfo)
 
 // ServiceConfigurator represents service configurator
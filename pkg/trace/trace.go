@@ -6,6 +6,7 @@ import (
 	"github.com/xqk/ox/pkg/olog"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/log"
+	"github.com/uber/jaeger-client-go"
 )
 
 var (
@@ -28,3 +29,15 @@ func StartSpanFromContext(ctx context.Context, op string, opts ...opentracing.St
 func SpanFromContext(ctx context.Context) opentracing.Span {
 	return opentracing.SpanFromContext(ctx)
 }
+
+// ExtractTraceID 返回ctx中span的trace id, 没有span时返回空
+func ExtractTraceID(ctx context.Context) string {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	if sc, ok := span.Context().(jaeger.SpanContext); ok {
+		return sc.TraceID().String()
+	}
+	return ""
+}
@@ -0,0 +1,138 @@
+// Package oaccess 访问日志的级别、采样、截断和脱敏配置, 供grpc服务端和客户端的访问日志拦截器使用
+package oaccess
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/xqk/ox/pkg/olog"
+)
+
+const (
+	// LevelOff 不记录成功请求的访问日志, 出错的请求仍然记录
+	LevelOff = "off"
+)
+
+// Config 访问日志配置
+type Config struct {
+	// Level 成功请求的日志级别: debug|info|warn|error|off, 默认info
+	Level string `json:"level" toml:"level"`
+	// SampleRate 成功请求的采样率, 取值(0,1], 默认1; 出错的请求始终记录
+	SampleRate float64 `json:"sampleRate" toml:"sampleRate"`
+	// MaxPayloadSize 请求和响应体的最大字节数, 超出部分截断, 0表示不限制
+	MaxPayloadSize int `json:"maxPayloadSize" toml:"maxPayloadSize"`
+	// RedactFields 需要脱敏的字段名, 不区分大小写和下划线, 如password可以匹配Password, pass_word
+	RedactFields []string `json:"redactFields" toml:"redactFields"`
+	// PayloadOnError 仅在出错时记录请求和响应体
+	PayloadOnError bool `json:"payloadOnError" toml:"payloadOnError"`
+	// DisablePayload 不记录请求和响应体
+	DisablePayload bool `json:"disablePayload" toml:"disablePayload"`
+	// Methods 按方法覆盖的配置
+	Methods []MethodConfig `json:"methods" toml:"methods"`
+}
+
+// MethodConfig 按方法覆盖的配置, 未设置的字段继承全局配置
+type MethodConfig struct {
+	// Method 完整方法名如/pkg.Service/Method, 以*结尾时按前缀匹配如/pkg.Service/*
+	Method         string   `json:"method" toml:"method"`
+	Level          string   `json:"level" toml:"level"`
+	SampleRate     float64  `json:"sampleRate" toml:"sampleRate"`
+	MaxPayloadSize int      `json:"maxPayloadSize" toml:"maxPayloadSize"`
+	RedactFields   []string `json:"redactFields" toml:"redactFields"`
+	PayloadOnError *bool    `json:"payloadOnError" toml:"payloadOnError"`
+	DisablePayload *bool    `json:"disablePayload" toml:"disablePayload"`
+}
+
+// DefaultConfig ...
+func DefaultConfig() *Config {
+	return &Config{
+		Level:        "info",
+		SampleRate:   1,
+		RedactFields: []string{"password", "token", "secret"},
+	}
+}
+
+// Build 预先计算每个方法的规则
+func (config *Config) Build() *Access {
+	access := &Access{
+		root:     newRule(config),
+		exact:    make(map[string]*Rule),
+		prefixes: make([]prefixRule, 0),
+	}
+	for _, mc := range config.Methods {
+		rule := newRule(config.merge(mc))
+		if strings.HasSuffix(mc.Method, "*") {
+			access.prefixes = append(access.prefixes, prefixRule{prefix: strings.TrimSuffix(mc.Method, "*"), rule: rule})
+			continue
+		}
+		access.exact[mc.Method] = rule
+	}
+	// 最长前缀优先
+	sort.SliceStable(access.prefixes, func(i, j int) bool {
+		return len(access.prefixes[i].prefix) > len(access.prefixes[j].prefix)
+	})
+	return access
+}
+
+func (config *Config) merge(mc MethodConfig) *Config {
+	var merged = *config
+	merged.Methods = nil
+	if mc.Level != "" {
+		merged.Level = mc.Level
+	}
+	if mc.SampleRate > 0 {
+		merged.SampleRate = mc.SampleRate
+	}
+	if mc.MaxPayloadSize > 0 {
+		merged.MaxPayloadSize = mc.MaxPayloadSize
+	}
+	if len(mc.RedactFields) > 0 {
+		merged.RedactFields = append(append([]string{}, config.RedactFields...), mc.RedactFields...)
+	}
+	if mc.PayloadOnError != nil {
+		merged.PayloadOnError = *mc.PayloadOnError
+	}
+	if mc.DisablePayload != nil {
+		merged.DisablePayload = *mc.DisablePayload
+	}
+	return &merged
+}
+
+// Access 按方法匹配访问日志规则
+type Access struct {
+	root     *Rule
+	exact    map[string]*Rule
+	prefixes []prefixRule
+}
+
+type prefixRule struct {
+	prefix string
+	rule   *Rule
+}
+
+// Rule returns rule of method, exact match first, then longest prefix
+func (access *Access) Rule(method string) *Rule {
+	if rule, ok := access.exact[method]; ok {
+		return rule
+	}
+	for _, pr := range access.prefixes {
+		if strings.HasPrefix(method, pr.prefix) {
+			return pr.rule
+		}
+	}
+	return access.root
+}
+
+func parseLevel(level string) (olog.Level, bool) {
+	var lv olog.Level
+	switch strings.ToLower(level) {
+	case LevelOff:
+		return lv, false
+	case "":
+		return olog.InfoLevel, true
+	}
+	if err := lv.UnmarshalText([]byte(level)); err != nil {
+		return olog.InfoLevel, true
+	}
+	return lv, true
+}
@@ -0,0 +1,142 @@
+package oaccess
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"unicode/utf8"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/util/ostring"
+)
+
+const redacted = "***"
+
+var _jsonAPI = jsoniter.Config{
+	SortMapKeys: true,
+	UseNumber:   true,
+	EscapeHTML:  true,
+}.Froze()
+
+// Rule 单个方法的访问日志规则
+type Rule struct {
+	level          olog.Level
+	enabled        bool
+	sampleRate     float64
+	maxPayloadSize int
+	redactFields   map[string]struct{}
+	payloadOnError bool
+	disablePayload bool
+}
+
+func newRule(config *Config) *Rule {
+	rule := &Rule{
+		sampleRate:     config.SampleRate,
+		maxPayloadSize: config.MaxPayloadSize,
+		redactFields:   make(map[string]struct{}, len(config.RedactFields)),
+		payloadOnError: config.PayloadOnError,
+		disablePayload: config.DisablePayload,
+	}
+	if rule.sampleRate <= 0 {
+		rule.sampleRate = 1
+	}
+	rule.level, rule.enabled = parseLevel(config.Level)
+	for _, field := range config.RedactFields {
+		rule.redactFields[normalize(field)] = struct{}{}
+	}
+	return rule
+}
+
+// Sampled reports whether the request should be logged, failed requests are always logged
+func (rule *Rule) Sampled(failed bool) bool {
+	if failed {
+		return true
+	}
+	if !rule.enabled {
+		return false
+	}
+	return rule.sampleRate >= 1 || rand.Float64() < rule.sampleRate
+}
+
+// Level returns log level of successful requests
+func (rule *Rule) Level() olog.Level {
+	return rule.level
+}
+
+// Log writes log at level of rule
+func (rule *Rule) Log(logger *olog.Logger, msg string, fields ...olog.Field) {
+	switch rule.level {
+	case olog.DebugLevel:
+		logger.Debug(msg, fields...)
+	case olog.WarnLevel:
+		logger.Warn(msg, fields...)
+	case olog.ErrorLevel, olog.PanicLevel, olog.FatalLevel:
+		logger.Error(msg, fields...)
+	default:
+		logger.Info(msg, fields...)
+	}
+}
+
+// PayloadFields returns redacted and truncated request and reply fields
+func (rule *Rule) PayloadFields(failed bool, req, reply interface{}) []olog.Field {
+	if rule.disablePayload || (rule.payloadOnError && !failed) {
+		return nil
+	}
+	return []olog.Field{
+		rule.Payload("req", req),
+		rule.Payload("reply", reply),
+	}
+}
+
+// Payload returns a field of redacted and truncated json of val
+func (rule *Rule) Payload(key string, val interface{}) olog.Field {
+	bs := ostring.JsonBytes(val)
+	if len(rule.redactFields) > 0 && len(bs) > 0 {
+		var obj interface{}
+		if err := _jsonAPI.Unmarshal(bs, &obj); err == nil && rule.redact(obj) {
+			bs, _ = _jsonAPI.Marshal(obj)
+		}
+	}
+
+	if rule.maxPayloadSize > 0 && len(bs) > rule.maxPayloadSize {
+		n := rule.maxPayloadSize
+		for n > 0 && !utf8.RuneStart(bs[n]) {
+			n--
+		}
+		return olog.String(key, string(bs[:n])+"...(truncated)")
+	}
+	if len(bs) == 0 {
+		return olog.Any(key, nil)
+	}
+	return olog.Any(key, json.RawMessage(bs))
+}
+
+// redact replaces values of sensitive fields in place, reports whether any field is replaced
+func (rule *Rule) redact(obj interface{}) bool {
+	var replaced bool
+	switch val := obj.(type) {
+	case map[string]interface{}:
+		for k, v := range val {
+			if _, ok := rule.redactFields[normalize(k)]; ok {
+				val[k] = redacted
+				replaced = true
+				continue
+			}
+			if rule.redact(v) {
+				replaced = true
+			}
+		}
+	case []interface{}:
+		for _, v := range val {
+			if rule.redact(v) {
+				replaced = true
+			}
+		}
+	}
+	return replaced
+}
+
+func normalize(field string) string {
+	return strings.ToLower(strings.ReplaceAll(field, "_", ""))
+}
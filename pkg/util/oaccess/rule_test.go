@@ -0,0 +1,96 @@
+package oaccess
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/olog"
+	"go.uber.org/zap/zapcore"
+)
+
+type loginRequest struct {
+	Username    string            `json:"username"`
+	Password    string            `json:"password"`
+	AccessToken string            `json:"access_token"`
+	Profile     map[string]string `json:"profile"`
+}
+
+func payloadString(field olog.Field) string {
+	enc := zapcore.NewMapObjectEncoder()
+	field.AddTo(enc)
+	switch val := enc.Fields[field.Key].(type) {
+	case string:
+		return val
+	default:
+		bs, _ := _jsonAPI.Marshal(val)
+		return string(bs)
+	}
+}
+
+func TestRule(t *testing.T) {
+	config := DefaultConfig()
+	config.RedactFields = append(config.RedactFields, "accessToken")
+	config.Methods = []MethodConfig{
+		{Method: "/pkg.Health/*", Level: LevelOff},
+		{Method: "/pkg.User/Login", Level: "debug", MaxPayloadSize: 20},
+		{Method: "/pkg.User/*", Level: "warn", RedactFields: []string{"phone"}},
+	}
+	access := config.Build()
+
+	t.Run("match", func(t *testing.T) {
+		assert.Equal(t, olog.InfoLevel, access.Rule("/pkg.Order/Create").Level())
+		assert.Equal(t, olog.DebugLevel, access.Rule("/pkg.User/Login").Level())
+		assert.Equal(t, olog.WarnLevel, access.Rule("/pkg.User/Logout").Level())
+	})
+
+	t.Run("sampling", func(t *testing.T) {
+		rule := access.Rule("/pkg.Health/Check")
+		assert.False(t, rule.Sampled(false))
+		assert.True(t, rule.Sampled(true))
+		assert.True(t, access.Rule("/pkg.Order/Create").Sampled(false))
+
+		config := DefaultConfig()
+		config.SampleRate = 0.000001
+		rule = config.Build().Rule("/pkg.Order/Create")
+		var sampled int
+		for i := 0; i < 1000; i++ {
+			if rule.Sampled(false) {
+				sampled++
+			}
+		}
+		assert.True(t, sampled < 10)
+	})
+
+	t.Run("redact", func(t *testing.T) {
+		req := &loginRequest{
+			Username:    "ox",
+			Password:    "123456",
+			AccessToken: "abc",
+			Profile:     map[string]string{"phone": "13800000000", "Secret": "s"},
+		}
+		assert.Equal(t,
+			`{"access_token":"***","password":"***","profile":{"Secret":"***","phone":"13800000000"},"username":"ox"}`,
+			payloadString(access.Rule("/pkg.Order/Create").Payload("req", req)),
+		)
+		assert.Equal(t,
+			`{"access_token":"***","password":"***","profile":{"Secret":"***","phone":"***"},"username":"ox"}`,
+			payloadString(access.Rule("/pkg.User/Logout").Payload("req", req)),
+		)
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		field := access.Rule("/pkg.User/Login").Payload("req", map[string]string{"username": "中文中文中文"})
+		assert.Equal(t, `{"username":"中文...(truncated)`, payloadString(field))
+	})
+
+	t.Run("payload on error", func(t *testing.T) {
+		config := DefaultConfig()
+		config.PayloadOnError = true
+		rule := config.Build().Rule("/pkg.Order/Create")
+		assert.Len(t, rule.PayloadFields(false, "req", "reply"), 0)
+		assert.Len(t, rule.PayloadFields(true, "req", "reply"), 2)
+
+		config.DisablePayload = true
+		assert.Len(t, config.Build().Rule("/pkg.Order/Create").PayloadFields(true, "req", "reply"), 0)
+	})
+}
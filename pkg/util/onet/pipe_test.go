@@ -0,0 +1,45 @@
+package onet
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeListener(t *testing.T) {
+	l := NewPipeListener()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	conn, err := l.DialContext(context.Background(), "")
+	assert.Nil(t, err)
+	_, err = conn.Write([]byte("ping"))
+	assert.Nil(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "ping", string(buf))
+	conn.Close()
+
+	// 没有Accept时拨号超时
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.DialContext(ctx, "")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	assert.Nil(t, l.Close())
+	_, err = l.Accept()
+	assert.Equal(t, net.ErrClosed, err)
+	_, err = l.DialContext(context.Background(), "")
+	assert.Equal(t, net.ErrClosed, err)
+}
@@ -0,0 +1,72 @@
+package onet
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// IsUnixNetwork reports whether network is unix domain socket
+func IsUnixNetwork(network string) bool {
+	return network == "unix" || network == "unixpacket"
+}
+
+// Listen announces on the local network address. For unix network, address is path of socket file,
+// or name of abstract socket if it starts with "@". Stale socket file is removed before listening,
+// mode is set to the socket file if not zero, and the file is removed when the listener is closed.
+func Listen(network, address string, mode os.FileMode) (net.Listener, error) {
+	if !IsUnixNetwork(network) {
+		return net.Listen(network, address)
+	}
+
+	var abstract = strings.HasPrefix(address, "@")
+	if !abstract {
+		if err := removeStaleSocket(network, address); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if ul, ok := l.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(!abstract)
+	}
+	if !abstract && mode != 0 {
+		if err := os.Chmod(address, mode); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Port returns port of tcp address, 0 for others such as unix address
+func Port(addr net.Addr) int {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.Port
+	}
+	return 0
+}
+
+// removeStaleSocket removes socket file left by the previous process, returns error if
+// the file is not a socket or someone is still listening on it.
+func removeStaleSocket(network, path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("listen %s: file exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout(network, path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("listen %s: address already in use", path)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,65 @@
+package onet
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "onet")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.sock")
+
+	l, err := Listen("unix", path, 0600)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, Port(l.Addr()))
+	fi, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// 监听中的socket不能被删除
+	_, err = Listen("unix", path, 0)
+	assert.NotNil(t, err)
+
+	conn, err := net.Dial("unix", path)
+	assert.Nil(t, err)
+	conn.Close()
+
+	// 关闭后删除socket文件
+	assert.Nil(t, l.Close())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// 残留的socket文件被删除后重新监听
+	stale, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	l, err = Listen("unix", path, 0)
+	assert.Nil(t, err)
+	l.Close()
+
+	// 不是socket的文件不会被删除
+	assert.Nil(t, ioutil.WriteFile(path, []byte("data"), 0644))
+	_, err = Listen("unix", path, 0)
+	assert.NotNil(t, err)
+}
+
+func TestListenAbstract(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract socket is only supported on linux")
+	}
+	l, err := Listen("unix", "@ox-onet-test", 0600)
+	assert.Nil(t, err)
+	defer l.Close()
+	conn, err := net.Dial("unix", "@ox-onet-test")
+	assert.Nil(t, err)
+	conn.Close()
+}
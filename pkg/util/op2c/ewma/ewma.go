@@ -0,0 +1,131 @@
+package ewma
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xqk/ox/pkg/util/op2c"
+	"google.golang.org/grpc/balancer"
+)
+
+const (
+	// decay time window of the moving averages
+	tau = int64(time.Millisecond * 600)
+	// a node which has not been picked within forcePick will be picked once
+	forcePick = int64(time.Second)
+	// success rate never drops to zero, otherwise the node could never recover
+	minSuccess = 0.01
+)
+
+type ewmaNode struct {
+	item     interface{}
+	inflight int64
+	// lag ewma of the call latency in nanoseconds
+	lag int64
+	// success ewma of the success rate, stored as math.Float64bits
+	success uint64
+	// stamp time of the last update of lag and success
+	stamp int64
+	// picked time of the last pick
+	picked int64
+}
+
+func (n *ewmaNode) load() float64 {
+	lag := float64(atomic.LoadInt64(&n.lag))
+	success := math.Float64frombits(atomic.LoadUint64(&n.success))
+	if success < minSuccess {
+		success = minSuccess
+	}
+	return math.Sqrt(lag+1) * float64(atomic.LoadInt64(&n.inflight)+1) / success
+}
+
+func (n *ewmaNode) observe(now int64, cost int64, failed bool) {
+	td := now - atomic.SwapInt64(&n.stamp, now)
+	if td < 0 {
+		td = 0
+	}
+	w := math.Exp(float64(-td) / float64(tau))
+
+	lag := atomic.LoadInt64(&n.lag)
+	atomic.StoreInt64(&n.lag, int64(float64(lag)*w+float64(cost)*(1-w)))
+
+	var ok float64 = 1
+	if failed {
+		ok = 0
+	}
+	success := math.Float64frombits(atomic.LoadUint64(&n.success))
+	atomic.StoreUint64(&n.success, math.Float64bits(success*w+ok*(1-w)))
+}
+
+type ewma struct {
+	items []*ewmaNode
+	mu    sync.Mutex
+	rand  *rand.Rand
+}
+
+// New returns a p2c which picks the item with the lowest load,
+// load is weighted by inflight requests, ewma latency and ewma success rate.
+func New() op2c.P2c {
+	return &ewma{
+		items: make([]*ewmaNode, 0),
+		rand:  rand.New(rand.NewSource(time.Now().Unix())),
+	}
+}
+
+func (p *ewma) Add(item interface{}) {
+	p.items = append(p.items, &ewmaNode{
+		item:    item,
+		success: math.Float64bits(1),
+		stamp:   time.Now().UnixNano(),
+	})
+}
+
+func (p *ewma) Next() (interface{}, func(balancer.DoneInfo)) {
+	var sc, backsc *ewmaNode
+
+	switch len(p.items) {
+	case 0:
+		return nil, func(balancer.DoneInfo) {}
+	case 1:
+		sc = p.items[0]
+	default:
+		// rand needs lock
+		p.mu.Lock()
+		a := p.rand.Intn(len(p.items))
+		b := p.rand.Intn(len(p.items) - 1)
+		p.mu.Unlock()
+
+		if b >= a {
+			b = b + 1
+		}
+		sc, backsc = p.items[a], p.items[b]
+
+		// choose the least loaded item
+		if sc.load() > backsc.load() {
+			sc, backsc = backsc, sc
+		}
+
+		// give the other one a chance to refresh its stats if it has not been picked for a while
+		now := time.Now().UnixNano()
+		if picked := atomic.LoadInt64(&backsc.picked); now-picked > forcePick &&
+			atomic.CompareAndSwapInt64(&backsc.picked, picked, now) {
+			sc = backsc
+		}
+	}
+
+	start := time.Now().UnixNano()
+	atomic.StoreInt64(&sc.picked, start)
+	atomic.AddInt64(&sc.inflight, 1)
+
+	return sc.item, func(di balancer.DoneInfo) {
+		atomic.AddInt64(&sc.inflight, -1)
+		if di.Err == op2c.ErrReleased {
+			return
+		}
+		now := time.Now().UnixNano()
+		sc.observe(now, now-start, op2c.IsFailure(di.Err))
+	}
+}
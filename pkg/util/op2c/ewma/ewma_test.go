@@ -0,0 +1,114 @@
+package ewma_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xqk/ox/pkg/util/op2c"
+	"github.com/xqk/ox/pkg/util/op2c/ewma"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEwma(t *testing.T) {
+	t.Run("0 item", func(t *testing.T) {
+		p := ewma.New()
+		item, done := p.Next()
+		done(balancer.DoneInfo{})
+		assert.Nil(t, item)
+	})
+
+	t.Run("1 item", func(t *testing.T) {
+		p := ewma.New()
+		p.Add(1)
+		item, done := p.Next()
+		done(balancer.DoneInfo{})
+		assert.Equal(t, 1, item)
+	})
+
+	t.Run("3 items", func(t *testing.T) {
+		p := ewma.New()
+		p.Add(1)
+		p.Add(2)
+		p.Add(3)
+
+		countMap := make(map[interface{}]int)
+		totalCount := 3000
+		for i := 0; i < totalCount; i++ {
+			item, done := p.Next()
+			done(balancer.DoneInfo{})
+			countMap[item]++
+		}
+
+		assert.Len(t, countMap, 3)
+	})
+}
+
+func TestEwmaAbnormal(t *testing.T) {
+	t.Run("released item", func(t *testing.T) {
+		p := ewma.New()
+		p.Add(1)
+		p.Add(2)
+
+		// 释放的节点不计为失败
+		countMap := make(map[interface{}]int)
+		for i := 0; i < 2000; i++ {
+			item, done := p.Next()
+			if item == 1 {
+				done(balancer.DoneInfo{Err: op2c.ErrReleased})
+			} else {
+				done(balancer.DoneInfo{Err: status.Error(codes.Unavailable, "unavailable")})
+			}
+			countMap[item]++
+		}
+
+		assert.Greater(t, countMap[1], countMap[2])
+	})
+
+	t.Run("failing item", func(t *testing.T) {
+		p := ewma.New()
+		p.Add(1)
+		p.Add(2)
+
+		countMap := make(map[interface{}]int)
+		for i := 0; i < 2000; i++ {
+			item, done := p.Next()
+			var err error
+			if item == 1 {
+				err = status.Error(codes.Unavailable, "unavailable")
+			}
+			done(balancer.DoneInfo{Err: err})
+			countMap[item]++
+		}
+
+		assert.Less(t, countMap[1], countMap[2])
+	})
+
+	t.Run("slow item", func(t *testing.T) {
+		p := ewma.New()
+		p.Add(1)
+		p.Add(2)
+
+		countMap := make(map[interface{}]int)
+		for i := 0; i < 200; i++ {
+			item, done := p.Next()
+			if item == 1 {
+				time.Sleep(time.Millisecond)
+			}
+			done(balancer.DoneInfo{})
+			countMap[item]++
+		}
+
+		assert.Less(t, countMap[1], countMap[2])
+	})
+
+	t.Run("business error", func(t *testing.T) {
+		p := ewma.New()
+		p.Add(1)
+		item, done := p.Next()
+		done(balancer.DoneInfo{Err: status.Error(codes.InvalidArgument, "invalid")})
+		assert.Equal(t, 1, item)
+	})
+}
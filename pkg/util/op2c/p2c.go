@@ -1,6 +1,16 @@
 package op2c
 
-import "google.golang.org/grpc/balancer"
+import (
+	"errors"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrReleased is passed to the done func returned by Next when the picked item is not used,
+// eg: it is ejected by outlier detection. The item is released without updating its stats.
+var ErrReleased = errors.New("p2c: picked item released")
 
 type P2c interface {
 	// Next returns next selected item.
@@ -8,3 +18,21 @@ type P2c interface {
 	// Add a item.
 	Add(interface{})
 }
+
+// IsFailure reports whether err should count against the backend which served the call.
+// Business errors are treated as successful calls, only transport and server side errors count.
+func IsFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return true
+	}
+	switch s.Code() {
+	case codes.Unknown, codes.DeadlineExceeded, codes.ResourceExhausted,
+		codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package otls
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+const (
+	// ClientAuthNone 不校验客户端证书
+	ClientAuthNone = "none"
+	// ClientAuthRequest 请求客户端证书, 但不校验
+	ClientAuthRequest = "request"
+	// ClientAuthRequire 要求客户端证书, 但不校验
+	ClientAuthRequire = "require"
+	// ClientAuthVerifyIfGiven 客户端提供证书时校验
+	ClientAuthVerifyIfGiven = "verify_if_given"
+	// ClientAuthRequireAndVerify 要求并校验客户端证书, 即mTLS
+	ClientAuthRequireAndVerify = "require_and_verify"
+)
+
+// Config TLS/mTLS config
+type Config struct {
+	// CertFile 证书文件, 客户端配置时用于mTLS
+	CertFile string `json:"certFile" toml:"certFile"`
+	// KeyFile 私钥文件
+	KeyFile string `json:"keyFile" toml:"keyFile"`
+	// CAFile CA证书文件, 服务端用于校验客户端证书, 客户端用于校验服务端证书
+	CAFile string `json:"caFile" toml:"caFile"`
+	// ServerName 客户端校验的服务端名称, 默认使用拨号地址
+	ServerName string `json:"serverName" toml:"serverName"`
+	// ClientAuth 服务端校验客户端证书的模式: none|request|require|verify_if_given|require_and_verify
+	ClientAuth string `json:"clientAuth" toml:"clientAuth"`
+	// InsecureSkipVerify 客户端不校验服务端证书, 仅用于测试
+	InsecureSkipVerify bool `json:"insecureSkipVerify" toml:"insecureSkipVerify"`
+	// DisableWatch 关闭证书文件变更时的自动加载
+	DisableWatch bool `json:"disableWatch" toml:"disableWatch"`
+}
+
+func (config *Config) clientAuthType() (tls.ClientAuthType, error) {
+	switch strings.ToLower(config.ClientAuth) {
+	case "":
+		if config.CAFile != "" {
+			// 配置了CA且未指定模式时, 默认开启mTLS
+			return tls.RequireAndVerifyClientCert, nil
+		}
+		return tls.NoClientCert, nil
+	case ClientAuthNone:
+		return tls.NoClientCert, nil
+	case ClientAuthRequest:
+		return tls.RequestClientCert, nil
+	case ClientAuthRequire:
+		return tls.RequireAnyClientCert, nil
+	case ClientAuthVerifyIfGiven:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequireAndVerify:
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("otls: invalid client auth %q", config.ClientAuth)
+}
@@ -0,0 +1,36 @@
+package otls
+
+import (
+	"crypto/tls"
+)
+
+// Identity is the identity of a peer presented by its certificate
+type Identity struct {
+	CommonName   string   `json:"commonName"`
+	Organization []string `json:"organization"`
+	DNSNames     []string `json:"dnsNames"`
+	// URIs 如SPIFFE ID: spiffe://cluster.local/ns/default/sa/app
+	URIs         []string `json:"uris"`
+	SerialNumber string   `json:"serialNumber"`
+}
+
+// IdentityFromState returns identity of the peer certificate in connection state.
+// Only certificates verified during handshake are trusted, unverified ones return false.
+func IdentityFromState(state tls.ConnectionState) (*Identity, bool) {
+	if len(state.PeerCertificates) == 0 || len(state.VerifiedChains) == 0 {
+		return nil, false
+	}
+
+	cert := state.PeerCertificates[0]
+	identity := &Identity{
+		CommonName:   cert.Subject.CommonName,
+		Organization: cert.Subject.Organization,
+		DNSNames:     cert.DNSNames,
+		URIs:         make([]string, 0, len(cert.URIs)),
+		SerialNumber: cert.SerialNumber.String(),
+	}
+	for _, uri := range cert.URIs {
+		identity.URIs = append(identity.URIs, uri.String())
+	}
+	return identity, true
+}
@@ -0,0 +1,206 @@
+package otls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/xqk/ox/pkg/olog"
+	"github.com/xqk/ox/pkg/util/ogo"
+)
+
+// Loader loads certificates from files, and reloads them when the files change on disk.
+// Certificates and CA are resolved per handshake, so reloading takes effect on new connections.
+type Loader struct {
+	config  *Config
+	cert    atomic.Value // *tls.Certificate
+	pool    atomic.Value // *x509.CertPool
+	watcher *fsnotify.Watcher
+	logger  *olog.Logger
+}
+
+// NewLoader creates a loader and loads certificates immediately
+func NewLoader(config *Config) (*Loader, error) {
+	l := &Loader{
+		config: config,
+		logger: olog.OxLogger.With(olog.FieldMod("otls")),
+	}
+	if err := l.Reload(); err != nil {
+		return nil, err
+	}
+
+	if !config.DisableWatch {
+		if err := l.watch(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Reload reloads certificates from files, old certificates are kept if it fails.
+func (l *Loader) Reload() error {
+	if l.config.CertFile != "" || l.config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(l.config.CertFile, l.config.KeyFile)
+		if err != nil {
+			return fmt.Errorf("otls: load key pair: %v", err)
+		}
+		l.cert.Store(&cert)
+	}
+
+	if l.config.CAFile != "" {
+		bs, err := ioutil.ReadFile(l.config.CAFile)
+		if err != nil {
+			return fmt.Errorf("otls: read ca file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bs) {
+			return fmt.Errorf("otls: no certificate found in ca file %s", l.config.CAFile)
+		}
+		l.pool.Store(pool)
+	}
+	return nil
+}
+
+// Close stops watching the files
+func (l *Loader) Close() error {
+	if l.watcher == nil {
+		return nil
+	}
+	return l.watcher.Close()
+}
+
+func (l *Loader) certificate() *tls.Certificate {
+	cert, _ := l.cert.Load().(*tls.Certificate)
+	return cert
+}
+
+func (l *Loader) certPool() *x509.CertPool {
+	pool, _ := l.pool.Load().(*x509.CertPool)
+	return pool
+}
+
+// ServerConfig returns tls config for servers
+func (l *Loader) ServerConfig() (*tls.Config, error) {
+	if l.certificate() == nil {
+		return nil, errors.New("otls: server requires certFile and keyFile")
+	}
+	clientAuth, err := l.config.clientAuthType()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return l.certificate(), nil
+		},
+		ClientAuth: clientAuth,
+	}
+	if clientAuth != tls.RequireAndVerifyClientCert && clientAuth != tls.VerifyClientCertIfGiven {
+		return config, nil
+	}
+
+	// 每次握手使用最新的CA校验客户端证书, 以支持CA的热加载, 校验通过后连接状态中有VerifiedChains
+	// 使用时才复制config, 调用方在返回后设置的NextProtos等同样生效
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := config.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = l.certPool()
+		return cfg, nil
+	}
+	return config, nil
+}
+
+// ClientConfig returns tls config for clients
+func (l *Loader) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: l.config.ServerName,
+		// 服务端证书由VerifyConnection使用最新的CA校验, 以支持CA的热加载
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert := l.certificate(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if l.config.InsecureSkipVerify {
+				return nil
+			}
+			return verify(cs.PeerCertificates, l.certPool(), cs.ServerName, x509.ExtKeyUsageServerAuth)
+		},
+	}
+}
+
+func verify(certs []*x509.Certificate, roots *x509.CertPool, dnsName string, usage x509.ExtKeyUsage) error {
+	if len(certs) == 0 {
+		return errors.New("otls: no peer certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       dnsName,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+func (l *Loader) watch() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// 监听文件所在目录, 以支持k8s secret等通过替换软链接更新文件的场景
+	var dirs = make(map[string]struct{})
+	for _, file := range []string{l.config.CertFile, l.config.KeyFile, l.config.CAFile} {
+		if file == "" {
+			continue
+		}
+		dir := filepath.Dir(file)
+		if _, ok := dirs[dir]; ok {
+			continue
+		}
+		dirs[dir] = struct{}{}
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	l.watcher = w
+
+	ogo.Go(func() {
+		for {
+			select {
+			case event, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+					continue
+				}
+				if err := l.Reload(); err != nil {
+					l.logger.Error("reload certificates", olog.FieldErr(err), olog.String("event", event.String()))
+					continue
+				}
+				l.logger.Info("reload certificates", olog.String("event", event.String()))
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				l.logger.Error("watch certificates", olog.FieldErr(err))
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,236 @@
+package otls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca *testCA) issue(t *testing.T, name string, serial int64, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/default/sa/" + name)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"ox"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		URIs:         []*url.URL{spiffe},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	assert.Nil(t, ioutil.WriteFile(path+".tmp", data, 0600))
+	assert.Nil(t, os.Rename(path+".tmp", path))
+}
+
+// handshake dials the server and returns identity seen by server
+func handshake(t *testing.T, server, client *tls.Config) (*Identity, error) {
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	assert.Nil(t, err)
+	defer lis.Close()
+
+	identities := make(chan *Identity, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			identities <- nil
+			return
+		}
+		defer conn.Close()
+		tlsConn := conn.(*tls.Conn)
+		if err := tlsConn.Handshake(); err != nil {
+			identities <- nil
+			return
+		}
+		identity, _ := IdentityFromState(tlsConn.ConnectionState())
+		identities <- identity
+	}()
+
+	conn, err := tls.Dial("tcp", lis.Addr().String(), client)
+	if err != nil {
+		<-identities
+		return nil, err
+	}
+	defer conn.Close()
+	// read to make sure server finished verifying client certificate
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _ = conn.Read(make([]byte, 1))
+	return <-identities, nil
+}
+
+func TestLoader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "otls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, "ca")
+	serverCert, serverKey := ca.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, "client", 3, x509.ExtKeyUsageClientAuth)
+	writeFile(t, filepath.Join(dir, "ca.pem"), ca.pem)
+	writeFile(t, filepath.Join(dir, "server.pem"), serverCert)
+	writeFile(t, filepath.Join(dir, "server.key"), serverKey)
+	writeFile(t, filepath.Join(dir, "client.pem"), clientCert)
+	writeFile(t, filepath.Join(dir, "client.key"), clientKey)
+
+	serverLoader, err := NewLoader(&Config{
+		CertFile: filepath.Join(dir, "server.pem"),
+		KeyFile:  filepath.Join(dir, "server.key"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+	})
+	assert.Nil(t, err)
+	defer serverLoader.Close()
+	serverConfig, err := serverLoader.ServerConfig()
+	assert.Nil(t, err)
+
+	t.Run("mtls", func(t *testing.T) {
+		clientLoader, err := NewLoader(&Config{
+			CertFile:   filepath.Join(dir, "client.pem"),
+			KeyFile:    filepath.Join(dir, "client.key"),
+			CAFile:     filepath.Join(dir, "ca.pem"),
+			ServerName: "localhost",
+		})
+		assert.Nil(t, err)
+		defer clientLoader.Close()
+
+		identity, err := handshake(t, serverConfig, clientLoader.ClientConfig())
+		assert.Nil(t, err)
+		assert.NotNil(t, identity)
+		assert.Equal(t, "client", identity.CommonName)
+		assert.Equal(t, []string{"spiffe://cluster.local/ns/default/sa/client"}, identity.URIs)
+	})
+
+	t.Run("client without certificate", func(t *testing.T) {
+		clientLoader, err := NewLoader(&Config{CAFile: filepath.Join(dir, "ca.pem"), ServerName: "localhost", DisableWatch: true})
+		assert.Nil(t, err)
+
+		identity, _ := handshake(t, serverConfig, clientLoader.ClientConfig())
+		assert.Nil(t, identity)
+	})
+
+	t.Run("unverified client certificate", func(t *testing.T) {
+		loader, err := NewLoader(&Config{
+			CertFile:     filepath.Join(dir, "server.pem"),
+			KeyFile:      filepath.Join(dir, "server.key"),
+			ClientAuth:   ClientAuthRequire,
+			DisableWatch: true,
+		})
+		assert.Nil(t, err)
+		requireConfig, err := loader.ServerConfig()
+		assert.Nil(t, err)
+
+		other := newTestCA(t, "other")
+		otherCert, otherKey := other.issue(t, "client", 4, x509.ExtKeyUsageClientAuth)
+		writeFile(t, filepath.Join(dir, "forged.pem"), otherCert)
+		writeFile(t, filepath.Join(dir, "forged.key"), otherKey)
+		clientLoader, err := NewLoader(&Config{
+			CertFile:     filepath.Join(dir, "forged.pem"),
+			KeyFile:      filepath.Join(dir, "forged.key"),
+			CAFile:       filepath.Join(dir, "ca.pem"),
+			ServerName:   "localhost",
+			DisableWatch: true,
+		})
+		assert.Nil(t, err)
+
+		identity, err := handshake(t, requireConfig, clientLoader.ClientConfig())
+		assert.Nil(t, err)
+		assert.Nil(t, identity)
+	})
+
+	t.Run("untrusted server", func(t *testing.T) {
+		other := newTestCA(t, "other")
+		writeFile(t, filepath.Join(dir, "other.pem"), other.pem)
+		clientLoader, err := NewLoader(&Config{
+			CertFile:     filepath.Join(dir, "client.pem"),
+			KeyFile:      filepath.Join(dir, "client.key"),
+			CAFile:       filepath.Join(dir, "other.pem"),
+			ServerName:   "localhost",
+			DisableWatch: true,
+		})
+		assert.Nil(t, err)
+
+		_, err = handshake(t, serverConfig, clientLoader.ClientConfig())
+		assert.NotNil(t, err)
+	})
+
+	t.Run("hot reload", func(t *testing.T) {
+		newServerCert, newServerKey := ca.issue(t, "server", 100, x509.ExtKeyUsageServerAuth)
+		writeFile(t, filepath.Join(dir, "server.key"), newServerKey)
+		writeFile(t, filepath.Join(dir, "server.pem"), newServerCert)
+
+		assert.Eventually(t, func() bool {
+			cert := serverLoader.certificate()
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			return err == nil && leaf.SerialNumber.Int64() == 100
+		}, time.Second*3, time.Millisecond*50)
+	})
+}
+
+func TestClientAuthType(t *testing.T) {
+	for auth, want := range map[string]tls.ClientAuthType{
+		ClientAuthNone:             tls.NoClientCert,
+		ClientAuthRequest:          tls.RequestClientCert,
+		ClientAuthRequire:          tls.RequireAnyClientCert,
+		ClientAuthVerifyIfGiven:    tls.VerifyClientCertIfGiven,
+		ClientAuthRequireAndVerify: tls.RequireAndVerifyClientCert,
+	} {
+		got, err := (&Config{ClientAuth: auth, CAFile: "ca.pem"}).clientAuthType()
+		assert.Nil(t, err)
+		assert.Equal(t, want, got, auth)
+	}
+
+	got, _ := (&Config{CAFile: "ca.pem"}).clientAuthType()
+	assert.Equal(t, tls.RequireAndVerifyClientCert, got)
+
+	_, err := (&Config{ClientAuth: "unknown"}).clientAuthType()
+	assert.NotNil(t, err)
+}